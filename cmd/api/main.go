@@ -1,26 +1,251 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
-	"github.com/arustydev/goslings/internal/app/cli/cmd"
+	"github.com/arustydev/goslings/internal/app/api"
+	"github.com/arustydev/goslings/internal/auth"
+	"github.com/arustydev/goslings/internal/auth/store"
+	"github.com/arustydev/goslings/internal/conf"
+	"github.com/arustydev/goslings/internal/sink"
+	"github.com/arustydev/goslings/internal/utils/telemetry"
 	"github.com/gin-gonic/gin"
 	log "github.com/sirupsen/logrus"
 )
 
-// https://masteringbackend.com/posts/gin-framework#getting-started-with-gin
 func main() {
-	log.SetFormatter(&log.JSONFormatter{})
-	// Create a new Gin router
-	router := gin.Default()
+	_ = telemetry.ConfigureLogging(telemetry.LogOptions{Format: telemetry.FormatJSON})
+	gin.SetMode(gin.ReleaseMode)
+	if len(os.Args) == 2 && os.Args[1] == "healthcheck" {
+		if err := healthcheck(); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if err := run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// run serves the /v1 API until SIGTERM or SIGINT, then stops running jobs at their next page
+// boundary, waiting up to the shutdown timeout for them. Config file changes, and SIGHUP,
+// reconfigure the server.
+func run() error {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
 
-	// Define a route for the root URL
-	router.GET("/", func(c *gin.Context) {
-		c.String(200, cmd.Goodbye("name"))
+	conf.InitConfig("")
+	logOpts, err := conf.GetLogOptions(telemetry.FormatJSON)
+	if err != nil {
+		return err
+	}
+	if err := telemetry.ConfigureLogging(logOpts); err != nil {
+		return err
+	}
+	traceOpts, err := conf.GetTraceOptions("goslings-api")
+	if err != nil {
+		return err
+	}
+	stopTracing, err := telemetry.ConfigureTracing(ctx, traceOpts)
+	if err != nil {
+		return err
+	}
+	defer stopTracing()
+	opts, err := conf.GetAPIOptions()
+	if err != nil {
+		return err
+	}
+	out, err := conf.GetOutputOptions()
+	if err != nil {
+		return err
+	}
+	storeOpts, err := conf.GetStoreOptions()
+	if err != nil {
+		return err
+	}
+	sinkConfigs, err := conf.GetSinkConfigs()
+	if err != nil {
+		return err
+	}
+	stopAudit, err := conf.ConfigureAudit()
+	if err != nil {
+		return err
+	}
+	defer stopAudit()
+
+	authManager, err := auth.NewAuthManager(ctx, storeOpts)
+	if err != nil {
+		return err
+	}
+	server := api.New(authManager, conf.GetAuthConfig(), opts.Out, out)
+	server.Settings = conf.Snapshot()
+	if server.Shipper, err = sink.NewShipper(authManager.Store, sinkConfigs); err != nil {
+		return err
+	}
+
+	if err := configureAccess(ctx, server, opts); err != nil {
+		return err
+	}
+	jobs, err := conf.GetJobs()
+	if err != nil {
+		return err
+	}
+	if err := server.UseJobs(jobs); err != nil {
+		return err
+	}
+
+	server.RequireTokens = opts.RequireTokens
+	if err := server.SetTrustedProxies(opts.TrustedProxies); err != nil {
+		return err
+	}
+	err = conf.Watch(ctx, func([]conf.Change) error {
+		return reconfigure(server, authManager.Store)
 	})
+	if err != nil {
+		log.Warnf("Configuration changes need a restart: %v", err)
+	}
+
+	srv := &http.Server{Addr: opts.Address, Handler: server}
+	if opts.TLS.Cert != "" {
+		cfg, cert, err := api.NewTLSConfig(opts.TLS)
+		if err != nil {
+			return err
+		}
+		srv.TLSConfig = cfg
+		if opts.TLS.Reload {
+			go cert.Watch(ctx, opts.TLS.ReloadInterval)
+		}
+	}
+	errs := make(chan error, 1)
+	go func() {
+		log.Infof("Serving the API on %s", opts.Address)
+		if srv.TLSConfig != nil {
+			errs <- srv.ListenAndServeTLS("", "")
+			return
+		}
+		log.Warn("Serving the API over plaintext HTTP, credentials are sent in the clear")
+		errs <- srv.ListenAndServe()
+	}()
 
-	// Run the server on port 8080
-	if err := router.Run(":8080"); err != nil {
-		log.Fatal(fmt.Errorf("router.run failed with error: %w", err))
+	select {
+	case err := <-errs:
+		if errors.Is(err, http.ErrServerClosed) {
+			return nil
+		}
+		return err
+	case <-ctx.Done():
+	}
+	log.Info("Shutting down, stopping running jobs at their next page")
+	shutdown, cancel := context.WithTimeout(context.Background(), opts.ShutdownTimeout)
+	defer cancel()
+	// Readiness fails from here on, and progress streams end once their jobs stop, so jobs are
+	// stopped before connections drain
+	jobsErr := server.Shutdown(shutdown)
+	if jobsErr != nil {
+		jobsErr = fmt.Errorf("jobs were aborted and can be resumed: %w", jobsErr)
+	}
+	drain, cancelDrain := context.WithTimeout(context.Background(), opts.DrainTimeout)
+	defer cancelDrain()
+	if err := srv.Shutdown(drain); err != nil {
+		log.Warnf("Closing connections still open after %s", opts.DrainTimeout)
+		_ = srv.Close()
+	}
+	return jobsErr
+}
+
+// reconfigure applies a reloaded configuration to the server
+func reconfigure(server *api.Server, st store.Store) error {
+	logOpts, err := conf.GetLogOptions(telemetry.FormatJSON)
+	if err != nil {
+		return err
+	}
+	opts, err := conf.GetAPIOptions()
+	if err != nil {
+		return err
+	}
+	out, err := conf.GetOutputOptions()
+	if err != nil {
+		return err
+	}
+	sinkConfigs, err := conf.GetSinkConfigs()
+	if err != nil {
+		return err
+	}
+	shipper, err := sink.NewShipper(st, sinkConfigs)
+	if err != nil {
+		return err
+	}
+	jobs, err := conf.GetJobs()
+	if err != nil {
+		return err
+	}
+	if err := server.Reconfigure(out, shipper, conf.Snapshot(), opts.RequireTokens); err != nil {
+		return err
+	}
+	if err := server.UseJobs(jobs); err != nil {
+		return err
+	}
+	return telemetry.ConfigureLogging(logOpts)
+}
+
+// configureAccess sets up the authentication methods configured besides API keys
+func configureAccess(ctx context.Context, server *api.Server, opts api.Options) error {
+	if opts.TLS.ClientCA != "" {
+		server.Authenticators = append(server.Authenticators, &api.MTLS{
+			Roles:       opts.Access.MTLS.Roles,
+			DefaultRole: opts.Access.MTLS.DefaultRole,
+		})
+	}
+	if opts.Access.OIDC.Issuer != "" {
+		// Keys are refreshed for as long as the server runs, not just until the first signal
+		oidcAuth, err := api.NewOIDC(context.WithoutCancel(ctx), opts.Access.OIDC)
+		if err != nil {
+			return err
+		}
+		server.Authenticators = append(server.Authenticators, oidcAuth)
+	}
+	if len(server.Authenticators) == 0 {
+		log.Info("Only API keys are accepted, create one with 'gosling apikey create'")
+	}
+	return nil
+}
+
+// healthcheck probes /healthz of the server running in the same container, for images
+// without a shell or curl
+func healthcheck() error {
+	conf.InitConfig("")
+	opts, err := conf.GetAPIOptions()
+	if err != nil {
+		return err
+	}
+	_, port, err := net.SplitHostPort(opts.Address)
+	if err != nil {
+		return fmt.Errorf("invalid api.address: %w", err)
+	}
+	scheme := "http"
+	client := &http.Client{Timeout: 5 * time.Second}
+	if opts.TLS.Cert != "" {
+		// The certificate names the service, not the loopback address, and the probe only
+		// checks that this container's server answers
+		scheme = "https"
+		client.Transport = &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}} //nolint:gosec // loopback probe
+	}
+	resp, err := client.Get(scheme + "://" + net.JoinHostPort("localhost", port) + "/healthz")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("health check failed: %s", resp.Status)
 	}
+	return nil
 }
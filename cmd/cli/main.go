@@ -3,15 +3,17 @@ package main
 import (
 	"context"
 	"fmt"
-	"time"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/arustydev/goslings/internal/app/cli/cmd"
 )
 
 func main() {
 	cmd.Goodbye("from the cli!")
-	// Create a context with timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	// Interrupting a command cancels its context, a honk run can take longer than any timeout
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer cancel()
 
 	cmd.Execute(ctx)
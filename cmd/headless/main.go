@@ -1,28 +1,189 @@
 package main
 
 import (
-	"fmt"
+	"context"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
-	"github.com/arustydev/goslings/internal/app/cli/cmd"
-	"github.com/arustydev/goslings/internal/app/tui"
+	"github.com/arustydev/goslings/internal/app/headless"
+	"github.com/arustydev/goslings/internal/auth"
+	"github.com/arustydev/goslings/internal/auth/store"
+	"github.com/arustydev/goslings/internal/conf"
+	"github.com/arustydev/goslings/internal/sink"
+	"github.com/arustydev/goslings/internal/utils/telemetry"
 	log "github.com/sirupsen/logrus"
 )
 
 func main() {
-	log.SetFormatter(&log.JSONFormatter{})
-	p := tui.NewTui()
-	if _, err := p.Run(); err != nil {
-		fmt.Printf("Alas, there's been an error: %v", err)
-		os.Exit(1)
+	_ = telemetry.ConfigureLogging(telemetry.LogOptions{Format: telemetry.FormatJSON})
+	os.Exit(run())
+}
+
+// run configures the daemon from brood.yaml and the environment, then runs it until it is
+// done or stopped. The first SIGTERM or SIGINT finishes the page being read; a second one,
+// or the shutdown timeout, aborts it. Config file changes, and SIGHUP, reconfigure it.
+func run() int {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	conf.InitConfig("")
+	logOpts, err := conf.GetLogOptions(telemetry.FormatJSON)
+	if err == nil {
+		err = telemetry.ConfigureLogging(logOpts)
+	}
+	if err != nil {
+		log.Errorf("Invalid log configuration: %v", err)
+		return headless.ExitConfig
+	}
+	traceOpts, err := conf.GetTraceOptions("goslings-headless")
+	if err != nil {
+		log.Errorf("Invalid trace configuration: %v", err)
+		return headless.ExitConfig
+	}
+	stopTracing, err := telemetry.ConfigureTracing(ctx, traceOpts)
+	if err != nil {
+		log.Errorf("Invalid trace configuration: %v", err)
+		return headless.ExitConfig
+	}
+	defer stopTracing()
+	opts, err := conf.GetHeadlessOptions()
+	if err != nil {
+		log.Errorf("Invalid headless configuration: %v", err)
+		return headless.ExitConfig
+	}
+	out, err := conf.GetOutputOptions()
+	if err != nil {
+		log.Errorf("Invalid output configuration: %v", err)
+		return headless.ExitConfig
+	}
+	storeOpts, err := conf.GetStoreOptions()
+	if err != nil {
+		log.Errorf("Invalid store configuration: %v", err)
+		return headless.ExitConfig
+	}
+	sinkConfigs, err := conf.GetSinkConfigs()
+	if err != nil {
+		log.Errorf("Invalid sink configuration: %v", err)
+		return headless.ExitConfig
+	}
+	jobs, err := conf.GetJobs()
+	if err != nil {
+		log.Errorf("Invalid schedule configuration: %v", err)
+		return headless.ExitConfig
+	}
+	stopAudit, err := conf.ConfigureAudit()
+	if err != nil {
+		log.Errorf("Invalid audit configuration: %v", err)
+		return headless.ExitConfig
+	}
+	defer stopAudit()
+
+	if opts.MetricsAddress != "" {
+		if err := telemetry.ServeMetrics(ctx, opts.MetricsAddress); err != nil {
+			log.Errorf("Invalid headless configuration: %v", err)
+			return headless.ExitConfig
+		}
+	}
+
+	authManager, err := auth.NewAuthManager(ctx, storeOpts)
+	if err != nil {
+		log.Errorf("Failed to create auth manager: %v", err)
+		return headless.ExitConfig
+	}
+	params := conf.GetAuthConfig()
+	method := auth.AppAuthMethod(opts.Auth)
+	if method == "" {
+		method = auth.ManagedIdentity
+		if params.ClientSecret != "" {
+			method = auth.AppSecret
+		}
 	}
-	fmt.Println(Hello("name string"))
+	cred, err := auth.NewAppCredential(params, method)
+	if err != nil {
+		log.Errorf("Invalid app-only authentication: %v", err)
+		return headless.ExitConfig
+	}
+	if err := authManager.UseCredential(ctx, cred, params); err != nil {
+		log.Errorf("Failed to authenticate with %s: %v", method, err)
+		return headless.ExitAuth
+	}
+
+	daemon, err := headless.NewDaemon(opts, out, authManager, authManager.Store, params.UsGovernment)
+	if err != nil {
+		log.Errorf("Invalid headless configuration: %v", err)
+		return headless.ExitConfig
+	}
+	daemon.Tenant = params.TenantID
+	daemon.Identity = params.Identity()
+	daemon.Settings = conf.Snapshot()
+	if len(jobs) > 0 {
+		if err := daemon.UseJobs(authManager.Store, jobs); err != nil {
+			log.Errorf("Invalid schedule configuration: %v", err)
+			return headless.ExitConfig
+		}
+	}
+	if daemon.Shipper, err = sink.NewShipper(authManager.Store, sinkConfigs); err != nil {
+		log.Errorf("Invalid sink configuration: %v", err)
+		return headless.ExitConfig
+	}
+	err = conf.Watch(ctx, func([]conf.Change) error {
+		return reconfigure(daemon, authManager.Store)
+	})
+	if err != nil {
+		log.Warnf("Configuration changes need a restart: %v", err)
+	}
+
+	stop := make(chan struct{})
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	defer signal.Stop(signals)
+	go func() {
+		sig := <-signals
+		log.Infof("Received %s, finishing the current page", sig)
+		close(stop)
+		select {
+		case sig = <-signals:
+			log.Warnf("Received %s again, aborting", sig)
+		case <-time.After(opts.ShutdownTimeout):
+			log.Warnf("Shutdown timeout of %s reached, aborting", opts.ShutdownTimeout)
+		case <-ctx.Done():
+		}
+		cancel()
+	}()
+
+	return daemon.Run(ctx, stop)
 }
 
-// Hello returns a greeting for the named person.
-func Hello(name string) string {
-	// Return a greeting that embeds the name in a message.
-	message := fmt.Sprintf("Hi, %v. Welcome!", name)
-	cmd.Goodbye(name)
-	return message
+// reconfigure applies a reloaded configuration to the daemon
+func reconfigure(daemon *headless.Daemon, st store.Store) error {
+	logOpts, err := conf.GetLogOptions(telemetry.FormatJSON)
+	if err != nil {
+		return err
+	}
+	opts, err := conf.GetHeadlessOptions()
+	if err != nil {
+		return err
+	}
+	out, err := conf.GetOutputOptions()
+	if err != nil {
+		return err
+	}
+	sinkConfigs, err := conf.GetSinkConfigs()
+	if err != nil {
+		return err
+	}
+	jobs, err := conf.GetJobs()
+	if err != nil {
+		return err
+	}
+	shipper, err := sink.NewShipper(st, sinkConfigs)
+	if err != nil {
+		return err
+	}
+	if err := daemon.Reconfigure(opts, out, shipper, jobs, conf.Snapshot()); err != nil {
+		return err
+	}
+	return telemetry.ConfigureLogging(logOpts)
 }
@@ -1,12 +1,16 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
 
-	"github.com/arustydev/goslings/internal/app/cli/cmd"
 	"github.com/arustydev/goslings/internal/app/tui"
-	log "github.com/sirupsen/logrus"
+	"github.com/arustydev/goslings/internal/auth"
+	"github.com/arustydev/goslings/internal/conf"
+	"github.com/arustydev/goslings/internal/utils/telemetry"
+	"github.com/arustydev/goslings/pkg/client"
+	flag "github.com/spf13/pflag"
 )
 
 // https://www.taranveerbains.ca/blog/13-making-a-tui-with-go
@@ -14,18 +18,78 @@ import (
 // https://github.com/charmbracelet/lipgloss
 // https://github.com/charmbracelet/bubbles
 func main() {
-	p := tui.NewTui()
-	if _, err := p.Run(); err != nil {
+	flag.String("remote", "", "URL of the API server to connect to, instead of this machine")
+	out := flag.String("out", "./output", "Directory collection runs are written to, and downloaded to with --remote")
+	config := flag.String("config", "", "Config file overriding the system, user and project ones (or "+conf.ConfigEnv+")")
+	flag.String("profile", "", "Profile of the tenant to investigate (profile)")
+	flag.Parse()
+	conf.BindFlag("remote.url", flag.Lookup("remote"))
+	conf.BindFlag("profile", flag.Lookup("profile"))
+
+	conf.InitConfig(*config)
+	if opts, err := conf.GetLogOptions(telemetry.FormatText); err != nil {
+		fmt.Fprintf(os.Stderr, "Keeping the default logging: %v\n", err)
+	} else if err := telemetry.ConfigureLogging(opts); err != nil {
+		fmt.Fprintf(os.Stderr, "Keeping the default logging: %v\n", err)
+	}
+	stopTracing := func() {}
+	if opts, err := conf.GetTraceOptions("goslings-tui"); err != nil {
+		fmt.Fprintf(os.Stderr, "Tracing is off: %v\n", err)
+	} else if stop, err := telemetry.ConfigureTracing(context.Background(), opts); err != nil {
+		fmt.Fprintf(os.Stderr, "Tracing is off: %v\n", err)
+	} else {
+		stopTracing = stop
+	}
+	stopAudit := func() {}
+	if stop, err := conf.ConfigureAudit(); err != nil {
+		fmt.Fprintf(os.Stderr, "Auditing is off: %v\n", err)
+	} else {
+		stopAudit = stop
+	}
+	backend, err := newBackend(context.Background(), *out)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Alas, there's been an error: %v\n", err)
+		stopAudit()
+		stopTracing()
+		os.Exit(1)
+	}
+
+	p := tui.NewTui(backend, *out)
+	_, err = p.Run()
+	stopAudit()
+	stopTracing()
+	if err != nil {
 		fmt.Printf("Alas, there's been an error: %v", err)
 		os.Exit(1)
 	}
-	log.Println("Hello logrus!")
-	fmt.Println(Hello("name string"))
 }
 
-func Hello(name string) string {
-	// Return a greeting that embeds the name in a message.
-	message := fmt.Sprintf("Hi, %v. Welcome!", name)
-	cmd.Goodbye(name)
-	return message
+// newBackend connects to the configured API server, or reads the runs of this machine
+func newBackend(ctx context.Context, out string) (tui.Backend, error) {
+	if opts := conf.GetRemoteOptions(); opts.URL != "" {
+		c, err := client.New(opts)
+		if err != nil {
+			return nil, err
+		}
+		return &tui.Remote{Client: c}, nil
+	}
+	storeOpts, err := conf.GetStoreOptions()
+	if err != nil {
+		return nil, err
+	}
+	authManager, err := auth.NewAuthManager(ctx, storeOpts)
+	if err != nil {
+		return nil, err
+	}
+	output, err := conf.GetOutputOptions()
+	if err != nil {
+		return nil, err
+	}
+	return &tui.Local{
+		Auth:     authManager,
+		Params:   conf.GetAuthConfig(),
+		Out:      out,
+		Output:   output,
+		Settings: conf.Snapshot(),
+	}, nil
 }
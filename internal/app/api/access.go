@@ -0,0 +1,186 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/arustydev/goslings/internal/audit"
+	"github.com/arustydev/goslings/pkg/apiv1"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// Common errors
+var (
+	ErrUnauthenticated = errors.New("unauthenticated")
+	ErrForbidden       = errors.New("forbidden")
+	ErrInvalidRole     = apiv1.ErrInvalidRole
+)
+
+// Role grants access to a set of /v1 endpoints, every role includes the ones below it
+type Role = apiv1.Role
+
+const (
+	Viewer   = apiv1.Viewer
+	Operator = apiv1.Operator
+	Admin    = apiv1.Admin
+)
+
+// ParseRole checks the name of a role, case-insensitively
+func ParseRole(name string) (Role, error) {
+	return apiv1.ParseRole(name)
+}
+
+// Principal is who made a request
+type Principal = apiv1.Principal
+
+// Authenticator identifies the caller of a request with one authentication method
+type Authenticator interface {
+	// Authenticate returns nil without an error when the request carries no credential for
+	// the method, and an error when it carries an invalid one
+	Authenticate(r *http.Request) (*Principal, error)
+}
+
+// AuditEvent records a request that was refused
+type AuditEvent struct {
+	Time   time.Time `json:"time"`
+	Action string    `json:"action"`
+	Method string    `json:"method"`
+	Path   string    `json:"path"`
+	Client string    `json:"client"`
+
+	// Principal is who was refused, nil when the caller could not be authenticated
+	Principal *Principal `json:"principal,omitempty"`
+
+	// Required is the role the endpoint needs
+	Required Role   `json:"required,omitempty"`
+	Reason   string `json:"reason"`
+}
+
+// Audit actions
+const (
+	AuditUnauthenticated = "api.unauthenticated"
+	AuditForbidden       = "api.forbidden"
+)
+
+// logAudit writes audit events to the log, marked with an audit field
+func logAudit(e AuditEvent) {
+	entry := log.WithFields(log.Fields{
+		"audit":    true,
+		"action":   e.Action,
+		"method":   e.Method,
+		"path":     e.Path,
+		"client":   e.Client,
+		"required": e.Required,
+	})
+	if e.Principal != nil {
+		entry = entry.WithFields(log.Fields{
+			"subject":     e.Principal.Subject,
+			"auth_method": e.Principal.Method,
+			"role":        e.Principal.Role,
+		})
+	}
+	entry.Warnf("Refused request: %s", e.Reason)
+}
+
+// principalKey is the gin context key of the request's principal
+const principalKey = "principal"
+
+// RequestPrincipal returns the principal of an authenticated request
+func RequestPrincipal(c *gin.Context) *Principal {
+	p, _ := c.Get(principalKey)
+	principal, _ := p.(*Principal)
+	return principal
+}
+
+// authenticate identifies the caller with the first authenticator that finds a credential;
+// a request without any credential, or with an invalid one, is refused
+func (s *Server) authenticate() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		for _, a := range s.authenticators() {
+			p, err := a.Authenticate(c.Request)
+			if err != nil {
+				s.refuse(c, http.StatusUnauthorized, AuditUnauthenticated, nil, "", err.Error())
+				return
+			}
+			if p != nil {
+				c.Set(principalKey, p)
+				c.Request = c.Request.WithContext(audit.WithActor(c.Request.Context(), p.Actor()))
+				c.Next()
+				return
+			}
+		}
+		s.refuse(c, http.StatusUnauthorized, AuditUnauthenticated, nil, "", "no credentials")
+	}
+}
+
+// auditRequests records every request once it has been served, as done by its principal or
+// else by its client
+func auditRequests() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+		actor := "anonymous@" + c.ClientIP()
+		if p := RequestPrincipal(c); p != nil {
+			actor = p.Actor()
+		}
+		var err error
+		if c.Writer.Status() >= http.StatusBadRequest {
+			err = errors.New(http.StatusText(c.Writer.Status()))
+		}
+		audit.Record(c.Request.Context(), audit.Event{
+			Actor:  actor,
+			Action: audit.ActionAPIRequest,
+			Target: c.Request.Method + " " + c.Request.URL.Path,
+			Details: map[string]string{
+				"client": c.ClientIP(),
+				"status": strconv.Itoa(c.Writer.Status()),
+			},
+		}, err)
+	}
+}
+
+// authenticators are the configured authenticators followed by the API keys of the store
+func (s *Server) authenticators() []Authenticator {
+	authenticators := append([]Authenticator{}, s.Authenticators...)
+	if s.keys != nil {
+		authenticators = append(authenticators, s.keys)
+	}
+	return authenticators
+}
+
+// allow refuses principals whose role does not include required
+func (s *Server) allow(required Role) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		p := RequestPrincipal(c)
+		if p == nil || !p.Role.Allows(required) {
+			reason := fmt.Sprintf("%s role required", required)
+			s.refuse(c, http.StatusForbidden, AuditForbidden, p, required, reason)
+			return
+		}
+		c.Next()
+	}
+}
+
+// refuse audits a refused request and aborts it; the response does not say why authentication
+// failed
+func (s *Server) refuse(c *gin.Context, status int, action string, p *Principal, required Role, reason string) {
+	s.Audit(AuditEvent{
+		Time:      time.Now().UTC(),
+		Action:    action,
+		Method:    c.Request.Method,
+		Path:      c.Request.URL.Path,
+		Client:    c.ClientIP(),
+		Principal: p,
+		Required:  required,
+		Reason:    reason,
+	})
+	err := ErrForbidden
+	if status == http.StatusUnauthorized {
+		err = ErrUnauthenticated
+		c.Header("WWW-Authenticate", `Bearer realm="goslings"`)
+	}
+	fail(c, status, err)
+}
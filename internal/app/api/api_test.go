@@ -0,0 +1,914 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"maps"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/arustydev/goslings/internal/audit"
+	"github.com/arustydev/goslings/internal/auth"
+	"github.com/arustydev/goslings/internal/auth/shared"
+	"github.com/arustydev/goslings/internal/collect"
+	"github.com/arustydev/goslings/internal/output"
+	"github.com/arustydev/goslings/internal/schedule"
+	"github.com/arustydev/goslings/pkg/apiv1"
+	"github.com/gin-gonic/gin"
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+// fakeCredential issues tokens named after their scope; with a prompt it first relays a
+// device code and waits for signedIn
+type fakeCredential struct {
+	prompt   auth.DevicePrompt
+	signedIn chan struct{}
+}
+
+func (f *fakeCredential) GetToken(ctx context.Context, opts policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	if f.prompt != nil {
+		if err := f.prompt(ctx, azidentity.DeviceCodeMessage{UserCode: "ABCD-EFGH", VerificationURL: "https://microsoft.com/devicelogin"}); err != nil {
+			return azcore.AccessToken{}, err
+		}
+		f.prompt = nil
+		<-f.signedIn
+	}
+	return azcore.AccessToken{Token: opts.Scopes[0], ExpiresOn: time.Now().Add(time.Hour)}, nil
+}
+
+// rewriteTransport sends every request to a test server
+type rewriteTransport struct {
+	target string
+}
+
+func (t rewriteTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r.URL.Scheme = "http"
+	r.URL.Host = strings.TrimPrefix(t.target, "http://")
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	os.Exit(m.Run())
+}
+
+func newServer(t *testing.T, graph http.HandlerFunc) *Server {
+	t.Helper()
+	a, err := auth.NewAuthManager(t.Context(), auth.Options{StoreType: shared.MemoryStore})
+	require.NoError(t, err)
+	s := New(a, &shared.AuthParams{TenantID: "contoso", ClientID: "app"}, t.TempDir(), output.Options{})
+	s.PollInterval = 10 * time.Millisecond
+	s.Authenticators = []Authenticator{static{&Principal{Subject: "test", Method: "test", Role: Admin}}}
+	s.NewCredential = func(method string, params *shared.AuthParams, prompt auth.DevicePrompt) (azcore.TokenCredential, error) {
+		return &fakeCredential{}, nil
+	}
+	if graph != nil {
+		srv := httptest.NewServer(graph)
+		t.Cleanup(srv.Close)
+		s.Client = &http.Client{Transport: rewriteTransport{target: srv.URL}}
+	}
+	t.Cleanup(func() { _ = s.Shutdown(context.Background()) })
+	return s
+}
+
+// static authenticates every request as its principal
+type static struct {
+	p *Principal
+}
+
+func (a static) Authenticate(r *http.Request) (*Principal, error) {
+	return a.p, nil
+}
+
+// call serves a request with a JSON body, decoding a JSON response into out
+func call(t *testing.T, s *Server, method, path string, body any, out any) *httptest.ResponseRecorder {
+	t.Helper()
+	return callAs(t, s, "", method, path, body, out)
+}
+
+// callAs serves a request with a bearer token
+func callAs(t *testing.T, s *Server, token, method, path string, body any, out any) *httptest.ResponseRecorder {
+	t.Helper()
+	var r io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		require.NoError(t, err)
+		r = bytes.NewReader(data)
+	}
+	req := httptest.NewRequest(method, path, r)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if out != nil {
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), out), w.Body.String())
+	}
+	return w
+}
+
+func authenticate(t *testing.T, s *Server) {
+	t.Helper()
+	var st AuthStatus
+	w := call(t, s, http.MethodPost, "/v1/auth", AuthRequest{Method: string(auth.AppSecret), ClientSecret: "secret"}, &st)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, AuthAuthenticated, st.State)
+}
+
+// waitFor polls a job until it has a status other than running or cancelling
+func waitFor(t *testing.T, s *Server, id string) Job {
+	t.Helper()
+	var j Job
+	require.Eventually(t, func() bool {
+		call(t, s, http.MethodGet, "/v1/jobs/"+id, nil, &j)
+		return j.Status != JobRunning && j.Status != JobCancelling
+	}, 5*time.Second, 10*time.Millisecond)
+	return j
+}
+
+func TestAuthWithAppCredential(t *testing.T) {
+	s := newServer(t, nil)
+	var st AuthStatus
+	call(t, s, http.MethodGet, "/v1/auth", nil, &st)
+	assert.Equal(t, AuthNone, st.State)
+
+	authenticate(t, s)
+	call(t, s, http.MethodGet, "/v1/auth", nil, &st)
+	assert.Equal(t, "contoso", st.Tenant)
+	assert.Equal(t, "app:app", st.Identity)
+	assert.ElementsMatch(t, []auth.Service{auth.GraphService, auth.AzureService, auth.M365Service}, st.Services)
+
+	var tenants []Tenant
+	call(t, s, http.MethodGet, "/v1/tenants", nil, &tenants)
+	assert.Equal(t, []Tenant{{ID: "contoso", Configured: true, Authenticated: true}}, tenants)
+
+	assert.Equal(t, http.StatusNoContent, call(t, s, http.MethodDelete, "/v1/auth", nil, nil).Code)
+	call(t, s, http.MethodGet, "/v1/auth", nil, &st)
+	assert.Equal(t, AuthNone, st.State)
+}
+
+func TestDeviceCodeIsRelayed(t *testing.T) {
+	s := newServer(t, nil)
+	signedIn := make(chan struct{})
+	s.NewCredential = func(method string, params *shared.AuthParams, prompt auth.DevicePrompt) (azcore.TokenCredential, error) {
+		require.Equal(t, DeviceCode, method)
+		return &fakeCredential{prompt: prompt, signedIn: signedIn}, nil
+	}
+
+	var st AuthStatus
+	w := call(t, s, http.MethodPost, "/v1/auth", AuthRequest{Method: DeviceCode}, &st)
+	require.Equal(t, http.StatusAccepted, w.Code)
+	assert.Equal(t, AuthPending, st.State)
+	assert.Equal(t, "ABCD-EFGH", st.UserCode)
+
+	// A second sign-in cannot start while the first is pending
+	assert.Equal(t, http.StatusConflict, call(t, s, http.MethodPost, "/v1/auth", AuthRequest{Method: DeviceCode}, nil).Code)
+
+	// Whoever holds the code can sign the server in, so viewers are not shown it
+	s.Authenticators = nil
+	admin, _, err := s.keys.Create(t.Context(), "admin", Admin)
+	require.NoError(t, err)
+	viewer, _, err := s.keys.Create(t.Context(), "viewer", Viewer)
+	require.NoError(t, err)
+	var seen AuthStatus
+	callAs(t, s, viewer, http.MethodGet, "/v1/auth", nil, &seen)
+	assert.Equal(t, AuthPending, seen.State)
+	assert.Empty(t, seen.UserCode)
+	assert.Empty(t, seen.VerificationURL)
+	seen = AuthStatus{}
+	callAs(t, s, admin, http.MethodGet, "/v1/auth", nil, &seen)
+	assert.Equal(t, "ABCD-EFGH", seen.UserCode)
+	s.Authenticators = []Authenticator{static{&Principal{Subject: "test", Method: "test", Role: Admin}}}
+
+	close(signedIn)
+	require.Eventually(t, func() bool {
+		st = AuthStatus{}
+		call(t, s, http.MethodGet, "/v1/auth", nil, &st)
+		return st.State == AuthAuthenticated
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Empty(t, st.UserCode)
+}
+
+func TestJobCollectsAndServesArtifacts(t *testing.T) {
+	s := newServer(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"value":[{"id":"1"},{"id":"2"}]}`)
+	})
+
+	// Jobs need the server to be authenticated
+	assert.Equal(t, http.StatusConflict, call(t, s, http.MethodPost, "/v1/jobs", JobRequest{}, nil).Code)
+	authenticate(t, s)
+
+	var j Job
+	w := call(t, s, http.MethodPost, "/v1/jobs", JobRequest{Datasets: []string{collect.EntraSignIns}, Since: "24h"}, &j)
+	require.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+	assert.Equal(t, "/v1/jobs/"+j.ID, w.Header().Get("Location"))
+
+	j = waitFor(t, s, j.ID)
+	assert.Equal(t, JobCompleted, j.Status)
+	assert.Equal(t, "contoso", j.Tenant)
+	assert.Equal(t, int64(2), j.Items)
+
+	var artifacts []Artifact
+	call(t, s, http.MethodGet, "/v1/jobs/"+j.ID+"/artifacts", nil, &artifacts)
+	var data *Artifact
+	for i, a := range artifacts {
+		if strings.HasSuffix(a.Path, ".jsonl") {
+			data = &artifacts[i]
+		}
+	}
+	require.NotNil(t, data, artifacts)
+	assert.NotEmpty(t, data.SHA256)
+
+	w = call(t, s, http.MethodGet, "/v1/jobs/"+j.ID+"/artifacts/"+data.Path, nil, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, 2, strings.Count(w.Body.String(), "\n"))
+	assert.Equal(t, http.StatusNotFound, call(t, s, http.MethodGet, "/v1/jobs/"+j.ID+"/artifacts/../../etc/passwd", nil, nil).Code)
+
+	var jobs []Job
+	call(t, s, http.MethodGet, "/v1/jobs?tenant=contoso", nil, &jobs)
+	assert.Len(t, jobs, 1)
+	call(t, s, http.MethodGet, "/v1/jobs?tenant=fabrikam", nil, &jobs)
+	assert.Empty(t, jobs)
+
+	// The stream of a finished job is its final state
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+	resp, err := srv.Client().Get(srv.URL + "/v1/jobs/" + j.ID + "/progress")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	stream, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	assert.Equal(t, 1, strings.Count(string(stream), "event:progress"))
+	assert.Contains(t, string(stream), `"status":"completed"`)
+}
+
+func TestCancelledJobCanBeResumed(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	s := newServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("$skiptoken") == "" {
+			close(started)
+			<-release
+			fmt.Fprint(w, `{"value":[{"id":"1"}],"@odata.nextLink":"https://graph.microsoft.com/v1.0/auditLogs/signIns?$skiptoken=2"}`)
+			return
+		}
+		fmt.Fprint(w, `{"value":[{"id":"2"}]}`)
+	})
+	authenticate(t, s)
+
+	var j Job
+	call(t, s, http.MethodPost, "/v1/jobs", JobRequest{Datasets: []string{collect.EntraSignIns}}, &j)
+	<-started
+	w := call(t, s, http.MethodDelete, "/v1/jobs/"+j.ID, nil, &j)
+	require.Equal(t, http.StatusAccepted, w.Code)
+	assert.Equal(t, JobCancelling, j.Status)
+	close(release)
+
+	// The page being read is written before the job stops
+	j = waitFor(t, s, j.ID)
+	assert.Equal(t, JobCancelled, j.Status)
+	assert.Equal(t, int64(1), j.Items)
+	assert.Equal(t, http.StatusConflict, call(t, s, http.MethodDelete, "/v1/jobs/"+j.ID, nil, nil).Code)
+
+	w = call(t, s, http.MethodPost, "/v1/jobs", JobRequest{Resume: j.ID}, nil)
+	require.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+	j = waitFor(t, s, j.ID)
+	assert.Equal(t, JobCompleted, j.Status)
+	assert.Equal(t, int64(2), j.Items)
+}
+
+func TestFinishedJobsAreEvicted(t *testing.T) {
+	s := newServer(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"value":[{"id":"1"}]}`)
+	})
+	s.MaxJobs = 1
+	authenticate(t, s)
+
+	var ids []string
+	for range 3 {
+		var j Job
+		w := call(t, s, http.MethodPost, "/v1/jobs", JobRequest{Datasets: []string{collect.EntraSignIns}}, &j)
+		require.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+		waitFor(t, s, j.ID)
+		ids = append(ids, j.ID)
+	}
+
+	s.mu.Lock()
+	assert.Len(t, s.jobs, 2, "the last finished job and the one started after it are kept")
+	assert.NotContains(t, s.jobs, ids[0])
+	s.mu.Unlock()
+
+	// An evicted job is described from its checkpoint, but its events are gone
+	var j Job
+	require.Equal(t, http.StatusOK, call(t, s, http.MethodGet, "/v1/jobs/"+ids[0], nil, &j).Code)
+	assert.Equal(t, JobCompleted, j.Status)
+	var jobs []Job
+	call(t, s, http.MethodGet, "/v1/jobs", nil, &jobs)
+	assert.Len(t, jobs, 3)
+	assert.Equal(t, http.StatusNotFound, call(t, s, http.MethodGet, "/v1/jobs/"+ids[0]+"/events", nil, nil).Code)
+
+	// Jobs that finished longer ago than the retention are evicted whatever their number
+	s.MaxJobs = 0
+	s.JobRetention = time.Nanosecond
+	w := call(t, s, http.MethodPost, "/v1/jobs", JobRequest{Datasets: []string{collect.EntraSignIns}}, &j)
+	require.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+	waitFor(t, s, j.ID)
+	s.mu.Lock()
+	assert.Equal(t, []string{j.ID}, slices.Collect(maps.Keys(s.jobs)))
+	s.mu.Unlock()
+}
+
+func TestAuthCannotChangeWhileJobsRun(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	s := newServer(t, func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		fmt.Fprint(w, `{"value":[{"id":"1"}]}`)
+	})
+	authenticate(t, s)
+
+	var j Job
+	call(t, s, http.MethodPost, "/v1/jobs", JobRequest{Datasets: []string{collect.EntraSignIns}}, &j)
+	<-started
+
+	// The running job would collect another tenant under the label of contoso
+	var e Error
+	w := call(t, s, http.MethodPost, "/v1/auth", AuthRequest{Method: string(auth.AppSecret), TenantID: "fabrikam", ClientSecret: "secret"}, &e)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Contains(t, e.Error, j.ID)
+	assert.Equal(t, http.StatusConflict, call(t, s, http.MethodDelete, "/v1/auth", nil, nil).Code)
+	var st AuthStatus
+	call(t, s, http.MethodGet, "/v1/auth", nil, &st)
+	assert.Equal(t, "contoso", st.Tenant)
+
+	close(release)
+	j = waitFor(t, s, j.ID)
+	assert.Equal(t, JobCompleted, j.Status)
+	w = call(t, s, http.MethodPost, "/v1/auth", AuthRequest{Method: string(auth.AppSecret), TenantID: "fabrikam", ClientSecret: "secret"}, nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	// A job of contoso cannot be resumed once the server is authenticated to fabrikam
+	cp, err := s.loadJob(j.ID)
+	require.NoError(t, err)
+	_, err = s.start(cp, s.Params, false, "")
+	assert.ErrorIs(t, err, auth.ErrNotAuthenticated)
+	assert.Equal(t, http.StatusNoContent, call(t, s, http.MethodDelete, "/v1/auth", nil, nil).Code)
+}
+
+func TestJobEventsAreReplayable(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	s := newServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("$skiptoken") == "" {
+			close(started)
+			<-release
+			fmt.Fprint(w, `{"value":[{"id":"1"}],"@odata.nextLink":"https://graph.microsoft.com/v1.0/auditLogs/signIns?$skiptoken=2"}`)
+			return
+		}
+		fmt.Fprint(w, `{"value":[{"id":"2"}]}`)
+	})
+	authenticate(t, s)
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+	stream := func(query string, header http.Header) []JobEvent {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodGet, srv.URL+query, nil)
+		require.NoError(t, err)
+		for key, values := range header {
+			req.Header[key] = values
+		}
+		resp, err := srv.Client().Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		return readEvents(t, resp.Body)
+	}
+
+	var j Job
+	call(t, s, http.MethodPost, "/v1/jobs", JobRequest{Datasets: []string{collect.EntraSignIns}}, &j)
+	<-started
+	// A live stream follows the job until it stops
+	live := make(chan []JobEvent)
+	go func() { live <- stream("/v1/jobs/"+j.ID+"/events", nil) }()
+	call(t, s, http.MethodDelete, "/v1/jobs/"+j.ID, nil, nil)
+	close(release)
+	first := <-live
+	require.NotEmpty(t, first)
+	assert.Equal(t, EventJobStarted, first[0].Type)
+	last := first[len(first)-1]
+	assert.Equal(t, EventJobFinished, last.Type)
+	assert.Equal(t, string(JobCancelled), last.Status)
+	for i, e := range first {
+		assert.Equal(t, int64(i), e.Offset)
+		assert.Equal(t, j.ID, e.RunID)
+	}
+	assert.Contains(t, first, JobEvent{Offset: 2, Event: collect.Event{
+		Type: collect.EventPageFetched, Time: first[2].Time, RunID: j.ID, Dataset: collect.EntraSignIns, Page: 1, Items: 1, Total: 1,
+	}})
+
+	// Offsets carry on when the job is resumed
+	call(t, s, http.MethodPost, "/v1/jobs", JobRequest{Resume: j.ID}, nil)
+	waitFor(t, s, j.ID)
+	resumed := stream("/v1/jobs/"+j.ID+"/events", http.Header{"Last-Event-ID": {strconv.FormatInt(last.Offset, 10)}})
+	require.NotEmpty(t, resumed)
+	assert.Equal(t, last.Offset+1, resumed[0].Offset)
+	assert.Equal(t, EventJobStarted, resumed[0].Type)
+	assert.Equal(t, string(JobCompleted), resumed[len(resumed)-1].Status)
+
+	all := stream("/v1/jobs/"+j.ID+"/events?offset=0", nil)
+	assert.Equal(t, append(first, resumed...), all)
+	assert.Equal(t, http.StatusBadRequest, call(t, s, http.MethodGet, "/v1/jobs/"+j.ID+"/events?offset=-1", nil, nil).Code)
+}
+
+// readEvents decodes the data of a server-sent event stream, checking that each event is
+// named after its type and identified by its offset
+func readEvents(t *testing.T, r io.Reader) []JobEvent {
+	t.Helper()
+	var events []JobEvent
+	var id, name string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		field, value, _ := strings.Cut(scanner.Text(), ":")
+		switch field {
+		case "id":
+			id = value
+		case "event":
+			name = value
+		case "data":
+			var e JobEvent
+			require.NoError(t, json.Unmarshal([]byte(value), &e))
+			assert.Equal(t, strconv.FormatInt(e.Offset, 10), id)
+			assert.Equal(t, string(e.Type), name)
+			events = append(events, e)
+		}
+	}
+	require.NoError(t, scanner.Err())
+	return events
+}
+
+func TestJobRequestsAreValidated(t *testing.T) {
+	s := newServer(t, nil)
+	authenticate(t, s)
+	for _, req := range []JobRequest{
+		{Datasets: []string{"nope"}},
+		{Since: "yesterday"},
+		{Since: "2026-10-18T00:00:00Z", Until: "2026-10-17T00:00:00Z"},
+		{Output: &apiv1.Output{Format: "xml"}},
+		{Ship: true},
+	} {
+		assert.Equal(t, http.StatusBadRequest, call(t, s, http.MethodPost, "/v1/jobs", req, nil).Code, req)
+	}
+	assert.Equal(t, http.StatusNotFound, call(t, s, http.MethodPost, "/v1/jobs", JobRequest{Resume: ".."}, nil).Code)
+	assert.Equal(t, http.StatusNotFound, call(t, s, http.MethodGet, "/v1/jobs/nope", nil, nil).Code)
+}
+
+func TestScheduledJobsRunAsJobs(t *testing.T) {
+	s := newServer(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"value":[{"id":"1"}]}`)
+	})
+	var schedules []Schedule
+	call(t, s, http.MethodGet, "/v1/schedules", nil, &schedules)
+	assert.Empty(t, schedules)
+
+	assert.ErrorIs(t, s.UseJobs([]schedule.Job{{Name: "signins", Schedule: "@daily", Datasets: []string{"nope"}}}), collect.ErrUnknownDataset)
+	require.NoError(t, s.UseJobs([]schedule.Job{{Name: "signins", Schedule: "@daily", Datasets: []string{collect.EntraSignIns}}}))
+
+	// A run fails while the server is not authenticated to the configured tenant
+	assert.ErrorIs(t, s.Scheduler.Trigger(t.Context(), "signins"), auth.ErrNotAuthenticated)
+	w := call(t, s, http.MethodPost, "/v1/auth", AuthRequest{Method: string(auth.AppSecret), TenantID: "fabrikam", ClientSecret: "secret"}, nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.ErrorContains(t, s.Scheduler.Trigger(t.Context(), "signins"), "the server is authenticated to fabrikam")
+
+	authenticate(t, s)
+	require.NoError(t, s.Scheduler.Trigger(t.Context(), "signins"))
+	var jobs []Job
+	call(t, s, http.MethodGet, "/v1/jobs", nil, &jobs)
+	require.Len(t, jobs, 1)
+	assert.Equal(t, "signins", jobs[0].Schedule)
+	assert.Equal(t, JobCompleted, jobs[0].Status)
+	assert.Equal(t, int64(1), jobs[0].Items)
+
+	call(t, s, http.MethodGet, "/v1/schedules", nil, &schedules)
+	require.Len(t, schedules, 1)
+	assert.Equal(t, "signins", schedules[0].Job)
+	assert.Equal(t, "@daily", schedules[0].Schedule)
+	assert.Equal(t, jobs[0].Datasets[0].Until, schedules[0].Until)
+	assert.Empty(t, schedules[0].LastError)
+	assert.False(t, schedules[0].Next.IsZero())
+
+	// Reloaded jobs replace the scheduled ones
+	require.NoError(t, s.UseJobs(nil))
+	call(t, s, http.MethodGet, "/v1/schedules", nil, &schedules)
+	assert.Empty(t, schedules)
+}
+
+// spec is the part of openapi.yaml checked against the handlers
+type spec struct {
+	Paths      map[string]map[string]yaml.Node `yaml:"paths"`
+	Components struct {
+		Schemas map[string]struct {
+			Properties map[string]yaml.Node `yaml:"properties"`
+		} `yaml:"schemas"`
+	} `yaml:"components"`
+}
+
+func loadSpec(t *testing.T) spec {
+	t.Helper()
+	var sp spec
+	require.NoError(t, yaml.Unmarshal(OpenAPI, &sp))
+	return sp
+}
+
+func TestOpenAPIDescribesEveryRoute(t *testing.T) {
+	sp := loadSpec(t)
+	params := regexp.MustCompile(`[:*](\w+)`)
+	s := New(nil, nil, "", output.Options{})
+
+	var served, specified []string
+	for _, r := range s.router.Routes() {
+		// The probes are not part of the versioned API
+		if !strings.HasPrefix(r.Path, "/v1") {
+			continue
+		}
+		path := params.ReplaceAllString(strings.TrimPrefix(r.Path, "/v1"), "{$1}")
+		served = append(served, r.Method+" "+path+" "+string(s.roles[r.Method+" "+r.Path]))
+	}
+	for path, ops := range sp.Paths {
+		for method, op := range ops {
+			if method == "parameters" {
+				continue
+			}
+			var extensions struct {
+				Role string `yaml:"x-role"`
+			}
+			require.NoError(t, op.Decode(&extensions))
+			specified = append(specified, strings.ToUpper(method)+" "+path+" "+extensions.Role)
+		}
+	}
+	sort.Strings(served)
+	sort.Strings(specified)
+	assert.Equal(t, served, specified)
+}
+
+func TestOpenAPISchemasMatchTypes(t *testing.T) {
+	sp := loadSpec(t)
+	types := map[string]any{
+		"Error":         Error{},
+		"AuthRequest":   AuthRequest{},
+		"AuthStatus":    AuthStatus{},
+		"JobRequest":    JobRequest{},
+		"OutputOptions": output.Options{},
+		"Job":           Job{},
+		"Progress":      collect.Progress{},
+		"Artifact":      Artifact{},
+		"Tenant":        Tenant{},
+		"Schedule":      Schedule{},
+		"Principal":     Principal{},
+		"APIKey":        APIKey{},
+		"KeyRequest":    KeyRequest{},
+		"CreatedKey":    CreatedKey{},
+		"JobEvent":      JobEvent{},
+	}
+	// Role is the only schema that is not an object
+	require.Len(t, sp.Components.Schemas, len(types)+1)
+	for name, v := range types {
+		schema, ok := sp.Components.Schemas[name]
+		require.True(t, ok, name)
+		var properties []string
+		for p := range schema.Properties {
+			properties = append(properties, p)
+		}
+		assert.ElementsMatch(t, jsonFields(reflect.TypeOf(v)), properties, name)
+	}
+}
+
+// jsonFields returns the JSON names of the fields of a struct, including those of embedded structs
+func jsonFields(typ reflect.Type) []string {
+	var fields []string
+	for i := range typ.NumField() {
+		f := typ.Field(i)
+		tag := strings.Split(f.Tag.Get("json"), ",")[0]
+		switch {
+		case f.Anonymous && tag == "":
+			fields = append(fields, jsonFields(f.Type)...)
+		case tag != "" && tag != "-":
+			fields = append(fields, tag)
+		}
+	}
+	return fields
+}
+
+func TestForwardedForIsOnlyTrustedFromProxies(t *testing.T) {
+	s := newServer(t, nil)
+	s.Authenticators = nil
+	var audits []AuditEvent
+	s.Audit = func(e AuditEvent) { audits = append(audits, e) }
+
+	refused := func() string {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/v1/jobs", nil)
+		req.Header.Set("X-Forwarded-For", "203.0.113.7")
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, req)
+		require.Equal(t, http.StatusUnauthorized, w.Code)
+		return audits[len(audits)-1].Client
+	}
+
+	// httptest requests come from 192.0.2.1, which is not a trusted proxy by default
+	assert.Equal(t, "192.0.2.1", refused())
+	require.NoError(t, s.SetTrustedProxies([]string{"192.0.2.0/24"}))
+	assert.Equal(t, "203.0.113.7", refused())
+
+	assert.Error(t, (&Options{TrustedProxies: []string{"not-an-address"}}).Validate())
+}
+
+func TestAPIKeysGrantTheirRole(t *testing.T) {
+	s := newServer(t, nil)
+	s.Authenticators = nil
+	var audits []AuditEvent
+	s.Audit = func(e AuditEvent) { audits = append(audits, e) }
+
+	admin, _, err := s.keys.Create(t.Context(), "bootstrap", Admin)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(admin, KeyPrefix))
+
+	// The spec is public, everything else needs credentials
+	assert.Equal(t, http.StatusOK, call(t, s, http.MethodGet, "/v1/openapi.yaml", nil, nil).Code)
+	assert.Equal(t, http.StatusUnauthorized, call(t, s, http.MethodGet, "/v1/jobs", nil, nil).Code)
+	assert.Equal(t, http.StatusUnauthorized, callAs(t, s, admin+"x", http.MethodGet, "/v1/jobs", nil, nil).Code)
+
+	var created CreatedKey
+	w := callAs(t, s, admin, http.MethodPost, "/v1/keys", KeyRequest{Name: "dashboard", Role: Viewer}, &created)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	viewer := created.Token
+
+	var p Principal
+	callAs(t, s, viewer, http.MethodGet, "/v1/me", nil, &p)
+	assert.Equal(t, Principal{Subject: created.Key.ID, Method: "apikey", Role: Viewer}, p)
+	assert.Equal(t, http.StatusOK, callAs(t, s, viewer, http.MethodGet, "/v1/jobs", nil, nil).Code)
+	assert.Equal(t, http.StatusForbidden, callAs(t, s, viewer, http.MethodPost, "/v1/jobs", JobRequest{}, nil).Code)
+	assert.Equal(t, http.StatusForbidden, callAs(t, s, viewer, http.MethodGet, "/v1/keys", nil, nil).Code)
+
+	// The key is also accepted in the X-API-Key header
+	req := httptest.NewRequest(http.MethodGet, "/v1/tenants", nil)
+	req.Header.Set("X-API-Key", viewer)
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// Only the hash of a key is stored
+	stored, err := s.Auth.Store.LoadState(t.Context(), KeysStateKey)
+	require.NoError(t, err)
+	assert.NotContains(t, string(stored), viewer)
+
+	assert.Equal(t, http.StatusNoContent, callAs(t, s, admin, http.MethodDelete, "/v1/keys/"+created.Key.ID, nil, nil).Code)
+	assert.Equal(t, http.StatusUnauthorized, callAs(t, s, viewer, http.MethodGet, "/v1/jobs", nil, nil).Code)
+
+	require.Len(t, audits, 5)
+	assert.Equal(t, AuditUnauthenticated, audits[0].Action)
+	assert.Equal(t, AuditForbidden, audits[2].Action)
+	assert.Equal(t, Operator, audits[2].Required)
+	assert.Equal(t, created.Key.ID, audits[2].Principal.Subject)
+	assert.Equal(t, "/v1/jobs", audits[2].Path)
+}
+
+func TestActionsAreAudited(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	stop, err := audit.Configure(audit.Options{File: path, Actor: "gosling@test"})
+	require.NoError(t, err)
+	defer stop()
+	s := newServer(t, nil)
+	s.Authenticators = nil
+
+	admin, key, err := s.keys.Create(t.Context(), "bootstrap", Admin)
+	require.NoError(t, err)
+	w := callAs(t, s, admin, http.MethodPost, "/v1/auth", AuthRequest{Method: string(auth.AppSecret), ClientSecret: "secret"}, nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var created CreatedKey
+	w = callAs(t, s, admin, http.MethodPost, "/v1/keys", KeyRequest{Name: "dashboard", Role: Viewer}, &created)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	require.Equal(t, http.StatusForbidden, callAs(t, s, created.Token, http.MethodDelete, "/v1/auth", nil, nil).Code)
+	require.Equal(t, http.StatusNoContent, callAs(t, s, admin, http.MethodDelete, "/v1/auth", nil, nil).Code)
+	stop()
+
+	report, err := audit.Verify(path)
+	require.NoError(t, err)
+	assert.Empty(t, report.Problems)
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var got []string
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var e audit.Event
+		require.NoError(t, json.Unmarshal([]byte(line), &e))
+		got = append(got, strings.Join([]string{e.Actor, e.Action, e.Target, e.Outcome}, " "))
+		if e.Action == audit.ActionAuthenticate {
+			assert.Equal(t, "contoso", e.Tenant)
+		}
+	}
+	actor := "apikey:" + key.ID
+	assert.Equal(t, []string{
+		"gosling@test token.issue " + key.ID + " success",
+		actor + " auth.authenticate app:app success",
+		actor + " api.request POST /v1/auth success",
+		actor + " token.issue " + created.Key.ID + " success",
+		actor + " api.request POST /v1/keys success",
+		"apikey:" + created.Key.ID + " api.request DELETE /v1/auth failure",
+		actor + " store.clear memory success",
+		actor + " api.request DELETE /v1/auth success",
+	}, got)
+}
+
+func TestOIDCBearerTokens(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	jwks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{Key: &key.PublicKey, KeyID: "k1", Algorithm: "RS256", Use: "sig"}}})
+	}))
+	defer jwks.Close()
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: key}, (&jose.SignerOptions{}).WithType("JWT").WithHeader("kid", "k1"))
+	require.NoError(t, err)
+	sign := func(audience string, roles ...string) string {
+		token, err := jwt.Signed(signer).Claims(map[string]any{
+			"iss":                "https://issuer.example.com",
+			"aud":                audience,
+			"sub":                "0001",
+			"preferred_username": "analyst@example.com",
+			"exp":                time.Now().Add(time.Hour).Unix(),
+			"roles":              roles,
+		}).Serialize()
+		require.NoError(t, err)
+		return token
+	}
+
+	oidcAuth, err := NewOIDC(t.Context(), OIDCOptions{
+		Issuer:   "https://issuer.example.com",
+		Audience: "api://goslings",
+		JWKSURL:  jwks.URL,
+		Roles:    map[string]Role{"goslings.operator": Operator},
+	})
+	require.NoError(t, err)
+	s := newServer(t, nil)
+	s.Authenticators = []Authenticator{oidcAuth}
+	s.Audit = func(AuditEvent) {}
+
+	var p Principal
+	w := callAs(t, s, sign("api://goslings", "User", "Goslings.Operator"), http.MethodGet, "/v1/me", nil, &p)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, Principal{Subject: "analyst@example.com", Method: "oidc", Role: Operator}, p)
+
+	assert.Equal(t, http.StatusOK, callAs(t, s, sign("api://goslings", "viewer"), http.MethodGet, "/v1/jobs", nil, nil).Code)
+	assert.Equal(t, http.StatusUnauthorized, callAs(t, s, sign("api://other", "admin"), http.MethodGet, "/v1/jobs", nil, nil).Code)
+	assert.Equal(t, http.StatusUnauthorized, callAs(t, s, sign("api://goslings", "User"), http.MethodGet, "/v1/jobs", nil, nil).Code)
+}
+
+func TestMTLSMapsCommonNamesToRoles(t *testing.T) {
+	m := &MTLS{Roles: map[string]Role{"collector.example.com": Operator}}
+	request := func(cn string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/v1/jobs", nil)
+		r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: cn}}}}}
+		return r
+	}
+
+	p, err := m.Authenticate(request("Collector.example.com"))
+	require.NoError(t, err)
+	assert.Equal(t, &Principal{Subject: "Collector.example.com", Method: "mtls", Role: Operator}, p)
+
+	_, err = m.Authenticate(request("unknown"))
+	assert.Error(t, err)
+	m.DefaultRole = Viewer
+	p, err = m.Authenticate(request("unknown"))
+	require.NoError(t, err)
+	assert.Equal(t, Viewer, p.Role)
+
+	// Plaintext requests carry no certificate
+	p, err = m.Authenticate(httptest.NewRequest(http.MethodGet, "/v1/jobs", nil))
+	assert.NoError(t, err)
+	assert.Nil(t, p)
+}
+
+func TestProbes(t *testing.T) {
+	s := newServer(t, nil)
+
+	var health Health
+	w := call(t, s, http.MethodGet, "/healthz", nil, &health)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, Health{Status: HealthOK}, health)
+
+	w = call(t, s, http.MethodGet, "/readyz", nil, &health)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, map[string]string{"store": HealthOK, "tokens": "not authenticated", "server": HealthOK}, health.Checks)
+
+	// A reload makes tokens required
+	assert.Error(t, s.Reconfigure(output.Options{Format: "xml"}, nil, nil, true))
+	require.NoError(t, s.Reconfigure(s.Output, nil, nil, true))
+	health = Health{}
+	w = call(t, s, http.MethodGet, "/readyz", nil, &health)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, HealthUnavailable, health.Status)
+	authenticate(t, s)
+	assert.Equal(t, http.StatusOK, call(t, s, http.MethodGet, "/readyz", nil, nil).Code)
+
+	require.NoError(t, s.Shutdown(t.Context()))
+	health = Health{}
+	w = call(t, s, http.MethodGet, "/readyz", nil, &health)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "shutting down", health.Checks["server"])
+	assert.Equal(t, http.StatusOK, call(t, s, http.MethodGet, "/healthz", nil, nil).Code)
+}
+
+func TestMetrics(t *testing.T) {
+	s := newServer(t, nil)
+	authenticate(t, s)
+
+	w := call(t, s, http.MethodGet, "/metrics", nil, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	body := w.Body.String()
+	assert.Contains(t, body, `goslings_token_operations_total{lease="graph",operation="acquire",outcome="success"}`)
+	assert.Contains(t, body, `goslings_store_operation_duration_seconds_count{operation="store_credentials",outcome="success",store="memory"}`)
+	assert.Contains(t, body, "go_gc_heap_allocs_bytes_total")
+	assert.NotContains(t, body, "# EOF")
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("Accept", "application/openmetrics-text; version=1.0.0")
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	assert.Contains(t, w.Header().Get("Content-Type"), "application/openmetrics-text")
+	assert.True(t, strings.HasSuffix(w.Body.String(), "# EOF\n"))
+}
+
+func TestCertificateIsReloaded(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	writeCert := func(cn string, modified time.Time) {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		require.NoError(t, err)
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(1),
+			Subject:      pkix.Name{CommonName: cn},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+		require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}), 0o600))
+		require.NoError(t, os.Chtimes(certFile, modified, modified))
+		require.NoError(t, os.Chtimes(keyFile, modified, modified))
+	}
+	served := func(cfg *tls.Config) string {
+		cert, err := cfg.GetCertificate(&tls.ClientHelloInfo{})
+		require.NoError(t, err)
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		require.NoError(t, err)
+		return leaf.Subject.CommonName
+	}
+
+	now := time.Now()
+	writeCert("first", now.Add(-time.Minute))
+	cfg, cert, err := NewTLSConfig(TLSOptions{Cert: certFile, Key: keyFile})
+	require.NoError(t, err)
+	assert.Equal(t, "first", served(cfg))
+
+	reloaded, err := cert.Reload()
+	require.NoError(t, err)
+	assert.False(t, reloaded)
+
+	writeCert("second", now)
+	reloaded, err = cert.Reload()
+	require.NoError(t, err)
+	assert.True(t, reloaded)
+	assert.Equal(t, "second", served(cfg))
+
+	// A broken file keeps the current certificate
+	require.NoError(t, os.WriteFile(keyFile, []byte("garbage"), 0o600))
+	_, err = cert.Reload()
+	assert.Error(t, err)
+	assert.Equal(t, "second", served(cfg))
+}
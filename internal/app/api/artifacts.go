@@ -0,0 +1,99 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/arustydev/goslings/internal/collect"
+	"github.com/arustydev/goslings/pkg/apiv1"
+	"github.com/gin-gonic/gin"
+)
+
+// Artifact is a file of a job's run directory
+type Artifact = apiv1.Artifact
+
+// listArtifacts lists the files of a job; unlike the manifest it includes parts still being written
+func (s *Server) listArtifacts(c *gin.Context) {
+	cp, err := s.loadJob(c.Param("id"))
+	if err != nil {
+		fail(c, errorStatus(err), err)
+		return
+	}
+
+	digests := map[string]string{}
+	if m, err := collect.LoadManifest(filepath.Join(cp.Dir(), collect.ManifestFileName)); err == nil {
+		for _, f := range m.Files {
+			digests[f.Path] = f.SHA256
+		}
+	}
+
+	artifacts := []Artifact{}
+	err = filepath.WalkDir(cp.Dir(), func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(cp.Dir(), path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		// Checkpoints are replaced through temporary files that may vanish while listing
+		if strings.HasPrefix(rel, collect.CheckpointFileName+".") {
+			return nil
+		}
+		artifacts = append(artifacts, Artifact{
+			Path:     rel,
+			Size:     info.Size(),
+			Modified: info.ModTime().UTC(),
+			SHA256:   digests[rel],
+		})
+		return nil
+	})
+	if err != nil {
+		fail(c, errorStatus(err), fmt.Errorf("failed to list artifacts: %w", err))
+		return
+	}
+	sort.Slice(artifacts, func(i, j int) bool { return artifacts[i].Path < artifacts[j].Path })
+	c.JSON(http.StatusOK, artifacts)
+}
+
+// getArtifact downloads a file of a job, refusing paths that leave its run directory
+func (s *Server) getArtifact(c *gin.Context) {
+	cp, err := s.loadJob(c.Param("id"))
+	if err != nil {
+		fail(c, errorStatus(err), err)
+		return
+	}
+
+	rel := strings.TrimPrefix(c.Param("path"), "/")
+	if !filepath.IsLocal(filepath.FromSlash(rel)) {
+		err := fmt.Errorf("%w: %s", ErrArtifactNotFound, rel)
+		fail(c, errorStatus(err), err)
+		return
+	}
+	path := filepath.Join(cp.Dir(), filepath.FromSlash(rel))
+	info, err := os.Lstat(path)
+	if errors.Is(err, os.ErrNotExist) || (err == nil && !info.Mode().IsRegular()) {
+		err := fmt.Errorf("%w: %s", ErrArtifactNotFound, rel)
+		fail(c, errorStatus(err), err)
+		return
+	}
+	if err != nil {
+		fail(c, errorStatus(err), err)
+		return
+	}
+	c.FileAttachment(path, filepath.Base(path))
+}
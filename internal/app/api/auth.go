@@ -0,0 +1,213 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/arustydev/goslings/internal/audit"
+	"github.com/arustydev/goslings/internal/auth"
+	"github.com/arustydev/goslings/internal/auth/shared"
+	"github.com/arustydev/goslings/pkg/apiv1"
+	"github.com/gin-gonic/gin"
+)
+
+// DeviceCode signs a user in with a device code relayed through the API
+const DeviceCode = apiv1.DeviceCode
+
+// promptTimeout bounds how long starting a device code sign-in waits for the code
+const promptTimeout = 30 * time.Second
+
+// AuthState is where the server is in authenticating
+type AuthState = apiv1.AuthState
+
+const (
+	AuthNone          = apiv1.AuthNone
+	AuthPending       = apiv1.AuthPending
+	AuthAuthenticated = apiv1.AuthAuthenticated
+	AuthFailed        = apiv1.AuthFailed
+)
+
+// CredentialFunc creates the credential of an authentication method; prompt relays the code
+// of a device code sign-in
+type CredentialFunc func(method string, params *shared.AuthParams, prompt auth.DevicePrompt) (azcore.TokenCredential, error)
+
+// newCredential creates device code credentials and the app-only credentials of the auth package
+func newCredential(method string, params *shared.AuthParams, prompt auth.DevicePrompt) (azcore.TokenCredential, error) {
+	if method == DeviceCode {
+		return auth.NewDeviceCodeCredential(params, prompt)
+	}
+	return auth.NewAppCredential(params, auth.AppAuthMethod(method))
+}
+
+// AuthRequest starts authentication, its fields override the configured parameters
+type AuthRequest = apiv1.AuthRequest
+
+// AuthStatus reports the server's authentication
+type AuthStatus = apiv1.AuthStatus
+
+// status reports the tokens of the AuthManager, along with a sign-in in progress
+func (s *Server) status() AuthStatus {
+	st := AuthStatus{State: AuthNone}
+	s.mu.Lock()
+	signIn := s.signIn
+	s.mu.Unlock()
+	if signIn != nil {
+		st = signIn.Status()
+	}
+	if st.State == AuthPending {
+		return st
+	}
+	if authenticated := StatusOf(s.Auth); authenticated.State == AuthAuthenticated {
+		authenticated.Method = st.Method
+		return authenticated
+	}
+	st.Services, st.ExpiresAt = nil, time.Time{}
+	return st
+}
+
+// StatusOf reports which services an AuthManager holds a valid token for, and as whom
+func StatusOf(a *auth.AuthManager) AuthStatus {
+	st := AuthStatus{State: AuthNone}
+	params := a.GetAuthParams()
+	if params == nil {
+		return st
+	}
+	for _, service := range auth.Services {
+		token, err := a.GetToken(service)
+		if err != nil {
+			continue
+		}
+		st.Services = append(st.Services, service)
+		if st.ExpiresAt.IsZero() || token.ExpiresAt.Before(st.ExpiresAt) {
+			st.ExpiresAt = token.ExpiresAt
+		}
+	}
+	if len(st.Services) > 0 {
+		st.State = AuthAuthenticated
+		st.Tenant = params.TenantID
+		st.Identity = params.Identity()
+	}
+	return st
+}
+
+// getAuth reports the server's authentication; the code of a pending device code sign-in
+// signs the server in, so only admins are shown it
+func (s *Server) getAuth(c *gin.Context) {
+	st := s.status()
+	if p := RequestPrincipal(c); p == nil || !p.Role.Allows(Admin) {
+		st.UserCode, st.VerificationURL, st.Message = "", "", ""
+	}
+	c.JSON(http.StatusOK, st)
+}
+
+// startAuth authenticates the server. App-only methods complete before responding; a device
+// code sign-in responds with the code once it is issued and completes in the background. Jobs
+// get their tokens from the server's AuthManager, so the server is not re-authenticated while
+// they run, and none start while a sign-in is pending.
+func (s *Server) startAuth(c *gin.Context) {
+	var req AuthRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		fail(c, http.StatusBadRequest, fmt.Errorf("%w: %w", ErrInvalidRequest, err))
+		return
+	}
+	params := s.params(req)
+
+	s.mu.Lock()
+	if s.signIn != nil && s.signIn.Pending() {
+		s.mu.Unlock()
+		fail(c, errorStatus(ErrSignInPending), ErrSignInPending)
+		return
+	}
+	if err := s.checkNoJobs(); err != nil {
+		s.mu.Unlock()
+		fail(c, errorStatus(err), err)
+		return
+	}
+	signIn := auth.NewSignIn(req.Method, params.TenantID)
+	s.signIn = signIn
+	s.mu.Unlock()
+
+	cred, err := s.NewCredential(req.Method, params, signIn.Prompt)
+	if err != nil {
+		signIn.Finish(s.ctx, err)
+		fail(c, http.StatusBadRequest, fmt.Errorf("%w: %w", ErrInvalidRequest, err))
+		return
+	}
+
+	if req.Method != DeviceCode {
+		err := s.Auth.UseCredential(c.Request.Context(), cred, params)
+		signIn.Finish(s.ctx, err)
+		if err != nil {
+			fail(c, http.StatusBadGateway, err)
+			return
+		}
+		c.JSON(http.StatusOK, s.status())
+		return
+	}
+
+	ctx := audit.WithActor(s.ctx, audit.ActorOf(c.Request.Context()))
+	switch err := s.Auth.SignInWithDeviceCode(ctx, signIn, cred, params, promptTimeout); {
+	case errors.Is(err, auth.ErrNoDeviceCode):
+		fail(c, http.StatusGatewayTimeout, err)
+	case err != nil:
+		fail(c, http.StatusBadGateway, err)
+	case signIn.Pending():
+		c.JSON(http.StatusAccepted, s.status())
+	default:
+		c.JSON(http.StatusOK, s.status())
+	}
+}
+
+// params merges an authentication request into the configured parameters
+func (s *Server) params(req AuthRequest) *shared.AuthParams {
+	params := &shared.AuthParams{}
+	if s.Params != nil {
+		*params = *s.Params
+	}
+	if req.TenantID != "" {
+		params.TenantID = req.TenantID
+	}
+	if req.ClientID != "" {
+		params.ClientID = req.ClientID
+	}
+	if req.ClientSecret != "" {
+		params.ClientSecret = req.ClientSecret
+	}
+	return params
+}
+
+// clearAuth signs the server out unless jobs are running; no job starts until it is done
+func (s *Server) clearAuth(c *gin.Context) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.checkNoJobs(); err != nil {
+		fail(c, errorStatus(err), err)
+		return
+	}
+	if err := s.Auth.Clear(c.Request.Context()); err != nil {
+		fail(c, errorStatus(err), err)
+		return
+	}
+	s.signIn = nil
+	c.Status(http.StatusNoContent)
+}
+
+// checkNoJobs fails with ErrJobsRunning while jobs run, it is called with s.mu held
+func (s *Server) checkNoJobs() error {
+	var running []string
+	for id, j := range s.jobs {
+		if !j.finished() {
+			running = append(running, id)
+		}
+	}
+	if len(running) == 0 {
+		return nil
+	}
+	sort.Strings(running)
+	return fmt.Errorf("%w: %s, the server's authentication cannot change until they stop", ErrJobsRunning, strings.Join(running, ", "))
+}
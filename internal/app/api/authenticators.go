@@ -0,0 +1,154 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+)
+
+// DefaultRolesClaim is the token claim OIDC roles are read from, it is where Entra ID puts app roles
+const DefaultRolesClaim = "roles"
+
+// MTLS authenticates callers by the client certificate verified during the TLS handshake
+type MTLS struct {
+	// Roles maps certificate common names to roles, case-insensitively
+	Roles map[string]Role
+
+	// DefaultRole is the role of a verified certificate missing from Roles, such
+	// certificates are refused when it is empty
+	DefaultRole Role
+}
+
+// Authenticate implements Authenticator.Authenticate for MTLS
+func (m *MTLS) Authenticate(r *http.Request) (*Principal, error) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil, nil
+	}
+	cn := r.TLS.VerifiedChains[0][0].Subject.CommonName
+	role := m.DefaultRole
+	for name, r := range m.Roles {
+		if strings.EqualFold(name, cn) {
+			role = r
+		}
+	}
+	if role == "" {
+		return nil, fmt.Errorf("client certificate %q has no role", cn)
+	}
+	return &Principal{Subject: cn, Method: "mtls", Role: role}, nil
+}
+
+// OIDCOptions configures bearer token validation against an OpenID Connect issuer
+type OIDCOptions struct {
+	// Issuer must match the iss claim, its discovery document locates the JWKS unless JWKSURL is set
+	Issuer string `mapstructure:"issuer"`
+
+	// Audience must be in the aud claim
+	Audience string `mapstructure:"audience"`
+
+	// JWKSURL is where the issuer's signing keys are published, skipping discovery
+	JWKSURL string `mapstructure:"jwks_url"`
+
+	// RolesClaim holds a role name or a list of them, DefaultRolesClaim when empty
+	RolesClaim string `mapstructure:"roles_claim"`
+
+	// Roles maps claim values to roles case-insensitively; a value naming a role maps to it
+	Roles map[string]Role `mapstructure:"roles"`
+}
+
+// OIDC authenticates callers by bearer tokens signed by an OpenID Connect issuer
+type OIDC struct {
+	Options OIDCOptions
+
+	verifier *oidc.IDTokenVerifier
+}
+
+// NewOIDC creates an OIDC authenticator, discovering the issuer's keys unless a JWKS URL is
+// set; ctx is used to fetch keys for as long as the authenticator is used
+func NewOIDC(ctx context.Context, opts OIDCOptions) (*OIDC, error) {
+	if opts.Issuer == "" || opts.Audience == "" {
+		return nil, errors.New("OIDC authentication needs an issuer and an audience")
+	}
+	if opts.RolesClaim == "" {
+		opts.RolesClaim = DefaultRolesClaim
+	}
+	cfg := &oidc.Config{ClientID: opts.Audience}
+	if opts.JWKSURL != "" {
+		keys := oidc.NewRemoteKeySet(ctx, opts.JWKSURL)
+		return &OIDC{Options: opts, verifier: oidc.NewVerifier(opts.Issuer, keys, cfg)}, nil
+	}
+	provider, err := oidc.NewProvider(ctx, opts.Issuer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to discover OIDC issuer: %w", err)
+	}
+	return &OIDC{Options: opts, verifier: provider.Verifier(cfg)}, nil
+}
+
+// Authenticate implements Authenticator.Authenticate for OIDC, API keys are left to Keys
+func (o *OIDC) Authenticate(r *http.Request) (*Principal, error) {
+	raw, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || strings.HasPrefix(raw, KeyPrefix) {
+		return nil, nil
+	}
+	token, err := o.verifier.Verify(r.Context(), raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid bearer token: %w", err)
+	}
+	var claims map[string]any
+	if err := token.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("invalid bearer token claims: %w", err)
+	}
+
+	subject := token.Subject
+	for _, claim := range []string{"preferred_username", "upn", "email"} {
+		if name, ok := claims[claim].(string); ok && name != "" {
+			subject = name
+			break
+		}
+	}
+	var role Role
+	for _, value := range claimValues(claims[o.Options.RolesClaim]) {
+		// The highest role granted wins
+		if r := o.role(value); r.Allows(role) && !role.Allows(r) {
+			role = r
+		}
+	}
+	if role == "" {
+		return nil, fmt.Errorf("token of %s grants no role", subject)
+	}
+	return &Principal{Subject: subject, Method: "oidc", Role: role}, nil
+}
+
+// role maps a claim value to a role, or returns an empty role
+func (o *OIDC) role(value string) Role {
+	for name, r := range o.Options.Roles {
+		if strings.EqualFold(name, value) {
+			return r
+		}
+	}
+	if r, err := ParseRole(value); err == nil {
+		return r
+	}
+	return ""
+}
+
+// claimValues returns a string claim, or the strings of a list claim
+func claimValues(claim any) []string {
+	switch v := claim.(type) {
+	case string:
+		return []string{v}
+	case []any:
+		var values []string
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	default:
+		return nil
+	}
+}
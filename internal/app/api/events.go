@@ -0,0 +1,128 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/arustydev/goslings/internal/collect"
+	"github.com/arustydev/goslings/pkg/apiv1"
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+)
+
+// ErrNoEvents is returned for the events of a job that has not run since the server started,
+// or whose events have been evicted
+var ErrNoEvents = errors.New("no events recorded")
+
+// DefaultEventHistory is how many events of a job are kept for replay
+const DefaultEventHistory = 10000
+
+// Job events, the others are sent by the collection, see collect.EventType
+const (
+	EventJobStarted  = apiv1.EventJobStarted
+	EventJobFinished = apiv1.EventJobFinished
+)
+
+// JobEvent is an event of a job with its offset in the job's events
+type JobEvent = apiv1.JobEvent
+
+// eventLog keeps the latest events of a job
+type eventLog struct {
+	mu     sync.Mutex
+	events []JobEvent
+	next   int64
+
+	// changed is closed and replaced whenever an event is added
+	changed chan struct{}
+}
+
+func newEventLog() *eventLog {
+	return &eventLog{changed: make(chan struct{})}
+}
+
+// add records an event, dropping the oldest once DefaultEventHistory are kept
+func (l *eventLog) add(e collect.Event) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events = append(l.events, JobEvent{Offset: l.next, Event: e})
+	l.next++
+	if len(l.events) > DefaultEventHistory {
+		l.events = append(l.events[:0], l.events[len(l.events)-DefaultEventHistory:]...)
+	}
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+// since returns the kept events from offset on, and a channel closed once more are added
+func (l *eventLog) since(offset int64) ([]JobEvent, <-chan struct{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var events []JobEvent
+	for _, e := range l.events {
+		if e.Offset >= offset {
+			events = append(events, e)
+		}
+	}
+	return events, l.changed
+}
+
+// addJobEvent records an event sent by the server itself for a job
+func addJobEvent(l *eventLog, runID string, e collect.Event) {
+	e.Time = time.Now().UTC()
+	e.RunID = runID
+	l.add(e)
+}
+
+// streamEvents sends the events of a job as server-sent events from an offset, given by the
+// offset parameter or the Last-Event-ID header of a reconnecting client, until the job stops
+func (s *Server) streamEvents(c *gin.Context) {
+	cp, err := s.loadJob(c.Param("id"))
+	if err != nil {
+		fail(c, errorStatus(err), err)
+		return
+	}
+	offset := int64(0)
+	if value := c.Query("offset"); value != "" {
+		if offset, err = strconv.ParseInt(value, 10, 64); err != nil || offset < 0 {
+			err := fmt.Errorf("%w: invalid offset %q", ErrInvalidRequest, value)
+			fail(c, errorStatus(err), err)
+			return
+		}
+	} else if id := c.GetHeader("Last-Event-ID"); id != "" {
+		if last, err := strconv.ParseInt(id, 10, 64); err == nil {
+			offset = last + 1
+		}
+	}
+
+	s.mu.Lock()
+	run := s.jobs[cp.RunID]
+	s.mu.Unlock()
+	if run == nil {
+		err := fmt.Errorf("%w: job %s has not run since the server started, or finished too long ago", ErrNoEvents, cp.RunID)
+		fail(c, errorStatus(err), err)
+		return
+	}
+
+	c.Stream(func(w io.Writer) bool {
+		// The last event is added before a run is marked finished
+		finished := run.finished()
+		events, changed := run.events.since(offset)
+		for _, e := range events {
+			c.Render(-1, sse.Event{Id: strconv.FormatInt(e.Offset, 10), Event: string(e.Type), Data: e})
+			offset = e.Offset + 1
+		}
+		if finished {
+			return false
+		}
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case <-changed:
+			return true
+		}
+	})
+}
@@ -0,0 +1,84 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/arustydev/goslings/internal/auth/store"
+	"github.com/arustydev/goslings/pkg/apiv1"
+	"github.com/gin-gonic/gin"
+)
+
+// healthTimeout bounds how long a readiness probe waits for the store
+const healthTimeout = 5 * time.Second
+
+// Health statuses
+const (
+	HealthOK          = "ok"
+	HealthUnavailable = "unavailable"
+)
+
+// Health is the response of the probes, Checks is only reported by readiness
+type Health = apiv1.Health
+
+// healthz reports that the server is serving requests, it does not depend on anything else
+// so an unreachable store or tenant does not get the server restarted
+func (s *Server) healthz(c *gin.Context) {
+	c.JSON(http.StatusOK, Health{Status: HealthOK})
+}
+
+// readyz reports whether the server should receive traffic: the store is reachable, the server
+// is not shutting down and, when RequireTokens is set, it holds a valid token
+func (s *Server) readyz(c *gin.Context) {
+	checks := map[string]string{
+		"store":  s.checkStore(c.Request.Context()),
+		"tokens": s.checkTokens(),
+		"server": HealthOK,
+	}
+	if s.draining.Load() {
+		checks["server"] = "shutting down"
+	}
+
+	health := Health{Status: HealthOK, Checks: checks}
+	_, _, _, requireTokens := s.live()
+	if checks["store"] != HealthOK || checks["server"] != HealthOK ||
+		(requireTokens && checks["tokens"] != HealthOK) {
+		health.Status = HealthUnavailable
+		c.JSON(http.StatusServiceUnavailable, health)
+		return
+	}
+	c.JSON(http.StatusOK, health)
+}
+
+// checkStore reads the API keys from the store, the state every request depends on
+func (s *Server) checkStore(ctx context.Context) string {
+	if s.Auth == nil || s.Auth.Store == nil {
+		return "not configured"
+	}
+	ctx, cancel := context.WithTimeout(ctx, healthTimeout)
+	defer cancel()
+	if _, err := s.Auth.Store.LoadState(ctx, KeysStateKey); err != nil && !errors.Is(err, store.ErrStateNotFound) {
+		return err.Error()
+	}
+	return HealthOK
+}
+
+// checkTokens reports whether the server holds a valid token for any service
+func (s *Server) checkTokens() string {
+	if s.Auth == nil {
+		return "not authenticated"
+	}
+	st := s.status()
+	switch {
+	case st.State == AuthAuthenticated:
+		return HealthOK
+	case st.State == AuthPending:
+		return "sign-in pending"
+	case s.Auth.GetAuthParams() != nil:
+		return "expired"
+	default:
+		return "not authenticated"
+	}
+}
@@ -0,0 +1,459 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/arustydev/goslings/internal/audit"
+	"github.com/arustydev/goslings/internal/auth"
+	"github.com/arustydev/goslings/internal/auth/shared"
+	"github.com/arustydev/goslings/internal/collect"
+	"github.com/arustydev/goslings/internal/output"
+	"github.com/arustydev/goslings/internal/utils/telemetry"
+	"github.com/arustydev/goslings/pkg/apiv1"
+	"github.com/gin-gonic/gin"
+)
+
+// Common errors
+var (
+	ErrInvalidRequest   = errors.New("invalid request")
+	ErrJobNotFound      = errors.New("job not found")
+	ErrJobNotRunning    = errors.New("job is not running")
+	ErrJobRunning       = errors.New("job is already running")
+	ErrJobsRunning      = errors.New("jobs are running")
+	ErrSignInPending    = errors.New("a sign-in is already pending")
+	ErrArtifactNotFound = errors.New("artifact not found")
+	ErrShuttingDown     = errors.New("the server is shutting down")
+)
+
+// DefaultSince is how far back a job collects time-windowed datasets unless it says otherwise
+const DefaultSince = 7 * 24 * time.Hour
+
+// JobStatus is the state of a job
+type JobStatus = apiv1.JobStatus
+
+const (
+	JobRunning     = apiv1.JobRunning
+	JobCancelling  = apiv1.JobCancelling
+	JobCompleted   = apiv1.JobCompleted
+	JobFailed      = apiv1.JobFailed
+	JobCancelled   = apiv1.JobCancelled
+	JobInterrupted = apiv1.JobInterrupted
+)
+
+// JobRequest launches a job, or resumes one; Since is DefaultSince when empty
+type JobRequest = apiv1.JobRequest
+
+// Job is the state of a collection run
+type Job = apiv1.Job
+
+// job is a run in progress in this server
+type job struct {
+	stop     chan struct{}
+	stopOnce sync.Once
+	cancel   context.CancelFunc
+
+	// done is closed once the run has returned with err, at ended
+	done  chan struct{}
+	err   error
+	ended time.Time
+
+	// events are kept across the runs of a job, so offsets carry on when it is resumed
+	events *eventLog
+}
+
+// requestStop stops the run at its next page boundary
+func (j *job) requestStop() {
+	j.stopOnce.Do(func() { close(j.stop) })
+}
+
+// stopping reports whether the run has been asked to stop
+func (j *job) stopping() bool {
+	select {
+	case <-j.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// finished reports whether the run has returned
+func (j *job) finished() bool {
+	select {
+	case <-j.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// validRunID reports whether id names a run directory directly under the output directory
+func validRunID(id string) bool {
+	return id != "" && id == filepath.Base(id) && filepath.IsLocal(id)
+}
+
+// loadJob reads the checkpoint of a job
+func (s *Server) loadJob(id string) (*collect.Checkpoint, error) {
+	if !validRunID(id) {
+		return nil, fmt.Errorf("%w: %s", ErrJobNotFound, id)
+	}
+	cp, err := collect.LoadCheckpoint(s.Out, id)
+	if errors.Is(err, collect.ErrCheckpointNotFound) {
+		return nil, fmt.Errorf("%w: %s", ErrJobNotFound, id)
+	}
+	return cp, err
+}
+
+// view describes a job from its checkpoint and, while it runs in this server, its run
+func (s *Server) view(cp *collect.Checkpoint) Job {
+	j := JobOf(cp)
+	s.mu.Lock()
+	run := s.jobs[cp.RunID]
+	s.mu.Unlock()
+	switch {
+	case run != nil && !run.finished() && run.stopping():
+		j.Status, j.Error = JobCancelling, ""
+	case run != nil && !run.finished():
+		j.Status, j.Error = JobRunning, ""
+	case run != nil:
+		j.Status, j.Error = outcome(cp, run.err)
+	}
+	return j
+}
+
+// JobOf describes a job from its checkpoint alone, as a job that is not running in this process
+func JobOf(cp *collect.Checkpoint) Job {
+	j := Job{
+		ID:       cp.RunID,
+		Tenant:   cp.Tenant,
+		Schedule: cp.Job,
+		Full:     cp.Full,
+		Output:   apiv1.Output(cp.Output),
+		Created:  cp.Created,
+		Updated:  cp.Updated,
+		Datasets: cp.Datasets,
+	}
+	for _, p := range cp.Datasets {
+		j.Items += p.Items
+	}
+	j.Status, j.Error = outcome(cp, nil)
+	return j
+}
+
+// outcome is the status of a job that is not running, err is what its run in this server
+// returned, nil when it has not run since the server started
+func outcome(cp *collect.Checkpoint, err error) (JobStatus, string) {
+	var failed []string
+	for _, p := range cp.Datasets {
+		if p.Error != "" {
+			failed = append(failed, p.Dataset+": "+p.Error)
+		}
+	}
+	switch {
+	case cp.Done():
+		return JobCompleted, ""
+	case len(failed) > 0:
+		return JobFailed, errors.Join(stringErrors(failed)...).Error()
+	case errors.Is(err, collect.ErrStopped) || errors.Is(err, context.Canceled):
+		return JobCancelled, err.Error()
+	case err != nil:
+		return JobInterrupted, err.Error()
+	default:
+		return JobInterrupted, ""
+	}
+}
+
+// stringErrors turns messages into errors so they can be joined
+func stringErrors(msgs []string) []error {
+	errs := make([]error, len(msgs))
+	for i, msg := range msgs {
+		errs[i] = errors.New(msg)
+	}
+	return errs
+}
+
+// listJobs lists every job in the output directory, oldest first, optionally of one tenant
+func (s *Server) listJobs(c *gin.Context) {
+	cps, err := collect.ListCheckpoints(s.Out)
+	if err != nil {
+		fail(c, errorStatus(err), err)
+		return
+	}
+	tenant := c.Query("tenant")
+	jobs := []Job{}
+	for _, cp := range cps {
+		if tenant != "" && cp.Tenant != tenant {
+			continue
+		}
+		jobs = append(jobs, s.view(cp))
+	}
+	c.JSON(http.StatusOK, jobs)
+}
+
+// getJob reports the progress of a job
+func (s *Server) getJob(c *gin.Context) {
+	cp, err := s.loadJob(c.Param("id"))
+	if err != nil {
+		fail(c, errorStatus(err), err)
+		return
+	}
+	c.JSON(http.StatusOK, s.view(cp))
+}
+
+// createJob launches a job, or resumes one, with the server's credentials
+func (s *Server) createJob(c *gin.Context) {
+	var req JobRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		fail(c, http.StatusBadRequest, fmt.Errorf("%w: %w", ErrInvalidRequest, err))
+		return
+	}
+	params := s.Auth.GetAuthParams()
+	if params == nil {
+		fail(c, errorStatus(auth.ErrNotAuthenticated), auth.ErrNotAuthenticated)
+		return
+	}
+	if _, shipper, _, _ := s.live(); req.Ship && shipper == nil {
+		fail(c, http.StatusBadRequest, fmt.Errorf("%w: no sinks are configured", ErrInvalidRequest))
+		return
+	}
+
+	var cp *collect.Checkpoint
+	if req.Resume != "" {
+		var err error
+		if cp, err = s.loadJob(req.Resume); err != nil {
+			fail(c, errorStatus(err), err)
+			return
+		}
+		if cp.Done() {
+			fail(c, http.StatusConflict, fmt.Errorf("job %s is already complete", cp.RunID))
+			return
+		}
+		if cp.Tenant != params.TenantID {
+			fail(c, http.StatusConflict, fmt.Errorf("job %s collects tenant %s, the server is authenticated to %s",
+				cp.RunID, cp.Tenant, params.TenantID))
+			return
+		}
+	} else {
+		plan, err := s.plan(req)
+		if err != nil {
+			fail(c, http.StatusBadRequest, fmt.Errorf("%w: %w", ErrInvalidRequest, err))
+			return
+		}
+		if cp, err = collect.NewCheckpoint(s.Out, collect.NewRunID(), params.TenantID, plan); err != nil {
+			fail(c, errorStatus(err), err)
+			return
+		}
+	}
+
+	// The checkpoint belongs to the run once started, the response reads it back from disk
+	view := s.view(cp)
+	if _, err := s.start(cp, params, req.Ship, audit.ActorOf(c.Request.Context())); err != nil {
+		fail(c, errorStatus(err), err)
+		return
+	}
+	if saved, err := s.loadJob(cp.RunID); err == nil {
+		view = s.view(saved)
+	}
+	c.Header("Location", "/v1/jobs/"+cp.RunID)
+	c.JSON(http.StatusAccepted, view)
+}
+
+// plan builds the collection plan of a job request
+func (s *Server) plan(req JobRequest) (collect.Plan, error) {
+	out, _, _, _ := s.live()
+	plan := collect.Plan{Datasets: req.Datasets, Full: req.Full, Output: out}
+	if len(plan.Datasets) == 0 {
+		plan.Datasets = collect.Datasets()
+	}
+	for _, name := range plan.Datasets {
+		if _, err := collect.Lookup(name); err != nil {
+			return collect.Plan{}, err
+		}
+	}
+	if req.Output != nil {
+		plan.Output = output.Options(*req.Output)
+	}
+	if err := plan.Output.Validate(); err != nil {
+		return collect.Plan{}, err
+	}
+
+	var err error
+	if plan.Since, plan.Until, err = collect.ParseWindow(req.Since, req.Until, time.Now(), DefaultSince); err != nil {
+		return collect.Plan{}, err
+	}
+	return plan, nil
+}
+
+// start runs a job in the background, audited as done by actor
+func (s *Server) start(cp *collect.Checkpoint, params *shared.AuthParams, ship bool, actor string) (*job, error) {
+	ctx, cancel := context.WithCancel(audit.WithActor(s.ctx, actor))
+	j := &job{stop: make(chan struct{}), cancel: cancel, done: make(chan struct{}), events: newEventLog()}
+
+	s.mu.Lock()
+	// Shutdown stops the jobs it finds under the lock, a job started after it would run on
+	if s.draining.Load() {
+		s.mu.Unlock()
+		cancel()
+		return nil, ErrShuttingDown
+	}
+	// A job collects with the tokens of the tenant it is labelled with, see startAuth
+	if s.signIn != nil && s.signIn.Pending() {
+		s.mu.Unlock()
+		cancel()
+		return nil, ErrSignInPending
+	}
+	if current := s.Auth.GetAuthParams(); current == nil || current.TenantID != cp.Tenant {
+		s.mu.Unlock()
+		cancel()
+		return nil, fmt.Errorf("%w: the server is no longer authenticated to %s", auth.ErrNotAuthenticated, cp.Tenant)
+	}
+	prev := s.jobs[cp.RunID]
+	if prev != nil && !prev.finished() {
+		s.mu.Unlock()
+		cancel()
+		return nil, fmt.Errorf("%w: %s", ErrJobRunning, cp.RunID)
+	}
+	if prev != nil {
+		j.events = prev.events
+	}
+	s.jobs[cp.RunID] = j
+	s.evictJobs(time.Now())
+	s.wg.Add(1)
+	s.mu.Unlock()
+
+	runner := &collect.Runner{
+		Tokens:       s.Auth,
+		Store:        s.Auth.Store,
+		Client:       s.Client,
+		USGovernment: params.UsGovernment,
+		Stop:         j.stop,
+		Events:       j.events.add,
+	}
+	addJobEvent(j.events, cp.RunID, collect.Event{Type: EventJobStarted})
+	go func() {
+		defer s.wg.Done()
+		defer cancel()
+		j.err = s.run(ctx, runner, cp, params.Identity(), ship)
+		status, msg := outcome(cp, j.err)
+		if j.err != nil && msg == "" {
+			msg = j.err.Error()
+		}
+		addJobEvent(j.events, cp.RunID, collect.Event{Type: EventJobFinished, Status: string(status), Error: msg})
+		j.ended = time.Now()
+		close(j.done)
+	}()
+	return j, nil
+}
+
+// evictJobs forgets finished jobs older than JobRetention, then the oldest finished jobs past
+// MaxJobs; it is called with s.mu held
+func (s *Server) evictJobs(now time.Time) {
+	var finished []string
+	for id, j := range s.jobs {
+		switch {
+		case !j.finished():
+		case s.JobRetention > 0 && now.Sub(j.ended) > s.JobRetention:
+			delete(s.jobs, id)
+		default:
+			finished = append(finished, id)
+		}
+	}
+	if s.MaxJobs <= 0 || len(finished) <= s.MaxJobs {
+		return
+	}
+	sort.Slice(finished, func(a, b int) bool {
+		return s.jobs[finished[a]].ended.Before(s.jobs[finished[b]].ended)
+	})
+	for _, id := range finished[:len(finished)-s.MaxJobs] {
+		delete(s.jobs, id)
+	}
+}
+
+// run collects a job, writes its manifest and ships it if asked to
+func (s *Server) run(ctx context.Context, runner *collect.Runner, cp *collect.Checkpoint, identity string, ship bool) error {
+	ctx = telemetry.WithField(ctx, telemetry.FieldRun, cp.RunID)
+	logger := telemetry.Logger(ctx)
+	logger.Info("Starting job")
+	runErr := runner.Run(ctx, cp)
+	_, shipper, settings, _ := s.live()
+	if _, err := collect.WriteManifest(cp, identity, settings); err != nil {
+		logger.Errorf("Failed to write manifest: %v", err)
+	}
+	if runErr != nil {
+		logger.Errorf("Job did not complete: %v", runErr)
+		return runErr
+	}
+	logger.Info("Job completed")
+
+	if ship && shipper != nil {
+		if err := shipper.Ship(context.WithoutCancel(ctx), cp); err != nil {
+			logger.Errorf("Failed to ship job: %v", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// cancelJob stops a running job once the page being read is written; force=true aborts it
+// instead. Either way the job can be resumed.
+func (s *Server) cancelJob(c *gin.Context) {
+	cp, err := s.loadJob(c.Param("id"))
+	if err != nil {
+		fail(c, errorStatus(err), err)
+		return
+	}
+	s.mu.Lock()
+	run := s.jobs[cp.RunID]
+	s.mu.Unlock()
+	if run == nil || run.finished() {
+		err := fmt.Errorf("%w: %s", ErrJobNotRunning, cp.RunID)
+		fail(c, errorStatus(err), err)
+		return
+	}
+
+	run.requestStop()
+	if c.Query("force") == "true" {
+		run.cancel()
+	}
+	c.JSON(http.StatusAccepted, s.view(cp))
+}
+
+// streamProgress sends the job as a server-sent "progress" event every time its checkpoint
+// is saved or its status changes, until it stops running
+func (s *Server) streamProgress(c *gin.Context) {
+	cp, err := s.loadJob(c.Param("id"))
+	if err != nil {
+		fail(c, errorStatus(err), err)
+		return
+	}
+
+	ticker := time.NewTicker(s.PollInterval)
+	defer ticker.Stop()
+	var last Job
+	c.Stream(func(w io.Writer) bool {
+		view := s.view(cp)
+		if !view.Updated.Equal(last.Updated) || view.Status != last.Status {
+			last = view
+			c.SSEvent("progress", view)
+		}
+		if view.Status != JobRunning && view.Status != JobCancelling {
+			return false
+		}
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case <-ticker.C:
+		}
+		if next, err := s.loadJob(cp.RunID); err == nil {
+			cp = next
+		}
+		return true
+	})
+}
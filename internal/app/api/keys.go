@@ -0,0 +1,236 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/arustydev/goslings/internal/audit"
+	"github.com/arustydev/goslings/internal/auth/store"
+	"github.com/arustydev/goslings/pkg/apiv1"
+	"github.com/gin-gonic/gin"
+)
+
+// ErrKeyNotFound is returned when revoking an unknown API key
+var ErrKeyNotFound = errors.New("API key not found")
+
+// KeysStateKey is the store key of the API keys
+const KeysStateKey = "api/keys"
+
+// KeyPrefix starts every API key, it tells them apart from OIDC bearer tokens
+const KeyPrefix = "gsk_"
+
+// APIKey describes an API key; the key itself is only returned when it is created
+type APIKey = apiv1.APIKey
+
+// keyRecord is a stored API key, only its SHA-256 is kept
+type keyRecord struct {
+	APIKey
+	Hash string `json:"hash"`
+}
+
+// Keys are the API keys kept in the store, callers present them as a bearer token or in the
+// X-API-Key header
+type Keys struct {
+	Store store.Store
+
+	// mu serializes the changes made through this process
+	mu sync.Mutex
+}
+
+// Create issues a key, returning it with its description; the key cannot be recovered later
+func (k *Keys) Create(ctx context.Context, name string, role Role) (token string, key APIKey, err error) {
+	defer func() {
+		audit.Record(ctx, audit.Event{Action: audit.ActionIssueToken, Target: key.ID,
+			Details: map[string]string{"name": name, "role": string(role)}}, err)
+	}()
+	role, err = ParseRole(string(role))
+	if err != nil {
+		return "", APIKey{}, err
+	}
+	if name == "" {
+		return "", APIKey{}, fmt.Errorf("%w: an API key needs a name", ErrInvalidRequest)
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	records, err := k.load(ctx)
+	if err != nil {
+		return "", APIKey{}, err
+	}
+
+	id, secret := make([]byte, 4), make([]byte, 32)
+	for {
+		if _, err := rand.Read(id); err != nil {
+			return "", APIKey{}, err
+		}
+		if findKey(records, hex.EncodeToString(id)) < 0 {
+			break
+		}
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return "", APIKey{}, err
+	}
+	key = APIKey{ID: hex.EncodeToString(id), Name: name, Role: role, Created: time.Now().UTC()}
+	token = KeyPrefix + key.ID + "_" + base64.RawURLEncoding.EncodeToString(secret)
+	records = append(records, keyRecord{APIKey: key, Hash: hashKey(token)})
+	if err := k.save(ctx, records); err != nil {
+		return "", APIKey{}, err
+	}
+	return token, key, nil
+}
+
+// List returns every key sorted by name
+func (k *Keys) List(ctx context.Context) ([]APIKey, error) {
+	records, err := k.load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]APIKey, len(records))
+	for i, r := range records {
+		keys[i] = r.APIKey
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Name < keys[j].Name })
+	return keys, nil
+}
+
+// Revoke deletes a key, requests made with it are refused from then on
+func (k *Keys) Revoke(ctx context.Context, id string) (err error) {
+	defer func() { audit.Record(ctx, audit.Event{Action: audit.ActionRevokeToken, Target: id}, err) }()
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	records, err := k.load(ctx)
+	if err != nil {
+		return err
+	}
+	i := findKey(records, id)
+	if i < 0 {
+		return fmt.Errorf("%w: %s", ErrKeyNotFound, id)
+	}
+	return k.save(ctx, append(records[:i], records[i+1:]...))
+}
+
+// Authenticate implements Authenticator.Authenticate for Keys
+func (k *Keys) Authenticate(r *http.Request) (*Principal, error) {
+	token := r.Header.Get("X-API-Key")
+	if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && strings.HasPrefix(bearer, KeyPrefix) {
+		token = bearer
+	}
+	if token == "" {
+		return nil, nil
+	}
+
+	id, _, ok := strings.Cut(strings.TrimPrefix(token, KeyPrefix), "_")
+	if !ok || !strings.HasPrefix(token, KeyPrefix) {
+		return nil, errors.New("malformed API key")
+	}
+	records, err := k.load(r.Context())
+	if err != nil {
+		return nil, err
+	}
+	i := findKey(records, id)
+	// The hash is compared even for an unknown ID so both take as long
+	want := strings.Repeat("0", sha256.Size*2)
+	if i >= 0 {
+		want = records[i].Hash
+	}
+	if subtle.ConstantTimeCompare([]byte(hashKey(token)), []byte(want)) != 1 || i < 0 {
+		return nil, errors.New("invalid API key")
+	}
+	return &Principal{Subject: id, Method: "apikey", Role: records[i].Role}, nil
+}
+
+// hashKey returns the hex SHA-256 of a key, keys are random enough not to need a slow hash
+func hashKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func findKey(records []keyRecord, id string) int {
+	for i, r := range records {
+		if r.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func (k *Keys) load(ctx context.Context) ([]keyRecord, error) {
+	data, err := k.Store.LoadState(ctx, KeysStateKey)
+	if errors.Is(err, store.ErrStateNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load API keys: %w", err)
+	}
+	var records []keyRecord
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal API keys: %w", err)
+	}
+	return records, nil
+}
+
+func (k *Keys) save(ctx context.Context, records []keyRecord) error {
+	data, err := json.Marshal(records)
+	if err != nil {
+		return fmt.Errorf("failed to marshal API keys: %w", err)
+	}
+	if err := k.Store.StoreState(ctx, KeysStateKey, data); err != nil {
+		return fmt.Errorf("failed to save API keys: %w", err)
+	}
+	return nil
+}
+
+// KeyRequest creates an API key
+type KeyRequest = apiv1.KeyRequest
+
+// CreatedKey is the response to creating an API key, the only time its token is returned
+type CreatedKey = apiv1.CreatedKey
+
+// listKeys lists the API keys without their secrets
+func (s *Server) listKeys(c *gin.Context) {
+	keys, err := s.keys.List(c.Request.Context())
+	if err != nil {
+		fail(c, errorStatus(err), err)
+		return
+	}
+	if keys == nil {
+		keys = []APIKey{}
+	}
+	c.JSON(http.StatusOK, keys)
+}
+
+// createKey issues an API key
+func (s *Server) createKey(c *gin.Context) {
+	var req KeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		fail(c, http.StatusBadRequest, fmt.Errorf("%w: %w", ErrInvalidRequest, err))
+		return
+	}
+	token, key, err := s.keys.Create(c.Request.Context(), req.Name, req.Role)
+	if err != nil {
+		fail(c, errorStatus(err), err)
+		return
+	}
+	c.JSON(http.StatusCreated, CreatedKey{Key: key, Token: token})
+}
+
+// revokeKey deletes an API key
+func (s *Server) revokeKey(c *gin.Context) {
+	if err := s.keys.Revoke(c.Request.Context(), c.Param("id")); err != nil {
+		fail(c, errorStatus(err), err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
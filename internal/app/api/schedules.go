@@ -0,0 +1,130 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/arustydev/goslings/internal/auth"
+	"github.com/arustydev/goslings/internal/collect"
+	"github.com/arustydev/goslings/internal/schedule"
+	"github.com/arustydev/goslings/pkg/apiv1"
+	"github.com/gin-gonic/gin"
+)
+
+// Schedule is a job of schedule.jobs and its state
+type Schedule = apiv1.Schedule
+
+// UseJobs runs jobs on their cron schedules as jobs of the server, starting the scheduler the
+// first time; later calls replace the jobs of the running scheduler. A run collects the
+// configured tenant and fails while the server is authenticated to another one.
+func (s *Server) UseJobs(jobs []schedule.Job) error {
+	for _, job := range jobs {
+		for _, name := range job.Datasets {
+			if _, err := collect.Lookup(name); err != nil {
+				return fmt.Errorf("job %s: %w", job.Name, err)
+			}
+		}
+	}
+
+	s.config.Lock()
+	defer s.config.Unlock()
+	if s.Scheduler != nil {
+		return s.Scheduler.Update(jobs...)
+	}
+	if len(jobs) == 0 {
+		return nil
+	}
+	if s.draining.Load() {
+		return ErrShuttingDown
+	}
+	var tenant string
+	if s.Params != nil {
+		tenant = s.Params.TenantID
+	}
+	sched, err := schedule.New(s.Auth.Store, tenant, s.runScheduled, jobs...)
+	if err != nil {
+		return err
+	}
+	s.Scheduler = sched
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		sched.Start(s.ctx, s.stopScheduler)
+	}()
+	return nil
+}
+
+// scheduler returns the scheduler, nil when no job is scheduled
+func (s *Server) scheduler() *schedule.Scheduler {
+	s.config.RLock()
+	defer s.config.RUnlock()
+	return s.Scheduler
+}
+
+// runScheduled collects a window of a scheduled job through the same path as a launched job,
+// shipping it when sinks are configured, and waits for it. A job whose shipping failed has
+// still collected its window.
+func (s *Server) runScheduled(_ context.Context, sj schedule.Job, window schedule.Window) error {
+	params := s.Auth.GetAuthParams()
+	if params == nil {
+		return auth.ErrNotAuthenticated
+	}
+	if tenant := s.scheduler().Tenant; params.TenantID != tenant {
+		return fmt.Errorf("job %s collects tenant %s, the server is authenticated to %s", sj.Name, tenant, params.TenantID)
+	}
+
+	datasets := sj.Datasets
+	if len(datasets) == 0 {
+		datasets = collect.Datasets()
+	}
+	out, shipper, _, _ := s.live()
+	cp, err := collect.NewCheckpoint(s.Out, collect.NewRunID(), params.TenantID, collect.Plan{
+		Job:      sj.Name,
+		Datasets: datasets,
+		Since:    window.Since,
+		Until:    window.Until,
+		Full:     sj.Full,
+		Output:   out,
+	})
+	if err != nil {
+		return err
+	}
+	j, err := s.start(cp, params, shipper != nil, "")
+	if err != nil {
+		return err
+	}
+	<-j.done
+	if j.err != nil && !cp.Done() {
+		return j.err
+	}
+	return nil
+}
+
+// listSchedules reports every scheduled job with its state, sorted by name
+func (s *Server) listSchedules(c *gin.Context) {
+	list := []Schedule{}
+	sched := s.scheduler()
+	if sched == nil {
+		c.JSON(http.StatusOK, list)
+		return
+	}
+	states, err := sched.States(c.Request.Context())
+	if err != nil {
+		fail(c, errorStatus(err), err)
+		return
+	}
+	jobs := map[string]schedule.Job{}
+	for _, job := range sched.Jobs() {
+		jobs[job.Name] = job
+	}
+	for _, st := range states {
+		// A job removed since the states were read is left out
+		job, ok := jobs[st.Job]
+		if !ok {
+			continue
+		}
+		list = append(list, Schedule{ScheduleState: st, Schedule: job.Schedule, Datasets: job.Datasets})
+	}
+	c.JSON(http.StatusOK, list)
+}
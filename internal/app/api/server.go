@@ -0,0 +1,413 @@
+// Package api serves the /v1 control plane: remote tools authenticate the server, launch,
+// follow and cancel collection jobs, and download their outputs
+package api
+
+import (
+	"context"
+	_ "embed"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/arustydev/goslings/internal/auth"
+	"github.com/arustydev/goslings/internal/auth/shared"
+	"github.com/arustydev/goslings/internal/output"
+	"github.com/arustydev/goslings/internal/schedule"
+	"github.com/arustydev/goslings/internal/sink"
+	"github.com/arustydev/goslings/internal/utils/telemetry"
+	"github.com/arustydev/goslings/pkg/apiv1"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// OpenAPI is the specification of the routes served by the server
+//
+//go:embed openapi.yaml
+var OpenAPI []byte
+
+const (
+	DefaultAddress         = ":8080"
+	DefaultOut             = "./output"
+	DefaultPollInterval    = time.Second
+	DefaultShutdownTimeout = 30 * time.Second
+	DefaultDrainTimeout    = 10 * time.Second
+	DefaultReloadInterval  = time.Minute
+	DefaultJobRetention    = 24 * time.Hour
+	DefaultMaxJobs         = 100
+)
+
+// Options configures the server, it is read from the api section of the configuration
+type Options struct {
+	// Address is the address the server listens on
+	Address string `mapstructure:"address"`
+
+	// Out is the directory runs are written to
+	Out string `mapstructure:"out"`
+
+	// ShutdownTimeout bounds how long a stop waits for running jobs to reach a page boundary
+	ShutdownTimeout time.Duration `mapstructure:"shutdown_timeout"`
+
+	// DrainTimeout bounds how long a stop then waits for in-flight requests to complete
+	DrainTimeout time.Duration `mapstructure:"drain_timeout"`
+
+	// RequireTokens reports the server unready while it holds no valid token; leave it off
+	// when the server is signed in through the API, an unready server gets no traffic
+	RequireTokens bool `mapstructure:"require_tokens"`
+
+	// TrustedProxies are the addresses and CIDR ranges of the proxies whose X-Forwarded-For
+	// and X-Real-IP headers name the client; without them the client is the peer address
+	TrustedProxies []string `mapstructure:"trusted_proxies"`
+
+	TLS TLSOptions `mapstructure:"tls"`
+
+	// Access configures how callers authenticate besides API keys, which are always accepted
+	Access AccessOptions `mapstructure:"auth"`
+}
+
+// TLSOptions configures the server's certificate and the CA its client certificates are verified with
+type TLSOptions struct {
+	// Cert and Key are PEM files, the server serves plaintext HTTP without them
+	Cert string `mapstructure:"cert"`
+	Key  string `mapstructure:"key"`
+
+	// ClientCA is a PEM bundle that enables mTLS; client certificates are optional so other
+	// methods keep working, but a certificate that does not verify fails the handshake
+	ClientCA string `mapstructure:"client_ca"`
+
+	// Reload checks Cert and Key every ReloadInterval and serves them once they change, so
+	// renewed certificates are picked up without a restart
+	Reload         bool          `mapstructure:"reload"`
+	ReloadInterval time.Duration `mapstructure:"reload_interval"`
+}
+
+// AccessOptions configures the authentication methods of callers
+type AccessOptions struct {
+	MTLS MTLSOptions `mapstructure:"mtls"`
+	OIDC OIDCOptions `mapstructure:"oidc"`
+}
+
+// MTLSOptions maps client certificates to roles, see MTLS
+type MTLSOptions struct {
+	Roles       map[string]Role `mapstructure:"roles"`
+	DefaultRole Role            `mapstructure:"default_role"`
+}
+
+// Validate checks the options, filling in defaults
+func (o *Options) Validate() error {
+	if (o.TLS.Cert == "") != (o.TLS.Key == "") {
+		return errors.New("api.tls.cert and api.tls.key must be set together")
+	}
+	if o.TLS.ClientCA != "" && o.TLS.Cert == "" {
+		return errors.New("api.tls.client_ca needs api.tls.cert and api.tls.key")
+	}
+	if o.TLS.Reload && o.TLS.Cert == "" {
+		return errors.New("api.tls.reload needs api.tls.cert and api.tls.key")
+	}
+	roles := []Role{o.Access.MTLS.DefaultRole}
+	for _, r := range o.Access.MTLS.Roles {
+		roles = append(roles, r)
+	}
+	for _, r := range o.Access.OIDC.Roles {
+		roles = append(roles, r)
+	}
+	for _, r := range roles {
+		if r == "" {
+			continue
+		}
+		if _, err := ParseRole(string(r)); err != nil {
+			return err
+		}
+	}
+	if o.Access.OIDC.Issuer != "" && o.Access.OIDC.Audience == "" {
+		return errors.New("api.auth.oidc.audience is required with an issuer")
+	}
+	if err := gin.New().SetTrustedProxies(o.TrustedProxies); err != nil {
+		return fmt.Errorf("api.trusted_proxies: %w", err)
+	}
+	if o.Address == "" {
+		o.Address = DefaultAddress
+	}
+	if o.Out == "" {
+		o.Out = DefaultOut
+	}
+	if o.ShutdownTimeout <= 0 {
+		o.ShutdownTimeout = DefaultShutdownTimeout
+	}
+	if o.DrainTimeout <= 0 {
+		o.DrainTimeout = DefaultDrainTimeout
+	}
+	if o.TLS.ReloadInterval <= 0 {
+		o.TLS.ReloadInterval = DefaultReloadInterval
+	}
+	return nil
+}
+
+// Server is the API server, it runs jobs with the same AuthManager and collectors as the CLI
+type Server struct {
+	// Auth authenticates the server and provides the tokens of every job
+	Auth *auth.AuthManager
+
+	// Params are the configured authentication parameters, a request may override the
+	// tenant and client
+	Params *shared.AuthParams
+
+	// Out is the directory runs are written to
+	Out string
+
+	// Output is how jobs write records unless they override it
+	Output output.Options
+
+	// Client is the HTTP client used by collections
+	Client *http.Client
+
+	// Settings are the redacted configuration recorded in manifests
+	Settings map[string]any
+
+	// Shipper ships completed jobs that ask for it, nil when no sink is configured
+	Shipper *sink.Shipper
+
+	// Scheduler starts the jobs of schedule.jobs, it is set by UseJobs
+	Scheduler *schedule.Scheduler
+
+	// NewCredential creates the credential of an authentication request
+	NewCredential CredentialFunc
+
+	// Authenticators identify callers, they are tried in order before the API keys of the store
+	Authenticators []Authenticator
+
+	// Audit records refused requests
+	Audit func(AuditEvent)
+
+	// PollInterval is how often a progress stream checks a job's checkpoint
+	PollInterval time.Duration
+
+	// JobRetention is how long a finished job's outcome and events are kept in memory, and
+	// MaxJobs how many finished jobs are; evicted jobs are described from their checkpoints
+	JobRetention time.Duration
+	MaxJobs      int
+
+	// RequireTokens fails readiness while the server holds no valid token, see Options
+	RequireTokens bool
+
+	router *gin.Engine
+	keys   *Keys
+
+	// roles are the roles required by each route, keyed by method and path
+	roles map[string]Role
+
+	// ctx is the parent of every job, it is cancelled when the server shuts down
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// draining fails readiness once the server is shutting down, and refuses new jobs
+	draining atomic.Bool
+
+	// stopScheduler is closed when the server shuts down
+	stopScheduler chan struct{}
+
+	// config guards Output, Settings, Shipper, Scheduler and RequireTokens once the server
+	// runs, see Reconfigure and UseJobs
+	config sync.RWMutex
+
+	mu     sync.Mutex
+	jobs   map[string]*job
+	signIn *auth.SignIn
+	wg     sync.WaitGroup
+}
+
+// New creates a server that writes runs to out, authenticated by authManager
+func New(authManager *auth.AuthManager, params *shared.AuthParams, out string, opts output.Options) *Server {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Server{
+		Auth:          authManager,
+		Params:        params,
+		Out:           out,
+		Output:        opts,
+		Client:        http.DefaultClient,
+		NewCredential: newCredential,
+		PollInterval:  DefaultPollInterval,
+		JobRetention:  DefaultJobRetention,
+		MaxJobs:       DefaultMaxJobs,
+		Audit:         logAudit,
+		ctx:           ctx,
+		cancel:        cancel,
+		stopScheduler: make(chan struct{}),
+		jobs:          map[string]*job{},
+		roles:         map[string]Role{},
+	}
+	if authManager != nil {
+		s.keys = &Keys{Store: authManager.Store}
+	}
+	s.router = s.routes()
+	return s
+}
+
+// Reconfigure applies a new output, shipper, settings and readiness requirement to a running
+// server; jobs already running finish with the ones they started with
+func (s *Server) Reconfigure(out output.Options, shipper *sink.Shipper, settings map[string]any, requireTokens bool) error {
+	if err := out.Validate(); err != nil {
+		return err
+	}
+	s.config.Lock()
+	defer s.config.Unlock()
+	s.Output = out
+	s.Shipper = shipper
+	s.Settings = settings
+	s.RequireTokens = requireTokens
+	return nil
+}
+
+// SetTrustedProxies sets the proxies whose forwarding headers name the client of a request,
+// see Options.TrustedProxies
+func (s *Server) SetTrustedProxies(proxies []string) error {
+	return s.router.SetTrustedProxies(proxies)
+}
+
+// live returns the output, shipper, settings and readiness requirement, which Reconfigure
+// may change
+func (s *Server) live() (output.Options, *sink.Shipper, map[string]any, bool) {
+	s.config.RLock()
+	defer s.config.RUnlock()
+	return s.Output, s.Shipper, s.Settings, s.RequireTokens
+}
+
+// routes registers every handler, each route must be described in openapi.yaml along with
+// the role it requires
+func (s *Server) routes() *gin.Engine {
+	router := gin.New()
+	// Forwarding headers are ignored until SetTrustedProxies names the proxies sending them
+	_ = router.SetTrustedProxies(nil)
+	router.Use(gin.Recovery(), logRequests())
+
+	// Probes and metrics are unauthenticated and outside the versioned API
+	router.GET("/healthz", s.healthz)
+	router.GET("/readyz", s.readyz)
+	router.GET(telemetry.MetricsPath, gin.WrapH(telemetry.MetricsHandler()))
+
+	v1 := router.Group("/v1")
+	v1.GET("/openapi.yaml", func(c *gin.Context) {
+		c.Data(http.StatusOK, "application/yaml", OpenAPI)
+	})
+
+	v1.Use(auditRequests(), s.authenticate())
+	s.handle(v1, http.MethodGet, "/me", Viewer, s.getPrincipal)
+
+	s.handle(v1, http.MethodGet, "/auth", Viewer, s.getAuth)
+	s.handle(v1, http.MethodPost, "/auth", Admin, s.startAuth)
+	s.handle(v1, http.MethodDelete, "/auth", Admin, s.clearAuth)
+
+	s.handle(v1, http.MethodGet, "/jobs", Viewer, s.listJobs)
+	s.handle(v1, http.MethodPost, "/jobs", Operator, s.createJob)
+	s.handle(v1, http.MethodGet, "/jobs/:id", Viewer, s.getJob)
+	s.handle(v1, http.MethodDelete, "/jobs/:id", Operator, s.cancelJob)
+	s.handle(v1, http.MethodGet, "/jobs/:id/progress", Viewer, s.streamProgress)
+	s.handle(v1, http.MethodGet, "/jobs/:id/events", Viewer, s.streamEvents)
+	s.handle(v1, http.MethodGet, "/jobs/:id/artifacts", Viewer, s.listArtifacts)
+	s.handle(v1, http.MethodGet, "/jobs/:id/artifacts/*path", Operator, s.getArtifact)
+
+	s.handle(v1, http.MethodGet, "/schedules", Viewer, s.listSchedules)
+
+	s.handle(v1, http.MethodGet, "/tenants", Viewer, s.listTenants)
+
+	s.handle(v1, http.MethodGet, "/keys", Admin, s.listKeys)
+	s.handle(v1, http.MethodPost, "/keys", Admin, s.createKey)
+	s.handle(v1, http.MethodDelete, "/keys/:id", Admin, s.revokeKey)
+	return router
+}
+
+// handle registers a handler for the principals whose role includes required
+func (s *Server) handle(group *gin.RouterGroup, method, path string, required Role, handler gin.HandlerFunc) {
+	group.Handle(method, path, s.allow(required), handler)
+	s.roles[method+" "+group.BasePath()+path] = required
+}
+
+// getPrincipal reports who the caller authenticated as
+func (s *Server) getPrincipal(c *gin.Context) {
+	c.JSON(http.StatusOK, RequestPrincipal(c))
+}
+
+// ServeHTTP implements http.Handler.ServeHTTP for Server
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.router.ServeHTTP(w, r)
+}
+
+// Shutdown reports the server unready, stops the scheduler, then stops every running job at its
+// next page boundary and waits for them; jobs still running when ctx is done are aborted and
+// left resumable
+func (s *Server) Shutdown(ctx context.Context) error {
+	if !s.draining.Swap(true) {
+		close(s.stopScheduler)
+	}
+	s.mu.Lock()
+	for _, j := range s.jobs {
+		j.requestStop()
+	}
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		s.cancel()
+		return nil
+	case <-ctx.Done():
+		s.cancel()
+		<-done
+		return ctx.Err()
+	}
+}
+
+// Error is the body of every error response
+type Error = apiv1.Error
+
+// fail aborts a request with an error response
+func fail(c *gin.Context, status int, err error) {
+	c.AbortWithStatusJSON(status, Error{Error: err.Error()})
+}
+
+// errorStatus maps the errors of the packages behind the API to response statuses
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrJobNotFound), errors.Is(err, ErrArtifactNotFound), errors.Is(err, ErrKeyNotFound),
+		errors.Is(err, ErrNoEvents):
+		return http.StatusNotFound
+	case errors.Is(err, ErrInvalidRequest), errors.Is(err, ErrInvalidRole):
+		return http.StatusBadRequest
+	case errors.Is(err, auth.ErrNotAuthenticated), errors.Is(err, ErrJobNotRunning),
+		errors.Is(err, ErrJobRunning), errors.Is(err, ErrJobsRunning), errors.Is(err, ErrSignInPending):
+		return http.StatusConflict
+	case errors.Is(err, ErrShuttingDown):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// logRequests logs every request once it has been served
+func logRequests() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		entry := telemetry.Logger(c.Request.Context()).WithFields(log.Fields{
+			"method":   c.Request.Method,
+			"path":     c.Request.URL.Path,
+			"status":   c.Writer.Status(),
+			"duration": time.Since(start).String(),
+			"client":   c.ClientIP(),
+		})
+		if p := RequestPrincipal(c); p != nil {
+			entry = entry.WithField("subject", p.Subject)
+		}
+		if c.Writer.Status() >= http.StatusInternalServerError {
+			entry.Warn("Request failed")
+			return
+		}
+		entry.Debug("Request served")
+	}
+}
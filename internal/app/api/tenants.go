@@ -0,0 +1,55 @@
+package api
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/arustydev/goslings/internal/collect"
+	"github.com/arustydev/goslings/pkg/apiv1"
+	"github.com/gin-gonic/gin"
+)
+
+// Tenant is a tenant the server is configured for, authenticated to or has collected from
+type Tenant = apiv1.Tenant
+
+// listTenants lists the known tenants sorted by ID
+func (s *Server) listTenants(c *gin.Context) {
+	cps, err := collect.ListCheckpoints(s.Out)
+	if err != nil {
+		fail(c, errorStatus(err), err)
+		return
+	}
+
+	tenants := map[string]*Tenant{}
+	tenant := func(id string) *Tenant {
+		t, ok := tenants[id]
+		if !ok {
+			t = &Tenant{ID: id}
+			tenants[id] = t
+		}
+		return t
+	}
+	if s.Params != nil && s.Params.TenantID != "" {
+		tenant(s.Params.TenantID).Configured = true
+	}
+	if st := s.status(); st.State == AuthAuthenticated && st.Tenant != "" {
+		tenant(st.Tenant).Authenticated = true
+	}
+	for _, cp := range cps {
+		if cp.Tenant == "" {
+			continue
+		}
+		t := tenant(cp.Tenant)
+		t.Jobs++
+		if cp.Created.After(t.LastJob) {
+			t.LastJob = cp.Created
+		}
+	}
+
+	list := make([]Tenant, 0, len(tenants))
+	for _, t := range tenants {
+		list = append(list, *t)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	c.JSON(http.StatusOK, list)
+}
@@ -0,0 +1,126 @@
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/arustydev/goslings/internal/utils/telemetry"
+)
+
+// Certificate is the server's certificate, reloaded from its files when they change
+type Certificate struct {
+	CertFile string
+	KeyFile  string
+
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	modified time.Time
+}
+
+// LoadCertificate loads a PEM certificate and key
+func LoadCertificate(certFile, keyFile string) (*Certificate, error) {
+	c := &Certificate{CertFile: certFile, KeyFile: keyFile}
+	if _, err := c.Reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate for Certificate
+func (c *Certificate) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.cert, nil
+}
+
+// Reload loads the files again if either was modified since they were last loaded, reporting
+// whether they were; the current certificate is kept when they cannot be loaded
+func (c *Certificate) Reload() (bool, error) {
+	modified, err := lastModified(c.CertFile, c.KeyFile)
+	if err != nil {
+		return false, err
+	}
+	c.mu.RLock()
+	unchanged := c.cert != nil && modified.Equal(c.modified)
+	c.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		return false, fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	c.mu.Lock()
+	c.cert, c.modified = &cert, modified
+	c.mu.Unlock()
+	return true, nil
+}
+
+// Watch reloads the certificate every interval until ctx is done
+func (c *Certificate) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		reloaded, err := c.Reload()
+		if err != nil {
+			telemetry.Logger(ctx).Warnf("Keeping the current TLS certificate: %v", err)
+			continue
+		}
+		if reloaded {
+			telemetry.Logger(ctx).Infof("Reloaded the TLS certificate from %s", c.CertFile)
+		}
+	}
+}
+
+// lastModified returns the latest modification time of files, following symlinks so the
+// atomic swaps of mounted Kubernetes secrets are noticed
+func lastModified(files ...string) (time.Time, error) {
+	var latest time.Time
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("failed to stat TLS file: %w", err)
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// NewTLSConfig creates the server's TLS configuration; client certificates are requested and
+// verified against opts.ClientCA when it is set, but not required so other methods keep working
+func NewTLSConfig(opts TLSOptions) (*tls.Config, *Certificate, error) {
+	cert, err := LoadCertificate(opts.Cert, opts.Key)
+	if err != nil {
+		return nil, nil, err
+	}
+	cfg := &tls.Config{
+		GetCertificate: cert.GetCertificate,
+		MinVersion:     tls.VersionTLS12,
+	}
+	if opts.ClientCA != "" {
+		pem, err := os.ReadFile(opts.ClientCA)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read client CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, nil, fmt.Errorf("no certificates in %s", opts.ClientCA)
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return cfg, cert, nil
+}
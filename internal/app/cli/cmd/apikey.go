@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/arustydev/goslings/internal/app/api"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	apikeyCreateCmd.Flags().StringP("role", "r", string(api.Viewer), "Role of the key: viewer, operator or admin")
+
+	apikeyCmd.AddCommand(apikeyCreateCmd)
+	apikeyCmd.AddCommand(apikeyListCmd)
+	apikeyCmd.AddCommand(apikeyRevokeCmd)
+}
+
+var apikeyCmd = &cobra.Command{
+	Use:   "apikey",
+	Short: "Manage the API keys accepted by the API server",
+	Long: `Manage the API keys accepted by the API server. Keys are kept hashed in the
+credential store the server uses, so this must run with the same store configuration.`,
+}
+
+var apikeyCreateCmd = &cobra.Command{
+	Use:   "create <name>",
+	Short: "Create an API key, printing it once",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		role, _ := cmd.Flags().GetString("role")
+		keys := newKeys(cmd)
+		token, key, err := keys.Create(cmd.Context(), args[0], api.Role(role))
+		if err != nil {
+			log.Fatalf("Failed to create API key: %v", err)
+		}
+		fmt.Fprintf(os.Stderr, "Created %s key %s (%s), it cannot be shown again\n", key.Role, key.ID, key.Name)
+		fmt.Println(token)
+	},
+}
+
+var apikeyListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the API keys without their secrets",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		list, err := newKeys(cmd).List(cmd.Context())
+		if err != nil {
+			log.Fatalf("Failed to list API keys: %v", err)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tNAME\tROLE\tCREATED")
+		for _, k := range list {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", k.ID, k.Name, k.Role, k.Created.Format(time.RFC3339))
+		}
+		_ = w.Flush()
+	},
+}
+
+var apikeyRevokeCmd = &cobra.Command{
+	Use:   "revoke <id>",
+	Short: "Revoke an API key",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := newKeys(cmd).Revoke(cmd.Context(), args[0]); err != nil {
+			log.Fatalf("Failed to revoke API key: %v", err)
+		}
+	},
+}
+
+// newKeys opens the API keys of the configured credential store
+func newKeys(cmd *cobra.Command) *api.Keys {
+	authManager, err := newAuthManager(cmd)
+	if err != nil {
+		log.Fatalf("Failed to create auth manager: %v", err)
+	}
+	return &api.Keys{Store: authManager.Store}
+}
@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/arustydev/goslings/internal/audit"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	auditCmd.AddCommand(auditVerifyCmd)
+}
+
+var auditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "Check the audit log of what goslings did",
+	Long: `Check the audit log of what goslings did. Every authentication, API key issued,
+collection, configuration change, store clear and API request is appended to audit.file,
+each event chained to the one before it by its hash.`,
+}
+
+var auditVerifyCmd = &cobra.Command{
+	Use:   "verify [file]",
+	Short: "Report the events of the audit log that were edited, removed or reordered",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		path := viper.GetString("audit.file")
+		if len(args) > 0 {
+			path = args[0]
+		}
+		if path == "" {
+			log.Fatal("Auditing is off, name the audit log to verify")
+		}
+		report, err := audit.Verify(path)
+		if err != nil {
+			log.Fatalf("Failed to verify the audit log: %v", err)
+		}
+
+		for _, p := range report.Problems {
+			fmt.Println(p)
+		}
+		if len(report.Problems) > 0 {
+			fmt.Printf("%d problems found in %d events of %s\n", len(report.Problems), report.Events, path)
+			log.Exit(1)
+		}
+		if report.Last == nil {
+			fmt.Printf("%s has no events\n", path)
+			return
+		}
+		fmt.Printf("All %d events of %s are chained, the last is event %d with hash %s\n",
+			report.Events, path, report.Last.Seq, report.Last.Hash)
+	},
+}
@@ -1,13 +1,11 @@
 package cmd
 
 import (
-	"crypto/rand"
 	"errors"
 	"fmt"
 	"time"
 
 	"github.com/arustydev/goslings/internal/auth"
-	"github.com/arustydev/goslings/internal/auth/shared"
 	"github.com/arustydev/goslings/internal/conf"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
@@ -18,24 +16,24 @@ var (
 	ErrConfigNotSet = errors.New("a config parameter was not set")
 )
 
+func init() {
+	authCmd.Flags().String("method", "device_code", "With --remote, how the server signs in: device_code, secret or managed_identity")
+}
+
 var authCmd = &cobra.Command{
 	Use:   "auth",
 	Short: "middle command for authenticating to the cloud",
 	Run: func(cmd *cobra.Command, args []string) {
-		log.Info("Starting authentication example")
-
-		// Generate a random encryption key (in a real app, you would store this securely)
-		encryptionKey := make([]byte, 32)
-		if _, err := rand.Read(encryptionKey); err != nil {
-			log.Fatalf("Failed to generate encryption key: %v", err)
+		if c := newClient(); c != nil {
+			method, _ := cmd.Flags().GetString("method")
+			remoteAuth(cmd.Context(), c, method)
+			return
 		}
 
+		log.Info("Starting authentication example")
+
 		// Initialize the auth manager
-		authManager, err := auth.NewAuthManager(cmd.Context(), auth.Options{
-			StoreType:     shared.FileStore,
-			StorePath:     "./.credentials",
-			EncryptionKey: encryptionKey,
-		})
+		authManager, err := newAuthManager(cmd)
 		if err != nil {
 			log.Fatalf("Failed to create auth manager: %v", err)
 		}
@@ -74,3 +72,12 @@ var authCmd = &cobra.Command{
 		log.Info("Authentication example completed successfully")
 	},
 }
+
+// newAuthManager creates an AuthManager backed by the configured credential store
+func newAuthManager(cmd *cobra.Command) (*auth.AuthManager, error) {
+	opts, err := conf.GetStoreOptions()
+	if err != nil {
+		return nil, err
+	}
+	return auth.NewAuthManager(cmd.Context(), opts)
+}
@@ -1,13 +1,246 @@
 package cmd
 
 import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/arustydev/goslings/internal/conf"
+	"github.com/charmbracelet/x/term"
+	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
 
+// configErr is why the config file could not be read, only conf commands run despite it
+var configErr error
+
+func init() {
+	confInitCmd.Flags().String("path", conf.DefaultConfigFile, "Where to write the configuration")
+	confInitCmd.Flags().Bool("force", false, "Overwrite an existing configuration")
+	confInitCmd.Flags().Bool("no-input", false, "Do not prompt, even from a terminal")
+	confInitCmd.Flags().String("format", "jsonl", "Output format: jsonl, csv or parquet (output.format)")
+
+	confShowCmd.Flags().Bool("all", false, "Show the keys that have no value too")
+	confSetCmd.Flags().String("path", "", "Config file to change, the loaded one with the highest precedence by default")
+
+	confCmd.AddCommand(confInitCmd)
+	confCmd.AddCommand(confShowCmd)
+	confCmd.AddCommand(confValidateCmd)
+	confCmd.AddCommand(confSetCmd)
+}
+
 var confCmd = &cobra.Command{
 	Use:   "conf",
 	Short: "convenience command for working with Gosling configuration",
+}
+
+// initPrompts are the keys conf init asks for, with the flag giving their default; the flags
+// other than format are the global ones of the keys
+var initPrompts = []struct {
+	key, flag, question string
+}{
+	{"msft.tenant", "tenant", "Tenant ID"},
+	{"auth.app.id", "client-id", "Application (client) ID, empty for the Azure SDK's public client"},
+	{"msft.subscription", "subscription", "Azure subscription ID, empty to skip Azure"},
+	{"msft.usgov.cloud", "usgov", "US Government cloud (true/false)"},
+	{"output.format", "format", "Output format (jsonl, csv or parquet)"},
+}
+
+var confInitCmd = &cobra.Command{
+	Use:   "init",
+	Short: "Write a commented brood.yaml, from flags or by asking for each value",
+	Args:  cobra.NoArgs,
 	Run: func(cmd *cobra.Command, args []string) {
-		// Do Stuff Here
+		path, _ := cmd.Flags().GetString("path")
+		force, _ := cmd.Flags().GetBool("force")
+		noInput, _ := cmd.Flags().GetBool("no-input")
+		if _, err := os.Stat(path); err == nil && !force {
+			log.Fatalf("%s already exists, change it with gosling conf set or overwrite it with --force", path)
+		} else if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			log.Fatalf("Failed to check %s: %v", path, err)
+		}
+
+		interactive := !noInput && term.IsTerminal(os.Stdin.Fd())
+		in := bufio.NewReader(os.Stdin)
+		values := map[string]any{}
+		for _, p := range initPrompts {
+			k, _ := conf.LookupKey(p.key)
+			text := cmd.Flags().Lookup(p.flag).Value.String()
+			for interactive {
+				answer, err := prompt(in, p.question, text)
+				if err != nil {
+					log.Fatalf("Failed to read the answer: %v", err)
+				}
+				if _, err := conf.ParseValue(k, answer); err != nil && answer != "" {
+					fmt.Fprintf(os.Stderr, "  %v\n", err)
+					continue
+				}
+				text = answer
+				break
+			}
+			if text == "" || (!interactive && !cmd.Flags().Changed(p.flag)) {
+				continue
+			}
+			value, err := conf.ParseValue(k, text)
+			if err != nil {
+				log.Fatalf("Invalid --%s: %v", p.flag, err)
+			}
+			values[p.key] = value
+		}
+
+		if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+			log.Fatalf("Failed to create %s: %v", filepath.Dir(path), err)
+		}
+		if err := os.WriteFile(path, conf.Template(values), 0o600); err != nil {
+			log.Fatalf("Failed to write %s: %v", path, err)
+		}
+		fmt.Printf("Wrote %s\n", path)
+		fmt.Printf("Keep secrets out of it, eg. set %s in the environment for the client secret\n", conf.EnvVar("auth.app.secret"))
 	},
 }
+
+// prompt asks a question on stderr, returning the default for an empty answer
+func prompt(in *bufio.Reader, question, def string) (string, error) {
+	if def != "" {
+		fmt.Fprintf(os.Stderr, "%s [%s]: ", question, def)
+	} else {
+		fmt.Fprintf(os.Stderr, "%s: ", question)
+	}
+	answer, err := in.ReadString('\n')
+	if err != nil && answer == "" {
+		return "", err
+	}
+	if answer = strings.TrimSpace(answer); answer == "" {
+		return def, nil
+	}
+	return answer, nil
+}
+
+var confShowCmd = &cobra.Command{
+	Use:   "show [section]",
+	Short: "Show the effective configuration and where each value comes from, secrets redacted",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if configErr != nil {
+			log.Errorf("%v, showing the environment and defaults", configErr)
+		}
+		all, _ := cmd.Flags().GetBool("all")
+		if layers := conf.Layers(); len(layers) > 0 {
+			fmt.Println("Config files, each overriding the previous one:")
+			for _, l := range layers {
+				fmt.Printf("  %-8s %s\n", l.Source, l.Path)
+			}
+			fmt.Println()
+		}
+
+		settings := conf.Settings()
+		if all {
+			settings = allSettings(settings)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "KEY\tVALUE\tSOURCE\tORIGIN")
+		for _, s := range settings {
+			if len(args) == 1 && s.Key != args[0] && !strings.HasPrefix(s.Key, args[0]+".") {
+				continue
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", s.Key, showValue(s.Value), s.Source, s.Origin)
+		}
+		_ = w.Flush()
+	},
+}
+
+// allSettings adds the keys that have no value to settings
+func allSettings(settings []conf.Setting) []conf.Setting {
+	set := make(map[string]conf.Setting, len(settings))
+	for _, s := range settings {
+		set[s.Key] = s
+	}
+	all := make([]conf.Setting, 0, len(conf.Keys))
+	for _, k := range conf.Keys {
+		s, ok := set[k.Name]
+		if !ok {
+			s = conf.Setting{Key: k.Name}
+		}
+		all = append(all, s)
+	}
+	return all
+}
+
+// showValue renders a value on a single line
+func showValue(value any) string {
+	switch value.(type) {
+	case nil:
+		return "-"
+	case map[string]any, []any:
+		data, err := json.Marshal(value)
+		if err == nil {
+			return string(data)
+		}
+	}
+	return fmt.Sprint(value)
+}
+
+var confValidateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Check the configuration for unknown keys, invalid values and conflicting options",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if configErr != nil {
+			fmt.Println(configErr)
+			log.Exit(1)
+		}
+		problems := conf.Validate()
+		for _, p := range problems {
+			fmt.Println(p)
+		}
+		if len(problems) > 0 {
+			fmt.Printf("%d problems found\n", len(problems))
+			log.Exit(1)
+		}
+		fmt.Println("The configuration is valid")
+	},
+}
+
+var confSetCmd = &cobra.Command{
+	Use:   "set <key> <value>",
+	Short: "Set a key in the config file with the highest precedence, keeping its comments; lists are comma separated",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		path := configPath(cmd)
+		if err := conf.Set(path, args[0], args[1]); err != nil {
+			log.Fatalf("Failed to set %s: %v", args[0], err)
+		}
+		if s, ok := setting(args[0]); ok && (s.Source == conf.SourceEnv || s.Source == conf.SourceFlag) {
+			log.Warnf("%s overrides %s", s.Origin, path)
+		}
+		fmt.Printf("Set %s in %s\n", args[0], path)
+	},
+}
+
+// setting returns the effective value of a key
+func setting(key string) (conf.Setting, bool) {
+	for _, s := range conf.Settings() {
+		if s.Key == strings.ToLower(key) {
+			return s, true
+		}
+	}
+	return conf.Setting{}, false
+}
+
+// configPath returns the config file a command changes: --path, or else the loaded file with
+// the highest precedence, or else the project file
+func configPath(cmd *cobra.Command) string {
+	if path, _ := cmd.Flags().GetString("path"); path != "" {
+		return path
+	}
+	if path := conf.ConfigFile(); path != "" {
+		return path
+	}
+	return conf.DefaultConfigFile
+}
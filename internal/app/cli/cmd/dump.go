@@ -1,13 +1,148 @@
 package cmd
 
 import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/arustydev/goslings/internal/collect"
+	"github.com/arustydev/goslings/internal/conf"
+	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
 
+func init() {
+	dumpCmd.Flags().Bool("full", false, "Ignore stored delta links and collect a full baseline")
+	dumpCmd.Flags().Bool("list", false, "List the available datasets and exit")
+	dumpCmd.Flags().StringP("out", "o", "./output", "Directory to write collection runs to")
+	dumpCmd.Flags().Duration("since", 7*24*time.Hour, "How far back to collect time-windowed datasets")
+	dumpCmd.Flags().String("resume", "", "Resume the interrupted run with this run ID")
+	dumpCmd.Flags().String("format", "jsonl", "Output format: jsonl, csv or parquet")
+	dumpCmd.Flags().String("compress", "none", "Output compression: none, gzip or zstd")
+	dumpCmd.Flags().Bool("ship", false, "Ship the completed run to the configured sinks")
+	dumpCmd.Flags().Bool("download", true, "With --remote, download the completed run into --out")
+
+	conf.BindFlag("output.format", dumpCmd.Flags().Lookup("format"))
+	conf.BindFlag("output.compression", dumpCmd.Flags().Lookup("compress"))
+}
+
 var dumpCmd = &cobra.Command{
-	Use:   "dump",
+	Use:   "dump [dataset...]",
 	Short: "middle command for dumping data from endpoints",
 	Run: func(cmd *cobra.Command, args []string) {
-		// Do Stuff Here
+		if list, _ := cmd.Flags().GetBool("list"); list {
+			for _, name := range collect.Datasets() {
+				fmt.Println(name)
+			}
+			return
+		}
+
+		opts := dumpOptions{Datasets: args}
+		opts.Full, _ = cmd.Flags().GetBool("full")
+		opts.Out, _ = cmd.Flags().GetString("out")
+		opts.Since, _ = cmd.Flags().GetDuration("since")
+		opts.Resume, _ = cmd.Flags().GetString("resume")
+		opts.Ship, _ = cmd.Flags().GetBool("ship")
+		opts.Download, _ = cmd.Flags().GetBool("download")
+		opts.Output = cmd.Flags().Changed("format") || cmd.Flags().Changed("compress")
+		runDump(cmd, opts)
 	},
 }
+
+// dumpOptions are the options of a collection run
+type dumpOptions struct {
+	Datasets []string
+	Full     bool
+	Out      string
+	Since    time.Duration
+	Resume   string
+	Ship     bool
+
+	// Download copies the files of a remote run into Out
+	Download bool
+
+	// Output sends the output configuration to a remote server instead of using its own
+	Output bool
+}
+
+// runDump collects a run, on the remote API server when one is configured
+func runDump(cmd *cobra.Command, opts dumpOptions) {
+	if c := newClient(); c != nil {
+		if _, err := remoteDump(cmd, c, jobRequest(opts), opts.Out, opts.Download); err != nil {
+			log.Fatalf("Remote job failed: %v", err)
+		}
+		return
+	}
+
+	authManager, err := newAuthManager(cmd)
+	if err != nil {
+		log.Fatalf("Failed to create auth manager: %v", err)
+	}
+	params := conf.GetAuthConfig()
+
+	var cp *collect.Checkpoint
+	if opts.Resume != "" {
+		if cp, err = collect.LoadCheckpoint(opts.Out, opts.Resume); err != nil {
+			log.Fatalf("Failed to load checkpoint: %v", err)
+		}
+		log.Infof("Resuming run %s", cp.RunID)
+	} else {
+		plan, err := newPlan(opts.Datasets, opts.Since)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		plan.Full = opts.Full
+		if plan.Output, err = conf.GetOutputOptions(); err != nil {
+			log.Fatalf("Invalid output configuration: %v", err)
+		}
+		if cp, err = collect.NewCheckpoint(opts.Out, collect.NewRunID(), params.TenantID, plan); err != nil {
+			log.Fatalf("Failed to create checkpoint: %v", err)
+		}
+		log.Infof("Starting run %s", cp.RunID)
+	}
+
+	runner := &collect.Runner{
+		Tokens:       authManager,
+		Store:        authManager.Store,
+		Client:       http.DefaultClient,
+		USGovernment: params.UsGovernment,
+	}
+	runErr := runner.Run(cmd.Context(), cp)
+	if err := writeManifest(cp, params.Identity()); err != nil {
+		log.Errorf("Failed to write manifest: %v", err)
+	}
+	if runErr != nil {
+		log.Fatalf("Run %s did not complete, resume it with --resume %s: %v", cp.RunID, cp.RunID, runErr)
+	}
+	log.Infof("Run %s completed in %s", cp.RunID, cp.Dir())
+
+	if opts.Ship {
+		if err := shipRun(cmd.Context(), cp, authManager.Store); err != nil {
+			log.Fatalf("Failed to ship run %s, retry with ship %s: %v", cp.RunID, cp.RunID, err)
+		}
+	}
+}
+
+// writeManifest records the run's chain of custody next to its output
+func writeManifest(cp *collect.Checkpoint, identity string) error {
+	digest, err := collect.WriteManifest(cp, identity, conf.Snapshot())
+	if err != nil {
+		return err
+	}
+	log.Infof("Wrote manifest %s (sha256 %s)", digest.Path, digest.SHA256)
+	return nil
+}
+
+// newPlan builds a collection plan for the named datasets, or every dataset
+func newPlan(datasets []string, since time.Duration) (collect.Plan, error) {
+	if len(datasets) == 0 {
+		datasets = collect.Datasets()
+	}
+	for _, name := range datasets {
+		if _, err := collect.Lookup(name); err != nil {
+			return collect.Plan{}, err
+		}
+	}
+	until := time.Now().UTC()
+	return collect.Plan{Datasets: datasets, Since: until.Add(-since), Until: until}, nil
+}
@@ -1,13 +1,387 @@
 package cmd
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/arustydev/goslings/internal/app/api"
+	"github.com/arustydev/goslings/internal/app/headless"
+	"github.com/arustydev/goslings/internal/auth"
+	"github.com/arustydev/goslings/internal/auth/shared"
+	"github.com/arustydev/goslings/internal/collect"
+	"github.com/arustydev/goslings/internal/conf"
+	"github.com/arustydev/goslings/pkg/apiv1"
+	"github.com/arustydev/goslings/pkg/client"
+	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 )
 
+// errMissingTokens is returned when a service the run needs could not be authenticated
+var errMissingTokens = errors.New("no token for")
+
+// collectorFamilies are the collector families a honk run can enable, by dataset name prefix
+var collectorFamilies = []string{"entra", "azure", "m365", "mde", "d4iot"}
+
+// defaultConcurrency is how many datasets a honk run collects at once unless configured
+const defaultConcurrency = 4
+
+func init() {
+	honkCmd.Flags().StringP("out", "o", headless.DefaultOut, "Directory to write collection runs to")
+	honkCmd.Flags().String("since", headless.DefaultSince.String(), "Start of the time window: a duration before --until, eg. 24h, or an RFC 3339 time")
+	honkCmd.Flags().String("until", "", "End of the time window as an RFC 3339 time, now by default")
+	honkCmd.Flags().StringSlice("include", nil, "Datasets to collect, by name or glob such as entra.*; every enabled dataset by default")
+	honkCmd.Flags().StringSlice("exclude", nil, "Datasets to skip, by name or glob")
+	honkCmd.Flags().StringSlice("collectors", collectorFamilies, "Collector families to enable")
+	honkCmd.Flags().Int("concurrency", defaultConcurrency, "How many datasets are collected at once")
+	honkCmd.Flags().String("auth", "", "How to authenticate when stored tokens cannot be renewed: device_code, secret or managed_identity; secret when a client secret is configured, device_code otherwise")
+	honkCmd.Flags().Bool("full", false, "Ignore stored delta links and collect a full baseline")
+	honkCmd.Flags().Bool("ship", false, "Ship the completed run to the configured sinks")
+	honkCmd.Flags().Bool("dry-run", false, "Print the plan and the state of every lease, then exit")
+
+	for _, name := range []string{"out", "since", "until", "include", "exclude", "collectors", "concurrency", "auth", "full", "ship"} {
+		conf.BindFlag("honk."+name, honkCmd.Flags().Lookup(name))
+	}
+}
+
 var honkCmd = &cobra.Command{
 	Use:   "honk",
-	Short: "Runs the Goslings tool",
+	Short: "Runs the Goslings tool, a complete investigation of every enabled collector",
+	Long: `Honk authenticates every service the enabled collectors need, collects their datasets
+concurrently into a new run and writes its summary and manifest. The honk section of the
+configuration sets the defaults of every flag.
+
+Exit codes: 0 when every dataset was collected, 1 when some failed, 2 for an invalid
+configuration, 3 when authentication failed, 4 when shipping failed and 5 when interrupted.`,
+	Args: cobra.NoArgs,
 	Run: func(cmd *cobra.Command, args []string) {
-		// Do Stuff Here
+		plan, err := newHonkPlan()
+		if err != nil {
+			exit(headless.ExitConfig, "Invalid honk configuration: %v", err)
+		}
+		plan.DryRun, _ = cmd.Flags().GetBool("dry-run")
+		for _, family := range plan.Skipped {
+			log.Warnf("The %s collectors are enabled but none are available in this build, skipping them", family)
+		}
+		if len(plan.Datasets) == 0 {
+			exit(headless.ExitConfig, "No dataset is selected")
+		}
+
+		if c := newClient(); c != nil {
+			log.Exit(remoteHonk(cmd, c, plan))
+		}
+		log.Exit(runHonk(cmd, plan))
 	},
 }
+
+// honkPlan is what a honk run collects
+type honkPlan struct {
+	collect.Plan
+
+	// Skipped are enabled collector families that have no dataset in this build
+	Skipped []string
+
+	// Services are the services whose tokens the datasets need
+	Services []auth.Service
+
+	Out         string
+	Concurrency int
+	Auth        string
+	Ship        bool
+	DryRun      bool
+}
+
+// newHonkPlan plans a honk run from the configuration and flags
+func newHonkPlan() (*honkPlan, error) {
+	p := &honkPlan{
+		Out:         viper.GetString("honk.out"),
+		Concurrency: viper.GetInt("honk.concurrency"),
+		Auth:        viper.GetString("honk.auth"),
+		Ship:        viper.GetBool("honk.ship"),
+	}
+	if p.Concurrency < 1 {
+		return nil, fmt.Errorf("concurrency must be at least 1, got %d", p.Concurrency)
+	}
+	if p.Out == "" {
+		return nil, errors.New("out is required")
+	}
+
+	var err error
+	p.Since, p.Until, err = collect.ParseWindow(viper.GetString("honk.since"), viper.GetString("honk.until"), time.Now(), headless.DefaultSince)
+	if err != nil {
+		return nil, err
+	}
+	p.Full = viper.GetBool("honk.full")
+	if p.Output, err = conf.GetOutputOptions(); err != nil {
+		return nil, err
+	}
+
+	families := viper.GetStringSlice("honk.collectors")
+	for _, family := range families {
+		if !slices.Contains(collectorFamilies, family) {
+			return nil, fmt.Errorf("unknown collector family %q, expected one of %s", family, strings.Join(collectorFamilies, ", "))
+		}
+	}
+	selected, err := collect.Select(viper.GetStringSlice("honk.include"), viper.GetStringSlice("honk.exclude"))
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range selected {
+		if !slices.Contains(families, collect.Family(name)) {
+			continue
+		}
+		c, err := collect.Lookup(name)
+		if err != nil {
+			return nil, err
+		}
+		p.Datasets = append(p.Datasets, name)
+		if !slices.Contains(p.Services, c.Service()) {
+			p.Services = append(p.Services, c.Service())
+		}
+	}
+	for _, family := range families {
+		if !slices.ContainsFunc(collect.Datasets(), func(name string) bool { return collect.Family(name) == family }) {
+			p.Skipped = append(p.Skipped, family)
+		}
+	}
+	return p, nil
+}
+
+// runHonk authenticates and collects the plan on this machine, returning the exit code
+func runHonk(cmd *cobra.Command, plan *honkPlan) int {
+	ctx := cmd.Context()
+	authManager, err := newAuthManager(cmd)
+	if err != nil {
+		log.Errorf("Failed to create auth manager: %v", err)
+		return headless.ExitConfig
+	}
+	params := conf.GetAuthConfig()
+
+	if plan.DryRun {
+		printHonkPlan(plan, authManager.LeaseStatuses())
+		return headless.ExitOK
+	}
+	if err := authenticate(ctx, authManager, params, plan.Services, plan.Auth); err != nil {
+		log.Errorf("Authentication failed: %v", err)
+		return headless.ExitAuth
+	}
+
+	cp, err := collect.NewCheckpoint(plan.Out, collect.NewRunID(), params.TenantID, plan.Plan)
+	if err != nil {
+		log.Errorf("Failed to create checkpoint: %v", err)
+		return headless.ExitConfig
+	}
+	log.Infof("Starting run %s of %d datasets, %d at a time", cp.RunID, len(cp.Datasets), plan.Concurrency)
+
+	runner := &collect.Runner{
+		Tokens:       authManager,
+		Store:        authManager.Store,
+		Client:       http.DefaultClient,
+		USGovernment: params.UsGovernment,
+		Concurrency:  plan.Concurrency,
+	}
+	runErr := runner.Run(ctx, cp)
+
+	// The summary is written first so the manifest hashes it
+	summary, err := collect.WriteSummary(cp)
+	if err != nil {
+		log.Errorf("Failed to write summary: %v", err)
+		summary = collect.Summarize(cp)
+	}
+	if err := writeManifest(cp, params.Identity()); err != nil {
+		log.Errorf("Failed to write manifest: %v", err)
+	}
+	printSummary(summary)
+
+	switch {
+	case errors.Is(runErr, collect.ErrStopped) || ctx.Err() != nil:
+		log.Warnf("Run %s was interrupted, resume it with gosling dump --out %s --resume %s", cp.RunID, plan.Out, cp.RunID)
+		return headless.ExitStopped
+	case runErr != nil:
+		log.Errorf("Run %s did not collect every dataset, retry them with gosling dump --out %s --resume %s", cp.RunID, plan.Out, cp.RunID)
+		return headless.ExitFailed
+	}
+	log.Infof("Run %s completed in %s", cp.RunID, cp.Dir())
+
+	if plan.Ship {
+		if err := shipRun(ctx, cp, authManager.Store); err != nil {
+			log.Errorf("Failed to ship run %s, retry with ship %s: %v", cp.RunID, cp.RunID, err)
+			return headless.ExitShip
+		}
+	}
+	return headless.ExitOK
+}
+
+// authenticate makes sure every service has a token: stored tokens are used, or renewed, and
+// otherwise the configured method signs in again
+func authenticate(ctx context.Context, a *auth.AuthManager, params *shared.AuthParams, services []auth.Service, method string) error {
+	if len(missingTokens(a, services)) == 0 {
+		return nil
+	}
+	if err := a.RenewTokens(ctx); err != nil {
+		log.Debugf("Failed to renew the stored tokens: %v", err)
+	} else if len(missingTokens(a, services)) == 0 {
+		return nil
+	}
+
+	if method == "" {
+		method = api.DeviceCode
+		if params.ClientSecret != "" {
+			method = string(auth.AppSecret)
+		}
+	}
+	var cred azcore.TokenCredential
+	var err error
+	if method == api.DeviceCode {
+		cred, err = auth.NewDeviceCodeCredential(params, func(ctx context.Context, msg azidentity.DeviceCodeMessage) error {
+			// The prompt is for the user, whatever the log level
+			fmt.Fprintln(os.Stderr, msg.Message)
+			return nil
+		})
+	} else {
+		cred, err = auth.NewAppCredential(params, auth.AppAuthMethod(method))
+	}
+	if err != nil {
+		return err
+	}
+	if err := a.UseCredential(ctx, cred, params); err != nil {
+		return err
+	}
+
+	if missing := missingTokens(a, services); len(missing) > 0 {
+		return fmt.Errorf("%w %s", errMissingTokens, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// missingTokens returns the services that have no valid token
+func missingTokens(a *auth.AuthManager, services []auth.Service) []string {
+	var missing []string
+	for _, service := range services {
+		if _, err := a.GetToken(service); err != nil {
+			missing = append(missing, string(service))
+		}
+	}
+	return missing
+}
+
+// remoteHonk runs the plan as a job of the API server and downloads it, returning the exit code
+func remoteHonk(cmd *cobra.Command, c *client.Client, plan *honkPlan) int {
+	req := api.JobRequest{
+		Datasets: plan.Datasets,
+		Full:     plan.Full,
+		Since:    plan.Since.Format(time.RFC3339),
+		Until:    plan.Until.Format(time.RFC3339),
+		Ship:     plan.Ship,
+		Output:   (*apiv1.Output)(&plan.Output),
+	}
+	st, err := c.AuthStatus(cmd.Context())
+	if err != nil {
+		log.Errorf("Failed to get the server's authentication: %v", err)
+		return headless.ExitAuth
+	}
+	var missing []string
+	for _, service := range plan.Services {
+		if !slices.Contains(st.Services, service) {
+			missing = append(missing, string(service))
+		}
+	}
+	if plan.DryRun {
+		printHonkPlan(plan, nil)
+		fmt.Printf("\nServer %s is %s with tokens for %v\n", c.BaseURL, st.State, st.Services)
+		return headless.ExitOK
+	}
+	if len(missing) > 0 {
+		log.Errorf("The server has %s %s, sign it in with gosling auth --remote %s", errMissingTokens, strings.Join(missing, ", "), c.BaseURL)
+		return headless.ExitAuth
+	}
+	if plan.Concurrency > 1 {
+		log.Debugf("The API server collects datasets one at a time, ignoring a concurrency of %d", plan.Concurrency)
+	}
+
+	id, err := remoteDump(cmd, c, req, plan.Out, true)
+	if err != nil {
+		log.Errorf("Remote job failed: %v", err)
+		return remoteExitCode(err)
+	}
+	cp, err := collect.LoadCheckpoint(plan.Out, id)
+	if err != nil {
+		log.Errorf("Failed to read the downloaded run %s: %v", id, err)
+		return headless.ExitFailed
+	}
+	printSummary(collect.Summarize(cp))
+	return headless.ExitOK
+}
+
+// printHonkPlan prints what a honk run would collect, and the state of the local leases
+func printHonkPlan(plan *honkPlan, leases []auth.LeaseStatus) {
+	fmt.Printf("Window:      %s to %s\n", plan.Since.Format(time.RFC3339), plan.Until.Format(time.RFC3339))
+	fmt.Printf("Output:      %s (%s, %s)\n", plan.Out, plan.Output.Format, plan.Output.Compression)
+	fmt.Printf("Concurrency: %d\n\n", plan.Concurrency)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "DATASET\tSERVICE\tMODE")
+	for _, name := range plan.Datasets {
+		c, _ := collect.Lookup(name)
+		mode := "window"
+		if inc, ok := c.(collect.Incremental); ok && inc.Incremental() {
+			mode = "delta"
+			if plan.Full {
+				mode = "full"
+			}
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", name, c.Service(), mode)
+	}
+	_ = w.Flush()
+	if len(plan.Skipped) > 0 {
+		fmt.Printf("\nNo collectors available for: %s\n", strings.Join(plan.Skipped, ", "))
+	}
+	if len(leases) == 0 {
+		return
+	}
+
+	fmt.Println()
+	w = tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "SERVICE\tNEEDED\tTOKEN")
+	for _, l := range leases {
+		state := "expires " + l.ExpiresAt.Format(time.RFC3339)
+		switch {
+		case l.ExpiresAt.IsZero():
+			state = "none"
+		case l.Expired():
+			state = "expired"
+		}
+		if l.Fallback {
+			state += " (graph)"
+		}
+		fmt.Fprintf(w, "%s\t%t\t%s\n", l.Service, slices.Contains(plan.Services, l.Service), state)
+	}
+	_ = w.Flush()
+}
+
+// printSummary prints the outcome of every dataset of a run
+func printSummary(s *collect.Summary) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "DATASET\tSTATUS\tRECORDS\tFILES\tDURATION\tERROR")
+	for _, d := range s.Datasets {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%s\t%s\n", d.Dataset, d.Status, d.Records, d.Files, d.Duration.Round(time.Second), d.Error)
+	}
+	_ = w.Flush()
+	fmt.Printf("\nRun %s: %d records from %d datasets, %d failed, %d not collected\n",
+		s.RunID, s.Records, len(s.Datasets), s.Failed, s.Pending)
+}
+
+// exit logs an error and exits with code, through logrus so the exit handlers run
+func exit(code int, format string, args ...any) {
+	log.Errorf(format, args...)
+	log.Exit(code)
+}
@@ -0,0 +1,168 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/arustydev/goslings/internal/conf"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	for _, c := range []*cobra.Command{confProfilesUseCmd, confProfilesAddCmd, confProfilesRemoveCmd} {
+		c.Flags().String("path", "", "Config file to change, the loaded one with the highest precedence by default")
+	}
+	confProfilesAddCmd.Flags().String("store-path", "", "Directory of the profile's file store, profiles/<name> under store.path by default")
+	confProfilesAddCmd.Flags().StringSlice("collectors", nil, "Collector families enabled for the tenant, honk.collectors by default")
+	confProfilesAddCmd.Flags().Bool("use", false, "Make it the active profile")
+
+	confProfilesCmd.AddCommand(confProfilesListCmd)
+	confProfilesCmd.AddCommand(confProfilesUseCmd)
+	confProfilesCmd.AddCommand(confProfilesAddCmd)
+	confProfilesCmd.AddCommand(confProfilesRemoveCmd)
+	confCmd.AddCommand(confProfilesCmd)
+}
+
+var confProfilesCmd = &cobra.Command{
+	Use:   "profiles",
+	Short: "Manage the named tenant profiles selected with --profile",
+}
+
+// profileFlags are the flags setting the fields of a new profile; the flags other than
+// store-path and collectors are the global ones of the keys the fields override
+var profileFlags = []struct {
+	field, flag string
+}{
+	{"tenant", "tenant"},
+	{"subscription", "subscription"},
+	{"client_id", "client-id"},
+	{"client_secret", "client-secret"},
+	{"usgov", "usgov"},
+	{"usgov_exo", "usgov-exo"},
+	{"store_path", "store-path"},
+	{"collectors", "collectors"},
+}
+
+var confProfilesListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the profiles, the active one marked with *",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		names := conf.Profiles()
+		if len(names) == 0 {
+			fmt.Println("No profiles, add one with gosling conf profiles add")
+			return
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "\tNAME\tTENANT\tCLOUD\tSTORE\tCOLLECTORS")
+		for _, name := range names {
+			fields, _ := conf.Profile(name)
+			active, cloud := "", "public"
+			if name == conf.ActiveProfile() {
+				active = "*"
+			}
+			if usgov, _ := fields["usgov"].(bool); usgov {
+				cloud = "usgov"
+			}
+			collectors := "-"
+			if list, ok := fields["collectors"]; ok {
+				collectors = showValue(list)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", active, name, showValue(fields["tenant"]), cloud, conf.ProfileStorePath(name), collectors)
+		}
+		_ = w.Flush()
+	},
+}
+
+var confProfilesUseCmd = &cobra.Command{
+	Use:   "use <name>",
+	Short: "Make a profile the active one, or none with an empty name",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		path := configPath(cmd)
+		name := strings.ToLower(args[0])
+		if name == "" {
+			if err := conf.Unset(path, "profile"); err != nil && !errors.Is(err, conf.ErrNotSet) {
+				log.Fatalf("Failed to unset the profile: %v", err)
+			}
+			fmt.Printf("No profile is active in %s\n", path)
+			return
+		}
+		if !slices.Contains(conf.Profiles(), name) {
+			log.Fatalf("No profile named %s, the profiles are %v", name, conf.Profiles())
+		}
+		if err := conf.Set(path, "profile", name); err != nil {
+			log.Fatalf("Failed to set the profile: %v", err)
+		}
+		if s, ok := setting("profile"); ok && (s.Source == conf.SourceEnv || s.Source == conf.SourceFlag) {
+			log.Warnf("%s overrides %s", s.Origin, path)
+		}
+		fmt.Printf("Using profile %s from %s\n", name, path)
+	},
+}
+
+var confProfilesAddCmd = &cobra.Command{
+	Use:   "add <name> --tenant <id> [flags]",
+	Short: "Add a profile from the tenant, client, cloud, store and collector flags",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		path := configPath(cmd)
+		name := strings.ToLower(args[0])
+		if name == "" || strings.ContainsAny(name, ". ") {
+			log.Fatalf("Invalid profile name %q, it cannot be empty or hold dots or spaces", args[0])
+		}
+		if slices.Contains(conf.Profiles(), name) {
+			log.Fatalf("Profile %s already exists, change it with gosling conf set profiles.%s.<field> <value>", name, name)
+		}
+		if !cmd.Flags().Changed("tenant") {
+			log.Fatalf("A profile needs a --tenant")
+		}
+
+		for _, f := range profileFlags {
+			flag := cmd.Flags().Lookup(f.flag)
+			if !flag.Changed {
+				continue
+			}
+			text := flag.Value.String()
+			if list, ok := flag.Value.(interface{ GetSlice() []string }); ok {
+				text = strings.Join(list.GetSlice(), ",")
+			}
+			if err := conf.Set(path, "profiles."+name+"."+f.field, text); err != nil {
+				log.Fatalf("Failed to set --%s: %v", f.flag, err)
+			}
+		}
+		fmt.Printf("Added profile %s to %s\n", name, path)
+		if use, _ := cmd.Flags().GetBool("use"); use {
+			if err := conf.Set(path, "profile", name); err != nil {
+				log.Fatalf("Failed to set the profile: %v", err)
+			}
+			fmt.Printf("Using profile %s\n", name)
+		}
+	},
+}
+
+var confProfilesRemoveCmd = &cobra.Command{
+	Use:   "remove <name>",
+	Short: "Remove a profile; its stored credentials are kept",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		path := configPath(cmd)
+		name := strings.ToLower(args[0])
+		store := conf.ProfileStorePath(name)
+		if err := conf.Unset(path, "profiles."+name); err != nil {
+			log.Fatalf("Failed to remove profile %s: %v", name, err)
+		}
+		if name == conf.ActiveProfile() {
+			if err := conf.Unset(path, "profile"); err != nil && !errors.Is(err, conf.ErrNotSet) {
+				log.Fatalf("Failed to unset the profile: %v", err)
+			}
+		}
+		fmt.Printf("Removed profile %s from %s\n", name, path)
+		fmt.Printf("Its stored credentials, if any, are still in %s\n", store)
+	},
+}
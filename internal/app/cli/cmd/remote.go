@@ -0,0 +1,197 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/arustydev/goslings/internal/app/api"
+	"github.com/arustydev/goslings/internal/app/headless"
+	"github.com/arustydev/goslings/internal/auth"
+	"github.com/arustydev/goslings/internal/collect"
+	"github.com/arustydev/goslings/internal/conf"
+	"github.com/arustydev/goslings/pkg/apiv1"
+	"github.com/arustydev/goslings/pkg/client"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// cancelTimeout bounds the request cancelling a remote job once the command is interrupted
+const cancelTimeout = 10 * time.Second
+
+// newClient creates a client of the configured API server, or returns nil to run locally
+func newClient() *client.Client {
+	opts := conf.GetRemoteOptions()
+	if opts.URL == "" {
+		return nil
+	}
+	c, err := client.New(opts)
+	if err != nil {
+		log.Fatalf("Invalid remote configuration: %v", err)
+	}
+	return c
+}
+
+// remoteAuth signs the API server in, relaying a device code prompt to the user
+func remoteAuth(ctx context.Context, c *client.Client, method string) {
+	params := conf.GetAuthConfig()
+	req := api.AuthRequest{Method: method, TenantID: params.TenantID, ClientID: params.ClientID}
+	if method == string(auth.AppSecret) {
+		req.ClientSecret = params.ClientSecret
+	}
+	st, err := c.SignIn(ctx, req)
+	if err != nil {
+		log.Fatalf("Failed to sign the server in: %v", err)
+	}
+	if st.State == api.AuthPending {
+		// The prompt is for the user, whatever the log level
+		fmt.Fprintln(os.Stderr, st.Message)
+		if st, err = c.WaitForSignIn(ctx); err != nil {
+			log.Fatalf("Failed to sign the server in: %v", err)
+		}
+	}
+	log.Infof("Server signed in to %s as %s, tokens expire at %s", st.Tenant, st.Identity, st.ExpiresAt.Format(time.RFC3339))
+}
+
+// jobRequest asks the API server for the run of a dump
+func jobRequest(opts dumpOptions) api.JobRequest {
+	req := api.JobRequest{Datasets: opts.Datasets, Full: opts.Full, Resume: opts.Resume, Ship: opts.Ship}
+	if opts.Since > 0 {
+		req.Since = opts.Since.String()
+	}
+	if opts.Output {
+		out, err := conf.GetOutputOptions()
+		if err != nil {
+			log.Fatalf("Invalid output configuration: %v", err)
+		}
+		req.Output = (*apiv1.Output)(&out)
+	}
+	return req
+}
+
+// Errors of a remote job that did not complete
+var (
+	errJobStopped    = errors.New("the job was stopped before it completed")
+	errJobIncomplete = errors.New("the job did not collect every dataset")
+)
+
+// remoteDump runs a job on the API server, following its events, and downloads it into out
+// once it completes; an interrupted command cancels the job so it can be resumed. It returns
+// the ID of the job, empty when it could not be started.
+func remoteDump(cmd *cobra.Command, c *client.Client, req api.JobRequest, out string, download bool) (string, error) {
+	ctx := cmd.Context()
+	j, err := c.CreateJob(ctx, req)
+	if err != nil {
+		return "", fmt.Errorf("failed to start the job: %w", err)
+	}
+	id := j.ID
+	log.Infof("Started job %s on %s", id, c.BaseURL)
+
+	err = c.Events(ctx, id, 0, func(e api.JobEvent) error {
+		logEvent(e)
+		return nil
+	})
+	if client.IsStatus(err, http.StatusNotFound) {
+		err = waitForJob(ctx, c, id)
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			stop, cancel := context.WithTimeout(context.WithoutCancel(ctx), cancelTimeout)
+			defer cancel()
+			if _, err := c.CancelJob(stop, id, false); err != nil && !client.IsStatus(err, http.StatusConflict) {
+				log.Errorf("Failed to cancel job %s: %v", id, err)
+			}
+			return id, fmt.Errorf("%w, resume it with --resume %s", errJobStopped, id)
+		}
+		return id, fmt.Errorf("lost track of job %s: %w", id, err)
+	}
+
+	if j, err = c.Job(ctx, id); err != nil {
+		return id, fmt.Errorf("failed to get job %s: %w", id, err)
+	}
+	switch j.Status {
+	case api.JobCompleted:
+	case api.JobFailed:
+		return id, fmt.Errorf("%w, retry them with --resume %s: %s", errJobIncomplete, id, j.Error)
+	default:
+		return id, fmt.Errorf("%w: job %s is %s, resume it with --resume %s", errJobStopped, id, j.Status, id)
+	}
+	log.Infof("Job %s completed with %d records", id, j.Items)
+	if !download {
+		return id, nil
+	}
+	artifacts, err := c.Download(ctx, id, out)
+	if err != nil {
+		return id, fmt.Errorf("failed to download job %s: %w", id, err)
+	}
+	log.Infof("Downloaded %d files to %s/%s", len(artifacts), out, id)
+	return id, nil
+}
+
+// remoteExitCode maps the error of a remote job to the exit code of the same failure locally
+func remoteExitCode(err error) int {
+	switch {
+	case err == nil:
+		return headless.ExitOK
+	case errors.Is(err, errJobStopped):
+		return headless.ExitStopped
+	case client.IsStatus(err, http.StatusUnauthorized), client.IsStatus(err, http.StatusForbidden),
+		client.IsStatus(err, http.StatusConflict):
+		// The server refused the caller, or is not signed in to the tenant
+		return headless.ExitAuth
+	case client.IsStatus(err, http.StatusBadRequest):
+		return headless.ExitConfig
+	default:
+		return headless.ExitFailed
+	}
+}
+
+// waitForJob polls a job until it stops, for servers that have no events for it
+func waitForJob(ctx context.Context, c *client.Client, id string) error {
+	ticker := time.NewTicker(c.PollInterval)
+	defer ticker.Stop()
+	for {
+		j, err := c.Job(ctx, id)
+		if err != nil {
+			return err
+		}
+		if j.Status != api.JobRunning && j.Status != api.JobCancelling {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// logEvent logs a job event the way a local run logs its progress
+func logEvent(e api.JobEvent) {
+	entry := log.WithField("run", e.RunID)
+	switch e.Type {
+	case api.EventJobStarted:
+		entry.Info("Job started")
+	case collect.EventDatasetStarted:
+		entry.Infof("Collecting %s", e.Dataset)
+	case collect.EventPageFetched:
+		entry.Debugf("Fetched page %d of %s, %d records so far", e.Page, e.Dataset, e.Total)
+	case collect.EventThrottled:
+		entry.Warnf("Throttled on %s (%d), retrying in %s", e.Dataset, e.StatusCode, e.Wait)
+	case collect.EventTokenRenewed:
+		entry.Infof("Renewed the %s token", e.Service)
+	case collect.EventDatasetCompleted:
+		entry.Infof("Collected %d records from %s", e.Total, e.Dataset)
+	case collect.EventDatasetFailed:
+		entry.Errorf("Failed to collect %s: %s", e.Dataset, e.Error)
+	case api.EventJobFinished:
+		if e.Error != "" {
+			entry.Warnf("Job %s: %s", e.Status, e.Error)
+			return
+		}
+		entry.Infof("Job %s", e.Status)
+	}
+}
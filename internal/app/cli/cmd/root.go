@@ -3,25 +3,76 @@ package cmd
 import (
 	"context"
 	"fmt"
-	"os"
+	"sync"
 
 	"github.com/arustydev/goslings/internal/about"
 	"github.com/arustydev/goslings/internal/conf"
+	"github.com/arustydev/goslings/internal/utils/telemetry"
+	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
 
+// stopTracing exports the spans of the command before it exits
+var stopTracing = func() {}
+
+// stopAudit forwards the audit events of the command before it exits
+var stopAudit = func() {}
+
+// flushed makes flush run once, whether the command returns or exits through logrus
+var flushed sync.Once
+
+// flush forwards the audit events, then exports the spans, including those of the forwarding;
+// it is the exit handler of log.Fatal and log.Exit, which commands exit through
+func flush() {
+	flushed.Do(func() {
+		stopAudit()
+		stopTracing()
+	})
+}
+
 // define flags and handle configuration
 func init() {
-	cobra.OnInitialize(conf.InitConfig)
+	log.RegisterExitHandler(flush)
+	cobra.OnInitialize(func() {
+		file, _ := rootCmd.PersistentFlags().GetString("config")
+		configErr = conf.LoadConfig(file)
+		if opts, err := conf.GetLogOptions(telemetry.FormatText); err != nil {
+			log.Warnf("Keeping the default logging: %v", err)
+		} else if err := telemetry.ConfigureLogging(opts); err != nil {
+			log.Warnf("Keeping the default logging: %v", err)
+		}
+		if opts, err := conf.GetTraceOptions("gosling"); err != nil {
+			log.Warnf("Tracing is off: %v", err)
+		} else if stop, err := telemetry.ConfigureTracing(context.Background(), opts); err != nil {
+			log.Warnf("Tracing is off: %v", err)
+		} else {
+			stopTracing = stop
+		}
+		if stop, err := conf.ConfigureAudit(); err != nil {
+			log.Warnf("Auditing is off: %v", err)
+		} else {
+			stopAudit = stop
+		}
+	})
 
 	rootCmd.AddCommand(honkCmd)
 	rootCmd.AddCommand(confCmd)
 	rootCmd.AddCommand(dumpCmd)
 	rootCmd.AddCommand(authCmd)
 	rootCmd.AddCommand(licenseCmd)
+	rootCmd.AddCommand(verifyCmd)
+	rootCmd.AddCommand(shipCmd)
+	rootCmd.AddCommand(apikeyCmd)
+	rootCmd.AddCommand(auditCmd)
 
 	rootCmd.PersistentFlags().
 		StringP("author", "a", "Adam Smith", "Author name for copyright attribution")
+	rootCmd.PersistentFlags().
+		String("remote", "", "Run auth, dump and honk on the API server at this URL (remote.url)")
+	conf.BindFlag("remote.url", rootCmd.PersistentFlags().Lookup("remote"))
+	rootCmd.PersistentFlags().
+		String("config", "", "Config file overriding the system, user and project ones (or "+conf.ConfigEnv+")")
+	conf.AddFlags(rootCmd.PersistentFlags())
 
 	// rootCmd.PersistentFlags().Bool("viper", true, "Use Viper for configuration")
 }
@@ -36,6 +87,18 @@ var rootCmd = &cobra.Command{
 			Azure Active Directory (AzureAD), Azure, and M365 environments.
                 Complete documentation is available at https://github.com/arustydev/goslings
                 https://github.com/cisagov/untitledgoosetool`,
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		// conf commands are how a config file that cannot be read gets fixed
+		if configErr == nil {
+			return
+		}
+		for c := cmd; c != nil; c = c.Parent() {
+			if c == confCmd {
+				return
+			}
+		}
+		log.Fatalf("%v, check it with gosling conf validate", configErr)
+	},
 	Run: func(cmd *cobra.Command, args []string) {
 		// Do Stuff Here
 		// TODO: Output subcommand options
@@ -43,8 +106,10 @@ var rootCmd = &cobra.Command{
 }
 
 func Execute(ctx context.Context) {
-	if err := rootCmd.ExecuteContext(ctx); err != nil {
+	err := rootCmd.ExecuteContext(ctx)
+	flush()
+	if err != nil {
 		fmt.Println(err)
-		os.Exit(1)
+		log.Exit(1)
 	}
 }
@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+
+	"github.com/arustydev/goslings/internal/auth/store"
+	"github.com/arustydev/goslings/internal/collect"
+	"github.com/arustydev/goslings/internal/conf"
+	"github.com/arustydev/goslings/internal/sink"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// errNoSinks is returned when shipping without any configured sink
+var errNoSinks = errors.New("no sinks are configured")
+
+func init() {
+	shipCmd.Flags().StringP("out", "o", "./output", "Directory the run was written to")
+}
+
+var shipCmd = &cobra.Command{
+	Use:   "ship <run-id>",
+	Short: "Ship a completed run to the configured sinks, resuming an interrupted delivery",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		out, _ := cmd.Flags().GetString("out")
+
+		authManager, err := newAuthManager(cmd)
+		if err != nil {
+			log.Fatalf("Failed to create auth manager: %v", err)
+		}
+		cp, err := collect.LoadCheckpoint(out, args[0])
+		if err != nil {
+			log.Fatalf("Failed to load checkpoint: %v", err)
+		}
+		if err := shipRun(cmd.Context(), cp, authManager.Store); err != nil {
+			log.Fatalf("Failed to ship run %s: %v", cp.RunID, err)
+		}
+	},
+}
+
+// shipRun delivers a completed run to every sink in the configuration
+func shipRun(ctx context.Context, cp *collect.Checkpoint, st store.Store) error {
+	configs, err := conf.GetSinkConfigs()
+	if err != nil {
+		return err
+	}
+	shipper, err := sink.NewShipper(st, configs)
+	if err != nil {
+		return err
+	}
+	if shipper == nil {
+		return errNoSinks
+	}
+	return shipper.Ship(ctx, cp)
+}
@@ -0,0 +1,35 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/arustydev/goslings/internal/collect"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify <manifest>",
+	Short: "Re-hash the outputs of a run and report any tampering",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		results, err := collect.Verify(args[0])
+		if err != nil {
+			log.Fatalf("Failed to verify manifest: %v", err)
+		}
+
+		tampered := 0
+		for _, r := range results {
+			if r.Status != collect.VerifyOK {
+				tampered++
+			}
+			fmt.Printf("%-10s %s\n", r.Status, r.Path)
+		}
+
+		if tampered > 0 {
+			fmt.Printf("%d of %d files do not match the manifest\n", tampered, len(results))
+			log.Exit(1)
+		}
+		fmt.Printf("All %d files match the manifest\n", len(results))
+	},
+}
@@ -0,0 +1,429 @@
+// Package headless runs collections without any interactive input, once, on an interval or
+// on cron schedules
+package headless
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/arustydev/goslings/internal/auth/store"
+	"github.com/arustydev/goslings/internal/collect"
+	"github.com/arustydev/goslings/internal/output"
+	"github.com/arustydev/goslings/internal/schedule"
+	"github.com/arustydev/goslings/internal/sink"
+	"github.com/arustydev/goslings/internal/utils/telemetry"
+)
+
+// Exit codes of the headless daemon
+const (
+	// ExitOK means every run completed and was shipped
+	ExitOK = 0
+
+	// ExitFailed means a run finished with datasets that could not be collected
+	ExitFailed = 1
+
+	// ExitConfig means the configuration is invalid
+	ExitConfig = 2
+
+	// ExitAuth means authentication failed
+	ExitAuth = 3
+
+	// ExitShip means a run completed but could not be shipped to every sink
+	ExitShip = 4
+
+	// ExitStopped means the daemon was stopped during a run, which is resumed on the next start
+	ExitStopped = 5
+)
+
+const (
+	DefaultSince           = 7 * 24 * time.Hour
+	DefaultOut             = "./output"
+	DefaultShutdownTimeout = 30 * time.Second
+)
+
+// Options configures the daemon, it is read from the headless section of the configuration
+type Options struct {
+	// Datasets are collected by every run, every dataset when empty
+	Datasets []string `mapstructure:"datasets"`
+
+	// Since is how far back time-windowed datasets are collected
+	Since time.Duration `mapstructure:"since"`
+
+	// Out is the directory runs are written to
+	Out string `mapstructure:"out"`
+
+	// Full ignores stored delta links
+	Full bool `mapstructure:"full"`
+
+	// Interval starts a run every interval, a single run is made when zero
+	Interval time.Duration `mapstructure:"interval"`
+
+	// Auth is the app-only authentication method: secret or managed_identity
+	Auth string `mapstructure:"auth"`
+
+	// ShutdownTimeout bounds how long a stop waits for the page being read
+	ShutdownTimeout time.Duration `mapstructure:"shutdown_timeout"`
+
+	// MetricsAddress is where the metrics are served, they are not served when empty
+	MetricsAddress string `mapstructure:"metrics_address"`
+}
+
+// Validate checks the options, filling in defaults
+func (o *Options) Validate() error {
+	if o.Since <= 0 {
+		o.Since = DefaultSince
+	}
+	if o.Out == "" {
+		o.Out = DefaultOut
+	}
+	if o.ShutdownTimeout <= 0 {
+		o.ShutdownTimeout = DefaultShutdownTimeout
+	}
+	if o.Interval < 0 {
+		return fmt.Errorf("invalid interval: %s", o.Interval)
+	}
+	for _, name := range o.Datasets {
+		if _, err := collect.Lookup(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Daemon collects, manifests and ships runs until it is stopped
+type Daemon struct {
+	Options Options
+
+	// Output is how records are written
+	Output output.Options
+
+	// Runner collects the runs, its Stop channel is set by Run
+	Runner *collect.Runner
+
+	// Shipper delivers completed runs, nil disables shipping
+	Shipper *sink.Shipper
+
+	// Tenant and Identity are recorded in checkpoints and manifests
+	Tenant   string
+	Identity string
+
+	// Settings is the redacted effective configuration recorded in manifests
+	Settings map[string]any
+
+	// Scheduler runs cron-scheduled jobs, the interval is used when nil
+	Scheduler *schedule.Scheduler
+
+	// mu guards Options, Output, Shipper and Settings once Run has started, see Reconfigure
+	mu sync.RWMutex
+
+	// stopped is set when a scheduled run was stopped before completing
+	stopped atomic.Bool
+}
+
+// NewDaemon creates a daemon collecting with the tokens of src
+func NewDaemon(opts Options, out output.Options, src collect.TokenSource, st store.Store, usGovernment bool) (*Daemon, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	if err := out.Validate(); err != nil {
+		return nil, err
+	}
+	return &Daemon{
+		Options: opts,
+		Output:  out,
+		Runner: &collect.Runner{
+			Tokens:       src,
+			Store:        st,
+			Client:       http.DefaultClient,
+			USGovernment: usGovernment,
+		},
+	}, nil
+}
+
+// Run makes the configured runs and returns the exit code. Closing stop lets the current
+// page finish and be checkpointed; cancelling ctx aborts immediately.
+func (d *Daemon) Run(ctx context.Context, stop <-chan struct{}) int {
+	d.Runner.Stop = stop
+	ctx = telemetry.WithField(ctx, telemetry.FieldTenant, d.Tenant)
+	logger := telemetry.Logger(ctx)
+
+	code, resumed := d.recover(ctx)
+	if code == ExitStopped {
+		return code
+	}
+	if d.Scheduler != nil {
+		return d.schedule(ctx, stop)
+	}
+	// A resumed run counts as the single run of a daemon without an interval
+	if opts, _, _, _ := d.live(); resumed && opts.Interval == 0 {
+		return code
+	}
+
+	for {
+		code = d.runPlan(ctx, d.newPlan())
+		opts, _, _, _ := d.live()
+		if opts.Interval == 0 || code == ExitStopped {
+			return code
+		}
+
+		next := time.Now().Add(opts.Interval)
+		logger.Infof("Next run at %s", next.Format(time.RFC3339))
+		timer := time.NewTimer(opts.Interval)
+		select {
+		case <-stop:
+			timer.Stop()
+			logger.Info("Stopped between runs")
+			return ExitOK
+		case <-ctx.Done():
+			timer.Stop()
+			return ExitOK
+		case <-timer.C:
+		}
+	}
+}
+
+// UseJobs runs jobs on their cron schedules instead of the interval, keeping their state in st
+func (d *Daemon) UseJobs(st store.Store, jobs []schedule.Job) error {
+	if err := checkJobs(jobs); err != nil {
+		return err
+	}
+	s, err := schedule.New(st, d.Tenant, d.runJob, jobs...)
+	if err != nil {
+		return err
+	}
+	d.Scheduler = s
+	return nil
+}
+
+// checkJobs checks the datasets of jobs
+func checkJobs(jobs []schedule.Job) error {
+	for _, job := range jobs {
+		for _, name := range job.Datasets {
+			if _, err := collect.Lookup(name); err != nil {
+				return fmt.Errorf("job %s: %w", job.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Reconfigure applies a new configuration to a running daemon once it is checked: the
+// datasets, window, interval, output, sinks, jobs and settings. The output directory,
+// authentication and shutdown timeout are kept. A run in progress finishes as it started,
+// the next one uses the new configuration. Switching between an interval and schedules
+// needs a restart.
+func (d *Daemon) Reconfigure(opts Options, out output.Options, shipper *sink.Shipper, jobs []schedule.Job, settings map[string]any) error {
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+	if err := out.Validate(); err != nil {
+		return err
+	}
+	if (len(jobs) > 0) != (d.Scheduler != nil) {
+		return errors.New("switching between an interval and schedule.jobs needs a restart")
+	}
+	if d.Scheduler != nil {
+		if err := checkJobs(jobs); err != nil {
+			return err
+		}
+		if err := d.Scheduler.Update(jobs...); err != nil {
+			return err
+		}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.Options.Datasets = opts.Datasets
+	d.Options.Since = opts.Since
+	d.Options.Full = opts.Full
+	d.Options.Interval = opts.Interval
+	d.Output = out
+	d.Shipper = shipper
+	d.Settings = settings
+	return nil
+}
+
+// live returns the options, output, shipper and settings, which Reconfigure may change
+func (d *Daemon) live() (Options, output.Options, *sink.Shipper, map[string]any) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.Options, d.Output, d.Shipper, d.Settings
+}
+
+// schedule runs the jobs until the daemon is stopped
+func (d *Daemon) schedule(ctx context.Context, stop <-chan struct{}) int {
+	for _, job := range d.Scheduler.Jobs() {
+		telemetry.Logger(ctx).WithField(telemetry.FieldJob, job.Name).Infof("Scheduled %s at %q", job.Name, job.Schedule)
+	}
+	d.Scheduler.Start(ctx, stop)
+	if d.stopped.Load() {
+		return ExitStopped
+	}
+	return ExitOK
+}
+
+// runJob collects a window of a scheduled job. A run whose shipping failed has still
+// collected its window; its delivery is completed on the next start.
+func (d *Daemon) runJob(ctx context.Context, job schedule.Job, window schedule.Window) error {
+	datasets := job.Datasets
+	if len(datasets) == 0 {
+		datasets = collect.Datasets()
+	}
+	_, out, _, _ := d.live()
+	code := d.runPlan(ctx, collect.Plan{
+		Job:      job.Name,
+		Datasets: datasets,
+		Since:    window.Since,
+		Until:    window.Until,
+		Full:     job.Full,
+		Output:   out,
+	})
+	switch code {
+	case ExitOK, ExitShip:
+		return nil
+	case ExitStopped:
+		d.stopped.Store(true)
+		return collect.ErrStopped
+	default:
+		return fmt.Errorf("run exited with code %d", code)
+	}
+}
+
+// recover resumes the latest run of every job, and the latest unscheduled run, if it was
+// stopped or interrupted, or ships it if it completed; a run that failed is left for a new one
+func (d *Daemon) recover(ctx context.Context) (code int, resumed bool) {
+	cps, err := collect.ListCheckpoints(d.Options.Out)
+	if err != nil {
+		telemetry.Logger(ctx).Errorf("Failed to list runs: %v", err)
+		return ExitConfig, false
+	}
+
+	latest := map[string]*collect.Checkpoint{}
+	for _, cp := range cps {
+		latest[cp.Job] = cp
+	}
+	for _, cp := range latest {
+		if cp.Job != "" && d.Scheduler == nil {
+			continue
+		}
+		logger := telemetry.Logger(ctx).WithField(telemetry.FieldRun, cp.RunID)
+		var c int
+		switch {
+		case cp.Failed():
+			// Retrying the failures on every start would keep a one-shot daemon on this run
+			logger.Warnf("Run %s failed, retry it with gosling dump --out %s --resume %s", cp.RunID, d.Options.Out, cp.RunID)
+			continue
+		case cp.Done():
+			// A delivery interrupted by the last stop is completed, the ledger skips what was shipped
+			c = d.ship(ctx, cp)
+		default:
+			logger.Infof("Resuming interrupted run %s", cp.RunID)
+			resumed = true
+			c = d.collect(ctx, cp)
+			if cp.Job != "" && (c == ExitOK || c == ExitShip) {
+				if err := d.Scheduler.Record(ctx, cp.Job, runWindow(cp)); err != nil {
+					logger.Errorf("Failed to record the window of run %s: %v", cp.RunID, err)
+				}
+			}
+		}
+		if c == ExitStopped {
+			return c, resumed
+		}
+		if c > code {
+			code = c
+		}
+	}
+	return code, resumed
+}
+
+// runWindow returns the time window a run collected
+func runWindow(cp *collect.Checkpoint) schedule.Window {
+	var w schedule.Window
+	for _, p := range cp.Datasets {
+		if w.Since.IsZero() || p.Since.Before(w.Since) {
+			w.Since = p.Since
+		}
+		if p.Until.After(w.Until) {
+			w.Until = p.Until
+		}
+	}
+	return w
+}
+
+// newPlan plans a run of the configured datasets ending now
+func (d *Daemon) newPlan() collect.Plan {
+	opts, out, _, _ := d.live()
+	datasets := opts.Datasets
+	if len(datasets) == 0 {
+		datasets = collect.Datasets()
+	}
+	until := time.Now().UTC()
+	return collect.Plan{
+		Datasets: datasets,
+		Since:    until.Add(-opts.Since),
+		Until:    until,
+		Full:     opts.Full,
+		Output:   out,
+	}
+}
+
+// runPlan starts a new run of plan
+func (d *Daemon) runPlan(ctx context.Context, plan collect.Plan) int {
+	cp, err := collect.NewCheckpoint(d.Options.Out, collect.NewRunID(), d.Tenant, plan)
+	if err != nil {
+		telemetry.Logger(ctx).Errorf("Failed to create checkpoint: %v", err)
+		return ExitConfig
+	}
+	telemetry.Logger(ctx).WithField(telemetry.FieldRun, cp.RunID).Infof("Starting run %s", cp.RunID)
+	return d.collect(ctx, cp)
+}
+
+// collect runs cp to completion, writes its manifest and ships it
+func (d *Daemon) collect(ctx context.Context, cp *collect.Checkpoint) int {
+	ctx = telemetry.WithField(ctx, telemetry.FieldRun, cp.RunID)
+	logger := telemetry.Logger(ctx)
+	runErr := d.Runner.Run(ctx, cp)
+	if err := d.writeManifest(ctx, cp); err != nil {
+		logger.Errorf("Failed to write manifest of run %s: %v", cp.RunID, err)
+	}
+
+	switch {
+	case errors.Is(runErr, collect.ErrStopped) || ctx.Err() != nil:
+		logger.Warnf("Run %s stopped, it will be resumed on the next start", cp.RunID)
+		return ExitStopped
+	case runErr != nil:
+		logger.Errorf("Run %s did not complete: %v", cp.RunID, runErr)
+		return ExitFailed
+	}
+	logger.Infof("Run %s completed in %s", cp.RunID, cp.Dir())
+	return d.ship(ctx, cp)
+}
+
+// ship delivers a completed run to the configured sinks
+func (d *Daemon) ship(ctx context.Context, cp *collect.Checkpoint) int {
+	_, _, shipper, _ := d.live()
+	if shipper == nil || len(shipper.Sinks) == 0 {
+		return ExitOK
+	}
+	if err := shipper.Ship(ctx, cp); err != nil {
+		telemetry.Logger(ctx).WithField(telemetry.FieldRun, cp.RunID).Errorf("Failed to ship run %s: %v", cp.RunID, err)
+		return ExitShip
+	}
+	return ExitOK
+}
+
+// writeManifest records the run's chain of custody next to its output
+func (d *Daemon) writeManifest(ctx context.Context, cp *collect.Checkpoint) error {
+	_, _, _, settings := d.live()
+	digest, err := collect.WriteManifest(cp, d.Identity, settings)
+	if err != nil {
+		return err
+	}
+	telemetry.Logger(ctx).WithField("sha256", digest.SHA256).Infof("Wrote manifest %s", digest.Path)
+	return nil
+}
@@ -0,0 +1,192 @@
+package headless
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/arustydev/goslings/internal/auth"
+	"github.com/arustydev/goslings/internal/auth/shared"
+	"github.com/arustydev/goslings/internal/auth/store"
+	"github.com/arustydev/goslings/internal/collect"
+	"github.com/arustydev/goslings/internal/output"
+	"github.com/arustydev/goslings/internal/schedule"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type staticTokens struct{}
+
+func (staticTokens) GetToken(service auth.Service) (*shared.Token, error) {
+	return &shared.Token{Value: "token"}, nil
+}
+
+func (staticTokens) RenewTokens(ctx context.Context) error {
+	return nil
+}
+
+// rewriteTransport sends every request to a test server
+type rewriteTransport struct {
+	target string
+}
+
+func (t rewriteTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r.URL.Scheme = "http"
+	r.URL.Host = strings.TrimPrefix(t.target, "http://")
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+func newDaemon(t *testing.T, out string, handler http.HandlerFunc) *Daemon {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	d, err := NewDaemon(Options{Datasets: []string{collect.EntraSignIns}, Out: out},
+		output.Options{}, staticTokens{}, store.NewMemoryStore(), false)
+	require.NoError(t, err)
+	d.Runner.Client = &http.Client{Transport: rewriteTransport{target: srv.URL}}
+	return d
+}
+
+func TestDaemonRunsOnceAndWritesManifest(t *testing.T) {
+	out := t.TempDir()
+	d := newDaemon(t, out, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"value":[{"id":"1"}]}`)
+	})
+
+	assert.Equal(t, ExitOK, d.Run(t.Context(), make(chan struct{})))
+
+	cps, err := collect.ListCheckpoints(out)
+	require.NoError(t, err)
+	require.Len(t, cps, 1)
+	assert.True(t, cps[0].Done())
+	results, err := collect.Verify(filepath.Join(cps[0].Dir(), collect.ManifestFileName))
+	require.NoError(t, err)
+	assert.NotEmpty(t, results)
+}
+
+func TestDaemonResumesStoppedRun(t *testing.T) {
+	out := t.TempDir()
+	stop := make(chan struct{})
+	d := newDaemon(t, out, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("$skiptoken") == "" {
+			close(stop)
+			fmt.Fprint(w, `{"value":[{"id":"1"}],"@odata.nextLink":"https://graph.microsoft.com/v1.0/auditLogs/signIns?$skiptoken=2"}`)
+			return
+		}
+		fmt.Fprint(w, `{"value":[{"id":"2"}]}`)
+	})
+	assert.Equal(t, ExitStopped, d.Run(t.Context(), stop))
+
+	// The next start resumes the stopped run instead of starting a new one
+	assert.Equal(t, ExitOK, d.Run(t.Context(), make(chan struct{})))
+	cps, err := collect.ListCheckpoints(out)
+	require.NoError(t, err)
+	require.Len(t, cps, 1)
+	assert.True(t, cps[0].Done())
+	assert.Equal(t, int64(2), cps[0].Datasets[0].Items)
+}
+
+func TestDaemonReportsFailedRun(t *testing.T) {
+	d := newDaemon(t, t.TempDir(), func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+	})
+	assert.Equal(t, ExitFailed, d.Run(t.Context(), make(chan struct{})))
+}
+
+func TestDaemonStartsNewRunAfterFailedRun(t *testing.T) {
+	out := t.TempDir()
+	fail := true
+	d := newDaemon(t, out, func(w http.ResponseWriter, r *http.Request) {
+		if fail {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		fmt.Fprint(w, `{"value":[{"id":"1"}]}`)
+	})
+	assert.Equal(t, ExitFailed, d.Run(t.Context(), make(chan struct{})))
+
+	// The next start collects a new run instead of retrying the failed one
+	fail = false
+	assert.Equal(t, ExitOK, d.Run(t.Context(), make(chan struct{})))
+	cps, err := collect.ListCheckpoints(out)
+	require.NoError(t, err)
+	require.Len(t, cps, 2)
+	done := 0
+	for _, cp := range cps {
+		if cp.Done() {
+			done++
+			continue
+		}
+		assert.True(t, cp.Failed())
+	}
+	assert.Equal(t, 1, done)
+}
+
+func TestOptionsRejectUnknownDataset(t *testing.T) {
+	opts := Options{Datasets: []string{"nope"}}
+	assert.ErrorIs(t, opts.Validate(), collect.ErrUnknownDataset)
+}
+
+func TestDaemonRecordsResumedScheduledRun(t *testing.T) {
+	out := t.TempDir()
+	stop := make(chan struct{})
+	d := newDaemon(t, out, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("$skiptoken") == "" {
+			close(stop)
+			fmt.Fprint(w, `{"value":[{"id":"1"}],"@odata.nextLink":"https://graph.microsoft.com/v1.0/auditLogs/signIns?$skiptoken=2"}`)
+			return
+		}
+		fmt.Fprint(w, `{"value":[{"id":"2"}]}`)
+	})
+	st := store.NewMemoryStore()
+	require.NoError(t, d.UseJobs(st, []schedule.Job{{
+		Name:     "signins",
+		Schedule: "@hourly",
+		Datasets: []string{collect.EntraSignIns},
+	}}))
+
+	// The scheduled run is stopped part way, its window is not recorded
+	d.Runner.Stop = stop
+	require.ErrorIs(t, d.Scheduler.Trigger(t.Context(), "signins"), collect.ErrStopped)
+	state, err := d.Scheduler.LoadState(t.Context(), "signins")
+	require.NoError(t, err)
+	assert.True(t, state.Until.IsZero())
+
+	// On the next start the run is resumed and its window recorded before scheduling
+	d.Runner.Stop = make(chan struct{})
+	code, resumed := d.recover(t.Context())
+	assert.Equal(t, ExitOK, code)
+	assert.True(t, resumed)
+
+	cps, err := collect.ListCheckpoints(out)
+	require.NoError(t, err)
+	require.Len(t, cps, 1)
+	assert.Equal(t, "signins", cps[0].Job)
+	assert.True(t, cps[0].Done())
+	state, err = d.Scheduler.LoadState(t.Context(), "signins")
+	require.NoError(t, err)
+	assert.Equal(t, cps[0].Datasets[0].Until, state.Until)
+}
+
+func TestDaemonReconfigure(t *testing.T) {
+	d := newDaemon(t, t.TempDir(), func(w http.ResponseWriter, r *http.Request) {})
+
+	err := d.Reconfigure(Options{Datasets: []string{"nope"}}, output.Options{}, nil, nil, nil)
+	assert.ErrorIs(t, err, collect.ErrUnknownDataset)
+	jobs := []schedule.Job{{Name: "signins", Schedule: "@hourly"}}
+	assert.Error(t, d.Reconfigure(Options{}, output.Options{}, nil, jobs, nil), "switching to schedules needs a restart")
+
+	require.NoError(t, d.Reconfigure(Options{Interval: time.Hour, Out: "ignored"}, output.Options{Format: output.CSV}, nil, nil, map[string]any{"a": 1}))
+	opts, out, _, settings := d.live()
+	assert.Equal(t, time.Hour, opts.Interval)
+	assert.Empty(t, opts.Datasets)
+	assert.NotEqual(t, "ignored", opts.Out, "the output directory is kept")
+	assert.Equal(t, output.CSV, out.Format)
+	assert.Equal(t, map[string]any{"a": 1}, settings)
+}
@@ -0,0 +1,282 @@
+package tui
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/arustydev/goslings/internal/app/api"
+	"github.com/arustydev/goslings/internal/auth"
+	"github.com/arustydev/goslings/internal/auth/shared"
+	"github.com/arustydev/goslings/internal/collect"
+	"github.com/arustydev/goslings/internal/output"
+	"github.com/arustydev/goslings/internal/utils/telemetry"
+	"github.com/arustydev/goslings/pkg/client"
+)
+
+// Common errors
+var (
+	ErrNoDatasets      = errors.New("no datasets selected")
+	ErrSignInPending   = errors.New("a sign-in is already pending")
+	ErrNoDeviceCode    = auth.ErrNoDeviceCode
+	ErrJobNotCompleted = errors.New("job did not complete")
+)
+
+// promptTimeout bounds the wait for a device code once a sign-in starts
+const promptTimeout = 30 * time.Second
+
+// Backend is what the TUI shows and acts on: this machine, or a remote API server
+type Backend interface {
+	// Name describes the backend in the header
+	Name() string
+
+	// Status reports the authentication to the tenant
+	Status(ctx context.Context) (*api.AuthStatus, error)
+
+	// Leases reports the token of every service
+	Leases(ctx context.Context) ([]auth.LeaseStatus, error)
+
+	// Jobs lists the collection runs, oldest first
+	Jobs(ctx context.Context) ([]api.Job, error)
+
+	// SignIn starts a device code sign-in, the pending status carries the code for the user
+	SignIn(ctx context.Context) (*api.AuthStatus, error)
+
+	// Run collects a run until it stops, sending its events to fn, and returns its ID
+	Run(ctx context.Context, req RunRequest, fn func(collect.Event)) (string, error)
+}
+
+// RunRequest is a collection picked in the TUI
+type RunRequest struct {
+	Datasets []string
+
+	// Since is how far back time-windowed datasets are collected
+	Since time.Duration
+
+	// Full ignores stored delta links
+	Full bool
+}
+
+// Local drives the AuthManager and collectors of this machine, like the CLI
+type Local struct {
+	Auth *auth.AuthManager
+
+	// Params are the configured authentication parameters
+	Params *shared.AuthParams
+
+	// Out is the directory runs are written to
+	Out string
+
+	// Output configures the writers of new runs
+	Output output.Options
+
+	// Settings is the redacted configuration recorded in manifests
+	Settings map[string]any
+
+	// NewCredential creates the device code credential, auth.NewDeviceCodeCredential by default
+	NewCredential func(params *shared.AuthParams, prompt auth.DevicePrompt) (azcore.TokenCredential, error)
+
+	mu sync.Mutex
+
+	// signIn is the latest sign-in started from the TUI
+	signIn *auth.SignIn
+}
+
+// Name implements Backend.Name for Local
+func (l *Local) Name() string {
+	return "local " + l.Out
+}
+
+// Status implements Backend.Status for Local
+func (l *Local) Status(ctx context.Context) (*api.AuthStatus, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.signIn != nil {
+		if st := l.signIn.Status(); st.State != api.AuthAuthenticated {
+			return &st, nil
+		}
+	}
+	st := api.StatusOf(l.Auth)
+	return &st, nil
+}
+
+// Leases implements Backend.Leases for Local
+func (l *Local) Leases(ctx context.Context) ([]auth.LeaseStatus, error) {
+	return l.Auth.LeaseStatuses(), nil
+}
+
+// Jobs implements Backend.Jobs for Local
+func (l *Local) Jobs(ctx context.Context) ([]api.Job, error) {
+	cps, err := collect.ListCheckpoints(l.Out)
+	if err != nil {
+		return nil, err
+	}
+	jobs := make([]api.Job, len(cps))
+	for i, cp := range cps {
+		jobs[i] = api.JobOf(cp)
+	}
+	return jobs, nil
+}
+
+// SignIn implements Backend.SignIn for Local, the user signs in while the TUI keeps running
+func (l *Local) SignIn(ctx context.Context) (*api.AuthStatus, error) {
+	l.mu.Lock()
+	if l.signIn != nil && l.signIn.Pending() {
+		l.mu.Unlock()
+		return nil, ErrSignInPending
+	}
+	params := &shared.AuthParams{}
+	if l.Params != nil {
+		*params = *l.Params
+	}
+	signIn := auth.NewSignIn(api.DeviceCode, params.TenantID)
+	l.signIn = signIn
+	l.mu.Unlock()
+
+	newCredential := l.NewCredential
+	if newCredential == nil {
+		newCredential = auth.NewDeviceCodeCredential
+	}
+	cred, err := newCredential(params, signIn.Prompt)
+	if err != nil {
+		signIn.Finish(ctx, err)
+		return nil, err
+	}
+	// The sign-in outlives the request starting it, the user has minutes to enter the code
+	if err := l.Auth.SignInWithDeviceCode(context.WithoutCancel(ctx), signIn, cred, params, promptTimeout); err != nil {
+		return nil, err
+	}
+	return l.Status(ctx)
+}
+
+// Run implements Backend.Run for Local, writing the run's manifest once it stops
+func (l *Local) Run(ctx context.Context, req RunRequest, fn func(collect.Event)) (string, error) {
+	if len(req.Datasets) == 0 {
+		return "", ErrNoDatasets
+	}
+	params := l.Auth.GetAuthParams()
+	if params == nil {
+		params = l.Params
+	}
+	if params == nil {
+		return "", auth.ErrNotAuthenticated
+	}
+
+	until := time.Now().UTC()
+	plan := collect.Plan{Datasets: req.Datasets, Full: req.Full, Since: until.Add(-req.Since), Until: until, Output: l.Output}
+	cp, err := collect.NewCheckpoint(l.Out, collect.NewRunID(), params.TenantID, plan)
+	if err != nil {
+		return "", fmt.Errorf("failed to create checkpoint: %w", err)
+	}
+	ctx = telemetry.WithField(ctx, telemetry.FieldRun, cp.RunID)
+	logger := telemetry.Logger(ctx)
+	logger.Infof("Starting run %s", cp.RunID)
+
+	runner := &collect.Runner{
+		Tokens:       l.Auth,
+		Store:        l.Auth.Store,
+		Client:       http.DefaultClient,
+		USGovernment: params.UsGovernment,
+		Events:       fn,
+	}
+	runErr := runner.Run(ctx, cp)
+	if digest, err := collect.WriteManifest(cp, params.Identity(), l.Settings); err != nil {
+		logger.Errorf("Failed to write manifest: %v", err)
+	} else {
+		logger.Infof("Wrote manifest %s (sha256 %s)", digest.Path, digest.SHA256)
+	}
+	if runErr != nil {
+		return cp.RunID, runErr
+	}
+	logger.Infof("Run %s completed in %s", cp.RunID, cp.Dir())
+	return cp.RunID, nil
+}
+
+// Remote calls an API server, device code prompts are relayed from it
+type Remote struct {
+	Client *client.Client
+}
+
+// Name implements Backend.Name for Remote
+func (r *Remote) Name() string {
+	return "remote " + r.Client.BaseURL.String()
+}
+
+// Status implements Backend.Status for Remote
+func (r *Remote) Status(ctx context.Context) (*api.AuthStatus, error) {
+	return r.Client.AuthStatus(ctx)
+}
+
+// Leases implements Backend.Leases for Remote, the server only reports the earliest expiry
+func (r *Remote) Leases(ctx context.Context) ([]auth.LeaseStatus, error) {
+	st, err := r.Client.AuthStatus(ctx)
+	if err != nil {
+		return nil, err
+	}
+	leases := make([]auth.LeaseStatus, 0, len(auth.Services))
+	for _, service := range auth.Services {
+		l := auth.LeaseStatus{Service: service}
+		for _, s := range st.Services {
+			if s == service {
+				l.ExpiresAt = st.ExpiresAt
+			}
+		}
+		leases = append(leases, l)
+	}
+	return leases, nil
+}
+
+// Jobs implements Backend.Jobs for Remote
+func (r *Remote) Jobs(ctx context.Context) ([]api.Job, error) {
+	return r.Client.Jobs(ctx, "")
+}
+
+// SignIn implements Backend.SignIn for Remote
+func (r *Remote) SignIn(ctx context.Context) (*api.AuthStatus, error) {
+	return r.Client.SignIn(ctx, api.AuthRequest{Method: api.DeviceCode})
+}
+
+// Run implements Backend.Run for Remote, following the job's events; a cancelled context
+// cancels the job so it can be resumed
+func (r *Remote) Run(ctx context.Context, req RunRequest, fn func(collect.Event)) (string, error) {
+	if len(req.Datasets) == 0 {
+		return "", ErrNoDatasets
+	}
+	j, err := r.Client.CreateJob(ctx, api.JobRequest{Datasets: req.Datasets, Full: req.Full, Since: req.Since.String()})
+	if err != nil {
+		return "", err
+	}
+	id := j.ID
+	ctx = telemetry.WithField(ctx, telemetry.FieldRun, id)
+	logger := telemetry.Logger(ctx)
+	logger.Infof("Started job %s on %s", id, r.Client.BaseURL)
+
+	err = r.Client.Events(ctx, id, 0, func(e api.JobEvent) error {
+		fn(e.Event)
+		return nil
+	})
+	if err != nil && ctx.Err() != nil {
+		stop, cancel := context.WithTimeout(context.WithoutCancel(ctx), requestTimeout)
+		defer cancel()
+		if _, err := r.Client.CancelJob(stop, id, false); err != nil && !client.IsStatus(err, http.StatusConflict) {
+			logger.Errorf("Failed to cancel job %s: %v", id, err)
+		}
+		return id, ctx.Err()
+	}
+	if err != nil {
+		return id, err
+	}
+
+	if j, err = r.Client.Job(ctx, id); err != nil {
+		return id, err
+	}
+	if j.Status != api.JobCompleted {
+		return id, fmt.Errorf("%w: job %s is %s: %s", ErrJobNotCompleted, id, j.Status, j.Error)
+	}
+	logger.Infof("Job %s completed with %d records", id, j.Items)
+	return id, nil
+}
@@ -0,0 +1,553 @@
+package tui
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/arustydev/goslings/internal/collect"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// Levels of the explorer, esc goes back up one
+const (
+	runsLevel = iota
+	datasetsLevel
+	recordsLevel
+)
+
+const (
+	// chunkSize is how many records are read at once, at most twice as many are kept
+	chunkSize = 250
+
+	// cellWidth bounds the width of a table column
+	cellWidth = 32
+
+	// defaultColumnCount is how many columns are shown before any is picked
+	defaultColumnCount = 5
+)
+
+// preferredColumns are shown first when present, they identify records of most datasets
+var preferredColumns = []string{
+	"id", "displayName", "userPrincipalName", "createdDateTime", "activityDateTime",
+	"activityDisplayName", "CreationTime", "Operation", "UserId",
+}
+
+// runsMsg carries the runs of the output directory
+type runsMsg struct {
+	runs []*collect.Checkpoint
+	err  error
+}
+
+// windowMsg carries records read from a source, appended to those kept or replacing them
+type windowMsg struct {
+	source   *recordSource
+	first    int
+	rows     []record
+	appended bool
+	eof      bool
+	err      error
+}
+
+// explorer browses the runs of an output directory, then the datasets of a run, then the
+// records of a dataset
+type explorer struct {
+	out   string
+	level int
+	err   error
+
+	runs     []*collect.Checkpoint
+	run      *collect.Checkpoint
+	datasets []*collect.Progress
+	dataset  *collect.Progress
+
+	// list is the cursor in the runs or datasets
+	list int
+
+	// source reads the records of the dataset, rows are the records kept from first on
+	source  *recordSource
+	rows    []record
+	first   int
+	cursor  int
+	loading bool
+	eof     bool
+
+	// toEnd keeps reading until the last record, to jump to it
+	toEnd bool
+
+	// columns are every column seen so far, selected those shown in order
+	columns  map[string]bool
+	selected []string
+	picking  bool
+	pick     int
+
+	detail       bool
+	detailScroll int
+
+	// typing edits input, which replaces filterText once entered
+	typing     bool
+	input      string
+	filterText string
+}
+
+func newExplorer(out string) *explorer {
+	return &explorer{out: out, columns: map[string]bool{}}
+}
+
+// load lists the runs of the output directory
+func (e *explorer) load() tea.Msg {
+	runs, err := collect.ListCheckpoints(e.out)
+	return runsMsg{runs: runs, err: err}
+}
+
+// capturing reports whether every key goes to the explorer, to type a filter
+func (e *explorer) capturing() bool {
+	return e.typing
+}
+
+// paths are the output files of the dataset, including the part a running collection writes
+func (e *explorer) paths() []string {
+	var paths []string
+	for _, f := range e.dataset.Files {
+		paths = append(paths, filepath.Join(e.run.Dir(), f))
+	}
+	if e.dataset.File != "" && !slices.Contains(e.dataset.Files, e.dataset.File) {
+		paths = append(paths, filepath.Join(e.run.Dir(), e.dataset.File))
+	}
+	return paths
+}
+
+// open reads the records of the dataset from the first one, with the current filter
+func (e *explorer) open() tea.Cmd {
+	if e.source != nil && !e.loading {
+		e.source.close()
+	}
+	e.source = newRecordSource(e.paths(), parseFilter(e.filterText))
+	e.rows, e.first, e.cursor, e.eof, e.toEnd, e.err = nil, 0, 0, false, false, nil
+	e.detail = false
+	return e.fetch(0, 2*chunkSize, false)
+}
+
+// fetch reads n records from first, it runs off the UI goroutine
+func (e *explorer) fetch(first, n int, appended bool) tea.Cmd {
+	e.loading = true
+	source := e.source
+	return func() tea.Msg {
+		rows, err := source.window(first, n)
+		return windowMsg{source: source, first: first, rows: rows, appended: appended, eof: source.eof, err: err}
+	}
+}
+
+// close stops reading the dataset
+func (e *explorer) close() {
+	if e.source != nil && !e.loading {
+		e.source.close()
+	}
+	e.source, e.rows = nil, nil
+}
+
+func (e *explorer) update(msg tea.Msg) tea.Cmd {
+	switch msg := msg.(type) {
+	case runsMsg:
+		e.err = msg.err
+		if msg.err == nil {
+			e.runs = msg.runs
+			if e.level == runsLevel {
+				e.list = min(e.list, max(len(e.runs)-1, 0))
+			}
+		}
+
+	case windowMsg:
+		if msg.source != e.source {
+			// The filter or dataset changed while reading
+			msg.source.close()
+			if e.source == nil {
+				e.loading = false
+			}
+			return nil
+		}
+		e.loading, e.eof, e.err = false, msg.eof, msg.err
+		if msg.appended {
+			e.rows = append(e.rows, msg.rows...)
+			if drop := len(e.rows) - 2*chunkSize; drop > 0 {
+				e.rows = slices.Clone(e.rows[drop:])
+				e.first += drop
+			}
+		} else {
+			e.rows, e.first = msg.rows, msg.first
+		}
+		e.learnColumns(msg.rows)
+		if e.toEnd && !e.eof && e.err == nil {
+			return e.fetch(e.first+len(e.rows), chunkSize, true)
+		}
+		if e.toEnd {
+			e.toEnd = false
+			e.cursor = e.first + len(e.rows) - 1
+		}
+		return e.move(0)
+	}
+	return nil
+}
+
+// learnColumns records the columns of records, picking the default columns once
+func (e *explorer) learnColumns(rows []record) {
+	for _, r := range rows {
+		for column := range r.flat {
+			e.columns[column] = true
+		}
+	}
+	if len(e.selected) > 0 || len(e.columns) == 0 {
+		return
+	}
+	for _, column := range preferredColumns {
+		if e.columns[column] && len(e.selected) < defaultColumnCount {
+			e.selected = append(e.selected, column)
+		}
+	}
+	for _, column := range e.columnNames() {
+		if len(e.selected) >= defaultColumnCount {
+			break
+		}
+		if !slices.Contains(e.selected, column) {
+			e.selected = append(e.selected, column)
+		}
+	}
+}
+
+// columnNames lists the known columns in order
+func (e *explorer) columnNames() []string {
+	names := make([]string, 0, len(e.columns))
+	for column := range e.columns {
+		names = append(names, column)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// move moves the cursor among the records kept, reading ahead or behind as it nears their ends
+func (e *explorer) move(delta int) tea.Cmd {
+	e.cursor = min(max(e.cursor+delta, e.first), max(e.first+len(e.rows)-1, e.first))
+	if e.loading || e.source == nil {
+		return nil
+	}
+	switch {
+	case !e.eof && e.cursor+chunkSize/2 >= e.first+len(e.rows):
+		return e.fetch(e.first+len(e.rows), chunkSize, true)
+	case e.first > 0 && e.cursor-chunkSize/2 < e.first:
+		// The files are read again from the start, they cannot be read backwards
+		return e.fetch(max(e.first-chunkSize, 0), 2*chunkSize, false)
+	}
+	return nil
+}
+
+// selectedRecord is the record under the cursor
+func (e *explorer) selectedRecord() (record, bool) {
+	i := e.cursor - e.first
+	if i < 0 || i >= len(e.rows) {
+		return record{}, false
+	}
+	return e.rows[i], true
+}
+
+func (e *explorer) handleKey(key string, pageSize int) tea.Cmd {
+	if e.typing {
+		return e.handleInput(key)
+	}
+	switch e.level {
+	case runsLevel:
+		switch key {
+		case "up", "k":
+			e.list = max(e.list-1, 0)
+		case "down", "j":
+			e.list = min(e.list+1, max(len(e.runs)-1, 0))
+		case "enter":
+			if len(e.runs) > 0 {
+				e.run, e.datasets = e.runs[e.list], e.runs[e.list].Datasets
+				e.level, e.list = datasetsLevel, 0
+			}
+		}
+	case datasetsLevel:
+		switch key {
+		case "up", "k":
+			e.list = max(e.list-1, 0)
+		case "down", "j":
+			e.list = min(e.list+1, max(len(e.datasets)-1, 0))
+		case "enter":
+			if len(e.datasets) > 0 {
+				e.dataset, e.level = e.datasets[e.list], recordsLevel
+				e.selected, e.columns = nil, map[string]bool{}
+				return e.open()
+			}
+		case "esc":
+			e.level, e.list = runsLevel, slices.Index(e.runs, e.run)
+		}
+	case recordsLevel:
+		if e.picking {
+			return e.handlePicker(key)
+		}
+		if e.detail {
+			switch key {
+			case "up", "k":
+				e.detailScroll = max(e.detailScroll-1, 0)
+			case "down", "j":
+				e.detailScroll++
+			case "enter", "esc":
+				e.detail = false
+			}
+			return nil
+		}
+		switch key {
+		case "up", "k":
+			return e.move(-1)
+		case "down", "j":
+			return e.move(1)
+		case "pgup":
+			return e.move(-pageSize)
+		case "pgdown", " ":
+			return e.move(pageSize)
+		case "home", "g":
+			if e.first > 0 {
+				e.cursor = 0
+				e.close()
+				return e.open()
+			}
+			return e.move(-e.cursor)
+		case "end", "G":
+			if e.eof {
+				return e.move(e.first + len(e.rows))
+			}
+			e.toEnd = true
+			if !e.loading {
+				return e.fetch(e.first+len(e.rows), chunkSize, true)
+			}
+		case "enter":
+			if _, ok := e.selectedRecord(); ok {
+				e.detail, e.detailScroll = true, 0
+			}
+		case "/":
+			e.typing, e.input = true, e.filterText
+		case "c":
+			e.picking, e.pick = true, 0
+		case "esc":
+			e.close()
+			e.level, e.list = datasetsLevel, slices.Index(e.datasets, e.dataset)
+		}
+	}
+	return nil
+}
+
+// handleInput edits the filter
+func (e *explorer) handleInput(key string) tea.Cmd {
+	switch key {
+	case "enter":
+		e.typing, e.filterText = false, strings.TrimSpace(e.input)
+		return e.open()
+	case "esc":
+		e.typing = false
+	case "backspace":
+		if _, size := utf8.DecodeLastRuneInString(e.input); size > 0 {
+			e.input = e.input[:len(e.input)-size]
+		}
+	default:
+		if utf8.RuneCountInString(key) == 1 {
+			e.input += key
+		}
+	}
+	return nil
+}
+
+// handlePicker selects the columns shown, in the order they are picked
+func (e *explorer) handlePicker(key string) tea.Cmd {
+	names := e.columnNames()
+	switch key {
+	case "up", "k":
+		e.pick = max(e.pick-1, 0)
+	case "down", "j":
+		e.pick = min(e.pick+1, max(len(names)-1, 0))
+	case " ", "x", "enter":
+		if len(names) == 0 {
+			return nil
+		}
+		column := names[e.pick]
+		if i := slices.Index(e.selected, column); i >= 0 {
+			e.selected = slices.Delete(e.selected, i, i+1)
+		} else {
+			e.selected = append(e.selected, column)
+		}
+	case "c", "esc":
+		e.picking = false
+	}
+	return nil
+}
+
+func (e *explorer) view(width, height int) string {
+	var b strings.Builder
+	switch e.level {
+	case runsLevel:
+		fmt.Fprintf(&b, "Runs in %s\n\n", e.out)
+		if len(e.runs) == 0 {
+			b.WriteString("No runs yet\n")
+		}
+		fmt.Fprintf(&b, "  %-26s %-38s %-20s %s\n", "RUN", "TENANT", "CREATED", "DATASETS")
+		for i, cp := range e.runs {
+			fmt.Fprintf(&b, "%s %-26s %-38s %-20s %d\n", cursorMark(i == e.list), cp.RunID, cp.Tenant,
+				cp.Created.Local().Format(time.DateTime), len(cp.Datasets))
+		}
+	case datasetsLevel:
+		fmt.Fprintf(&b, "Run %s\n\n", e.run.RunID)
+		fmt.Fprintf(&b, "  %-28s %10s %6s  %s\n", "DATASET", "RECORDS", "FILES", "STATE")
+		for i, p := range e.datasets {
+			state := "collecting"
+			switch {
+			case p.Done:
+				state = "done"
+			case p.Error != "":
+				state = "failed: " + p.Error
+			}
+			fmt.Fprintf(&b, "%s %-28s %10d %6d  %s\n", cursorMark(i == e.list), p.Dataset, p.Items, len(p.Files), state)
+		}
+	case recordsLevel:
+		b.WriteString(e.recordsView(width, height))
+	}
+	if e.err != nil {
+		fmt.Fprintf(&b, "\n%s\n", failure.Render("Error: "+e.err.Error()))
+	}
+	return b.String()
+}
+
+// recordsView shows the records as a table, the selected one as JSON or the column picker
+func (e *explorer) recordsView(width, height int) string {
+	var b strings.Builder
+	count := fmt.Sprintf("%d", e.first+len(e.rows))
+	if !e.eof {
+		count += "+"
+	}
+	filter := e.filterText
+	if e.typing {
+		filter = e.input + "█"
+	}
+	fmt.Fprintf(&b, "%s / %s · record %d of %s · filter: %s", e.run.RunID, e.dataset.Dataset, e.cursor+1, count, filter)
+	if e.loading {
+		b.WriteString(" · reading...")
+	}
+	b.WriteString("\n\n")
+
+	switch {
+	case e.picking:
+		names := e.columnNames()
+		start := max(min(e.pick-height/2, len(names)-height), 0)
+		for i := start; i < min(start+height, len(names)); i++ {
+			check := " "
+			if n := slices.Index(e.selected, names[i]); n >= 0 {
+				check = fmt.Sprint(n + 1)
+			}
+			fmt.Fprintf(&b, "%s [%s] %s\n", cursorMark(i == e.pick), check, names[i])
+		}
+	case e.detail:
+		r, _ := e.selectedRecord()
+		var indented bytes.Buffer
+		if err := json.Indent(&indented, r.raw, "", "  "); err != nil {
+			indented.Write(r.raw)
+		}
+		lines := strings.Split(indented.String(), "\n")
+		e.detailScroll = min(e.detailScroll, max(len(lines)-height, 0))
+		for _, line := range lines[e.detailScroll:min(e.detailScroll+height, len(lines))] {
+			b.WriteString(truncate(line, width) + "\n")
+		}
+	default:
+		b.WriteString(e.tableView(width, height))
+	}
+	return b.String()
+}
+
+// tableView shows a page of height records holding the cursor
+func (e *explorer) tableView(width, height int) string {
+	if len(e.rows) == 0 {
+		if e.loading {
+			return "Reading...\n"
+		}
+		return "No records\n"
+	}
+	top := e.cursor - e.cursor%height
+	var page []record
+	for i := top; i < top+height; i++ {
+		if i >= e.first && i < e.first+len(e.rows) {
+			page = append(page, e.rows[i-e.first])
+		}
+	}
+
+	// Columns are as wide as their widest value on the page, as many as fit
+	var columns []string
+	var widths []int
+	used := 2
+	for _, column := range e.selected {
+		w := utf8.RuneCountInString(column)
+		for _, r := range page {
+			w = max(w, utf8.RuneCountInString(r.flat[column]))
+		}
+		w = min(w, cellWidth)
+		if used+w > width && len(columns) > 0 {
+			break
+		}
+		columns, widths, used = append(columns, column), append(widths, w), used+w+1
+	}
+
+	var b strings.Builder
+	b.WriteString("  ")
+	for i, column := range columns {
+		fmt.Fprintf(&b, "%-*s ", widths[i], truncate(column, widths[i]))
+	}
+	b.WriteString("\n")
+	for i, r := range page {
+		b.WriteString(cursorMark(top+i == e.cursor) + " ")
+		if r.err != nil {
+			b.WriteString(truncate("invalid record: "+r.err.Error(), width-2) + "\n")
+			continue
+		}
+		for j, column := range columns {
+			fmt.Fprintf(&b, "%-*s ", widths[j], truncate(r.flat[column], widths[j]))
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// help lists the keys of the explorer's level
+func (e *explorer) help() string {
+	switch {
+	case e.typing:
+		return "type terms, column=value filters a column · enter apply · esc cancel"
+	case e.level == runsLevel:
+		return "enter open run"
+	case e.level == datasetsLevel:
+		return "enter open dataset · esc runs"
+	case e.picking:
+		return "space show/hide column · esc done"
+	case e.detail:
+		return "↑/↓ scroll · esc table"
+	}
+	return "↑/↓/pgup/pgdn/g/G move · enter JSON · / filter · c columns · esc datasets"
+}
+
+func cursorMark(selected bool) string {
+	if selected {
+		return ">"
+	}
+	return " "
+}
+
+// truncate cuts s to width runes, marking the cut
+func truncate(s string, width int) string {
+	s = strings.ReplaceAll(s, "\n", " ")
+	if width <= 0 || utf8.RuneCountInString(s) <= width {
+		return s
+	}
+	runes := []rune(s)
+	return string(runes[:width-1]) + "…"
+}
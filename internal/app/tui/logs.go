@@ -0,0 +1,68 @@
+package tui
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// logHistory is how many log lines the log pane keeps
+	logHistory = 500
+
+	// logBuffer is how many log lines wait for the TUI before new ones are dropped
+	logBuffer = 256
+)
+
+// logLine is a log entry shown in the log pane
+type logLine struct {
+	time    time.Time
+	level   log.Level
+	message string
+}
+
+// logHook sends log entries to the log pane instead of the terminal the TUI draws on
+type logHook struct {
+	lines chan logLine
+}
+
+func newLogHook() *logHook {
+	return &logHook{lines: make(chan logLine, logBuffer)}
+}
+
+// Levels implements log.Hook.Levels for logHook
+func (h *logHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+// Fire implements log.Hook.Fire for logHook, it never blocks the goroutine logging
+func (h *logHook) Fire(entry *log.Entry) error {
+	select {
+	case h.lines <- logLine{time: entry.Time, level: entry.Level, message: entry.Message}:
+	default:
+	}
+	return nil
+}
+
+// logPane keeps the latest log lines
+type logPane struct {
+	lines []logLine
+}
+
+func (p *logPane) add(line logLine) {
+	p.lines = append(p.lines, line)
+	if len(p.lines) > logHistory {
+		p.lines = append(p.lines[:0], p.lines[len(p.lines)-logHistory:]...)
+	}
+}
+
+// view shows the last height lines
+func (p *logPane) view(height int) string {
+	var b strings.Builder
+	for _, line := range p.lines[max(len(p.lines)-height, 0):] {
+		fmt.Fprintf(&b, "%s %-5.5s %s\n", line.time.Local().Format(time.TimeOnly), strings.ToUpper(line.level.String()), line.message)
+	}
+	return b.String()
+}
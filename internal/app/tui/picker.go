@@ -0,0 +1,100 @@
+package tui
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/arustydev/goslings/internal/collect"
+)
+
+// windows are the time ranges the picker offers for time-windowed datasets
+var windows = []time.Duration{time.Hour, 24 * time.Hour, 7 * 24 * time.Hour, 30 * 24 * time.Hour, 90 * 24 * time.Hour}
+
+// defaultWindow is the index of the window the CLI defaults to
+const defaultWindow = 2
+
+// picker selects the datasets and time range of a collection
+type picker struct {
+	datasets []string
+	selected map[string]bool
+	cursor   int
+
+	// window indexes windows
+	window int
+
+	// full ignores stored delta links
+	full bool
+}
+
+func newPicker() picker {
+	return picker{datasets: collect.Datasets(), selected: map[string]bool{}, window: defaultWindow}
+}
+
+// move moves the cursor by delta datasets, staying in the list
+func (p *picker) move(delta int) {
+	p.cursor = min(max(p.cursor+delta, 0), len(p.datasets)-1)
+}
+
+// toggle selects or deselects the dataset under the cursor
+func (p *picker) toggle() {
+	if len(p.datasets) == 0 {
+		return
+	}
+	name := p.datasets[p.cursor]
+	p.selected[name] = !p.selected[name]
+}
+
+// toggleAll selects every dataset, or none once they all are
+func (p *picker) toggleAll() {
+	all := len(p.request().Datasets) == len(p.datasets)
+	for _, name := range p.datasets {
+		p.selected[name] = !all
+	}
+}
+
+// widen moves to the next time range by delta, staying in windows
+func (p *picker) widen(delta int) {
+	p.window = min(max(p.window+delta, 0), len(windows)-1)
+}
+
+// request is the collection picked, datasets in the order they are listed
+func (p *picker) request() RunRequest {
+	req := RunRequest{Since: windows[p.window], Full: p.full}
+	for _, name := range p.datasets {
+		if p.selected[name] {
+			req.Datasets = append(req.Datasets, name)
+		}
+	}
+	return req
+}
+
+func (p *picker) view() string {
+	var b strings.Builder
+	for i, name := range p.datasets {
+		cursor := " "
+		if i == p.cursor {
+			cursor = ">"
+		}
+		check := " "
+		if p.selected[name] {
+			check = "x"
+		}
+		fmt.Fprintf(&b, "%s [%s] %s\n", cursor, check, name)
+	}
+	full := "no"
+	if p.full {
+		full = "yes"
+	}
+	fmt.Fprintf(&b, "\nTime range: last %s · Full baseline: %s · %d selected\n",
+		formatWindow(windows[p.window]), full, len(p.request().Datasets))
+	return b.String()
+}
+
+// formatWindow prints a window in days once it is a whole number of them
+func formatWindow(d time.Duration) string {
+	if d >= 24*time.Hour && d%(24*time.Hour) == 0 {
+		return fmt.Sprintf("%dd", d/(24*time.Hour))
+	}
+	return d.String()
+}
@@ -0,0 +1,132 @@
+package tui
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/arustydev/goslings/internal/collect"
+)
+
+// Dataset states shown in the progress view
+const (
+	statePending   = "pending"
+	stateRunning   = "running"
+	stateCompleted = "completed"
+	stateFailed    = "failed"
+)
+
+// datasetProgress counts the pages and records of a dataset of the run
+type datasetProgress struct {
+	dataset string
+	state   string
+	pages   int
+	items   int64
+
+	// throttles counts the throttled requests, throttled is set until the next page arrives
+	throttles int
+	throttled bool
+	wait      string
+
+	err string
+}
+
+// progress is the live view of a collection run, built from its events
+type progress struct {
+	runID    string
+	started  time.Time
+	datasets []*datasetProgress
+
+	// renewals counts the tokens renewed during the run
+	renewals int
+
+	running bool
+	err     error
+}
+
+func newProgress(req RunRequest) *progress {
+	p := &progress{started: time.Now(), running: true}
+	for _, name := range req.Datasets {
+		p.datasets = append(p.datasets, &datasetProgress{dataset: name, state: statePending})
+	}
+	return p
+}
+
+// dataset returns the progress of a dataset, adding datasets the run reports but were not picked
+func (p *progress) dataset(name string) *datasetProgress {
+	for _, d := range p.datasets {
+		if d.dataset == name {
+			return d
+		}
+	}
+	d := &datasetProgress{dataset: name, state: statePending}
+	p.datasets = append(p.datasets, d)
+	return d
+}
+
+// apply updates the counters with an event of the run
+func (p *progress) apply(e collect.Event) {
+	if p.runID == "" {
+		p.runID = e.RunID
+	}
+	switch e.Type {
+	case collect.EventDatasetStarted:
+		p.dataset(e.Dataset).state = stateRunning
+	case collect.EventPageFetched:
+		d := p.dataset(e.Dataset)
+		d.state, d.pages, d.items, d.throttled = stateRunning, d.pages+1, e.Total, false
+	case collect.EventThrottled:
+		d := p.dataset(e.Dataset)
+		d.throttles++
+		d.throttled, d.wait = true, e.Wait
+	case collect.EventTokenRenewed:
+		p.renewals++
+	case collect.EventDatasetCompleted:
+		d := p.dataset(e.Dataset)
+		d.state, d.items, d.throttled = stateCompleted, e.Total, false
+	case collect.EventDatasetFailed:
+		d := p.dataset(e.Dataset)
+		d.state, d.err, d.throttled = stateFailed, e.Error, false
+	}
+}
+
+// finish records the end of the run
+func (p *progress) finish(runID string, err error) {
+	if runID != "" {
+		p.runID = runID
+	}
+	p.running, p.err = false, err
+}
+
+func (p *progress) view() string {
+	var b strings.Builder
+	runID := p.runID
+	if runID == "" {
+		runID = "starting"
+	}
+	status := "running for " + time.Since(p.started).Truncate(time.Second).String()
+	switch {
+	case p.running:
+	case p.err != nil:
+		status = "stopped: " + p.err.Error()
+	default:
+		status = "completed"
+	}
+	fmt.Fprintf(&b, "Run %s · %s · %d token renewals\n\n", runID, status, p.renewals)
+
+	fmt.Fprintf(&b, "%-28s %-10s %6s %10s  %s\n", "DATASET", "STATE", "PAGES", "RECORDS", "THROTTLING")
+	for _, d := range p.datasets {
+		throttling := ""
+		switch {
+		case d.throttled:
+			throttling = fmt.Sprintf("throttled, retrying in %s (%d so far)", d.wait, d.throttles)
+		case d.throttles > 0:
+			throttling = fmt.Sprintf("%d throttled", d.throttles)
+		}
+		fmt.Fprintf(&b, "%-28s %-10s %6d %10d  %s\n", d.dataset, d.state, d.pages, d.items, throttling)
+		if d.err != "" {
+			fmt.Fprintf(&b, "  %s\n", d.err)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,135 @@
+package tui
+
+import (
+	"bytes"
+	"encoding/json"
+	"iter"
+	"strings"
+
+	"github.com/arustydev/goslings/internal/output"
+)
+
+// record is a record of an output file, with its columns
+type record struct {
+	raw  json.RawMessage
+	flat map[string]string
+
+	// err is set when the record is not valid JSON, eg. the last line of a part being written
+	err error
+}
+
+func newRecord(raw json.RawMessage) record {
+	flat, err := output.Flatten(raw)
+	return record{raw: raw, flat: flat, err: err}
+}
+
+// filter selects records by free text and by field; every term must match
+type filter struct {
+	// text are lowercased terms searched in the raw record
+	text [][]byte
+
+	// fields are lowercased values searched in a column of the record
+	fields map[string]string
+}
+
+// parseFilter reads space separated terms, column=value terms filter on a column and the
+// others on the whole record
+func parseFilter(s string) filter {
+	f := filter{fields: map[string]string{}}
+	for _, term := range strings.Fields(s) {
+		if column, value, ok := strings.Cut(term, "="); ok && column != "" {
+			f.fields[column] = strings.ToLower(value)
+			continue
+		}
+		f.text = append(f.text, []byte(strings.ToLower(term)))
+	}
+	return f
+}
+
+// match reports whether a record matches, flattening it only once its text matched
+func (f filter) match(raw json.RawMessage) (record, bool) {
+	if len(f.text) > 0 {
+		lower := bytes.ToLower(raw)
+		for _, term := range f.text {
+			if !bytes.Contains(lower, term) {
+				return record{}, false
+			}
+		}
+	}
+	r := newRecord(raw)
+	for column, value := range f.fields {
+		if !strings.Contains(strings.ToLower(r.flat[column]), value) {
+			return record{}, false
+		}
+	}
+	return r, true
+}
+
+// recordSource streams the matching records of a dataset's files without loading them; only
+// the records asked for are kept
+type recordSource struct {
+	paths  []string
+	filter filter
+
+	next func() (json.RawMessage, error, bool)
+	stop func()
+
+	// read counts the matching records pulled since the files were opened
+	read int
+	eof  bool
+}
+
+func newRecordSource(paths []string, f filter) *recordSource {
+	return &recordSource{paths: paths, filter: f}
+}
+
+// records chains the records of every file
+func (s *recordSource) records() iter.Seq2[json.RawMessage, error] {
+	return func(yield func(json.RawMessage, error) bool) {
+		for _, path := range s.paths {
+			for raw, err := range output.ReadRecords(path) {
+				if !yield(raw, err) || err != nil {
+					return
+				}
+			}
+		}
+	}
+}
+
+// window returns up to n matching records from index, reopening the files to go backwards
+func (s *recordSource) window(index, n int) ([]record, error) {
+	if s.next == nil || index < s.read {
+		s.close()
+		s.next, s.stop = iter.Pull2(s.records())
+		s.read, s.eof = 0, false
+	}
+	var rows []record
+	for !s.eof && len(rows) < n {
+		raw, err, ok := s.next()
+		if !ok {
+			s.eof = true
+			break
+		}
+		if err != nil {
+			s.eof = true
+			return rows, err
+		}
+		r, ok := s.filter.match(raw)
+		if !ok {
+			continue
+		}
+		if s.read >= index {
+			rows = append(rows, r)
+		}
+		s.read++
+	}
+	return rows, nil
+}
+
+// close releases the files
+func (s *recordSource) close() {
+	if s.stop != nil {
+		s.stop()
+	}
+	s.next, s.stop = nil, nil
+}
@@ -1,105 +1,441 @@
 package tui
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
 
+	"github.com/arustydev/goslings/internal/app/api"
+	"github.com/arustydev/goslings/internal/auth"
+	"github.com/arustydev/goslings/internal/collect"
 	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	log "github.com/sirupsen/logrus"
 )
 
+// ErrRunning is shown when a collection is started while another one runs
+var ErrRunning = errors.New("a collection is already running")
+
+const (
+	// refreshInterval is how often the status and jobs are read again
+	refreshInterval = 2 * time.Second
+
+	// requestTimeout bounds every call to the backend but a run
+	requestTimeout = 10 * time.Second
+
+	// eventBuffer is how many events of a run wait for the TUI before the run blocks
+	eventBuffer = 1024
+
+	// logHeight is the height of the log pane
+	logHeight = 8
+
+	// chromeHeight is the height of the tabs, help and log pane around a screen
+	chromeHeight = logHeight + 10
+
+	// defaultWidth and defaultHeight are used until the terminal reports its size
+	defaultWidth  = 120
+	defaultHeight = 40
+)
+
+// screen is a view of the TUI, switched with tab or its number
+type screen int
+
+const (
+	authScreen screen = iota
+	collectScreen
+	progressScreen
+	jobsScreen
+	exploreScreen
+)
+
+var screenNames = []string{"Auth", "Collect", "Progress", "Jobs", "Explore"}
+
+var (
+	activeTab   = lipgloss.NewStyle().Bold(true).Reverse(true).Padding(0, 1)
+	inactiveTab = lipgloss.NewStyle().Padding(0, 1)
+	faint       = lipgloss.NewStyle().Faint(true)
+	failure     = lipgloss.NewStyle().Foreground(lipgloss.Color("9"))
+)
+
+// refreshMsg carries the status, leases and jobs read from the backend
+type refreshMsg struct {
+	status *api.AuthStatus
+	leases []auth.LeaseStatus
+	jobs   []api.Job
+	err    error
+}
+
+// signInMsg carries the response to starting a sign-in
+type signInMsg struct {
+	status *api.AuthStatus
+	err    error
+}
+
+// eventMsg is an event of the running collection
+type eventMsg collect.Event
+
+// runDoneMsg reports the end of a collection
+type runDoneMsg struct {
+	runID string
+	err   error
+}
+
+// logMsg is a log entry for the log pane
+type logMsg logLine
+
+// tickMsg triggers a refresh
+type tickMsg time.Time
+
 type model struct {
-	choices  []string         // items on the to-do list
-	cursor   int              // which to-do list item our cursor is pointing at
-	selected map[int]struct{} // which to-do items are selected
+	backend Backend
+	screen  screen
+
+	status *api.AuthStatus
+	leases []auth.LeaseStatus
+	jobs   []api.Job
+
+	picker   picker
+	progress *progress
+	explorer *explorer
+	logs     logPane
+
+	width  int
+	height int
+
+	// events and logLines receive from the goroutines of runs and loggers
+	events   chan collect.Event
+	logLines <-chan logLine
+
+	// cancel stops the running collection, it is nil when none runs
+	cancel context.CancelFunc
+
+	// quitting waits for the running collection to stop before quitting
+	quitting bool
+
+	// err is the last error of the backend, cleared by the next successful call
+	err error
 }
 
-func initialModel() model {
+func initialModel(backend Backend, out string, logLines <-chan logLine) model {
 	return model{
-		// Our to-do list is a grocery list
-		choices: []string{"Buy carrots", "Buy celery", "Buy kohlrabi"},
-
-		// A map which indicates which choices are selected. We're using
-		// the  map like a mathematical set. The keys refer to the indexes
-		// of the `choices` slice, above.
-		selected: make(map[int]struct{}),
+		backend:  backend,
+		picker:   newPicker(),
+		explorer: newExplorer(out),
+		width:    defaultWidth,
+		height:   defaultHeight,
+		events:   make(chan collect.Event, eventBuffer),
+		logLines: logLines,
 	}
 }
 
 func (m model) Init() tea.Cmd {
-	// Just return `nil`, which means "no I/O right now, please."
-	return nil
+	return tea.Batch(m.refresh, m.explorer.load, tick(), m.waitForEvent, m.waitForLog)
+}
+
+// refresh reads the status, leases and jobs from the backend
+func (m model) refresh() tea.Msg {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	st, err := m.backend.Status(ctx)
+	if err != nil {
+		return refreshMsg{err: err}
+	}
+	leases, err := m.backend.Leases(ctx)
+	if err != nil {
+		return refreshMsg{err: err}
+	}
+	jobs, err := m.backend.Jobs(ctx)
+	return refreshMsg{status: st, leases: leases, jobs: jobs, err: err}
+}
+
+// signIn starts a sign-in on the backend
+func (m model) signIn() tea.Msg {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	st, err := m.backend.SignIn(ctx)
+	return signInMsg{status: st, err: err}
+}
+
+// waitForEvent receives the next event of a collection
+func (m model) waitForEvent() tea.Msg {
+	return eventMsg(<-m.events)
+}
+
+// waitForLog receives the next log entry, for as long as there are loggers
+func (m model) waitForLog() tea.Msg {
+	if m.logLines == nil {
+		return nil
+	}
+	return logMsg(<-m.logLines)
+}
+
+func tick() tea.Cmd {
+	return tea.Tick(refreshInterval, func(t time.Time) tea.Msg { return tickMsg(t) })
+}
+
+// startRun runs the picked collection until it stops or is cancelled
+func (m model) startRun() (model, tea.Cmd) {
+	if m.cancel != nil {
+		m.err = ErrRunning
+		return m, nil
+	}
+	req := m.picker.request()
+	if len(req.Datasets) == 0 {
+		m.err = ErrNoDatasets
+		return m, nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	m.cancel, m.err = cancel, nil
+	m.progress = newProgress(req)
+	m.screen = progressScreen
+	backend, events := m.backend, m.events
+	return m, func() tea.Msg {
+		runID, err := backend.Run(ctx, req, func(e collect.Event) { events <- e })
+		return runDoneMsg{runID: runID, err: err}
+	}
 }
 
 func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
-	// Is it a key press?
 	case tea.KeyMsg:
+		return m.handleKey(msg)
 
-		// Cool, what was the actual key pressed?
-		switch msg.String() {
+	case tea.WindowSizeMsg:
+		m.width, m.height = msg.Width, msg.Height
 
-		// These keys should exit the program.
-		case "ctrl+c", "q":
-			return m, tea.Quit
+	case tickMsg:
+		if m.screen == exploreScreen && m.explorer.level == runsLevel {
+			return m, tea.Batch(m.refresh, m.explorer.load, tick())
+		}
+		return m, tea.Batch(m.refresh, tick())
 
-		// The "up" and "k" keys move the cursor up
-		case "up", "k":
-			if m.cursor > 0 {
-				m.cursor--
-			}
+	case runsMsg, windowMsg:
+		return m, m.explorer.update(msg)
 
-		// The "down" and "j" keys move the cursor down
-		case "down", "j":
-			if m.cursor < len(m.choices)-1 {
-				m.cursor++
-			}
+	case refreshMsg:
+		m.err = msg.err
+		if msg.err == nil {
+			m.status, m.leases, m.jobs = msg.status, msg.leases, msg.jobs
+		}
 
-		// The "enter" key and the spacebar (a literal space) toggle
-		// the selected state for the item that the cursor is pointing at.
-		case "enter", " ":
-			_, ok := m.selected[m.cursor]
-			if ok {
-				delete(m.selected, m.cursor)
-			} else {
-				m.selected[m.cursor] = struct{}{}
-			}
+	case signInMsg:
+		m.err = msg.err
+		if msg.err == nil {
+			m.status = msg.status
 		}
-	}
 
-	// Return the updated model to the Bubble Tea runtime for processing.
-	// Note that we're not returning a command.
+	case eventMsg:
+		if m.progress != nil {
+			m.progress.apply(collect.Event(msg))
+		}
+		return m, m.waitForEvent
+
+	case runDoneMsg:
+		m.cancel = nil
+		if m.progress != nil {
+			m.progress.finish(msg.runID, msg.err)
+		}
+		if m.quitting {
+			return m, tea.Quit
+		}
+		return m, m.refresh
+
+	case logMsg:
+		m.logs.add(logLine(msg))
+		return m, m.waitForLog
+	}
 	return m, nil
 }
 
-func (m model) View() string {
-	// The header
-	s := "What should we buy at the market?\n\n"
+// handleKey handles the keys of every screen, then those of the current one
+func (m model) handleKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	if m.screen == exploreScreen && m.explorer.capturing() && msg.String() != "ctrl+c" {
+		return m, m.explorer.handleKey(msg.String(), m.screenHeight())
+	}
+	switch key := msg.String(); key {
+	case "ctrl+c", "q":
+		if m.cancel == nil {
+			return m, tea.Quit
+		}
+		// The run is stopped at its next page so it can be resumed
+		m.cancel()
+		m.quitting = true
+		return m, nil
+	case "tab":
+		m.screen = (m.screen + 1) % screen(len(screenNames))
+		return m, nil
+	case "shift+tab":
+		m.screen = (m.screen + screen(len(screenNames)) - 1) % screen(len(screenNames))
+		return m, nil
+	case "1", "2", "3", "4", "5":
+		m.screen = screen(key[0] - '1')
+		return m, nil
+	case "r":
+		return m, tea.Batch(m.refresh, m.explorer.load)
+	}
 
-	// Iterate over our choices
-	for i, choice := range m.choices {
+	switch m.screen {
+	case authScreen:
+		if msg.String() == "s" {
+			return m, m.signIn
+		}
+	case collectScreen:
+		switch msg.String() {
+		case "up", "k":
+			m.picker.move(-1)
+		case "down", "j":
+			m.picker.move(1)
+		case " ", "x":
+			m.picker.toggle()
+		case "a":
+			m.picker.toggleAll()
+		case "left", "h":
+			m.picker.widen(-1)
+		case "right", "l":
+			m.picker.widen(1)
+		case "f":
+			m.picker.full = !m.picker.full
+		case "enter":
+			return m.startRun()
+		}
+	case progressScreen:
+		if msg.String() == "c" && m.cancel != nil {
+			m.cancel()
+		}
+	case exploreScreen:
+		return m, m.explorer.handleKey(msg.String(), m.screenHeight())
+	}
+	return m, nil
+}
+
+// screenHeight is how many lines a screen has between the tabs and the log pane
+func (m model) screenHeight() int {
+	return max(m.height-chromeHeight, 5)
+}
 
-		// Is the cursor pointing at this choice?
-		cursor := " " // no cursor
-		if m.cursor == i {
-			cursor = ">" // cursor!
+func (m model) View() string {
+	var b strings.Builder
+	for i, name := range screenNames {
+		style := inactiveTab
+		if screen(i) == m.screen {
+			style = activeTab
 		}
+		b.WriteString(style.Render(fmt.Sprintf("%d %s", i+1, name)))
+	}
+	b.WriteString(faint.Render("  goslings · "+m.backend.Name()) + "\n\n")
 
-		// Is this choice selected?
-		checked := " " // not selected
-		if _, ok := m.selected[i]; ok {
-			checked = "x" // selected!
+	switch m.screen {
+	case authScreen:
+		b.WriteString(m.authView())
+	case collectScreen:
+		b.WriteString(m.picker.view())
+	case progressScreen:
+		if m.progress == nil {
+			b.WriteString("No collection yet, pick one on the Collect screen\n")
+		} else {
+			b.WriteString(m.progress.view())
 		}
+	case jobsScreen:
+		b.WriteString(m.jobsView())
+	case exploreScreen:
+		b.WriteString(m.explorer.view(m.width, m.screenHeight()))
+	}
+
+	if m.err != nil {
+		b.WriteString("\n" + failure.Render("Error: "+m.err.Error()) + "\n")
+	}
+	b.WriteString("\n" + faint.Render("── Logs ──") + "\n")
+	b.WriteString(m.logs.view(logHeight))
+	b.WriteString("\n" + faint.Render(m.help()) + "\n")
+	return b.String()
+}
+
+// authView shows the authentication to the tenant and the token of every service
+func (m model) authView() string {
+	var b strings.Builder
+	switch st := m.status; {
+	case st == nil:
+		b.WriteString("Loading...\n")
+	case st.State == api.AuthPending:
+		fmt.Fprintf(&b, "Sign-in pending: %s\n", st.Message)
+	case st.State == api.AuthAuthenticated:
+		fmt.Fprintf(&b, "Signed in to %s as %s\n", st.Tenant, st.Identity)
+	case st.State == api.AuthFailed:
+		fmt.Fprintf(&b, "Sign-in failed: %s\n", st.Error)
+	default:
+		b.WriteString("Not signed in, press s to sign in with a device code\n")
+	}
 
-		// Render the row
-		s += fmt.Sprintf("%s [%s] %s\n", cursor, checked, choice)
+	if len(m.leases) > 0 {
+		fmt.Fprintf(&b, "\n%-10s %-10s %s\n", "SERVICE", "STATUS", "EXPIRES")
 	}
+	for _, l := range m.leases {
+		status, expires := "valid", l.ExpiresAt.Local().Format(time.DateTime)
+		switch {
+		case l.ExpiresAt.IsZero():
+			status, expires = "missing", ""
+		case l.Expired():
+			status = "expired"
+		default:
+			expires += fmt.Sprintf(" (in %s)", time.Until(l.ExpiresAt).Truncate(time.Minute))
+		}
+		if l.Fallback {
+			expires += ", graph token"
+		}
+		fmt.Fprintf(&b, "%-10s %-10s %s\n", l.Service, status, expires)
+	}
+	return b.String()
+}
 
-	// The footer
-	s += "\nPress q to quit.\n"
+// jobsView lists the collection runs
+func (m model) jobsView() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%-26s %-12s %-38s %10s  %s\n", "JOB", "STATUS", "TENANT", "ITEMS", "UPDATED")
+	if len(m.jobs) == 0 {
+		b.WriteString("No jobs yet\n")
+	}
+	for _, j := range m.jobs {
+		fmt.Fprintf(&b, "%-26s %-12s %-38s %10d  %s\n",
+			j.ID, j.Status, j.Tenant, j.Items, j.Updated.Local().Format(time.DateTime))
+	}
+	return b.String()
+}
 
-	// Send the UI for rendering
-	return s
+// help lists the keys of the current screen
+func (m model) help() string {
+	keys := "tab/1-5 screens · r refresh · q quit"
+	switch m.screen {
+	case authScreen:
+		keys = "s sign in · " + keys
+	case collectScreen:
+		keys = "↑/↓ move · space select · a all · ←/→ time range · f full · enter start · " + keys
+	case progressScreen:
+		if m.cancel != nil {
+			keys = "c cancel · " + keys
+		}
+	case exploreScreen:
+		if m.explorer.capturing() {
+			return m.explorer.help()
+		}
+		keys = m.explorer.help() + " · " + keys
+	}
+	if m.quitting {
+		keys = "stopping the collection at its next page..."
+	}
+	return keys
 }
 
-func NewTui() *tea.Program {
-	return tea.NewProgram(initialModel())
+// NewTui creates the TUI of a backend, exploring the runs in out; logs are shown in its log
+// pane instead of on stderr, a configured log file still receives them
+func NewTui(backend Backend, out string) *tea.Program {
+	hook := newLogHook()
+	log.AddHook(hook)
+	if log.StandardLogger().Out == os.Stderr {
+		log.SetOutput(io.Discard)
+	}
+	return tea.NewProgram(initialModel(backend, out, hook.lines), tea.WithAltScreen())
 }
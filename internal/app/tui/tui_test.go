@@ -0,0 +1,358 @@
+package tui
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/arustydev/goslings/internal/app/api"
+	"github.com/arustydev/goslings/internal/auth"
+	"github.com/arustydev/goslings/internal/auth/shared"
+	"github.com/arustydev/goslings/internal/collect"
+	tea "github.com/charmbracelet/bubbletea"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeBackend records the runs it is asked for and replays events for them
+type fakeBackend struct {
+	runs   []RunRequest
+	events []collect.Event
+	err    error
+}
+
+func (f *fakeBackend) Name() string { return "fake" }
+
+func (f *fakeBackend) Status(ctx context.Context) (*api.AuthStatus, error) {
+	return &api.AuthStatus{State: api.AuthAuthenticated, Tenant: "contoso", Identity: "app"}, nil
+}
+
+func (f *fakeBackend) Leases(ctx context.Context) ([]auth.LeaseStatus, error) {
+	return []auth.LeaseStatus{{Service: auth.GraphService, ExpiresAt: time.Now().Add(time.Hour)}, {Service: auth.M365Service}}, nil
+}
+
+func (f *fakeBackend) Jobs(ctx context.Context) ([]api.Job, error) {
+	return nil, nil
+}
+
+func (f *fakeBackend) SignIn(ctx context.Context) (*api.AuthStatus, error) {
+	return nil, errors.New("not supported")
+}
+
+func (f *fakeBackend) Run(ctx context.Context, req RunRequest, fn func(collect.Event)) (string, error) {
+	f.runs = append(f.runs, req)
+	for _, e := range f.events {
+		fn(e)
+	}
+	return "run-1", f.err
+}
+
+// press sends keys to the model, returning the command of the last one
+func press(t *testing.T, m model, keys ...tea.KeyMsg) (model, tea.Cmd) {
+	t.Helper()
+	var cmd tea.Cmd
+	for _, key := range keys {
+		var next tea.Model
+		next, cmd = m.Update(key)
+		m = next.(model)
+	}
+	return m, cmd
+}
+
+func runes(s string) tea.KeyMsg {
+	return tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(s)}
+}
+
+func TestPickerBuildsTheRequest(t *testing.T) {
+	m := initialModel(&fakeBackend{}, t.TempDir(), nil)
+	m, _ = press(t, m, runes("2"), tea.KeyMsg{Type: tea.KeySpace}, tea.KeyMsg{Type: tea.KeyDown},
+		tea.KeyMsg{Type: tea.KeySpace}, tea.KeyMsg{Type: tea.KeyRight}, runes("f"))
+	assert.Equal(t, collectScreen, m.screen)
+
+	req := m.picker.request()
+	assert.Equal(t, collect.Datasets()[:2], req.Datasets)
+	assert.Equal(t, 30*24*time.Hour, req.Since)
+	assert.True(t, req.Full)
+
+	m, _ = press(t, m, runes("a"))
+	assert.Len(t, m.picker.request().Datasets, len(collect.Datasets()))
+	m, _ = press(t, m, runes("a"))
+	assert.Empty(t, m.picker.request().Datasets)
+
+	// Nothing runs without a dataset
+	m, cmd := press(t, m, tea.KeyMsg{Type: tea.KeyEnter})
+	assert.Nil(t, cmd)
+	assert.ErrorIs(t, m.err, ErrNoDatasets)
+}
+
+func TestRunEventsUpdateTheProgress(t *testing.T) {
+	backend := &fakeBackend{events: []collect.Event{
+		{Type: collect.EventDatasetStarted, RunID: "run-1", Dataset: collect.EntraUsers},
+		{Type: collect.EventPageFetched, RunID: "run-1", Dataset: collect.EntraUsers, Page: 1, Items: 100, Total: 100},
+		{Type: collect.EventThrottled, RunID: "run-1", Dataset: collect.EntraUsers, StatusCode: 429, Wait: "5s"},
+	}}
+	m := initialModel(backend, t.TempDir(), nil)
+	m.picker.selected[collect.EntraUsers] = true
+	m.picker.selected[collect.EntraGroups] = true
+	m, cmd := press(t, m, runes("2"), tea.KeyMsg{Type: tea.KeyEnter})
+	require.NotNil(t, cmd)
+	assert.Equal(t, progressScreen, m.screen)
+	assert.NotNil(t, m.cancel)
+
+	// A second run cannot start while one runs
+	m, _ = press(t, m, runes("2"), tea.KeyMsg{Type: tea.KeyEnter})
+	assert.ErrorIs(t, m.err, ErrRunning)
+	m.screen = progressScreen
+
+	done := cmd()
+	for range backend.events {
+		next, _ := m.Update(m.waitForEvent())
+		m = next.(model)
+	}
+	users := m.progress.dataset(collect.EntraUsers)
+	assert.Equal(t, stateRunning, users.state)
+	assert.Equal(t, 1, users.pages)
+	assert.Equal(t, int64(100), users.items)
+	assert.True(t, users.throttled)
+	assert.Contains(t, m.View(), "throttled, retrying in 5s")
+	assert.Equal(t, statePending, m.progress.dataset(collect.EntraGroups).state)
+
+	next, _ := m.Update(done)
+	m = next.(model)
+	assert.Nil(t, m.cancel)
+	assert.False(t, m.progress.running)
+	assert.Equal(t, "run-1", m.progress.runID)
+	require.Len(t, backend.runs, 1)
+	assert.ElementsMatch(t, []string{collect.EntraUsers, collect.EntraGroups}, backend.runs[0].Datasets)
+}
+
+func TestProgressCountsDatasets(t *testing.T) {
+	p := newProgress(RunRequest{Datasets: []string{collect.EntraUsers}})
+	p.apply(collect.Event{Type: collect.EventThrottled, Dataset: collect.EntraUsers, Wait: "1s"})
+	p.apply(collect.Event{Type: collect.EventPageFetched, Dataset: collect.EntraUsers, Total: 10})
+	p.apply(collect.Event{Type: collect.EventTokenRenewed})
+	p.apply(collect.Event{Type: collect.EventDatasetCompleted, Dataset: collect.EntraUsers, Total: 10})
+	p.apply(collect.Event{Type: collect.EventDatasetFailed, Dataset: collect.EntraGroups, Error: "forbidden"})
+
+	users := p.dataset(collect.EntraUsers)
+	assert.Equal(t, stateCompleted, users.state)
+	assert.Equal(t, 1, users.throttles)
+	assert.False(t, users.throttled)
+	assert.Equal(t, 1, p.renewals)
+	assert.Equal(t, stateFailed, p.dataset(collect.EntraGroups).state)
+	assert.Contains(t, p.view(), "1 throttled")
+	assert.Contains(t, p.view(), "forbidden")
+}
+
+func TestLogHookNeverBlocks(t *testing.T) {
+	hook := newLogHook()
+	logger := log.New()
+	logger.AddHook(hook)
+	logger.SetOutput(io.Discard)
+	for i := range logBuffer + 10 {
+		logger.Infof("line %d", i)
+	}
+	assert.Len(t, hook.lines, logBuffer)
+
+	var pane logPane
+	for range logHistory + 1 {
+		pane.add(<-hook.lines)
+		hook.lines <- logLine{message: "again"}
+	}
+	assert.Len(t, pane.lines, logHistory)
+	assert.Equal(t, "line 1", pane.lines[0].message)
+	assert.Equal(t, 3, strings.Count(pane.view(3), "\n"))
+}
+
+// fakeCredential relays a device code, then issues tokens named after their scope once signedIn
+type fakeCredential struct {
+	prompt   auth.DevicePrompt
+	signedIn chan struct{}
+}
+
+func (f *fakeCredential) GetToken(ctx context.Context, opts policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	if f.prompt != nil {
+		if err := f.prompt(ctx, azidentity.DeviceCodeMessage{UserCode: "ABCD-EFGH", Message: "Enter ABCD-EFGH"}); err != nil {
+			return azcore.AccessToken{}, err
+		}
+		f.prompt = nil
+		<-f.signedIn
+	}
+	return azcore.AccessToken{Token: opts.Scopes[0], ExpiresOn: time.Now().Add(time.Hour)}, nil
+}
+
+func TestLocalSignInRelaysTheDeviceCode(t *testing.T) {
+	a, err := auth.NewAuthManager(t.Context(), auth.Options{StoreType: shared.MemoryStore})
+	require.NoError(t, err)
+	signedIn := make(chan struct{})
+	l := &Local{
+		Auth:   a,
+		Params: &shared.AuthParams{TenantID: "contoso"},
+		Out:    t.TempDir(),
+		NewCredential: func(params *shared.AuthParams, prompt auth.DevicePrompt) (azcore.TokenCredential, error) {
+			return &fakeCredential{prompt: prompt, signedIn: signedIn}, nil
+		},
+	}
+
+	st, err := l.SignIn(t.Context())
+	require.NoError(t, err)
+	assert.Equal(t, api.AuthPending, st.State)
+	assert.Equal(t, "Enter ABCD-EFGH", st.Message)
+	_, err = l.SignIn(t.Context())
+	assert.ErrorIs(t, err, ErrSignInPending)
+
+	close(signedIn)
+	require.Eventually(t, func() bool {
+		st, err := l.Status(t.Context())
+		return err == nil && st.State == api.AuthAuthenticated
+	}, 5*time.Second, 10*time.Millisecond)
+
+	leases, err := l.Leases(t.Context())
+	require.NoError(t, err)
+	for _, lease := range leases {
+		assert.False(t, lease.Expired(), lease.Service)
+	}
+
+	_, err = l.Run(t.Context(), RunRequest{}, func(collect.Event) {})
+	assert.ErrorIs(t, err, ErrNoDatasets)
+}
+
+// writeRun writes a run of entra.users whose records are split over two parts
+func writeRun(t *testing.T, out string, records int) *collect.Checkpoint {
+	t.Helper()
+	cp, err := collect.NewCheckpoint(out, "run-1", "contoso", collect.Plan{Datasets: []string{collect.EntraUsers}})
+	require.NoError(t, err)
+	p := cp.Datasets[0]
+	for part, name := range []string{"entra.users.jsonl", "entra.users.0001.jsonl"} {
+		var b strings.Builder
+		for i := part * records / 2; i < (part+1)*records/2; i++ {
+			fmt.Fprintf(&b, `{"id":"%d","displayName":"user %d","manager":{"id":"%d"}}`+"\n", i, i, i%7)
+		}
+		require.NoError(t, os.WriteFile(filepath.Join(cp.Dir(), name), []byte(b.String()), 0o600))
+		p.Files = append(p.Files, name)
+	}
+	p.Items, p.Done = int64(records), true
+	require.NoError(t, cp.Save())
+	return cp
+}
+
+// drain runs the commands of the explorer until it has none left
+func drain(e *explorer, cmd tea.Cmd) {
+	for cmd != nil {
+		cmd = e.update(cmd())
+	}
+}
+
+func TestFilterMatchesTextAndColumns(t *testing.T) {
+	raw := json.RawMessage(`{"id":"1","displayName":"Alice","manager":{"id":"7"}}`)
+	for filter, want := range map[string]bool{
+		"":                     true,
+		"alice":                true,
+		"ALICE 1":              true,
+		"bob":                  false,
+		"manager.id=7":         true,
+		"manager.id=8":         false,
+		"displayName=ali":      true,
+		"alice displayName=bo": false,
+	} {
+		_, ok := parseFilter(filter).match(raw)
+		assert.Equal(t, want, ok, filter)
+	}
+}
+
+func TestRecordSourceReadsWindows(t *testing.T) {
+	cp := writeRun(t, t.TempDir(), 1000)
+	s := newRecordSource([]string{
+		filepath.Join(cp.Dir(), "entra.users.jsonl"),
+		filepath.Join(cp.Dir(), "entra.users.0001.jsonl"),
+	}, parseFilter(""))
+	defer s.close()
+
+	ids := func(rows []record) []string {
+		var ids []string
+		for _, r := range rows {
+			ids = append(ids, r.flat["id"])
+		}
+		return ids
+	}
+	rows, err := s.window(0, 3)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"0", "1", "2"}, ids(rows))
+	rows, err = s.window(499, 2)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"499", "500"}, ids(rows))
+
+	// Going back reads the files again
+	rows, err = s.window(10, 1)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10"}, ids(rows))
+
+	rows, err = s.window(998, 10)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"998", "999"}, ids(rows))
+	assert.True(t, s.eof)
+}
+
+func TestExplorerBrowsesRecords(t *testing.T) {
+	out := t.TempDir()
+	writeRun(t, out, 1000)
+	e := newExplorer(out)
+	drain(e, e.load)
+	require.Len(t, e.runs, 1)
+
+	e.handleKey("enter", 20)
+	assert.Equal(t, datasetsLevel, e.level)
+	drain(e, e.handleKey("enter", 20))
+	assert.Equal(t, recordsLevel, e.level)
+	assert.Equal(t, []string{"id", "displayName", "manager.id"}, e.selected)
+	assert.LessOrEqual(t, len(e.rows), 2*chunkSize)
+	assert.Contains(t, e.view(120, 20), "user 0")
+
+	// Only a window of the records is kept while scrolling to the end
+	drain(e, e.handleKey("G", 20))
+	assert.Equal(t, 999, e.cursor)
+	assert.True(t, e.eof)
+	assert.LessOrEqual(t, len(e.rows), 2*chunkSize)
+	r, ok := e.selectedRecord()
+	require.True(t, ok)
+	assert.Equal(t, "999", r.flat["id"])
+
+	drain(e, e.handleKey("g", 20))
+	assert.Equal(t, 0, e.cursor)
+	assert.Equal(t, 0, e.first)
+
+	// The detail pane shows the record as indented JSON
+	e.handleKey("enter", 20)
+	assert.Contains(t, e.view(120, 20), `"displayName": "user 0"`)
+	e.handleKey("esc", 20)
+
+	e.handleKey("/", 20)
+	for _, key := range strings.Split("manager.id=3 user", "") {
+		e.handleKey(key, 20)
+	}
+	drain(e, e.handleKey("enter", 20))
+	assert.Equal(t, "manager.id=3 user", e.filterText)
+	assert.True(t, e.eof)
+	assert.Len(t, e.rows, 143)
+
+	// Hiding a column through the picker
+	e.handleKey("c", 20)
+	e.handleKey(" ", 20)
+	e.handleKey("esc", 20)
+	assert.Equal(t, []string{"id", "manager.id"}, e.selected)
+
+	e.handleKey("esc", 20)
+	assert.Equal(t, datasetsLevel, e.level)
+	assert.Nil(t, e.source)
+}
@@ -0,0 +1,404 @@
+// Package audit keeps a tamper-evident record of what goslings does with the tenant tokens it
+// holds: every event is appended to a file, chained to the one before it by its hash, so that
+// an event edited or removed afterwards is found by Verify
+package audit
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/arustydev/goslings/internal/utils/telemetry"
+	log "github.com/sirupsen/logrus"
+)
+
+// Actions recorded
+const (
+	ActionAuthenticate = "auth.authenticate"
+	ActionIssueToken   = "token.issue"
+	ActionRevokeToken  = "token.revoke"
+	ActionCollectStart = "collect.start"
+	ActionCollectStop  = "collect.stop"
+	ActionConfigChange = "config.change"
+	ActionStoreClear   = "store.clear"
+	ActionAPIRequest   = "api.request"
+)
+
+// Outcomes of the actions
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+)
+
+const (
+	// ForwardInterval is how often the events written are forwarded, see Forwarder
+	ForwardInterval = 10 * time.Second
+
+	// ForwardBatch is how many pending events are forwarded without waiting for the interval
+	ForwardBatch = 500
+
+	// ForwardTimeout bounds how long forwarding a batch may take
+	ForwardTimeout = time.Minute
+)
+
+// Common errors
+var (
+	ErrInvalidOptions = errors.New("invalid audit options")
+	ErrCorrupt        = errors.New("audit log is corrupt")
+	ErrClosed         = errors.New("audit log is closed")
+)
+
+// Event is an action recorded in the audit log
+type Event struct {
+	// Seq numbers the events of a file from 1, a missing number is a gap
+	Seq uint64 `json:"seq"`
+
+	Time time.Time `json:"time"`
+
+	// Actor is who acted: an API caller as method:subject, or else the user@host running
+	// the process
+	Actor string `json:"actor"`
+
+	Action string `json:"action"`
+	Tenant string `json:"tenant,omitempty"`
+
+	// Target is what was acted on, such as a run ID, a configuration key or a request path
+	Target string `json:"target,omitempty"`
+
+	Outcome string `json:"outcome"`
+
+	// Error is why the action failed, with its secrets redacted
+	Error string `json:"error,omitempty"`
+
+	Details map[string]string `json:"details,omitempty"`
+
+	// Prev is the Hash of the previous event, empty for the first one
+	Prev string `json:"prev,omitempty"`
+
+	// Hash is the SHA-256 of the event as JSON without it, see Sum
+	Hash string `json:"hash,omitempty"`
+}
+
+// Sum returns the hash of the event: the hex SHA-256 of its JSON without Hash
+func (e Event) Sum() string {
+	e.Hash = ""
+	data, _ := json.Marshal(e)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Forwarder delivers the events written to the audit log elsewhere, such as to sinks, so
+// that a copy is kept out of reach of whoever can edit the file
+type Forwarder interface {
+	// Forward delivers consecutive events of file
+	Forward(ctx context.Context, file string, events []Event) error
+}
+
+// Options configures auditing, it is read from the audit section of the configuration
+type Options struct {
+	// File receives the events, auditing is off when empty
+	File string `mapstructure:"file"`
+
+	// Sinks are the names of the sinks the events are forwarded to
+	Sinks []string `mapstructure:"sinks"`
+
+	// Forwarder delivers the events to Sinks, it is required when there are any
+	Forwarder Forwarder `mapstructure:"-"`
+
+	// Actor is who acted when the context of an event names no one, user@host when empty
+	Actor string `mapstructure:"-"`
+}
+
+// Validate checks the options, filling in defaults
+func (o *Options) Validate() error {
+	if o.File == "" && len(o.Sinks) > 0 {
+		return fmt.Errorf("%w: forwarding to sinks needs a file", ErrInvalidOptions)
+	}
+	if o.Actor == "" {
+		o.Actor = processActor()
+	}
+	return nil
+}
+
+// processActor returns the user@host running the process
+func processActor() string {
+	name := "unknown"
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		host = "localhost"
+	}
+	return name + "@" + host
+}
+
+// Log appends events to a file, chaining each to the last event of the file; other processes
+// may append to the same file
+type Log struct {
+	path  string
+	file  *os.File
+	actor string
+
+	mu      sync.Mutex
+	closed  bool
+	forward chan Event
+	done    chan struct{}
+}
+
+// Open opens the log at path, creating it when missing; the last event must be intact for
+// the next one to be chained to it
+func Open(path string) (*Log, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create the audit directory: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the audit log: %w", err)
+	}
+	if _, err := lastEvent(f); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return &Log{path: path, file: f, actor: processActor()}, nil
+}
+
+// Path returns the file of the log
+func (l *Log) Path() string {
+	return l.path
+}
+
+// Append numbers e and chains it to the last event of the file before writing it, returning
+// it as written
+func (l *Log) Append(e Event) (Event, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return Event{}, ErrClosed
+	}
+	if err := lockFile(l.file); err != nil {
+		return Event{}, fmt.Errorf("failed to lock the audit log: %w", err)
+	}
+	defer func() { _ = unlockFile(l.file) }()
+
+	last, err := lastEvent(l.file)
+	if err != nil {
+		return Event{}, err
+	}
+	e.Seq, e.Prev = 1, ""
+	if last != nil {
+		e.Seq, e.Prev = last.Seq+1, last.Hash
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	e.Time = e.Time.UTC()
+	if e.Actor == "" {
+		e.Actor = l.actor
+	}
+	e.Hash = e.Sum()
+	line, err := json.Marshal(e)
+	if err != nil {
+		return Event{}, fmt.Errorf("failed to marshal the audit event: %w", err)
+	}
+	if _, err := l.file.Write(append(line, '\n')); err != nil {
+		return Event{}, fmt.Errorf("failed to write the audit event: %w", err)
+	}
+
+	if l.forward != nil {
+		select {
+		case l.forward <- e:
+		default:
+			log.Warnf("Not forwarding audit event %d, the forwarder is behind; it is in %s", e.Seq, l.path)
+		}
+	}
+	return e, nil
+}
+
+// Close stops forwarding, once the pending events are forwarded, and closes the file
+func (l *Log) Close() error {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return nil
+	}
+	l.closed = true
+	if l.forward != nil {
+		close(l.forward)
+	}
+	l.mu.Unlock()
+	if l.done != nil {
+		<-l.done
+	}
+	return l.file.Close()
+}
+
+// forwardTo forwards every event appended from now on, every ForwardInterval or ForwardBatch
+// events, until the log is closed
+func (l *Log) forwardTo(f Forwarder) {
+	l.forward = make(chan Event, 2*ForwardBatch)
+	l.done = make(chan struct{})
+	go func() {
+		defer close(l.done)
+		ticker := time.NewTicker(ForwardInterval)
+		defer ticker.Stop()
+		var pending []Event
+		flush := func() {
+			if len(pending) == 0 {
+				return
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), ForwardTimeout)
+			defer cancel()
+			if err := f.Forward(ctx, l.path, pending); err != nil {
+				log.Errorf("Failed to forward audit events %d to %d: %v", pending[0].Seq, pending[len(pending)-1].Seq, err)
+			}
+			pending = nil
+		}
+		for {
+			select {
+			case e, ok := <-l.forward:
+				if !ok {
+					flush()
+					return
+				}
+				pending = append(pending, e)
+				if len(pending) >= ForwardBatch {
+					flush()
+				}
+			case <-ticker.C:
+				flush()
+			}
+		}
+	}()
+}
+
+// lastEvent reads the last event of f, nil when f is empty
+func lastEvent(f *os.File) (*Event, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the audit log: %w", err)
+	}
+	size := info.Size()
+	if size == 0 {
+		return nil, nil
+	}
+
+	// Read backwards until the newline ending the line before the last one
+	const chunk = 4096
+	var tail []byte
+	for end := size; end > 0; {
+		start := max(end-chunk, 0)
+		buf := make([]byte, end-start)
+		if _, err := f.ReadAt(buf, start); err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("failed to read the audit log: %w", err)
+		}
+		tail = append(buf, tail...)
+		if bytes.IndexByte(tail[:len(tail)-1], '\n') >= 0 {
+			break
+		}
+		end = start
+	}
+	if tail[len(tail)-1] != '\n' {
+		return nil, fmt.Errorf("%w: the last event is incomplete, check it with gosling audit verify", ErrCorrupt)
+	}
+	line := tail[bytes.LastIndexByte(tail[:len(tail)-1], '\n')+1:]
+	e, err := decode(line)
+	if err != nil {
+		return nil, fmt.Errorf("%w: the last line is not an event, check it with gosling audit verify", ErrCorrupt)
+	}
+	return e, nil
+}
+
+// decode parses a line of the log, refusing fields an event does not have
+func decode(line []byte) (*Event, error) {
+	dec := json.NewDecoder(bytes.NewReader(line))
+	dec.DisallowUnknownFields()
+	var e Event
+	if err := dec.Decode(&e); err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// active is the log Record appends to, nil when auditing is off
+var active atomic.Pointer[Log]
+
+// Configure opens the log Record appends to, forwarding its events with opts.Forwarder. The
+// returned function forwards the pending events and closes the log; it must be called before
+// the process exits.
+func Configure(opts Options) (func(), error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	if opts.File == "" {
+		log.Warn("Auditing is off, set audit.file to record what goslings does")
+		return func() {}, nil
+	}
+	if len(opts.Sinks) > 0 && opts.Forwarder == nil {
+		return nil, fmt.Errorf("%w: the sinks need a forwarder", ErrInvalidOptions)
+	}
+	l, err := Open(opts.File)
+	if err != nil {
+		return nil, err
+	}
+	l.actor = opts.Actor
+	if opts.Forwarder != nil {
+		l.forwardTo(opts.Forwarder)
+	}
+	active.Store(l)
+	return func() {
+		active.CompareAndSwap(l, nil)
+		if err := l.Close(); err != nil {
+			log.Warnf("Failed to close the audit log: %v", err)
+		}
+	}, nil
+}
+
+// actorKey is the context key of the actor
+type actorKey struct{}
+
+// WithActor returns a context whose events are recorded as done by actor
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorOf returns the actor of ctx, empty when it names no one
+func ActorOf(ctx context.Context) string {
+	actor, _ := ctx.Value(actorKey{}).(string)
+	return actor
+}
+
+// Record appends an event to the configured log, err being why the action failed. The actor
+// and tenant come from ctx unless e names them. It does nothing when auditing is off; a
+// failure to record is logged, it does not stop the action.
+func Record(ctx context.Context, e Event, err error) {
+	l := active.Load()
+	if l == nil {
+		return
+	}
+	if e.Actor == "" {
+		e.Actor = ActorOf(ctx)
+	}
+	if e.Tenant == "" {
+		e.Tenant, _ = telemetry.Logger(ctx).Data[telemetry.FieldTenant].(string)
+	}
+	e.Outcome = OutcomeSuccess
+	if err != nil {
+		e.Outcome = OutcomeFailure
+		e.Error = telemetry.Redact(err.Error())
+	}
+	if _, err := l.Append(e); err != nil {
+		telemetry.Logger(ctx).Errorf("Failed to audit %s: %v", e.Action, err)
+	}
+}
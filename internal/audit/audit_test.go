@@ -0,0 +1,224 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/arustydev/goslings/internal/utils/telemetry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeLog appends n events to a new log, returning its path
+func writeLog(t *testing.T, n int) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "audit", "audit.jsonl")
+	l, err := Open(path)
+	require.NoError(t, err)
+	defer l.Close()
+	for range n {
+		_, err := l.Append(Event{Action: ActionAPIRequest, Outcome: OutcomeSuccess})
+		require.NoError(t, err)
+	}
+	return path
+}
+
+// lines reads the lines of a log
+func lines(t *testing.T, path string) [][]byte {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	split := bytes.SplitAfter(data, []byte("\n"))
+	return split[:len(split)-1]
+}
+
+func TestAppendChainsEvents(t *testing.T) {
+	path := writeLog(t, 3)
+
+	// Another process appending to the file continues the chain
+	l, err := Open(path)
+	require.NoError(t, err)
+	e, err := l.Append(Event{Action: ActionStoreClear, Outcome: OutcomeSuccess})
+	require.NoError(t, err)
+	require.NoError(t, l.Close())
+	assert.Equal(t, uint64(4), e.Seq)
+	_, err = l.Append(Event{Action: ActionStoreClear})
+	assert.ErrorIs(t, err, ErrClosed)
+
+	var events []Event
+	for _, line := range lines(t, path) {
+		var e Event
+		require.NoError(t, json.Unmarshal(line, &e))
+		events = append(events, e)
+	}
+	require.Len(t, events, 4)
+	assert.Empty(t, events[0].Prev)
+	for i, e := range events {
+		assert.Equal(t, uint64(i+1), e.Seq)
+		assert.Equal(t, e.Sum(), e.Hash)
+		assert.NotEmpty(t, e.Actor)
+		if i > 0 {
+			assert.Equal(t, events[i-1].Hash, e.Prev)
+		}
+	}
+
+	report, err := Verify(path)
+	require.NoError(t, err)
+	assert.Empty(t, report.Problems)
+	assert.Equal(t, 4, report.Events)
+	assert.Equal(t, events[3], *report.Last)
+}
+
+func TestVerifyFindsTampering(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		tamper func(lines [][]byte) [][]byte
+		want   []Problem
+	}{
+		{
+			name: "edited",
+			tamper: func(lines [][]byte) [][]byte {
+				lines[1] = bytes.Replace(lines[1], []byte(ActionAPIRequest), []byte(ActionStoreClear), 1)
+				return lines
+			},
+			want: []Problem{{Line: 2, Seq: 2, Reason: "edited, its hash does not match"}},
+		},
+		{
+			name: "rehashed",
+			tamper: func(lines [][]byte) [][]byte {
+				var e Event
+				_ = json.Unmarshal(lines[1], &e)
+				e.Actor = "someone@else"
+				e.Hash = e.Sum()
+				data, _ := json.Marshal(e)
+				lines[1] = append(data, '\n')
+				return lines
+			},
+			want: []Problem{{Line: 3, Seq: 3, Reason: "chain broken, it does not follow event 2 as written"}},
+		},
+		{
+			name: "removed",
+			tamper: func(lines [][]byte) [][]byte {
+				return append(lines[:1], lines[3:]...)
+			},
+			want: []Problem{{Line: 2, Seq: 4, Reason: "gap, events 2 to 3 are missing"}},
+		},
+		{
+			name: "head removed",
+			tamper: func(lines [][]byte) [][]byte {
+				return lines[2:]
+			},
+			want: []Problem{{Line: 1, Seq: 3, Reason: "the log does not start at event 1, events before it were removed"}},
+		},
+		{
+			name: "reordered",
+			tamper: func(lines [][]byte) [][]byte {
+				lines[1], lines[2] = lines[2], lines[1]
+				return lines
+			},
+			want: []Problem{
+				{Line: 2, Seq: 3, Reason: "gap, event 2 is missing"},
+				{Line: 3, Seq: 2, Reason: "out of order, it follows event 3"},
+				{Line: 4, Seq: 4, Reason: "gap, event 3 is missing"},
+			},
+		},
+		{
+			name: "cut short",
+			tamper: func(lines [][]byte) [][]byte {
+				lines[3] = lines[3][:10]
+				return lines
+			},
+			want: []Problem{{Line: 4, Reason: "incomplete event, the file was cut short"}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			path := writeLog(t, 4)
+			require.NoError(t, os.WriteFile(path, bytes.Join(tc.tamper(lines(t, path)), nil), 0o600))
+
+			report, err := Verify(path)
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, report.Problems)
+		})
+	}
+}
+
+func TestOpenRefusesBrokenTail(t *testing.T) {
+	path := writeLog(t, 2)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o600)
+	require.NoError(t, err)
+	_, err = f.WriteString(`{"seq":3,"act`)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	_, err = Open(path)
+	assert.ErrorIs(t, err, ErrCorrupt)
+}
+
+// fakeForwarder records the events forwarded
+type fakeForwarder struct {
+	mu     sync.Mutex
+	file   string
+	events []Event
+}
+
+func (f *fakeForwarder) Forward(_ context.Context, file string, events []Event) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.file = file
+	f.events = append(f.events, events...)
+	return nil
+}
+
+func TestRecord(t *testing.T) {
+	// Nothing is recorded while auditing is off
+	Record(context.Background(), Event{Action: ActionStoreClear}, nil)
+
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	forwarder := &fakeForwarder{}
+	stop, err := Configure(Options{File: path, Sinks: []string{"splunk"}, Forwarder: forwarder, Actor: "gosling@test"})
+	require.NoError(t, err)
+
+	ctx := telemetry.WithField(context.Background(), telemetry.FieldTenant, "contoso")
+	Record(ctx, Event{Action: ActionCollectStart, Target: "run-1"}, nil)
+	Record(WithActor(ctx, "apikey:1f2e3d4c"), Event{Action: ActionAuthenticate, Tenant: "fabrikam"},
+		errors.New("refused client_secret=s3cr3t"))
+	stop()
+	Record(ctx, Event{Action: ActionStoreClear}, nil)
+
+	report, err := Verify(path)
+	require.NoError(t, err)
+	assert.Empty(t, report.Problems)
+	require.Equal(t, 2, report.Events)
+
+	forwarder.mu.Lock()
+	defer forwarder.mu.Unlock()
+	assert.Equal(t, path, forwarder.file)
+	require.Len(t, forwarder.events, 2)
+	started, failed := forwarder.events[0], forwarder.events[1]
+	assert.Equal(t, "gosling@test", started.Actor)
+	assert.Equal(t, "contoso", started.Tenant)
+	assert.Equal(t, OutcomeSuccess, started.Outcome)
+	assert.Equal(t, "apikey:1f2e3d4c", failed.Actor)
+	assert.Equal(t, "fabrikam", failed.Tenant)
+	assert.Equal(t, OutcomeFailure, failed.Outcome)
+	assert.Equal(t, "refused client_secret="+telemetry.Redacted, failed.Error)
+	assert.Equal(t, *report.Last, failed)
+}
+
+func TestOptionsValidate(t *testing.T) {
+	opts := Options{}
+	require.NoError(t, opts.Validate())
+	assert.NotEmpty(t, opts.Actor)
+
+	opts = Options{Sinks: []string{"splunk"}}
+	assert.ErrorIs(t, opts.Validate(), ErrInvalidOptions)
+
+	_, err := Configure(Options{File: filepath.Join(t.TempDir(), "audit.jsonl"), Sinks: []string{"splunk"}})
+	assert.ErrorIs(t, err, ErrInvalidOptions)
+}
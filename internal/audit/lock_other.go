@@ -0,0 +1,18 @@
+//go:build !unix
+
+package audit
+
+import (
+	"os"
+)
+
+// lockFile does nothing, only the appends of this process are serialized; give each process
+// its own audit file
+func lockFile(*os.File) error {
+	return nil
+}
+
+// unlockFile does nothing, see lockFile
+func unlockFile(*os.File) error {
+	return nil
+}
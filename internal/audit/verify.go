@@ -0,0 +1,96 @@
+package audit
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Problem is an event of the log that was edited, or that does not follow the one before it
+type Problem struct {
+	// Line is the line of the file, from 1
+	Line int
+
+	// Seq is the number of the event, zero when the line is not an event
+	Seq uint64
+
+	Reason string
+}
+
+func (p Problem) String() string {
+	if p.Seq == 0 {
+		return fmt.Sprintf("line %d: %s", p.Line, p.Reason)
+	}
+	return fmt.Sprintf("line %d, event %d: %s", p.Line, p.Seq, p.Reason)
+}
+
+// Report is what Verify found in a log
+type Report struct {
+	// Events is the number of lines that are events
+	Events int
+
+	// Last is the last event of the log, nil when it has none; a copy forwarded elsewhere
+	// ending with the same hash shows the file was not cut short
+	Last *Event
+
+	Problems []Problem
+}
+
+// Verify checks that every event of the log at path has its hash and follows the event before
+// it, numbered from 1, which finds events that were edited, removed or reordered
+func Verify(path string) (*Report, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the audit log: %w", err)
+	}
+	defer f.Close()
+
+	report := &Report{}
+	add := func(line int, seq uint64, format string, args ...any) {
+		report.Problems = append(report.Problems, Problem{Line: line, Seq: seq, Reason: fmt.Sprintf(format, args...)})
+	}
+	r := bufio.NewReader(f)
+	var prev *Event
+	for n := 1; ; n++ {
+		line, err := r.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			if len(line) > 0 {
+				add(n, 0, "incomplete event, the file was cut short")
+			}
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the audit log: %w", err)
+		}
+
+		e, err := decode(line)
+		if err != nil {
+			add(n, 0, "not an event: %v", err)
+			// The next event cannot be checked against this line
+			prev = nil
+			continue
+		}
+		report.Events++
+		report.Last = e
+		if e.Sum() != e.Hash {
+			add(n, e.Seq, "edited, its hash does not match")
+		}
+		switch {
+		case prev == nil && n == 1 && (e.Seq != 1 || e.Prev != ""):
+			add(n, e.Seq, "the log does not start at event 1, events before it were removed")
+		case prev == nil:
+		case e.Seq == prev.Seq+2:
+			add(n, e.Seq, "gap, event %d is missing", prev.Seq+1)
+		case e.Seq > prev.Seq+2:
+			add(n, e.Seq, "gap, events %d to %d are missing", prev.Seq+1, e.Seq-1)
+		case e.Seq <= prev.Seq:
+			add(n, e.Seq, "out of order, it follows event %d", prev.Seq)
+		case e.Prev != prev.Hash:
+			add(n, e.Seq, "chain broken, it does not follow event %d as written", prev.Seq)
+		}
+		prev = e
+	}
+	return report, nil
+}
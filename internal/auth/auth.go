@@ -7,12 +7,19 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"maps"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/arustydev/goslings/internal/audit"
 	"github.com/arustydev/goslings/internal/auth/lease"
 	"github.com/arustydev/goslings/internal/auth/shared"
 	"github.com/arustydev/goslings/internal/auth/store"
+	"github.com/arustydev/goslings/internal/utils/telemetry"
+	"github.com/arustydev/goslings/pkg/apiv1"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -20,6 +27,7 @@ import (
 var (
 	ErrStoreNotInitialized = errors.New("credential store not initialized")
 	ErrLeaseNotInitialized = errors.New("authentication lease not initialized")
+	ErrMissingTenant       = errors.New("no tenant to authenticate to")
 )
 
 // Errors from external packages
@@ -28,19 +36,33 @@ var (
 	ErrCredentialsExpired = lease.ErrCredentialsExpired
 )
 
-// Service defines supported service types
-type Service string
+// Service defines supported service types, it is reported by the API
+type Service = apiv1.Service
 
 const (
-	// AzureService represents Azure services
-	AzureService Service = "azure"
+	AzureService = apiv1.AzureService
+	M365Service  = apiv1.M365Service
+	GraphService = apiv1.GraphService
+)
 
-	// M365Service represents Microsoft 365 services
-	M365Service Service = "m365"
+// Services lists every supported service, in the order they are reported
+var Services = []Service{GraphService, AzureService, M365Service}
 
-	// GraphService represents Microsoft Graph API
-	GraphService Service = "graph"
-)
+// LeaseStatus reports the token of a service without renewing it
+type LeaseStatus struct {
+	Service Service
+
+	// ExpiresAt is zero when there is no token for the service
+	ExpiresAt time.Time
+
+	// Fallback is set when the service uses the graph token, like GetToken does
+	Fallback bool
+}
+
+// Expired reports whether the service has no token, or an expired one
+func (l LeaseStatus) Expired() bool {
+	return !time.Now().Before(l.ExpiresAt)
+}
 
 // AuthManager is the main entry point for authentication functionality
 type AuthManager struct {
@@ -53,6 +75,15 @@ type AuthManager struct {
 	// mu protects concurrent access to credentials
 	mu sync.RWMutex
 
+	// credential acquires app-only tokens, it is nil for interactive authentication
+	credential azcore.TokenCredential
+
+	// tenant is the tenant whose stored tokens are loaded, see Options.Tenant
+	tenant string
+
+	// storeType is logged with the messages about the store
+	storeType shared.StoreType
+
 	// Current authentication state
 	currentAuthParams *shared.AuthParams
 	currentCreds      *shared.Credentials
@@ -69,12 +100,18 @@ type Options struct {
 
 	// EncryptionKey is the key for encrypting sensitive data
 	EncryptionKey []byte
+
+	// Tenant is the tenant whose stored tokens are loaded, tokens of another tenant are
+	// ignored; any tenant's are loaded when empty
+	Tenant string
 }
 
 // NewAuthManager creates a new authentication manager
 func NewAuthManager(ctx context.Context, opts Options) (*AuthManager, error) {
 	auth := &AuthManager{
-		Leases: make(map[Service]lease.Lease),
+		Leases:    make(map[Service]lease.Lease),
+		tenant:    opts.Tenant,
+		storeType: opts.StoreType,
 	}
 
 	// Initialize the credential store
@@ -82,6 +119,8 @@ func NewAuthManager(ctx context.Context, opts Options) (*AuthManager, error) {
 	switch opts.StoreType {
 	case shared.FileStore:
 		auth.Store, err = store.NewFileStore(opts.StorePath, opts.EncryptionKey)
+	case shared.MemoryStore:
+		auth.Store = store.NewMemoryStore()
 	case shared.K8sStore:
 		// TODO: implement Kubernetes store logic
 		// auth.Store, err = store.NewK8sStore(opts.StorePath, opts.EncryptionKey)
@@ -97,31 +136,41 @@ func NewAuthManager(ctx context.Context, opts Options) (*AuthManager, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to initialize credential store: %w", err)
 	}
+	auth.Store = store.NewMeteredStore(auth.Store, string(opts.StoreType))
 
 	// Initialize the leases
 	if auth.Leases[AzureService], err = lease.NewLease(ctx, &lease.RealAzureCredentialFactory{}); err != nil {
-		log.Debugf("Failed to lease credentials from Azure: %v", err)
+		telemetry.Logger(ctx).WithField(telemetry.FieldLease, AzureService).Debugf("Failed to lease credentials from Azure: %v", err)
 		// Continue without credentials, we'll get them later
 	}
 	// auth.Leases[M365Service] = lease.NewM365Lease()
 
 	// Load credentials from store
 	if err := auth.loadFromStore(context.Background()); err != nil {
-		log.Debugf("Failed to load credentials from store: %v", err)
+		auth.logger(ctx).Debugf("Failed to load credentials from store: %v", err)
 		// Continue without credentials, we'll get them later
 	}
 
 	return auth, nil
 }
 
+// logger returns the logger of ctx, with the type of the store
+func (a *AuthManager) logger(ctx context.Context) *log.Entry {
+	return telemetry.Logger(ctx).WithField(telemetry.FieldStore, a.storeType)
+}
+
 // loadFromStore loads authentication state from the store
 func (a *AuthManager) loadFromStore(ctx context.Context) error {
 	if a.Store == nil {
 		return ErrStoreNotInitialized
 	}
 
-	// Load auth params
+	// Load auth params, the tokens of another tenant are left alone so they are never mixed
 	params, err := a.Store.LoadParams(ctx)
+	if err == nil && a.tenant != "" && params.TenantID != "" && !strings.EqualFold(params.TenantID, a.tenant) {
+		a.logger(ctx).Warnf("The credential store holds the tokens of tenant %s, not %s; ignoring them, use a profile per tenant", params.TenantID, a.tenant)
+		return nil
+	}
 	if err == nil {
 		a.currentAuthParams = params
 	}
@@ -172,11 +221,15 @@ func (a *AuthManager) saveToStore(ctx context.Context) error {
 }
 
 // Authenticate performs authentication using the provided parameters
-func (a *AuthManager) Authenticate(ctx context.Context, params *shared.AuthParams) error {
+func (a *AuthManager) Authenticate(ctx context.Context, params *shared.AuthParams) (err error) {
 	a.mu.Lock()
 	defer a.mu.Unlock()
+	defer func() { a.auditAuthentication(ctx, params, err) }()
 
-	log.Debug("Starting authentication process")
+	a.logger(ctx).Debug("Starting authentication process")
+	if params == nil || params.TenantID == "" {
+		return ErrMissingTenant
+	}
 
 	// Update current auth params
 	a.currentAuthParams = params
@@ -221,15 +274,28 @@ func (a *AuthManager) Authenticate(ctx context.Context, params *shared.AuthParam
 
 	// Save to store
 	if err := a.saveToStore(ctx); err != nil {
-		log.Infof("Warning: Failed to save authentication state: %v", err)
+		a.logger(ctx).Warnf("Failed to save authentication state: %v", err)
 		// Continue anyway, as we've authenticated successfully
 	}
 
-	log.Info("Authentication completed successfully")
+	a.logger(ctx).Info("Authentication completed successfully")
 
 	return nil
 }
 
+// auditAuthentication records a sign-in with params, along with the services it got a token for
+func (a *AuthManager) auditAuthentication(ctx context.Context, params *shared.AuthParams, err error) {
+	e := audit.Event{Action: audit.ActionAuthenticate}
+	if params != nil {
+		e.Tenant, e.Target = params.TenantID, params.Identity()
+	}
+	if err == nil && a.currentCreds != nil {
+		services := slices.Sorted(maps.Keys(a.currentCreds.Tokens))
+		e.Details = map[string]string{"method": string(a.currentCreds.AuthType), "tokens": strings.Join(services, ",")}
+	}
+	audit.Record(ctx, e, err)
+}
+
 // GetToken gets a token for the specified service
 // TODO: This probably shouldn't be public?
 func (a *AuthManager) GetToken(service Service) (*shared.Token, error) {
@@ -242,20 +308,13 @@ func (a *AuthManager) GetToken(service Service) (*shared.Token, error) {
 	}
 
 	// Map service to token name
-	var tokenName string
-	switch service {
-	case AzureService:
-		tokenName = "azure"
-	case M365Service:
-		tokenName = "exchange"
-	case GraphService:
-		tokenName = "graph"
-	default:
+	name := tokenName(service)
+	if name == "" {
 		return nil, fmt.Errorf("unsupported service: %s", service)
 	}
 
 	// Get the token
-	token, ok := a.currentCreds.Tokens[tokenName]
+	token, ok := a.currentCreds.Tokens[name]
 	if !ok {
 		// Try graph token as fallback for Azure
 		if service == AzureService {
@@ -268,14 +327,55 @@ func (a *AuthManager) GetToken(service Service) (*shared.Token, error) {
 		}
 	}
 
-	// Check if token is expired
-	if time.Now().After(token.ExpiresAt) {
+	// Check if token is expired, app-only tokens are renewed ahead of expiry
+	grace := time.Duration(0)
+	if a.credential != nil {
+		grace = renewBefore
+	}
+	if time.Now().Add(grace).After(token.ExpiresAt) {
 		return nil, ErrCredentialsExpired
 	}
 
 	return token, nil
 }
 
+// LeaseStatuses reports the token of every service
+func (a *AuthManager) LeaseStatuses() []LeaseStatus {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	statuses := make([]LeaseStatus, 0, len(Services))
+	for _, service := range Services {
+		st := LeaseStatus{Service: service}
+		if a.currentCreds != nil {
+			token, ok := a.currentCreds.Tokens[tokenName(service)]
+			if !ok && service == AzureService {
+				token, ok = a.currentCreds.Tokens["graph"]
+				st.Fallback = ok
+			}
+			if ok {
+				st.ExpiresAt = token.ExpiresAt
+			}
+		}
+		statuses = append(statuses, st)
+	}
+	return statuses
+}
+
+// tokenName maps a service to the name of its token in Credentials.Tokens
+func tokenName(service Service) string {
+	switch service {
+	case AzureService:
+		return "azure"
+	case M365Service:
+		return "exchange"
+	case GraphService:
+		return "graph"
+	default:
+		return ""
+	}
+}
+
 // RenewTokens renews all tokens
 func (a *AuthManager) RenewTokens(ctx context.Context) error {
 	a.mu.Lock()
@@ -286,7 +386,14 @@ func (a *AuthManager) RenewTokens(ctx context.Context) error {
 		return ErrNotAuthenticated
 	}
 
-	log.Debug("Starting token renewal process")
+	a.logger(ctx).Debug("Starting token renewal process")
+
+	// App-only credentials acquire new tokens without a refresh token
+	if a.credential != nil {
+		if err := a.acquireAppTokens(ctx, telemetry.TokenRenew); err != nil {
+			return fmt.Errorf("failed to renew app-only tokens: %w", err)
+		}
+	}
 
 	// // Renew Azure/Graph tokens
 	// azureLease, ok := a.Leases[AzureService]
@@ -346,11 +453,11 @@ func (a *AuthManager) RenewTokens(ctx context.Context) error {
 
 	// Save to store
 	if err := a.saveToStore(ctx); err != nil {
-		log.Infof("Warning: Failed to save renewed tokens: %v", err)
+		a.logger(ctx).Warnf("Failed to save renewed tokens: %v", err)
 		// Continue anyway, as we've renewed successfully
 	}
 
-	log.Info("Token renewal completed successfully")
+	a.logger(ctx).Info("Token renewal completed successfully")
 
 	return nil
 }
@@ -365,16 +472,19 @@ func (a *AuthManager) Clear(ctx context.Context) error {
 	}
 
 	// Clear the store
-	if err := a.Store.Clear(ctx); err != nil {
+	err := a.Store.Clear(ctx)
+	audit.Record(ctx, audit.Event{Action: audit.ActionStoreClear, Target: string(a.storeType)}, err)
+	if err != nil {
 		return fmt.Errorf("failed to clear credential store: %w", err)
 	}
 
 	// Clear current state
+	a.credential = nil
 	a.currentAuthParams = nil
 	a.currentCreds = nil
 	a.m365Resources = nil
 
-	log.Info("Cleared all authentication state")
+	a.logger(ctx).Info("Cleared all authentication state")
 
 	return nil
 }
@@ -0,0 +1,170 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/arustydev/goslings/internal/auth/shared"
+	"github.com/arustydev/goslings/internal/utils/telemetry"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// ErrMissingAppParams is returned when app-only authentication lacks a tenant, client or secret
+var ErrMissingAppParams = errors.New("app-only authentication needs a tenant, client ID and client secret")
+
+// AppAuthMethod selects how an application authenticates without a user
+type AppAuthMethod string
+
+const (
+	// AppSecret authenticates with the application's client secret
+	AppSecret AppAuthMethod = "secret"
+
+	// ManagedIdentity authenticates as the Azure managed identity of the host; a client ID
+	// selects a user-assigned identity
+	ManagedIdentity AppAuthMethod = "managed_identity"
+)
+
+// appServices are the services an app-only credential acquires tokens for
+var appServices = []Service{GraphService, AzureService, M365Service}
+
+// renewBefore is how long before expiry an app-only token is renewed
+const renewBefore = 5 * time.Minute
+
+// Scope returns the scope of a service's token for the commercial or US Government cloud
+func Scope(service Service, usGovernment bool) (string, error) {
+	scopes := map[Service][2]string{
+		GraphService: {"https://graph.microsoft.com/.default", "https://graph.microsoft.us/.default"},
+		AzureService: {"https://management.azure.com/.default", "https://management.usgovcloudapi.net/.default"},
+		M365Service:  {"https://manage.office.com/.default", "https://manage.office365.us/.default"},
+	}
+	s, ok := scopes[service]
+	if !ok {
+		return "", fmt.Errorf("unsupported service: %s", service)
+	}
+	if usGovernment {
+		return s[1], nil
+	}
+	return s[0], nil
+}
+
+// NewAppCredential creates a credential that authenticates as an application, never prompting
+func NewAppCredential(params *shared.AuthParams, method AppAuthMethod) (azcore.TokenCredential, error) {
+	opts := policy.ClientOptions{Cloud: cloud.AzurePublic}
+	if params.UsGovernment {
+		opts.Cloud = cloud.AzureGovernment
+	}
+
+	switch method {
+	case AppSecret:
+		if params.TenantID == "" || params.ClientID == "" || params.ClientSecret == "" {
+			return nil, ErrMissingAppParams
+		}
+		return azidentity.NewClientSecretCredential(params.TenantID, params.ClientID, params.ClientSecret,
+			&azidentity.ClientSecretCredentialOptions{ClientOptions: opts})
+	case ManagedIdentity:
+		miOpts := &azidentity.ManagedIdentityCredentialOptions{ClientOptions: opts}
+		if params.ClientID != "" {
+			miOpts.ID = azidentity.ClientID(params.ClientID)
+		}
+		return azidentity.NewManagedIdentityCredential(miOpts)
+	default:
+		return nil, fmt.Errorf("unsupported app authentication method: %s", method)
+	}
+}
+
+// DevicePrompt relays a device code to the user who signs in with it
+type DevicePrompt func(ctx context.Context, message azidentity.DeviceCodeMessage) error
+
+// NewDeviceCodeCredential creates a credential that signs a user in with a device code; the
+// code is passed to prompt, and the client ID defaults to the Azure SDK's public client
+func NewDeviceCodeCredential(params *shared.AuthParams, prompt DevicePrompt) (azcore.TokenCredential, error) {
+	opts := &azidentity.DeviceCodeCredentialOptions{
+		ClientOptions: policy.ClientOptions{Cloud: cloud.AzurePublic},
+		TenantID:      params.TenantID,
+		ClientID:      params.ClientID,
+		UserPrompt:    prompt,
+	}
+	if params.UsGovernment {
+		opts.ClientOptions.Cloud = cloud.AzureGovernment
+	}
+	return azidentity.NewDeviceCodeCredential(opts)
+}
+
+// UseCredential authenticates with a credential, acquiring a token for every service; tokens
+// are acquired again from the credential when they are renewed. A device code credential
+// should already have signed the user in, as the manager is locked while tokens are acquired.
+func (a *AuthManager) UseCredential(ctx context.Context, cred azcore.TokenCredential, params *shared.AuthParams) (err error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	defer func() { a.auditAuthentication(ctx, params, err) }()
+
+	a.credential = cred
+	a.currentAuthParams = params
+	if err := a.acquireAppTokens(ctx, telemetry.TokenAcquire); err != nil {
+		return err
+	}
+
+	if err := a.saveToStore(ctx); err != nil {
+		a.logger(ctx).Warnf("Failed to save authentication state: %v", err)
+	}
+	a.logger(ctx).Infof("Authenticated as %s", params.Identity())
+	return nil
+}
+
+// acquireAppTokens replaces the current credentials with fresh tokens from the credential. A
+// service the application has no access to is skipped, unless every service fails. Each
+// token is counted in the metrics under operation, TokenAcquire or TokenRenew.
+func (a *AuthManager) acquireAppTokens(ctx context.Context, operation string) error {
+	authType := shared.ClientCredentialsAuth
+	if _, ok := a.credential.(*azidentity.DeviceCodeCredential); ok {
+		authType = shared.DeviceCodeAuth
+	}
+	creds := &shared.Credentials{
+		Tokens:        make(map[string]*shared.Token),
+		AuthType:      authType,
+		LastRefreshed: time.Now(),
+	}
+
+	var errs []error
+	for _, service := range appServices {
+		scope, err := Scope(service, a.currentAuthParams.UsGovernment)
+		if err != nil {
+			return err
+		}
+		start := time.Now()
+		spanCtx, span := telemetry.StartSpan(ctx, "auth.token",
+			telemetry.Attr(telemetry.FieldLease, string(service)),
+			attribute.String("goslings.operation", operation),
+		)
+		tk, err := a.credential.GetToken(spanCtx, policy.TokenRequestOptions{Scopes: []string{scope}})
+		telemetry.ObserveToken(string(service), operation, start, err)
+		telemetry.EndSpan(span, err)
+		if err != nil {
+			telemetry.Logger(ctx).WithField(telemetry.FieldLease, service).Warnf("Failed to acquire a %s token: %v", service, err)
+			errs = append(errs, fmt.Errorf("%s: %w", service, err))
+			continue
+		}
+		creds.Tokens[tokenName(service)] = &shared.Token{
+			Value:     tk.Token,
+			Type:      "Bearer",
+			ExpiresAt: tk.ExpiresOn,
+			Scopes:    []string{scope},
+			Resource:  string(service),
+		}
+		if creds.ExpiresAt.IsZero() || tk.ExpiresOn.Before(creds.ExpiresAt) {
+			creds.ExpiresAt = tk.ExpiresOn
+		}
+	}
+	if len(creds.Tokens) == 0 {
+		return fmt.Errorf("failed to authenticate: %w", errors.Join(errs...))
+	}
+
+	a.currentCreds = creds
+	return nil
+}
@@ -0,0 +1,211 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/arustydev/goslings/internal/audit"
+	"github.com/arustydev/goslings/internal/auth/shared"
+	"github.com/arustydev/goslings/internal/auth/store"
+	"github.com/arustydev/goslings/pkg/apiv1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeCredential issues tokens named after their scope, refusing the scopes in deny
+type fakeCredential struct {
+	calls   int
+	expires time.Duration
+	deny    map[string]bool
+}
+
+func (f *fakeCredential) GetToken(ctx context.Context, opts policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	f.calls++
+	if f.deny[opts.Scopes[0]] {
+		return azcore.AccessToken{}, errors.New("AADSTS500011: resource not found")
+	}
+	return azcore.AccessToken{Token: opts.Scopes[0], ExpiresOn: time.Now().Add(f.expires)}, nil
+}
+
+func newMemoryManager(t *testing.T) *AuthManager {
+	t.Helper()
+	a, err := NewAuthManager(t.Context(), Options{StoreType: shared.MemoryStore})
+	require.NoError(t, err)
+	return a
+}
+
+func TestUseCredentialAcquiresEveryServiceToken(t *testing.T) {
+	a := newMemoryManager(t)
+	cred := &fakeCredential{expires: time.Hour, deny: map[string]bool{"https://management.azure.com/.default": true}}
+	require.NoError(t, a.UseCredential(t.Context(), cred, &shared.AuthParams{ClientID: "app"}))
+
+	token, err := a.GetToken(GraphService)
+	require.NoError(t, err)
+	assert.Equal(t, "https://graph.microsoft.com/.default", token.Value)
+
+	token, err = a.GetToken(M365Service)
+	require.NoError(t, err)
+	assert.Equal(t, "https://manage.office.com/.default", token.Value)
+
+	// A denied service falls back to the graph token like interactive credentials do
+	token, err = a.GetToken(AzureService)
+	require.NoError(t, err)
+	assert.Equal(t, "https://graph.microsoft.com/.default", token.Value)
+
+	leases := a.LeaseStatuses()
+	require.Len(t, leases, len(Services))
+	for _, l := range leases {
+		assert.False(t, l.Expired(), l.Service)
+		assert.Equal(t, l.Service == AzureService, l.Fallback, l.Service)
+	}
+
+	stored, err := a.Store.LoadCredentials(t.Context())
+	require.NoError(t, err)
+	assert.Equal(t, shared.ClientCredentialsAuth, stored.AuthType)
+}
+
+func TestUseCredentialRenewsBeforeExpiry(t *testing.T) {
+	a := newMemoryManager(t)
+	cred := &fakeCredential{expires: time.Minute}
+	require.NoError(t, a.UseCredential(t.Context(), cred, &shared.AuthParams{UsGovernment: true}))
+
+	_, err := a.GetToken(GraphService)
+	require.ErrorIs(t, err, ErrCredentialsExpired)
+
+	cred.expires = time.Hour
+	require.NoError(t, a.RenewTokens(t.Context()))
+	token, err := a.GetToken(GraphService)
+	require.NoError(t, err)
+	assert.Equal(t, "https://graph.microsoft.us/.default", token.Value)
+	assert.Equal(t, 6, cred.calls)
+}
+
+func TestUseCredentialFailsWithoutAnyToken(t *testing.T) {
+	a := newMemoryManager(t)
+	cred := &fakeCredential{deny: map[string]bool{
+		"https://graph.microsoft.com/.default":  true,
+		"https://management.azure.com/.default": true,
+		"https://manage.office.com/.default":    true,
+	}}
+	assert.Error(t, a.UseCredential(t.Context(), cred, &shared.AuthParams{}))
+}
+
+func TestNewAppCredentialNeedsSecret(t *testing.T) {
+	_, err := NewAppCredential(&shared.AuthParams{TenantID: "t", ClientID: "c"}, AppSecret)
+	assert.ErrorIs(t, err, ErrMissingAppParams)
+}
+
+func TestStoredTokensOfAnotherTenantAreIgnored(t *testing.T) {
+	dir, key := t.TempDir(), make([]byte, 32)
+	fs, err := store.NewFileStore(dir, key)
+	require.NoError(t, err)
+	require.NoError(t, fs.StoreParams(t.Context(), &shared.AuthParams{TenantID: "contoso"}))
+	require.NoError(t, fs.StoreCredentials(t.Context(), &shared.Credentials{Tokens: map[string]*shared.Token{}}))
+
+	a, err := NewAuthManager(t.Context(), Options{StoreType: shared.FileStore, StorePath: dir, EncryptionKey: key, Tenant: "fabrikam"})
+	require.NoError(t, err)
+	assert.Nil(t, a.GetAuthParams())
+
+	a, err = NewAuthManager(t.Context(), Options{StoreType: shared.FileStore, StorePath: dir, EncryptionKey: key, Tenant: "Contoso"})
+	require.NoError(t, err)
+	assert.Equal(t, "contoso", a.GetAuthParams().TenantID)
+}
+
+func TestAuthenticationsAreAudited(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	stop, err := audit.Configure(audit.Options{File: path, Actor: "gosling@test"})
+	require.NoError(t, err)
+	defer stop()
+
+	a := newMemoryManager(t)
+	assert.ErrorIs(t, a.Authenticate(t.Context(), &shared.AuthParams{ClientID: "app"}), ErrMissingTenant)
+	require.NoError(t, a.Authenticate(t.Context(), &shared.AuthParams{TenantID: "contoso", ClientID: "app"}))
+	stop()
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var events []audit.Event
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var e audit.Event
+		require.NoError(t, json.Unmarshal([]byte(line), &e))
+		events = append(events, e)
+	}
+	require.Len(t, events, 2)
+	assert.Equal(t, audit.ActionAuthenticate, events[0].Action)
+	assert.Equal(t, audit.OutcomeFailure, events[0].Outcome)
+	assert.Equal(t, ErrMissingTenant.Error(), events[0].Error)
+	assert.Equal(t, audit.OutcomeSuccess, events[1].Outcome)
+	assert.Equal(t, "contoso", events[1].Tenant)
+}
+
+// deviceCredential relays a device code, then signs in once signedIn is closed or fails with err
+type deviceCredential struct {
+	fakeCredential
+	prompt   DevicePrompt
+	signedIn chan struct{}
+	err      error
+}
+
+func (d *deviceCredential) GetToken(ctx context.Context, opts policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	if d.err != nil {
+		return azcore.AccessToken{}, d.err
+	}
+	if d.prompt != nil {
+		if err := d.prompt(ctx, azidentity.DeviceCodeMessage{UserCode: "ABCD-EFGH", VerificationURL: "https://microsoft.com/devicelogin"}); err != nil {
+			return azcore.AccessToken{}, err
+		}
+		d.prompt = nil
+		<-d.signedIn
+	}
+	return d.fakeCredential.GetToken(ctx, opts)
+}
+
+func TestDeviceCodeSignInRelaysTheCode(t *testing.T) {
+	a := newMemoryManager(t)
+	signIn := NewSignIn("device_code", "contoso")
+	cred := &deviceCredential{fakeCredential: fakeCredential{expires: time.Hour}, prompt: signIn.Prompt, signedIn: make(chan struct{})}
+
+	require.NoError(t, a.SignInWithDeviceCode(t.Context(), signIn, cred, &shared.AuthParams{TenantID: "contoso"}, time.Second))
+	st := signIn.Status()
+	assert.True(t, signIn.Pending())
+	assert.Equal(t, "ABCD-EFGH", st.UserCode)
+
+	close(cred.signedIn)
+	require.Eventually(t, func() bool { return !signIn.Pending() }, 5*time.Second, 10*time.Millisecond)
+	st = signIn.Status()
+	assert.Equal(t, apiv1.AuthAuthenticated, st.State)
+	assert.Empty(t, st.UserCode)
+	assert.Equal(t, "contoso", a.GetAuthParams().TenantID)
+}
+
+func TestFailedDeviceCodeSignInIsAudited(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	stop, err := audit.Configure(audit.Options{File: path, Actor: "gosling@test"})
+	require.NoError(t, err)
+	defer stop()
+
+	a := newMemoryManager(t)
+	signIn := NewSignIn("device_code", "contoso")
+	cred := &deviceCredential{err: errors.New("AADSTS70016: authorization_pending")}
+	err = a.SignInWithDeviceCode(t.Context(), signIn, cred, &shared.AuthParams{TenantID: "contoso"}, time.Second)
+	require.ErrorContains(t, err, "device code sign-in failed")
+	assert.Equal(t, apiv1.AuthFailed, signIn.Status().State)
+	stop()
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var e audit.Event
+	require.NoError(t, json.Unmarshal(data, &e))
+	assert.Equal(t, audit.ActionAuthenticate, e.Action)
+	assert.Equal(t, audit.OutcomeFailure, e.Outcome)
+	assert.Equal(t, "device_code", e.Details["method"])
+}
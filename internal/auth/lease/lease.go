@@ -7,6 +7,7 @@ import (
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
 	"github.com/arustydev/goslings/internal/auth/shared"
+	"github.com/arustydev/goslings/internal/utils/telemetry"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -60,7 +61,7 @@ func (l *Lease) Acquire(ctx context.Context) (*shared.Credentials, error) {
 
 	// Retrieve the Token
 	if token, err := l.AuthFactory.AcquireToken(ctx, policy.TokenRequestOptions{}); err != nil {
-		log.Debugf("Device code authentication failed: %v", err)
+		telemetry.Logger(ctx).Debugf("Device code authentication failed: %v", err)
 	} else {
 		creds.Tokens["example"] = token
 	}
@@ -82,7 +83,7 @@ func (l *Lease) Renew(ctx context.Context) (*shared.Credentials, error) {
 
 	// Retrieve the Token
 	if token, err := l.AuthFactory.AcquireToken(ctx, policy.TokenRequestOptions{}); err != nil {
-		log.Debugf("Device code authentication failed: %v", err)
+		telemetry.Logger(ctx).Debugf("Device code authentication failed: %v", err)
 	} else {
 		creds.Tokens["example"] = token
 	}
@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/arustydev/goslings/internal/auth/shared"
+	"github.com/arustydev/goslings/internal/utils/telemetry"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -34,7 +35,7 @@ func (l *M365Lease) Acquire(
 ) (*shared.Credentials, error) {
 	// Skip if M365 is not enabled
 	if !params.M365Enabled {
-		log.Debug("M365 authentication is disabled")
+		m365Logger(ctx).Debug("M365 authentication is disabled")
 		return &shared.Credentials{
 			Tokens:        make(map[string]*shared.Token),
 			AuthType:      shared.M365Auth,
@@ -43,7 +44,7 @@ func (l *M365Lease) Acquire(
 		}, nil
 	}
 
-	log.Info("Authenticating to Microsoft 365 services")
+	m365Logger(ctx).Info("Authenticating to Microsoft 365 services")
 
 	// Create a new credentials map
 	creds := &shared.Credentials{
@@ -69,7 +70,7 @@ func (l *M365Lease) Acquire(
 	// If message trace is enabled, get additional tokens
 	if params.MessageTraceEnabled {
 		if err := l.authenticateMessageTrace(ctx, params, creds, baseURL); err != nil {
-			log.Infof("Warning: Failed to authenticate for message trace: %v", err)
+			m365Logger(ctx).Warnf("Failed to authenticate for message trace: %v", err)
 			// Continue anyway, as this is not critical
 		}
 	}
@@ -113,7 +114,7 @@ func (l *M365Lease) authenticateExchangeOnline(
 	// In a real implementation, this would make HTTP requests to authenticate with Exchange Online
 	// For now, we'll provide a placeholder implementation
 
-	log.Debugf("Authenticating to Exchange Online at %s", baseURL)
+	m365Logger(ctx).Debugf("Authenticating to Exchange Online at %s", baseURL)
 
 	// Placeholder for cookie-based authentication
 	// In a real implementation, you would:
@@ -133,7 +134,7 @@ func (l *M365Lease) authenticateExchangeOnline(
 
 	creds.Tokens["exchange"] = token
 
-	log.Debug("Successfully authenticated to Exchange Online")
+	m365Logger(ctx).Debug("Successfully authenticated to Exchange Online")
 
 	return nil
 }
@@ -148,7 +149,7 @@ func (l *M365Lease) authenticateMessageTrace(
 	// In a real implementation, this would make HTTP requests to authenticate with Message Trace
 	// For now, we'll provide a placeholder implementation
 
-	log.Debugf("Authenticating to Message Trace at %s", baseURL)
+	m365Logger(ctx).Debugf("Authenticating to Message Trace at %s", baseURL)
 
 	// Placeholder for token-based authentication
 	// In a real implementation, you would:
@@ -166,7 +167,7 @@ func (l *M365Lease) authenticateMessageTrace(
 
 	creds.Tokens["msgtrace"] = token
 
-	log.Debug("Successfully authenticated to Message Trace")
+	m365Logger(ctx).Debug("Successfully authenticated to Message Trace")
 
 	return nil
 }
@@ -178,3 +179,8 @@ func (l *M365Lease) getM365BaseURL(params *shared.AuthParams) string {
 	}
 	return "https://outlook.office.com"
 }
+
+// m365Logger returns the logger of ctx, with the lease field of Microsoft 365
+func m365Logger(ctx context.Context) *log.Entry {
+	return telemetry.Logger(ctx).WithField(telemetry.FieldLease, "m365")
+}
@@ -64,6 +64,17 @@ type AuthParams struct {
 	MessageTraceEnabled bool `mapstructure:"GOSLING_EXO_MSG_TRACE"`
 }
 
+// Identity returns who authenticates with these parameters, the user or else the application
+func (p *AuthParams) Identity() string {
+	if p.Username != "" {
+		return p.Username
+	}
+	if p.ClientID != "" {
+		return "app:" + p.ClientID
+	}
+	return ""
+}
+
 // Token represents an authentication token
 type Token struct {
 	// Value is the actual token string
@@ -121,6 +132,9 @@ const (
 
 	// VaultStore represents a HashiCorp Vault-based credential store
 	VaultStore StoreType = "vault"
+
+	// MemoryStore represents an in-process credential store that does not persist
+	MemoryStore StoreType = "memory"
 )
 
 // M365Resources holds M365-specific authentication resources
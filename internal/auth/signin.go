@@ -0,0 +1,114 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/arustydev/goslings/internal/audit"
+	"github.com/arustydev/goslings/internal/auth/shared"
+	"github.com/arustydev/goslings/internal/utils/telemetry"
+	"github.com/arustydev/goslings/pkg/apiv1"
+)
+
+// ErrNoDeviceCode is returned when a device code sign-in issues no code in time
+var ErrNoDeviceCode = errors.New("no device code was issued")
+
+// SignIn is a sign-in started on behalf of a user who is not at the terminal, such as one
+// started through the API or the TUI; it relays the device code of a device code sign-in
+type SignIn struct {
+	mu     sync.Mutex
+	status apiv1.AuthStatus
+
+	// prompted receives once the device code has been issued
+	prompted chan struct{}
+}
+
+// NewSignIn starts tracking a pending sign-in with method
+func NewSignIn(method, tenant string) *SignIn {
+	return &SignIn{
+		status:   apiv1.AuthStatus{State: apiv1.AuthPending, Method: method, Tenant: tenant},
+		prompted: make(chan struct{}, 1),
+	}
+}
+
+// Status returns the state of the sign-in, with the device code while it is pending
+func (s *SignIn) Status() apiv1.AuthStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.status
+}
+
+// Pending reports whether the sign-in has not finished
+func (s *SignIn) Pending() bool {
+	return s.Status().State == apiv1.AuthPending
+}
+
+// Prompt implements DevicePrompt, it records the device code for Status
+func (s *SignIn) Prompt(ctx context.Context, msg azidentity.DeviceCodeMessage) error {
+	s.mu.Lock()
+	s.status.UserCode, s.status.VerificationURL, s.status.Message = msg.UserCode, msg.VerificationURL, msg.Message
+	s.mu.Unlock()
+	select {
+	case s.prompted <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Finish records the outcome of the sign-in, clearing its device code
+func (s *SignIn) Finish(ctx context.Context, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status.UserCode, s.status.VerificationURL, s.status.Message = "", "", ""
+	if err != nil {
+		telemetry.Logger(ctx).Errorf("Authentication with %s failed: %v", s.status.Method, err)
+		s.status.State = apiv1.AuthFailed
+		s.status.Error = err.Error()
+		return
+	}
+	s.status.State = apiv1.AuthAuthenticated
+}
+
+// SignInWithDeviceCode signs the user of a device code credential in and hands the credential
+// to the manager, in the background as the user has minutes to enter the code; cred must relay
+// its code to signIn.Prompt. It returns once the code has been issued or the sign-in finished,
+// with ErrNoDeviceCode when neither happens within timeout. ctx bounds the sign-in, so it
+// must outlive the request starting it, and carries its audit actor.
+func (a *AuthManager) SignInWithDeviceCode(ctx context.Context, signIn *SignIn, cred azcore.TokenCredential, params *shared.AuthParams, timeout time.Duration) error {
+	result := make(chan error, 1)
+	go func() {
+		err := a.useDeviceCode(ctx, cred, params)
+		signIn.Finish(ctx, err)
+		result <- err
+	}()
+	select {
+	case <-signIn.prompted:
+		return nil
+	case err := <-result:
+		return err
+	case <-time.After(timeout):
+		return ErrNoDeviceCode
+	}
+}
+
+// useDeviceCode waits for the user to sign in before handing the credential to the manager,
+// which is locked while it acquires tokens
+func (a *AuthManager) useDeviceCode(ctx context.Context, cred azcore.TokenCredential, params *shared.AuthParams) error {
+	scope, err := Scope(GraphService, params.UsGovernment)
+	if err != nil {
+		return err
+	}
+	if _, err := cred.GetToken(ctx, policy.TokenRequestOptions{Scopes: []string{scope}}); err != nil {
+		err = fmt.Errorf("device code sign-in failed: %w", err)
+		audit.Record(ctx, audit.Event{Action: audit.ActionAuthenticate, Tenant: params.TenantID, Target: params.Identity(),
+			Details: map[string]string{"method": apiv1.DeviceCode}}, err)
+		return err
+	}
+	return a.UseCredential(ctx, cred, params)
+}
@@ -7,8 +7,10 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/arustydev/goslings/internal/auth/shared"
 	"golang.org/x/crypto/nacl/secretbox"
@@ -34,10 +36,25 @@ type Store interface {
 	// LoadM365Resources retrieves M365-specific resources from the backing store
 	LoadM365Resources(ctx context.Context) (*shared.M365Resources, error)
 
-	// Clear removes all stored credentials and parameters
+	// StoreState stores opaque state (eg. delta links or schedules) under key
+	StoreState(ctx context.Context, key string, state []byte) error
+
+	// LoadState retrieves the state stored under key, or ErrStateNotFound
+	LoadState(ctx context.Context, key string) ([]byte, error)
+
+	// Clear removes all stored credentials and parameters, and the delta links of their
+	// tenant; other state, such as API key records and sink ledgers, survives a sign-out
 	Clear(ctx context.Context) error
 }
 
+// ErrStateNotFound is returned by LoadState when nothing is stored under a key
+var ErrStateNotFound = errors.New("state not found")
+
+// DeltaPrefix returns the prefix of the state keys holding the delta links of tenant
+func DeltaPrefix(tenant string) string {
+	return "delta/" + tenant + "/"
+}
+
 // FileStore implements Store using encrypted local files
 type FileStore struct {
 	// BasePath is the directory where credential files will be stored
@@ -52,6 +69,7 @@ const (
 	CredsFileName  = "credentials.enc"
 	ParamsFileName = "params.enc"
 	M365FileName   = "m365.enc"
+	StateDirName   = "state"
 )
 
 // NewFileStore creates a new file-based credential store
@@ -269,6 +287,46 @@ func (fs *FileStore) LoadM365Resources(ctx context.Context) (*shared.M365Resourc
 	return &resources, nil
 }
 
+// statePath returns the file holding the state for key
+func (fs *FileStore) statePath(key string) string {
+	return filepath.Join(fs.BasePath, StateDirName, url.PathEscape(key)+".enc")
+}
+
+// StoreState implements Store.StoreState for FileStore
+func (fs *FileStore) StoreState(ctx context.Context, key string, state []byte) error {
+	// Encrypt the data
+	encrypted, err := fs.encrypt(state)
+	if err != nil {
+		return err
+	}
+
+	// Write to file
+	path := fs.statePath(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("failed to create directory for state: %w", err)
+	}
+	if err := os.WriteFile(path, encrypted, 0o600); err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+
+	return nil
+}
+
+// LoadState implements Store.LoadState for FileStore
+func (fs *FileStore) LoadState(ctx context.Context, key string) ([]byte, error) {
+	// Read file
+	encrypted, err := os.ReadFile(fs.statePath(key))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: %s", ErrStateNotFound, key)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state file: %w", err)
+	}
+
+	// Decrypt the data
+	return fs.decrypt(encrypted)
+}
+
 // Clear implements Store.Clear for FileStore
 func (fs *FileStore) Clear(ctx context.Context) error {
 	files := []string{
@@ -277,6 +335,21 @@ func (fs *FileStore) Clear(ctx context.Context) error {
 		filepath.Join(fs.BasePath, M365FileName),
 	}
 
+	// The delta links of the signed-out tenant must not be resumed by whoever signs in next
+	if params, err := fs.LoadParams(ctx); err == nil && params.TenantID != "" {
+		dir := filepath.Join(fs.BasePath, StateDirName)
+		entries, err := os.ReadDir(dir)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to list state files: %w", err)
+		}
+		prefix := url.PathEscape(DeltaPrefix(params.TenantID))
+		for _, entry := range entries {
+			if strings.HasPrefix(entry.Name(), prefix) {
+				files = append(files, filepath.Join(dir, entry.Name()))
+			}
+		}
+	}
+
 	var firstErr error
 	for _, file := range files {
 		if _, err := os.Stat(file); err == nil {
@@ -1 +1,119 @@
 package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/arustydev/goslings/internal/auth/shared"
+)
+
+// MemoryStore implements Store in process memory; nothing survives a restart
+type MemoryStore struct {
+	mu        sync.RWMutex
+	creds     *shared.Credentials
+	params    *shared.AuthParams
+	resources *shared.M365Resources
+	state     map[string][]byte
+}
+
+// NewMemoryStore creates a new in-memory credential store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{state: make(map[string][]byte)}
+}
+
+// StoreCredentials implements Store.StoreCredentials for MemoryStore
+func (ms *MemoryStore) StoreCredentials(ctx context.Context, creds *shared.Credentials) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.creds = creds
+	return nil
+}
+
+// LoadCredentials implements Store.LoadCredentials for MemoryStore
+func (ms *MemoryStore) LoadCredentials(ctx context.Context) (*shared.Credentials, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	if ms.creds == nil {
+		return nil, errors.New("credentials not found")
+	}
+	return ms.creds, nil
+}
+
+// StoreParams implements Store.StoreParams for MemoryStore
+func (ms *MemoryStore) StoreParams(ctx context.Context, params *shared.AuthParams) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.params = params
+	return nil
+}
+
+// LoadParams implements Store.LoadParams for MemoryStore
+func (ms *MemoryStore) LoadParams(ctx context.Context) (*shared.AuthParams, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	if ms.params == nil {
+		return nil, errors.New("parameters not found")
+	}
+	return ms.params, nil
+}
+
+// StoreM365Resources implements Store.StoreM365Resources for MemoryStore
+func (ms *MemoryStore) StoreM365Resources(
+	ctx context.Context,
+	resources *shared.M365Resources,
+) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.resources = resources
+	return nil
+}
+
+// LoadM365Resources implements Store.LoadM365Resources for MemoryStore
+func (ms *MemoryStore) LoadM365Resources(ctx context.Context) (*shared.M365Resources, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	if ms.resources == nil {
+		return nil, errors.New("M365 resources not found")
+	}
+	return ms.resources, nil
+}
+
+// StoreState implements Store.StoreState for MemoryStore
+func (ms *MemoryStore) StoreState(ctx context.Context, key string, state []byte) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.state[key] = append([]byte(nil), state...)
+	return nil
+}
+
+// LoadState implements Store.LoadState for MemoryStore
+func (ms *MemoryStore) LoadState(ctx context.Context, key string) ([]byte, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	state, ok := ms.state[key]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrStateNotFound, key)
+	}
+	return append([]byte(nil), state...), nil
+}
+
+// Clear implements Store.Clear for MemoryStore
+func (ms *MemoryStore) Clear(ctx context.Context) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if ms.params != nil && ms.params.TenantID != "" {
+		prefix := DeltaPrefix(ms.params.TenantID)
+		for key := range ms.state {
+			if strings.HasPrefix(key, prefix) {
+				delete(ms.state, key)
+			}
+		}
+	}
+	ms.creds = nil
+	ms.params = nil
+	ms.resources = nil
+	return nil
+}
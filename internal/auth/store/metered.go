@@ -0,0 +1,104 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/arustydev/goslings/internal/auth/shared"
+	"github.com/arustydev/goslings/internal/utils/telemetry"
+)
+
+// MeteredStore implements Store over another Store, recording the latency of every operation
+// in the metrics
+type MeteredStore struct {
+	Store
+
+	// Type labels the metrics, eg. file
+	Type string
+}
+
+// NewMeteredStore records the latency of the operations of st under a store type
+func NewMeteredStore(st Store, storeType string) *MeteredStore {
+	return &MeteredStore{Store: st, Type: storeType}
+}
+
+// observe records an operation started at start, a missing state is not a failure
+func (ms *MeteredStore) observe(operation string, start time.Time, err error) {
+	if errors.Is(err, ErrStateNotFound) {
+		err = nil
+	}
+	telemetry.ObserveStore(ms.Type, operation, start, err)
+}
+
+// StoreCredentials implements Store.StoreCredentials for MeteredStore
+func (ms *MeteredStore) StoreCredentials(ctx context.Context, creds *shared.Credentials) error {
+	start := time.Now()
+	err := ms.Store.StoreCredentials(ctx, creds)
+	ms.observe("store_credentials", start, err)
+	return err
+}
+
+// LoadCredentials implements Store.LoadCredentials for MeteredStore
+func (ms *MeteredStore) LoadCredentials(ctx context.Context) (*shared.Credentials, error) {
+	start := time.Now()
+	creds, err := ms.Store.LoadCredentials(ctx)
+	ms.observe("load_credentials", start, err)
+	return creds, err
+}
+
+// StoreParams implements Store.StoreParams for MeteredStore
+func (ms *MeteredStore) StoreParams(ctx context.Context, params *shared.AuthParams) error {
+	start := time.Now()
+	err := ms.Store.StoreParams(ctx, params)
+	ms.observe("store_params", start, err)
+	return err
+}
+
+// LoadParams implements Store.LoadParams for MeteredStore
+func (ms *MeteredStore) LoadParams(ctx context.Context) (*shared.AuthParams, error) {
+	start := time.Now()
+	params, err := ms.Store.LoadParams(ctx)
+	ms.observe("load_params", start, err)
+	return params, err
+}
+
+// StoreM365Resources implements Store.StoreM365Resources for MeteredStore
+func (ms *MeteredStore) StoreM365Resources(ctx context.Context, resources *shared.M365Resources) error {
+	start := time.Now()
+	err := ms.Store.StoreM365Resources(ctx, resources)
+	ms.observe("store_m365_resources", start, err)
+	return err
+}
+
+// LoadM365Resources implements Store.LoadM365Resources for MeteredStore
+func (ms *MeteredStore) LoadM365Resources(ctx context.Context) (*shared.M365Resources, error) {
+	start := time.Now()
+	resources, err := ms.Store.LoadM365Resources(ctx)
+	ms.observe("load_m365_resources", start, err)
+	return resources, err
+}
+
+// StoreState implements Store.StoreState for MeteredStore
+func (ms *MeteredStore) StoreState(ctx context.Context, key string, state []byte) error {
+	start := time.Now()
+	err := ms.Store.StoreState(ctx, key, state)
+	ms.observe("store_state", start, err)
+	return err
+}
+
+// LoadState implements Store.LoadState for MeteredStore
+func (ms *MeteredStore) LoadState(ctx context.Context, key string) ([]byte, error) {
+	start := time.Now()
+	state, err := ms.Store.LoadState(ctx, key)
+	ms.observe("load_state", start, err)
+	return state, err
+}
+
+// Clear implements Store.Clear for MeteredStore
+func (ms *MeteredStore) Clear(ctx context.Context) error {
+	start := time.Now()
+	err := ms.Store.Clear(ctx)
+	ms.observe("clear", start, err)
+	return err
+}
@@ -251,6 +251,63 @@ func (_c *MockStore_LoadParams_Call) RunAndReturn(run func(ctx context.Context)
 	return _c
 }
 
+// LoadState provides a mock function for the type MockStore
+func (_mock *MockStore) LoadState(ctx context.Context, key string) ([]byte, error) {
+	ret := _mock.Called(ctx, key)
+
+	if len(ret) == 0 {
+		panic("no return value specified for LoadState")
+	}
+
+	var r0 []byte
+	var r1 error
+	if returnFunc, ok := ret.Get(0).(func(context.Context, string) ([]byte, error)); ok {
+		return returnFunc(ctx, key)
+	}
+	if returnFunc, ok := ret.Get(0).(func(context.Context, string) []byte); ok {
+		r0 = returnFunc(ctx, key)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]byte)
+		}
+	}
+	if returnFunc, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = returnFunc(ctx, key)
+	} else {
+		r1 = ret.Error(1)
+	}
+	return r0, r1
+}
+
+// MockStore_LoadState_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'LoadState'
+type MockStore_LoadState_Call struct {
+	*mock.Call
+}
+
+// LoadState is a helper method to define mock.On call
+//   - ctx
+//   - key
+func (_e *MockStore_Expecter) LoadState(ctx interface{}, key interface{}) *MockStore_LoadState_Call {
+	return &MockStore_LoadState_Call{Call: _e.mock.On("LoadState", ctx, key)}
+}
+
+func (_c *MockStore_LoadState_Call) Run(run func(ctx context.Context, key string)) *MockStore_LoadState_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context), args[1].(string))
+	})
+	return _c
+}
+
+func (_c *MockStore_LoadState_Call) Return(bytes []byte, err error) *MockStore_LoadState_Call {
+	_c.Call.Return(bytes, err)
+	return _c
+}
+
+func (_c *MockStore_LoadState_Call) RunAndReturn(run func(ctx context.Context, key string) ([]byte, error)) *MockStore_LoadState_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
 // StoreCredentials provides a mock function for the type MockStore
 func (_mock *MockStore) StoreCredentials(ctx context.Context, creds *shared.Credentials) error {
 	ret := _mock.Called(ctx, creds)
@@ -388,3 +445,50 @@ func (_c *MockStore_StoreParams_Call) RunAndReturn(run func(ctx context.Context,
 	_c.Call.Return(run)
 	return _c
 }
+
+// StoreState provides a mock function for the type MockStore
+func (_mock *MockStore) StoreState(ctx context.Context, key string, state []byte) error {
+	ret := _mock.Called(ctx, key, state)
+
+	if len(ret) == 0 {
+		panic("no return value specified for StoreState")
+	}
+
+	var r0 error
+	if returnFunc, ok := ret.Get(0).(func(context.Context, string, []byte) error); ok {
+		r0 = returnFunc(ctx, key, state)
+	} else {
+		r0 = ret.Error(0)
+	}
+	return r0
+}
+
+// MockStore_StoreState_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'StoreState'
+type MockStore_StoreState_Call struct {
+	*mock.Call
+}
+
+// StoreState is a helper method to define mock.On call
+//   - ctx
+//   - key
+//   - state
+func (_e *MockStore_Expecter) StoreState(ctx interface{}, key interface{}, state interface{}) *MockStore_StoreState_Call {
+	return &MockStore_StoreState_Call{Call: _e.mock.On("StoreState", ctx, key, state)}
+}
+
+func (_c *MockStore_StoreState_Call) Run(run func(ctx context.Context, key string, state []byte)) *MockStore_StoreState_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context), args[1].(string), args[2].([]byte))
+	})
+	return _c
+}
+
+func (_c *MockStore_StoreState_Call) Return(err error) *MockStore_StoreState_Call {
+	_c.Call.Return(err)
+	return _c
+}
+
+func (_c *MockStore_StoreState_Call) RunAndReturn(run func(ctx context.Context, key string, state []byte) error) *MockStore_StoreState_Call {
+	_c.Call.Return(run)
+	return _c
+}
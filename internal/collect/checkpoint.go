@@ -0,0 +1,226 @@
+package collect
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/arustydev/goslings/internal/output"
+	"github.com/arustydev/goslings/pkg/apiv1"
+)
+
+// CheckpointFileName is the name of the checkpoint file kept in every run directory
+const CheckpointFileName = "checkpoint.json"
+
+// ErrCheckpointNotFound is returned when a run has no checkpoint to resume from
+var ErrCheckpointNotFound = errors.New("checkpoint not found")
+
+// Progress records how far a dataset has been collected, it is reported by the API
+type Progress = apiv1.Progress
+
+// Checkpoint records the progress of every dataset of a run
+type Checkpoint struct {
+	// RunID identifies the run, it is also the name of the run directory
+	RunID string `json:"run_id"`
+
+	// Tenant is the tenant the run collects from
+	Tenant string `json:"tenant"`
+
+	// Job is the scheduled job that started the run, if any
+	Job string `json:"job,omitempty"`
+
+	// Full is set when delta links were ignored
+	Full bool `json:"full"`
+
+	// Output is how records are written, it cannot change when a run is resumed
+	Output output.Options `json:"output"`
+
+	// Created and Updated are when the run started and the checkpoint was last saved
+	Created time.Time `json:"created"`
+	Updated time.Time `json:"updated"`
+
+	// Datasets is the progress of each dataset, in collection order
+	Datasets []*Progress `json:"datasets"`
+
+	dir string
+
+	// mu serializes saving the checkpoint and changing the progress of a running dataset
+	mu sync.Mutex
+}
+
+// NewRunID returns a sortable, unique run identifier
+func NewRunID() string {
+	suffix := make([]byte, 3)
+	_, _ = rand.Read(suffix)
+	return time.Now().UTC().Format("20060102T150405Z") + "-" + hex.EncodeToString(suffix)
+}
+
+// NewCheckpoint creates the checkpoint of a new run in <root>/<run-id>
+func NewCheckpoint(root, runID, tenant string, plan Plan) (*Checkpoint, error) {
+	if err := plan.Output.Validate(); err != nil {
+		return nil, err
+	}
+	dir := filepath.Join(root, runID)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create run directory: %w", err)
+	}
+
+	now := time.Now().UTC()
+	cp := &Checkpoint{
+		RunID:   runID,
+		Tenant:  tenant,
+		Job:     plan.Job,
+		Full:    plan.Full,
+		Output:  plan.Output,
+		Created: now,
+		Updated: now,
+		dir:     dir,
+	}
+	for _, name := range plan.Datasets {
+		cp.Datasets = append(cp.Datasets, &Progress{
+			Dataset: name,
+			Since:   plan.Since,
+			Until:   plan.Until,
+		})
+	}
+	return cp, cp.Save()
+}
+
+// LoadCheckpoint reads the checkpoint of the run in <root>/<run-id>
+func LoadCheckpoint(root, runID string) (*Checkpoint, error) {
+	dir := filepath.Join(root, runID)
+	data, err := os.ReadFile(filepath.Join(dir, CheckpointFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrCheckpointNotFound, runID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read checkpoint: %w", err)
+	}
+
+	var cp Checkpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal checkpoint: %w", err)
+	}
+	cp.dir = dir
+	return &cp, nil
+}
+
+// ListCheckpoints returns the checkpoint of every run under root, oldest first
+func ListCheckpoints(root string) ([]*Checkpoint, error) {
+	entries, err := os.ReadDir(root)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list runs: %w", err)
+	}
+
+	var cps []*Checkpoint
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		cp, err := LoadCheckpoint(root, e.Name())
+		if errors.Is(err, ErrCheckpointNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		cps = append(cps, cp)
+	}
+	// Run IDs start with their creation time
+	sort.Slice(cps, func(i, j int) bool { return cps[i].RunID < cps[j].RunID })
+	return cps, nil
+}
+
+// Dir returns the run directory
+func (cp *Checkpoint) Dir() string {
+	return cp.dir
+}
+
+// Done reports whether every dataset has been collected
+func (cp *Checkpoint) Done() bool {
+	for _, p := range cp.Datasets {
+		if !p.Done {
+			return false
+		}
+	}
+	return true
+}
+
+// Failed reports whether the run ended with datasets that failed and none that were stopped
+// or interrupted; resuming it would only retry the failures
+func (cp *Checkpoint) Failed() bool {
+	failed := false
+	for _, p := range cp.Datasets {
+		switch {
+		case p.Done:
+		case p.Error == "":
+			return false
+		default:
+			failed = true
+		}
+	}
+	return failed
+}
+
+// Save durably replaces the checkpoint file; a crash leaves either the old or new checkpoint
+func (cp *Checkpoint) Save() error {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+
+	cp.Updated = time.Now().UTC()
+	data, err := json.MarshalIndent(cp, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal checkpoint: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(cp.dir, CheckpointFileName+".*")
+	if err != nil {
+		return fmt.Errorf("failed to create checkpoint: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync checkpoint: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close checkpoint: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(cp.dir, CheckpointFileName)); err != nil {
+		return fmt.Errorf("failed to replace checkpoint: %w", err)
+	}
+	return syncDir(cp.dir)
+}
+
+// update changes the progress of a dataset while the checkpoint is not being saved
+func (cp *Checkpoint) update(fn func()) {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	fn()
+}
+
+// syncDir flushes a directory entry so a rename survives a crash
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("failed to open run directory: %w", err)
+	}
+	defer d.Close()
+	// Some platforms do not support syncing directories, the rename is still atomic there
+	_ = d.Sync()
+	return nil
+}
@@ -0,0 +1,283 @@
+// Package collect provides the collectors that pull datasets from Microsoft services
+package collect
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/arustydev/goslings/internal/auth"
+	"github.com/arustydev/goslings/internal/auth/shared"
+	"github.com/arustydev/goslings/internal/collect/odata"
+)
+
+// Common errors
+var (
+	ErrUnknownDataset = errors.New("unknown dataset")
+	ErrNoEndpoint     = errors.New("no endpoint for service")
+)
+
+// Emit receives each record produced by a collector
+type Emit func(rec json.RawMessage) error
+
+// Request contains everything a collector needs for a single run
+type Request struct {
+	// Client is the HTTP client used for every request
+	Client *http.Client
+
+	// Token provides a bearer token for the collector's service
+	Token odata.TokenFunc
+
+	// BaseURL is the service endpoint, see Endpoint
+	BaseURL string
+
+	// Since and Until bound time-windowed datasets, zero values are open ended
+	Since time.Time
+	Until time.Time
+
+	// Cursor resumes from a link previously returned in Result
+	Cursor string
+
+	// Hooks report paging progress
+	Hooks odata.Hooks
+}
+
+// Result describes where a collection stopped
+type Result struct {
+	// Cursor is the link of the next unread page, empty when complete
+	Cursor string
+
+	// DeltaLink is returned by delta queries once every page has been read
+	DeltaLink string
+
+	// Items is the number of records emitted
+	Items int64
+}
+
+// Collector pulls a single dataset from a Microsoft service
+type Collector interface {
+	// Dataset is the unique name of the dataset (eg. "entra.users")
+	Dataset() string
+
+	// Service is the service whose token the collector requires
+	Service() auth.Service
+
+	// Collect pages through the dataset, emitting every record
+	Collect(ctx context.Context, req Request, emit Emit) (Result, error)
+}
+
+// ODataCollector collects any dataset exposed as a paged Microsoft API collection
+type ODataCollector struct {
+	// Name is the dataset name
+	Name string
+
+	// Resource is the service the collection belongs to
+	Resource auth.Service
+
+	// Path is the collection path, relative to Request.BaseURL
+	Path string
+
+	// Query holds the query options for the first page
+	Query odata.Query
+
+	// TimeField, when set, filters the collection to Request.Since/Until on this property
+	TimeField string
+}
+
+// Dataset implements Collector.Dataset for ODataCollector
+func (c *ODataCollector) Dataset() string {
+	return c.Name
+}
+
+// Service implements Collector.Service for ODataCollector
+func (c *ODataCollector) Service() auth.Service {
+	return c.Resource
+}
+
+// Collect implements Collector.Collect for ODataCollector
+func (c *ODataCollector) Collect(ctx context.Context, req Request, emit Emit) (Result, error) {
+	query := c.Query
+	if c.TimeField != "" {
+		query.Filter = joinFilters(query.Filter, WindowFilter(c.TimeField, req.Since, req.Until))
+	}
+
+	pager, err := odata.NewPager(
+		strings.TrimRight(req.BaseURL, "/")+c.Path,
+		query,
+		odata.WithClient(req.Client),
+		odata.WithToken(req.Token),
+		odata.WithHooks(req.Hooks),
+		odata.WithCursor(req.Cursor),
+	)
+	if err != nil {
+		return Result{}, err
+	}
+
+	return Drain(ctx, pager, emit)
+}
+
+// Drain emits every item of the pager and reports where it stopped
+func Drain(ctx context.Context, pager *odata.Pager, emit Emit) (Result, error) {
+	var count int64
+	for item, err := range pager.Items(ctx) {
+		if err != nil {
+			return Result{Cursor: pager.NextLink(), Items: count}, err
+		}
+		if err := emit(item); err != nil {
+			return Result{Cursor: pager.NextLink(), Items: count}, err
+		}
+		count++
+	}
+	return Result{Cursor: pager.NextLink(), DeltaLink: pager.DeltaLink(), Items: count}, nil
+}
+
+// WindowFilter builds an OData filter bounding field to [since, until)
+func WindowFilter(field string, since, until time.Time) string {
+	var parts []string
+	if !since.IsZero() {
+		parts = append(parts, fmt.Sprintf("%s ge %s", field, since.UTC().Format(time.RFC3339)))
+	}
+	if !until.IsZero() {
+		parts = append(parts, fmt.Sprintf("%s lt %s", field, until.UTC().Format(time.RFC3339)))
+	}
+	return strings.Join(parts, " and ")
+}
+
+// joinFilters combines OData filters with "and", skipping empty ones
+func joinFilters(filters ...string) string {
+	var parts []string
+	for _, f := range filters {
+		if f != "" {
+			parts = append(parts, f)
+		}
+	}
+	return strings.Join(parts, " and ")
+}
+
+// TokenSource provides tokens for a service, it is implemented by *auth.AuthManager
+type TokenSource interface {
+	GetToken(service auth.Service) (*shared.Token, error)
+	RenewTokens(ctx context.Context) error
+}
+
+// TokenFor adapts a TokenSource to an odata.TokenFunc, renewing expired tokens once
+func TokenFor(src TokenSource, service auth.Service) odata.TokenFunc {
+	return func(ctx context.Context) (string, error) {
+		token, err := src.GetToken(service)
+		if errors.Is(err, auth.ErrCredentialsExpired) {
+			if err := src.RenewTokens(ctx); err != nil {
+				return "", err
+			}
+			token, err = src.GetToken(service)
+		}
+		if err != nil {
+			return "", err
+		}
+		return token.Value, nil
+	}
+}
+
+// Endpoint returns the base URL of a service for the commercial or US Government cloud
+func Endpoint(service auth.Service, usGovernment bool) (string, error) {
+	endpoints := map[auth.Service][2]string{
+		auth.GraphService: {"https://graph.microsoft.com/v1.0", "https://graph.microsoft.us/v1.0"},
+		auth.AzureService: {"https://management.azure.com", "https://management.usgovcloudapi.net"},
+		auth.M365Service:  {"https://manage.office.com/api/v1.0", "https://manage.office365.us/api/v1.0"},
+	}
+	e, ok := endpoints[service]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrNoEndpoint, service)
+	}
+	if usGovernment {
+		return e[1], nil
+	}
+	return e[0], nil
+}
+
+// registry holds every known collector, keyed by dataset name
+var registry = map[string]Collector{}
+
+// Register makes a collector available by its dataset name
+func Register(c Collector) {
+	registry[c.Dataset()] = c
+}
+
+// Lookup returns the collector for a dataset
+func Lookup(dataset string) (Collector, error) {
+	c, ok := registry[dataset]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownDataset, dataset)
+	}
+	return c, nil
+}
+
+// Datasets returns the names of every registered dataset, sorted
+func Datasets() []string {
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Family returns the family of a dataset, the part of its name before the first dot (eg. "entra")
+func Family(dataset string) string {
+	family, _, _ := strings.Cut(dataset, ".")
+	return family
+}
+
+// Select returns the registered datasets matching any include pattern, every dataset when there
+// are none, and no exclude pattern. Patterns are dataset names or globs such as "entra.*"; an
+// include pattern matching no dataset is an error.
+func Select(include, exclude []string) ([]string, error) {
+	all := Datasets()
+	if len(include) == 0 {
+		include = []string{"*"}
+	}
+
+	var names []string
+	for _, name := range all {
+		in, err := matchAny(include, name)
+		if err != nil {
+			return nil, err
+		}
+		out, err := matchAny(exclude, name)
+		if err != nil {
+			return nil, err
+		}
+		if in && !out {
+			names = append(names, name)
+		}
+	}
+	for _, pattern := range include {
+		if !slices.ContainsFunc(all, func(name string) bool {
+			ok, _ := path.Match(pattern, name)
+			return ok
+		}) {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownDataset, pattern)
+		}
+	}
+	return names, nil
+}
+
+// matchAny reports whether a dataset matches one of the patterns
+func matchAny(patterns []string, dataset string) (bool, error) {
+	for _, pattern := range patterns {
+		ok, err := path.Match(pattern, dataset)
+		if err != nil {
+			return false, fmt.Errorf("invalid dataset pattern %q: %w", pattern, err)
+		}
+		if ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
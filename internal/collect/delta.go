@@ -0,0 +1,104 @@
+package collect
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/arustydev/goslings/internal/auth/store"
+	"github.com/arustydev/goslings/internal/utils/telemetry"
+)
+
+// Incremental is implemented by collectors that can resume from a delta link
+type Incremental interface {
+	Collector
+
+	// Incremental reports whether Request.Cursor may hold a delta link
+	Incremental() bool
+}
+
+// DeltaState is the delta link persisted for a tenant's dataset
+type DeltaState struct {
+	// Link is the delta link returned by the last complete collection
+	Link string `json:"link"`
+
+	// Updated is when the link was stored
+	Updated time.Time `json:"updated"`
+}
+
+// DeltaKey returns the store key holding the delta link of a tenant's dataset
+func DeltaKey(tenant, dataset string) string {
+	return store.DeltaPrefix(tenant) + dataset
+}
+
+// LoadDelta returns the stored delta link of a tenant's dataset, empty if there is none
+func LoadDelta(ctx context.Context, st store.Store, tenant, dataset string) (string, error) {
+	data, err := st.LoadState(ctx, DeltaKey(tenant, dataset))
+	if errors.Is(err, store.ErrStateNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to load delta link: %w", err)
+	}
+
+	var state DeltaState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return "", fmt.Errorf("failed to unmarshal delta link: %w", err)
+	}
+	return state.Link, nil
+}
+
+// SaveDelta stores the delta link of a tenant's dataset
+func SaveDelta(ctx context.Context, st store.Store, tenant, dataset, link string) error {
+	data, err := json.Marshal(DeltaState{Link: link, Updated: time.Now().UTC()})
+	if err != nil {
+		return fmt.Errorf("failed to marshal delta link: %w", err)
+	}
+	if err := st.StoreState(ctx, DeltaKey(tenant, dataset), data); err != nil {
+		return fmt.Errorf("failed to store delta link: %w", err)
+	}
+	return nil
+}
+
+// CollectDelta runs an incremental collector from the tenant's stored delta link, or from a
+// baseline when full is set or no link is stored, then stores the new delta link.
+// Collectors that are not incremental are run as-is.
+func CollectDelta(
+	ctx context.Context,
+	st store.Store,
+	tenant string,
+	c Collector,
+	req Request,
+	full bool,
+	emit Emit,
+) (Result, error) {
+	inc, ok := c.(Incremental)
+	if !ok || !inc.Incremental() {
+		return c.Collect(ctx, req, emit)
+	}
+
+	if !full && req.Cursor == "" {
+		link, err := LoadDelta(ctx, st, tenant, c.Dataset())
+		if err != nil {
+			return Result{}, err
+		}
+		if link != "" {
+			telemetry.Logger(ctx).Debugf("Resuming %s from stored delta link", c.Dataset())
+		}
+		req.Cursor = link
+	}
+
+	res, err := c.Collect(ctx, req, emit)
+	if err != nil {
+		return res, err
+	}
+
+	if res.DeltaLink != "" {
+		if err := SaveDelta(ctx, st, tenant, c.Dataset(), res.DeltaLink); err != nil {
+			return res, err
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,89 @@
+package collect
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/arustydev/goslings/internal/auth/shared"
+	"github.com/arustydev/goslings/internal/auth/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCollectDeltaResumesFromStoredLink(t *testing.T) {
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("$deltatoken") == "" {
+			fmt.Fprintf(w, `{"value":[{"id":"1"},{"id":"2"}],"@odata.deltaLink":"%s/users/delta?$deltatoken=d1"}`, srv.URL)
+			return
+		}
+		fmt.Fprintf(w, `{"value":[{"id":"2","@removed":{}}],"@odata.deltaLink":"%s/users/delta?$deltatoken=d2"}`, srv.URL)
+	}))
+	defer srv.Close()
+
+	st := store.NewMemoryStore()
+	c := &DeltaCollector{Name: EntraUsers, Path: "/users/delta"}
+	req := Request{
+		Client:  srv.Client(),
+		BaseURL: srv.URL,
+		Token:   func(ctx context.Context) (string, error) { return "token", nil },
+	}
+	discard := func(json.RawMessage) error { return nil }
+
+	// The first run has no stored link and collects a baseline
+	res, err := CollectDelta(t.Context(), st, "tenant", c, req, false, discard)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), res.Items)
+
+	link, err := LoadDelta(t.Context(), st, "tenant", EntraUsers)
+	require.NoError(t, err)
+	assert.Equal(t, srv.URL+"/users/delta?$deltatoken=d1", link)
+
+	// The second run only collects the changes
+	res, err = CollectDelta(t.Context(), st, "tenant", c, req, false, discard)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), res.Items)
+
+	link, err = LoadDelta(t.Context(), st, "tenant", EntraUsers)
+	require.NoError(t, err)
+	assert.Equal(t, srv.URL+"/users/delta?$deltatoken=d2", link)
+
+	// Another tenant has its own link
+	link, err = LoadDelta(t.Context(), st, "other", EntraUsers)
+	require.NoError(t, err)
+	assert.Empty(t, link)
+
+	// A full run ignores the stored link
+	res, err = CollectDelta(t.Context(), st, "tenant", c, req, true, discard)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), res.Items)
+}
+
+func TestSignOutForgetsTenantDeltaLinks(t *testing.T) {
+	files, err := store.NewFileStore(t.TempDir(), make([]byte, 32))
+	require.NoError(t, err)
+	for name, st := range map[string]store.Store{"file": files, "memory": store.NewMemoryStore()} {
+		t.Run(name, func(t *testing.T) {
+			ctx := t.Context()
+			require.NoError(t, st.StoreParams(ctx, &shared.AuthParams{TenantID: "tenant"}))
+			require.NoError(t, SaveDelta(ctx, st, "tenant", EntraUsers, "https://graph/users/delta?$deltatoken=d1"))
+			require.NoError(t, SaveDelta(ctx, st, "other", EntraUsers, "https://graph/users/delta?$deltatoken=o1"))
+			require.NoError(t, st.StoreState(ctx, "api/keys", []byte("[]")))
+
+			require.NoError(t, st.Clear(ctx))
+
+			link, err := LoadDelta(ctx, st, "tenant", EntraUsers)
+			require.NoError(t, err)
+			assert.Empty(t, link)
+			link, err = LoadDelta(ctx, st, "other", EntraUsers)
+			require.NoError(t, err)
+			assert.NotEmpty(t, link, "only the signed-out tenant is forgotten")
+			_, err = st.LoadState(ctx, "api/keys")
+			assert.NoError(t, err)
+		})
+	}
+}
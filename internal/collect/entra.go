@@ -0,0 +1,100 @@
+package collect
+
+import (
+	"context"
+	"strings"
+
+	"github.com/arustydev/goslings/internal/auth"
+	"github.com/arustydev/goslings/internal/collect/odata"
+)
+
+// Entra ID datasets, collected from Microsoft Graph
+const (
+	EntraUsers             = "entra.users"
+	EntraGroups            = "entra.groups"
+	EntraApplications      = "entra.applications"
+	EntraServicePrincipals = "entra.service_principals"
+	EntraDirectoryRoles    = "entra.directory_roles"
+	EntraSignIns           = "entra.signins"
+	EntraDirectoryAudits   = "entra.directory_audits"
+)
+
+func init() {
+	Register(&DeltaCollector{Name: EntraUsers, Resource: auth.GraphService, Path: "/users/delta"})
+	Register(&DeltaCollector{Name: EntraGroups, Resource: auth.GraphService, Path: "/groups/delta"})
+	Register(&DeltaCollector{
+		Name:     EntraApplications,
+		Resource: auth.GraphService,
+		Path:     "/applications/delta",
+	})
+	Register(&DeltaCollector{
+		Name:     EntraServicePrincipals,
+		Resource: auth.GraphService,
+		Path:     "/servicePrincipals/delta",
+	})
+	Register(&DeltaCollector{
+		Name:     EntraDirectoryRoles,
+		Resource: auth.GraphService,
+		Path:     "/directoryRoles/delta",
+	})
+	Register(&ODataCollector{
+		Name:      EntraSignIns,
+		Resource:  auth.GraphService,
+		Path:      "/auditLogs/signIns",
+		TimeField: "createdDateTime",
+	})
+	Register(&ODataCollector{
+		Name:      EntraDirectoryAudits,
+		Resource:  auth.GraphService,
+		Path:      "/auditLogs/directoryAudits",
+		TimeField: "activityDateTime",
+	})
+}
+
+// DeltaCollector collects a Graph resource through a delta query. A Request.Cursor holding a
+// delta link from a previous run returns only the changes made since that run.
+type DeltaCollector struct {
+	// Name is the dataset name
+	Name string
+
+	// Resource is the service the collection belongs to
+	Resource auth.Service
+
+	// Path is the delta function path, relative to Request.BaseURL
+	Path string
+
+	// Query holds the query options for the baseline request
+	Query odata.Query
+}
+
+// Dataset implements Collector.Dataset for DeltaCollector
+func (c *DeltaCollector) Dataset() string {
+	return c.Name
+}
+
+// Service implements Collector.Service for DeltaCollector
+func (c *DeltaCollector) Service() auth.Service {
+	return c.Resource
+}
+
+// Incremental implements Incremental.Incremental for DeltaCollector
+func (c *DeltaCollector) Incremental() bool {
+	return true
+}
+
+// Collect implements Collector.Collect for DeltaCollector
+func (c *DeltaCollector) Collect(ctx context.Context, req Request, emit Emit) (Result, error) {
+	pager, err := odata.NewPager(
+		strings.TrimRight(req.BaseURL, "/")+c.Path,
+		c.Query,
+		odata.WithClient(req.Client),
+		odata.WithToken(req.Token),
+		odata.WithHooks(req.Hooks),
+		odata.WithCursor(req.Cursor),
+	)
+	if err != nil {
+		return Result{}, err
+	}
+
+	return Drain(ctx, pager, emit)
+}
@@ -0,0 +1,75 @@
+package collect
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/arustydev/goslings/internal/collect/odata"
+	"github.com/arustydev/goslings/internal/utils/telemetry"
+	"github.com/arustydev/goslings/pkg/apiv1"
+)
+
+// EventType names what an Event reports, the events are those of the API
+type EventType = apiv1.EventType
+
+const (
+	EventDatasetStarted   = apiv1.EventDatasetStarted
+	EventPageFetched      = apiv1.EventPageFetched
+	EventThrottled        = apiv1.EventThrottled
+	EventTokenRenewed     = apiv1.EventTokenRenewed
+	EventDatasetCompleted = apiv1.EventDatasetCompleted
+	EventDatasetFailed    = apiv1.EventDatasetFailed
+)
+
+// Event reports the progress of a run as it happens
+type Event = apiv1.Event
+
+// emit sends an event of the run to Events, if set
+func (r *Runner) emit(cp *Checkpoint, e Event) {
+	if r.Events == nil {
+		return
+	}
+	e.Time = time.Now().UTC()
+	e.RunID = cp.RunID
+	r.Events(e)
+}
+
+// hooks reports the pages and throttling of a dataset as events, once onPage has checkpointed
+// the page; a page is reported even when the run stops after it
+func (r *Runner) hooks(cp *Checkpoint, p *Progress, onPage func(odata.Page) error) odata.Hooks {
+	return odata.Hooks{
+		OnPage: func(page odata.Page) error {
+			err := onPage(page)
+			if err == nil || errors.Is(err, ErrStopped) {
+				telemetry.AddRecords(p.Dataset, page.Items)
+				r.emit(cp, Event{Type: EventPageFetched, Dataset: p.Dataset, Page: page.Number, Items: page.Items, Total: p.Items})
+			}
+			return err
+		},
+		OnThrottle: func(t odata.Throttle) {
+			r.emit(cp, Event{
+				Type:       EventThrottled,
+				Dataset:    p.Dataset,
+				StatusCode: t.StatusCode,
+				Attempt:    t.Attempt,
+				Wait:       t.Wait.String(),
+			})
+		},
+	}
+}
+
+// renewals reports the successful token renewals of a TokenSource
+type renewals struct {
+	TokenSource
+	onRenew func()
+}
+
+// RenewTokens implements TokenSource.RenewTokens for renewals
+func (r renewals) RenewTokens(ctx context.Context) error {
+	if err := r.TokenSource.RenewTokens(ctx); err != nil {
+		return err
+	}
+	r.onRenew()
+	return nil
+}
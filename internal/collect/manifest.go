@@ -0,0 +1,239 @@
+package collect
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/arustydev/goslings/internal/about"
+)
+
+// ManifestFileName is the name of the manifest file kept in every run directory
+const ManifestFileName = "manifest.json"
+
+// DatasetManifest describes what was collected for a dataset
+type DatasetManifest struct {
+	Dataset  string    `json:"dataset"`
+	Since    time.Time `json:"since,omitzero"`
+	Until    time.Time `json:"until,omitzero"`
+	Started  time.Time `json:"started,omitzero"`
+	Finished time.Time `json:"finished,omitzero"`
+	Records  int64     `json:"records"`
+	Complete bool      `json:"complete"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// FileDigest is the size and SHA-256 of an output file
+type FileDigest struct {
+	// Path is relative to the run directory
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// Manifest records what a run collected, when, from which tenant and by whom
+type Manifest struct {
+	Tool     string            `json:"tool"`
+	Version  string            `json:"version"`
+	RunID    string            `json:"run_id"`
+	Tenant   string            `json:"tenant"`
+	Identity string            `json:"identity"`
+	Host     string            `json:"host"`
+	Started  time.Time         `json:"started"`
+	Finished time.Time         `json:"finished"`
+	Config   map[string]any    `json:"config"`
+	Datasets []DatasetManifest `json:"datasets"`
+	Files    []FileDigest      `json:"files"`
+	Errors   []string          `json:"errors,omitempty"`
+}
+
+// BuildManifest describes the run of a checkpoint, hashing every file in the run directory.
+// config should already have its secrets redacted.
+func BuildManifest(cp *Checkpoint, identity string, config map[string]any) (*Manifest, error) {
+	host, _ := os.Hostname()
+	m := &Manifest{
+		Tool:     "goslings",
+		Version:  about.Version,
+		RunID:    cp.RunID,
+		Tenant:   cp.Tenant,
+		Identity: identity,
+		Host:     host,
+		Started:  cp.Created,
+		Finished: time.Now().UTC(),
+		Config:   config,
+	}
+
+	for _, p := range cp.Datasets {
+		m.Datasets = append(m.Datasets, DatasetManifest{
+			Dataset:  p.Dataset,
+			Since:    p.Since,
+			Until:    p.Until,
+			Started:  p.Started,
+			Finished: p.Finished,
+			Records:  p.Items,
+			Complete: p.Done,
+			Error:    p.Error,
+		})
+		if p.Error != "" {
+			m.Errors = append(m.Errors, fmt.Sprintf("%s: %s", p.Dataset, p.Error))
+		}
+	}
+
+	files, err := HashDir(cp.Dir())
+	if err != nil {
+		return nil, err
+	}
+	m.Files = files
+	return m, nil
+}
+
+// Write writes the manifest into dir
+func (m *Manifest) Write(dir string) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal manifest: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, ManifestFileName), data, 0o600); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	return nil
+}
+
+// WriteManifest builds and writes the manifest of a run, returning the manifest's digest;
+// the manifest cannot hash itself, so its digest should be recorded out of band
+func WriteManifest(cp *Checkpoint, identity string, config map[string]any) (FileDigest, error) {
+	m, err := BuildManifest(cp, identity, config)
+	if err != nil {
+		return FileDigest{}, err
+	}
+	if err := m.Write(cp.Dir()); err != nil {
+		return FileDigest{}, err
+	}
+	digest, err := HashFile(filepath.Join(cp.Dir(), ManifestFileName))
+	if err != nil {
+		return FileDigest{}, fmt.Errorf("failed to hash manifest: %w", err)
+	}
+	return digest, nil
+}
+
+// LoadManifest reads a manifest file
+func LoadManifest(path string) (*Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal manifest: %w", err)
+	}
+	return &m, nil
+}
+
+// HashDir returns the digest of every file under dir except the manifest, sorted by path
+func HashDir(dir string) ([]FileDigest, error) {
+	var files []FileDigest
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if rel == ManifestFileName {
+			return nil
+		}
+		digest, err := HashFile(path)
+		if err != nil {
+			return err
+		}
+		digest.Path = filepath.ToSlash(rel)
+		files = append(files, digest)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash run directory: %w", err)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	return files, nil
+}
+
+// HashFile streams a file through SHA-256
+func HashFile(path string) (FileDigest, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return FileDigest{}, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return FileDigest{}, err
+	}
+	return FileDigest{Path: path, Size: n, SHA256: hex.EncodeToString(h.Sum(nil))}, nil
+}
+
+// VerifyStatus is the outcome of verifying a single file
+type VerifyStatus string
+
+const (
+	VerifyOK         VerifyStatus = "ok"
+	VerifyModified   VerifyStatus = "modified"
+	VerifyMissing    VerifyStatus = "missing"
+	VerifyUnexpected VerifyStatus = "unexpected"
+)
+
+// VerifyResult is the outcome of verifying a file against a manifest
+type VerifyResult struct {
+	Path   string
+	Status VerifyStatus
+}
+
+// Verify re-hashes the files of the run directory holding the manifest at path. Files that
+// changed, disappeared or were added since the manifest was written are reported.
+func Verify(path string) ([]VerifyResult, error) {
+	m, err := LoadManifest(path)
+	if err != nil {
+		return nil, err
+	}
+
+	current, err := HashDir(filepath.Dir(path))
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]FileDigest, len(current))
+	for _, f := range current {
+		seen[f.Path] = f
+	}
+
+	var results []VerifyResult
+	for _, want := range m.Files {
+		got, ok := seen[want.Path]
+		delete(seen, want.Path)
+		switch {
+		case !ok:
+			results = append(results, VerifyResult{Path: want.Path, Status: VerifyMissing})
+		case got.SHA256 != want.SHA256 || got.Size != want.Size:
+			results = append(results, VerifyResult{Path: want.Path, Status: VerifyModified})
+		default:
+			results = append(results, VerifyResult{Path: want.Path, Status: VerifyOK})
+		}
+	}
+	for _, f := range current {
+		if _, ok := seen[f.Path]; ok {
+			results = append(results, VerifyResult{Path: f.Path, Status: VerifyUnexpected})
+		}
+	}
+	return results, nil
+}
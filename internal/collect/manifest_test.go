@@ -0,0 +1,46 @@
+package collect
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyDetectsTampering(t *testing.T) {
+	cp, err := NewCheckpoint(t.TempDir(), "run", "tenant", Plan{Datasets: []string{EntraUsers}})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(cp.Dir(), "entra.users.jsonl"), []byte("{}\n"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(cp.Dir(), "entra.groups.jsonl"), []byte("{}\n"), 0o600))
+
+	m, err := BuildManifest(cp, "app:client", map[string]any{"msft": map[string]any{"tenant": "tenant"}})
+	require.NoError(t, err)
+	require.NoError(t, m.Write(cp.Dir()))
+	assert.Len(t, m.Files, 3)
+
+	path := filepath.Join(cp.Dir(), ManifestFileName)
+	results, err := Verify(path)
+	require.NoError(t, err)
+	for _, r := range results {
+		assert.Equal(t, VerifyOK, r.Status, r.Path)
+	}
+
+	require.NoError(t, os.WriteFile(filepath.Join(cp.Dir(), "entra.users.jsonl"), []byte("{\"x\":1}\n"), 0o600))
+	require.NoError(t, os.Remove(filepath.Join(cp.Dir(), "entra.groups.jsonl")))
+	require.NoError(t, os.WriteFile(filepath.Join(cp.Dir(), "extra.jsonl"), nil, 0o600))
+
+	results, err = Verify(path)
+	require.NoError(t, err)
+	statuses := map[string]VerifyStatus{}
+	for _, r := range results {
+		statuses[r.Path] = r.Status
+	}
+	assert.Equal(t, map[string]VerifyStatus{
+		CheckpointFileName:   VerifyOK,
+		"entra.users.jsonl":  VerifyModified,
+		"entra.groups.jsonl": VerifyMissing,
+		"extra.jsonl":        VerifyUnexpected,
+	}, statuses)
+}
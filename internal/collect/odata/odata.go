@@ -0,0 +1,485 @@
+// Package odata provides a streaming iterator over Microsoft's paged API responses
+package odata
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/arustydev/goslings/internal/utils/telemetry"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Common errors
+var (
+	ErrNoToken         = errors.New("no token function configured")
+	ErrTooManyRetries  = errors.New("request throttled too many times")
+	ErrUnexpectedToken = errors.New("unexpected JSON token in response")
+)
+
+// Response keys and headers used by Microsoft APIs to link pages together
+const (
+	NextLinkKey     = "@odata.nextLink"  // Microsoft Graph & Defender APIs
+	DeltaLinkKey    = "@odata.deltaLink" // Microsoft Graph delta queries
+	ARMNextLinkKey  = "nextLink"         // Azure Resource Manager APIs
+	ValueKey        = "value"            // the collection of items in a page
+	NextPageURIHead = "NextPageUri"      // Office 365 Management Activity API
+)
+
+// DefaultMaxRetries is the number of times a throttled request is retried
+const DefaultMaxRetries = 5
+
+// TokenFunc returns a bearer token to attach to each request
+type TokenFunc func(ctx context.Context) (string, error)
+
+// Query holds the OData system query options sent with the first request
+type Query struct {
+	// Top is the page size requested from the server ($top)
+	Top int
+
+	// Filter restricts the items returned ($filter)
+	Filter string
+
+	// Select restricts the properties returned ($select)
+	Select []string
+
+	// OrderBy sorts the items returned ($orderby)
+	OrderBy string
+
+	// SkipToken resumes a collection from a server-issued token ($skiptoken)
+	SkipToken string
+
+	// Extra holds any other query parameters (eg. api-version)
+	Extra url.Values
+}
+
+// Values encodes the query as url.Values, ignoring unset options
+func (q Query) Values() url.Values {
+	v := url.Values{}
+	for key, vals := range q.Extra {
+		v[key] = append([]string(nil), vals...)
+	}
+	if q.Top > 0 {
+		v.Set("$top", strconv.Itoa(q.Top))
+	}
+	if q.Filter != "" {
+		v.Set("$filter", q.Filter)
+	}
+	if len(q.Select) > 0 {
+		v.Set("$select", strings.Join(q.Select, ","))
+	}
+	if q.OrderBy != "" {
+		v.Set("$orderby", q.OrderBy)
+	}
+	if q.SkipToken != "" {
+		v.Set("$skiptoken", q.SkipToken)
+	}
+	return v
+}
+
+// Page describes a single page once all of its items have been yielded
+type Page struct {
+	// Number is the 1-based index of the page within this iteration
+	Number int
+
+	// URL is the link the page was fetched from
+	URL string
+
+	// Items is the number of items in the page
+	Items int
+
+	// Total is the running number of items across all pages
+	Total int64
+
+	// NextLink is the link to the following page, empty on the last page
+	NextLink string
+
+	// DeltaLink is the delta link returned on the last page of a delta query
+	DeltaLink string
+}
+
+// Throttle describes a throttled request that is about to be retried
+type Throttle struct {
+	// URL is the link that was throttled
+	URL string
+
+	// StatusCode is the HTTP status returned by the server
+	StatusCode int
+
+	// Attempt is the 1-based retry attempt
+	Attempt int
+
+	// Wait is how long the pager will sleep before retrying
+	Wait time.Duration
+}
+
+// Hooks are optional callbacks used to report progress
+type Hooks struct {
+	// OnPage is called after every item of a page has been yielded
+	OnPage func(Page) error
+
+	// OnThrottle is called before the pager sleeps on a throttled request
+	OnThrottle func(Throttle)
+}
+
+// StatusError is returned when the server responds with an unexpected status
+type StatusError struct {
+	URL        string
+	StatusCode int
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("unexpected status %d from %s: %s", e.StatusCode, e.URL, e.Body)
+}
+
+// Pager walks a paged Microsoft API response, one item at a time
+type Pager struct {
+	// Client is the HTTP client used for every request
+	Client *http.Client
+
+	// Token provides the bearer token for every request
+	Token TokenFunc
+
+	// Header holds additional headers sent with every request
+	Header http.Header
+
+	// Hooks are the progress callbacks
+	Hooks Hooks
+
+	// MaxRetries caps the retries of a throttled request
+	MaxRetries int
+
+	start     string
+	page      int
+	total     int64
+	nextLink  string
+	deltaLink string
+}
+
+// Option configures a Pager
+type Option func(*Pager)
+
+// WithClient sets the HTTP client used by the pager
+func WithClient(c *http.Client) Option {
+	return func(p *Pager) { p.Client = c }
+}
+
+// WithToken sets the token function used by the pager
+func WithToken(t TokenFunc) Option {
+	return func(p *Pager) { p.Token = t }
+}
+
+// WithHeader adds a header sent with every request
+func WithHeader(key, value string) Option {
+	return func(p *Pager) { p.Header.Add(key, value) }
+}
+
+// WithHooks sets the progress callbacks
+func WithHooks(h Hooks) Option {
+	return func(p *Pager) { p.Hooks = h }
+}
+
+// WithCursor resumes from a link returned by NextLink or DeltaLink, ignoring the query
+func WithCursor(link string) Option {
+	return func(p *Pager) {
+		if link != "" {
+			p.start = link
+		}
+	}
+}
+
+// NewPager creates a pager for the collection at rawURL
+func NewPager(rawURL string, q Query, opts ...Option) (*Pager, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse collection url: %w", err)
+	}
+	values := u.Query()
+	for key, vals := range q.Values() {
+		values[key] = vals
+	}
+	u.RawQuery = values.Encode()
+
+	p := &Pager{
+		Client:     http.DefaultClient,
+		Header:     http.Header{},
+		MaxRetries: DefaultMaxRetries,
+		start:      u.String(),
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p, nil
+}
+
+// NextLink returns the link of the page that will be fetched next; it is a resumable cursor
+func (p *Pager) NextLink() string {
+	return p.nextLink
+}
+
+// DeltaLink returns the delta link once a delta query has been fully consumed
+func (p *Pager) DeltaLink() string {
+	return p.deltaLink
+}
+
+// Total returns the number of items yielded so far
+func (p *Pager) Total() int64 {
+	return p.total
+}
+
+// Items returns an iterator over every item of every page. Items are decoded one at a
+// time from the response body, so a page is never held in memory as a whole.
+func (p *Pager) Items(ctx context.Context) iter.Seq2[json.RawMessage, error] {
+	return func(yield func(json.RawMessage, error) bool) {
+		link := p.start
+		for link != "" {
+			next, ok := p.nextPage(ctx, link, yield)
+			if !ok {
+				return
+			}
+			link = next
+		}
+	}
+}
+
+// nextPage yields the items of the page at link and calls OnPage, in a span covering both;
+// it returns the link of the following page, ok is false once the iteration is over
+func (p *Pager) nextPage(ctx context.Context, link string, yield func(json.RawMessage, error) bool) (string, bool) {
+	ctx, span := telemetry.StartSpan(ctx, "odata.page", attribute.Int("odata.page", p.page+1))
+	p.nextLink = link
+	page, ok, err := p.fetch(ctx, link, yield)
+	if err != nil {
+		telemetry.EndSpan(span, err)
+		yield(nil, err)
+		return "", false
+	}
+	if !ok {
+		span.End()
+		return "", false
+	}
+
+	p.page++
+	page.Number = p.page
+	p.total += int64(page.Items)
+	page.Total = p.total
+	p.nextLink = page.NextLink
+	if page.DeltaLink != "" {
+		p.deltaLink = page.DeltaLink
+	}
+	span.SetAttributes(attribute.Int("odata.items", page.Items))
+
+	if p.Hooks.OnPage != nil {
+		if err := p.Hooks.OnPage(page); err != nil {
+			telemetry.EndSpan(span, err)
+			yield(nil, err)
+			return "", false
+		}
+	}
+	span.End()
+	return page.NextLink, true
+}
+
+// fetch retrieves a page and yields its items; ok is false when the consumer stopped early
+func (p *Pager) fetch(
+	ctx context.Context,
+	link string,
+	yield func(json.RawMessage, error) bool,
+) (Page, bool, error) {
+	resp, err := p.do(ctx, link)
+	if err != nil {
+		return Page{}, false, err
+	}
+	defer resp.Body.Close()
+
+	page := Page{URL: link, NextLink: resp.Header.Get(NextPageURIHead)}
+	ok, err := decodePage(json.NewDecoder(resp.Body), &page, yield)
+	return page, ok, err
+}
+
+// do sends the request, retrying throttled responses after the server's Retry-After
+func (p *Pager) do(ctx context.Context, link string) (*http.Response, error) {
+	for attempt := 1; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, link, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to build request: %w", err)
+		}
+		for key, vals := range p.Header {
+			req.Header[key] = vals
+		}
+		req.Header.Set("Accept", "application/json")
+		if p.Token == nil {
+			return nil, ErrNoToken
+		}
+		token, err := p.Token(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get token: %w", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		resp, err := p.send(req, attempt)
+		if err != nil {
+			return nil, fmt.Errorf("request to %s failed: %w", link, err)
+		}
+
+		switch {
+		case resp.StatusCode == http.StatusOK:
+			return resp, nil
+		case resp.StatusCode == http.StatusTooManyRequests ||
+			resp.StatusCode == http.StatusServiceUnavailable:
+			resp.Body.Close()
+			if attempt > p.MaxRetries {
+				return nil, fmt.Errorf("%w: %s", ErrTooManyRetries, link)
+			}
+			wait := retryAfter(resp.Header, attempt)
+			telemetry.ObserveThrottle(req.URL.Host, resp.StatusCode, wait)
+			trace.SpanFromContext(ctx).AddEvent("throttled", trace.WithAttributes(
+				semconv.HTTPResponseStatusCode(resp.StatusCode),
+				attribute.String("odata.retry_after", wait.String()),
+			))
+			if p.Hooks.OnThrottle != nil {
+				p.Hooks.OnThrottle(Throttle{
+					URL:        link,
+					StatusCode: resp.StatusCode,
+					Attempt:    attempt,
+					Wait:       wait,
+				})
+			}
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(wait):
+			}
+		default:
+			body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+			resp.Body.Close()
+			return nil, &StatusError{URL: link, StatusCode: resp.StatusCode, Body: string(body)}
+		}
+	}
+}
+
+// send sends a request in a span, counting it in the metrics
+func (p *Pager) send(req *http.Request, attempt int) (*http.Response, error) {
+	ctx, span := telemetry.StartSpan(req.Context(), "odata.request",
+		semconv.HTTPRequestMethodKey.String(req.Method),
+		semconv.ServerAddress(req.URL.Host),
+		semconv.URLPath(req.URL.Path),
+		attribute.Int("odata.attempt", attempt),
+	)
+	start := time.Now()
+	resp, err := p.Client.Do(req.WithContext(ctx))
+	if err != nil {
+		telemetry.ObserveRequest(req.URL.Host, 0, start)
+		telemetry.EndSpan(span, err)
+		return nil, err
+	}
+	telemetry.ObserveRequest(req.URL.Host, resp.StatusCode, start)
+	span.SetAttributes(semconv.HTTPResponseStatusCode(resp.StatusCode))
+	if resp.StatusCode != http.StatusOK {
+		span.SetStatus(codes.Error, http.StatusText(resp.StatusCode))
+	}
+	span.End()
+	return resp, nil
+}
+
+// retryAfter reads the Retry-After header, falling back to exponential backoff
+func retryAfter(h http.Header, attempt int) time.Duration {
+	if v := h.Get("Retry-After"); v != "" {
+		if secs, err := strconv.Atoi(v); err == nil {
+			return time.Duration(secs) * time.Second
+		}
+		if at, err := http.ParseTime(v); err == nil {
+			return time.Until(at)
+		}
+	}
+	return time.Duration(1<<min(attempt, 6)) * time.Second
+}
+
+// decodePage streams a page body; it accepts either an object with a value array or a bare array
+func decodePage(
+	dec *json.Decoder,
+	page *Page,
+	yield func(json.RawMessage, error) bool,
+) (bool, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return false, fmt.Errorf("failed to read page: %w", err)
+	}
+
+	switch tok {
+	case json.Delim('['):
+		return decodeArray(dec, page, yield)
+	case json.Delim('{'):
+	default:
+		return false, fmt.Errorf("%w: %v", ErrUnexpectedToken, tok)
+	}
+
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return false, fmt.Errorf("failed to read page: %w", err)
+		}
+		key, _ := tok.(string)
+
+		switch key {
+		case ValueKey:
+			tok, err := dec.Token()
+			if err != nil {
+				return false, fmt.Errorf("failed to read page: %w", err)
+			}
+			if tok != json.Delim('[') {
+				return false, fmt.Errorf("%w: %v", ErrUnexpectedToken, tok)
+			}
+			if ok, err := decodeArray(dec, page, yield); !ok || err != nil {
+				return ok, err
+			}
+		case NextLinkKey, ARMNextLinkKey:
+			if err := dec.Decode(&page.NextLink); err != nil {
+				return false, fmt.Errorf("failed to read next link: %w", err)
+			}
+		case DeltaLinkKey:
+			if err := dec.Decode(&page.DeltaLink); err != nil {
+				return false, fmt.Errorf("failed to read delta link: %w", err)
+			}
+		default:
+			var skip json.RawMessage
+			if err := dec.Decode(&skip); err != nil {
+				return false, fmt.Errorf("failed to read page: %w", err)
+			}
+		}
+	}
+	return true, nil
+}
+
+// decodeArray yields array elements until the closing bracket
+func decodeArray(
+	dec *json.Decoder,
+	page *Page,
+	yield func(json.RawMessage, error) bool,
+) (bool, error) {
+	for dec.More() {
+		var item json.RawMessage
+		if err := dec.Decode(&item); err != nil {
+			return false, fmt.Errorf("failed to decode item: %w", err)
+		}
+		page.Items++
+		if !yield(item, nil) {
+			return false, nil
+		}
+	}
+	if _, err := dec.Token(); err != nil {
+		return false, fmt.Errorf("failed to read page: %w", err)
+	}
+	return true, nil
+}
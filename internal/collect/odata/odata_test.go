@@ -0,0 +1,189 @@
+package odata
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+func staticToken(ctx context.Context) (string, error) {
+	return "token", nil
+}
+
+func TestPagerFollowsNextLink(t *testing.T) {
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+		switch r.URL.Query().Get("$skiptoken") {
+		case "":
+			assert.Equal(t, "2", r.URL.Query().Get("$top"))
+			assert.Equal(t, "id,displayName", r.URL.Query().Get("$select"))
+			fmt.Fprintf(w, `{"value":[{"id":"1"},{"id":"2"}],"@odata.nextLink":"%s/users?$skiptoken=p2"}`, srv.URL)
+		case "p2":
+			fmt.Fprintf(w, `{"@odata.context":"ctx","value":[{"id":"3"}],"@odata.deltaLink":"%s/users?$deltatoken=d"}`, srv.URL)
+		}
+	}))
+	defer srv.Close()
+
+	var pages []Page
+	pager, err := NewPager(srv.URL+"/users", Query{Top: 2, Select: []string{"id", "displayName"}},
+		WithToken(staticToken),
+		WithHooks(Hooks{OnPage: func(p Page) error {
+			pages = append(pages, p)
+			return nil
+		}}),
+	)
+	require.NoError(t, err)
+
+	var ids []string
+	for item, err := range pager.Items(t.Context()) {
+		require.NoError(t, err)
+		var rec struct{ ID string }
+		require.NoError(t, json.Unmarshal(item, &rec))
+		ids = append(ids, rec.ID)
+	}
+
+	assert.Equal(t, []string{"1", "2", "3"}, ids)
+	assert.Len(t, pages, 2)
+	assert.Equal(t, int64(3), pages[1].Total)
+	assert.Empty(t, pager.NextLink())
+	assert.Equal(t, srv.URL+"/users?$deltatoken=d", pager.DeltaLink())
+}
+
+func TestPagerNextPageURIHeader(t *testing.T) {
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("nextpage") == "" {
+			w.Header().Set(NextPageURIHead, srv.URL+"/content?nextpage=2")
+			fmt.Fprint(w, `[{"id":"a"}]`)
+			return
+		}
+		fmt.Fprint(w, `[{"id":"b"},{"id":"c"}]`)
+	}))
+	defer srv.Close()
+
+	pager, err := NewPager(srv.URL+"/content", Query{}, WithToken(staticToken))
+	require.NoError(t, err)
+
+	count := 0
+	for _, err := range pager.Items(t.Context()) {
+		require.NoError(t, err)
+		count++
+	}
+	assert.Equal(t, 3, count)
+	assert.Equal(t, int64(3), pager.Total())
+}
+
+func TestPagerRetriesThrottled(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		fmt.Fprint(w, `{"value":[{"id":"1"}]}`)
+	}))
+	defer srv.Close()
+
+	var throttles []Throttle
+	pager, err := NewPager(srv.URL, Query{},
+		WithToken(staticToken),
+		WithHooks(Hooks{OnThrottle: func(th Throttle) { throttles = append(throttles, th) }}),
+	)
+	require.NoError(t, err)
+
+	for _, err := range pager.Items(t.Context()) {
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 2, calls)
+	require.Len(t, throttles, 1)
+	assert.Equal(t, http.StatusTooManyRequests, throttles[0].StatusCode)
+}
+
+func TestPagerSpans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		fmt.Fprint(w, `{"value":[{"id":"1"},{"id":"2"}]}`)
+	}))
+	defer srv.Close()
+
+	pager, err := NewPager(srv.URL+"/v1.0/users", Query{}, WithToken(staticToken))
+	require.NoError(t, err)
+	for _, err := range pager.Items(t.Context()) {
+		require.NoError(t, err)
+	}
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 3)
+	throttled, served, page := spans[0], spans[1], spans[2]
+	assert.Equal(t, "odata.request", throttled.Name())
+	assert.Equal(t, "odata.request", served.Name())
+	assert.Equal(t, "odata.page", page.Name())
+	for _, s := range []sdktrace.ReadOnlySpan{throttled, served} {
+		assert.Equal(t, page.SpanContext().SpanID(), s.Parent().SpanID())
+		assert.Contains(t, s.Attributes(), semconv.URLPath("/v1.0/users"))
+	}
+	assert.Contains(t, throttled.Attributes(), semconv.HTTPResponseStatusCode(http.StatusTooManyRequests))
+	assert.Equal(t, codes.Error, throttled.Status().Code)
+	assert.Contains(t, served.Attributes(), semconv.HTTPResponseStatusCode(http.StatusOK))
+	assert.Contains(t, page.Attributes(), attribute.Int("odata.items", 2))
+	require.Len(t, page.Events(), 1)
+	assert.Equal(t, "throttled", page.Events()[0].Name)
+}
+
+func TestPagerStopsEarlyAtCursor(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"value":[{"id":"1"},{"id":"2"}],"@odata.nextLink":"never"}`)
+	}))
+	defer srv.Close()
+
+	pager, err := NewPager(srv.URL, Query{}, WithToken(staticToken))
+	require.NoError(t, err)
+
+	for range pager.Items(t.Context()) {
+		break
+	}
+	// The partially read page is still the cursor to resume from
+	assert.Equal(t, srv.URL, pager.NextLink())
+}
+
+func TestPagerStatusError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "denied", http.StatusForbidden)
+	}))
+	defer srv.Close()
+
+	pager, err := NewPager(srv.URL, Query{}, WithToken(staticToken))
+	require.NoError(t, err)
+
+	for _, err := range pager.Items(t.Context()) {
+		var se *StatusError
+		require.ErrorAs(t, err, &se)
+		assert.Equal(t, http.StatusForbidden, se.StatusCode)
+	}
+}
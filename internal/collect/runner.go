@@ -0,0 +1,293 @@
+package collect
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/arustydev/goslings/internal/audit"
+	"github.com/arustydev/goslings/internal/auth/store"
+	"github.com/arustydev/goslings/internal/collect/odata"
+	"github.com/arustydev/goslings/internal/output"
+	"github.com/arustydev/goslings/internal/utils/telemetry"
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// ErrStopped is returned when a run is stopped before every dataset was collected
+var ErrStopped = errors.New("run stopped")
+
+// Plan lists what a run collects
+type Plan struct {
+	// Job is the scheduled job the run belongs to, if any
+	Job string
+
+	// Datasets are collected in order
+	Datasets []string
+
+	// Since and Until bound time-windowed datasets
+	Since time.Time
+	Until time.Time
+
+	// Full ignores stored delta links
+	Full bool
+
+	// Output is how records are written
+	Output output.Options
+}
+
+// ParseWindow reads the time window of a plan. until is an RFC 3339 time, now when empty; since
+// is a duration before until, eg. 24h, or an RFC 3339 time, and def before until when empty.
+func ParseWindow(since, until string, now time.Time, def time.Duration) (time.Time, time.Time, error) {
+	end := now.UTC()
+	if until != "" {
+		t, err := time.Parse(time.RFC3339, until)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid until: %w", err)
+		}
+		end = t.UTC()
+	}
+	start := end.Add(-def)
+	if since != "" {
+		if d, err := time.ParseDuration(since); err == nil {
+			start = end.Add(-d)
+		} else if t, err := time.Parse(time.RFC3339, since); err == nil {
+			start = t.UTC()
+		} else {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid since %q, expected a duration or an RFC 3339 time", since)
+		}
+	}
+	if !start.Before(end) {
+		return time.Time{}, time.Time{}, errors.New("since must be before until")
+	}
+	return start, end, nil
+}
+
+// Runner collects the datasets of a run into its run directory, checkpointing after every page
+type Runner struct {
+	// Tokens provides a token for every service
+	Tokens TokenSource
+
+	// Store persists delta links
+	Store store.Store
+
+	// Client is the HTTP client used for every request
+	Client *http.Client
+
+	// USGovernment selects the US Government cloud endpoints
+	USGovernment bool
+
+	// Stop stops the run once closed, after the page being read has been written and
+	// checkpointed; unlike cancelling the context it never abandons a page
+	Stop <-chan struct{}
+
+	// Events receives the events of the run as they happen, from the goroutine collecting the
+	// dataset; it must be safe for concurrent use when Concurrency is above 1
+	Events func(Event)
+
+	// Concurrency is how many datasets are collected at once, one when zero
+	Concurrency int
+}
+
+// stopping reports whether Stop has been closed
+func (r *Runner) stopping() bool {
+	select {
+	case <-r.Stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// Run collects every dataset of the checkpoint that is not done yet, Concurrency at a time. A
+// dataset that fails is recorded in the checkpoint and the run moves on; the errors are returned
+// joined. Once stopped or cancelled no other dataset is started.
+func (r *Runner) Run(ctx context.Context, cp *Checkpoint) (err error) {
+	fields := log.Fields{telemetry.FieldRun: cp.RunID, telemetry.FieldTenant: cp.Tenant}
+	if cp.Job != "" {
+		fields[telemetry.FieldJob] = cp.Job
+	}
+	ctx = telemetry.WithFields(ctx, fields)
+	ctx, span := telemetry.StartSpan(ctx, "collect.run",
+		telemetry.Attr(telemetry.FieldRun, cp.RunID),
+		telemetry.Attr(telemetry.FieldTenant, cp.Tenant),
+		telemetry.Attr(telemetry.FieldJob, cp.Job),
+	)
+	defer func() { telemetry.EndSpan(span, err) }()
+
+	pending := 0
+	for _, p := range cp.Datasets {
+		if !p.Done {
+			pending++
+		}
+	}
+	started := audit.Event{Action: audit.ActionCollectStart, Tenant: cp.Tenant, Target: cp.RunID,
+		Details: map[string]string{"datasets": strconv.Itoa(pending)}}
+	if cp.Job != "" {
+		started.Details["job"] = cp.Job
+	}
+	audit.Record(ctx, started, nil)
+	defer func() {
+		audit.Record(ctx, audit.Event{Action: audit.ActionCollectStop, Tenant: cp.Tenant, Target: cp.RunID}, err)
+	}()
+
+	var (
+		mu   sync.Mutex
+		errs []error
+
+		// halt is the error that ends the run early
+		halt error
+		wg   sync.WaitGroup
+	)
+	halted := func(err error) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil && halt == nil {
+			halt = err
+		}
+		return halt != nil
+	}
+
+	// A dataset is started once a slot is free, so a stop is seen before starting the next one
+	slots := make(chan struct{}, max(r.Concurrency, 1))
+	for _, p := range cp.Datasets {
+		if p.Done {
+			continue
+		}
+		slots <- struct{}{}
+		if halted(ctx.Err()) {
+			break
+		}
+		if r.stopping() {
+			halted(ErrStopped)
+			break
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			ctx := telemetry.WithField(ctx, telemetry.FieldCollector, p.Dataset)
+			logger := telemetry.Logger(ctx)
+			err := r.runDataset(ctx, cp, p)
+			switch {
+			case err == nil:
+				logger.Infof("Collected %d records from %s", p.Items, p.Dataset)
+				r.emit(cp, Event{Type: EventDatasetCompleted, Dataset: p.Dataset, Total: p.Items})
+			case ctx.Err() != nil || errors.Is(err, ErrStopped):
+				halted(err)
+			default:
+				logger.Errorf("Failed to collect %s: %v", p.Dataset, err)
+				cp.update(func() { p.Error = err.Error() })
+				r.emit(cp, Event{Type: EventDatasetFailed, Dataset: p.Dataset, Total: p.Items, Error: p.Error})
+				mu.Lock()
+				errs = append(errs, fmt.Errorf("%s: %w", p.Dataset, err))
+				mu.Unlock()
+				halted(cp.Save())
+			}
+		}()
+	}
+	wg.Wait()
+
+	if halt != nil {
+		return halt
+	}
+	return errors.Join(errs...)
+}
+
+// runDataset collects a dataset from its checkpointed cursor, in a span parenting the spans of
+// its pages
+func (r *Runner) runDataset(ctx context.Context, cp *Checkpoint, p *Progress) (err error) {
+	ctx, span := telemetry.StartSpan(ctx, "collect.dataset", telemetry.Attr(telemetry.FieldCollector, p.Dataset))
+	defer func() {
+		span.SetAttributes(attribute.Int64("goslings.items", p.Items))
+		telemetry.EndSpan(span, err)
+	}()
+	c, err := Lookup(p.Dataset)
+	if err != nil {
+		return err
+	}
+	baseURL, err := Endpoint(c.Service(), r.USGovernment)
+	if err != nil {
+		return err
+	}
+
+	out, err := output.Open(cp.Dir(), p.Dataset, cp.Output, output.Position{
+		Part:   p.Part,
+		File:   p.File,
+		Offset: p.Offset,
+	})
+	if err != nil {
+		return err
+	}
+
+	cp.update(func() {
+		if p.Started.IsZero() {
+			p.Started = time.Now().UTC()
+		}
+		p.Error = ""
+	})
+	r.emit(cp, Event{Type: EventDatasetStarted, Dataset: p.Dataset, Total: p.Items})
+
+	tokens := renewals{TokenSource: r.Tokens, onRenew: func() {
+		r.emit(cp, Event{Type: EventTokenRenewed, Dataset: p.Dataset, Service: c.Service()})
+	}}
+	// Items counted from the cursor are relative to the page the checkpoint stopped at
+	base := p.Items
+	req := Request{
+		Client:  r.Client,
+		Token:   TokenFor(tokens, c.Service()),
+		BaseURL: baseURL,
+		Since:   p.Since,
+		Until:   p.Until,
+		Cursor:  p.Cursor,
+		Hooks: r.hooks(cp, p, func(page odata.Page) error {
+			pos, err := out.Checkpoint()
+			if err != nil {
+				return err
+			}
+			cp.update(func() {
+				p.Cursor = page.NextLink
+				p.Items = base + page.Total
+				p.Part = pos.Part
+				p.File = pos.File
+				p.Offset = pos.Offset
+			})
+			if err := cp.Save(); err != nil {
+				return err
+			}
+			if page.NextLink != "" && r.stopping() {
+				return ErrStopped
+			}
+			return nil
+		}),
+	}
+
+	res, err := CollectDelta(ctx, r.Store, cp.Tenant, c, req, cp.Full, out.Write)
+	if err != nil {
+		// Records after the last checkpoint are discarded when the writer is reopened
+		if aerr := out.Abandon(); aerr != nil {
+			telemetry.Logger(ctx).Debugf("Failed to close output of %s: %v", p.Dataset, aerr)
+		}
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+
+	cp.update(func() {
+		p.Cursor = ""
+		p.Items = base + res.Items
+		p.File = ""
+		p.Offset = 0
+		p.Files = out.Files()
+		p.Done = true
+		p.Finished = time.Now().UTC()
+	})
+	return cp.Save()
+}
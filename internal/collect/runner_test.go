@@ -0,0 +1,281 @@
+package collect
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/arustydev/goslings/internal/auth"
+	"github.com/arustydev/goslings/internal/auth/shared"
+	"github.com/arustydev/goslings/internal/auth/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type staticTokens struct{}
+
+func (staticTokens) GetToken(service auth.Service) (*shared.Token, error) {
+	return &shared.Token{Value: "token"}, nil
+}
+
+func (staticTokens) RenewTokens(ctx context.Context) error {
+	return nil
+}
+
+// rewriteTransport sends every request to a test server
+type rewriteTransport struct {
+	target string
+}
+
+func (t rewriteTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r.URL.Scheme = "http"
+	r.URL.Host = strings.TrimPrefix(t.target, "http://")
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+func TestRunnerResumesWithoutDuplicates(t *testing.T) {
+	var fail atomic.Bool
+	fail.Store(true)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("$skiptoken") {
+		case "":
+			fmt.Fprint(w, `{"value":[{"id":"1"},{"id":"2"}],"@odata.nextLink":"https://graph.microsoft.com/v1.0/auditLogs/signIns?$skiptoken=2"}`)
+		case "2":
+			if fail.Load() {
+				http.Error(w, "boom", http.StatusInternalServerError)
+				return
+			}
+			fmt.Fprint(w, `{"value":[{"id":"3"}]}`)
+		}
+	}))
+	defer srv.Close()
+
+	root := t.TempDir()
+	cp, err := NewCheckpoint(root, "run", "tenant", Plan{Datasets: []string{EntraSignIns}})
+	require.NoError(t, err)
+
+	runner := &Runner{
+		Tokens: staticTokens{},
+		Store:  store.NewMemoryStore(),
+		Client: &http.Client{Transport: rewriteTransport{target: srv.URL}},
+	}
+
+	// The second page fails, the first page is checkpointed
+	require.Error(t, runner.Run(t.Context(), cp))
+
+	cp, err = LoadCheckpoint(root, "run")
+	require.NoError(t, err)
+	require.Len(t, cp.Datasets, 1)
+	assert.False(t, cp.Datasets[0].Done)
+	assert.Equal(t, int64(2), cp.Datasets[0].Items)
+	assert.Contains(t, cp.Datasets[0].Cursor, "$skiptoken=2")
+
+	// Simulate a crash that left a partial record after the checkpointed offset
+	out := filepath.Join(cp.Dir(), cp.Datasets[0].File)
+	f, err := os.OpenFile(out, os.O_APPEND|os.O_WRONLY, 0o600)
+	require.NoError(t, err)
+	_, err = f.WriteString(`{"id":"partial`)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	fail.Store(false)
+	require.NoError(t, runner.Run(t.Context(), cp))
+	assert.True(t, cp.Done())
+	assert.Equal(t, int64(3), cp.Datasets[0].Items)
+
+	data, err := os.ReadFile(out)
+	require.NoError(t, err)
+	assert.Equal(t, "{\"id\":\"1\"}\n{\"id\":\"2\"}\n{\"id\":\"3\"}\n", string(data))
+}
+
+func TestRunnerStopFinishesCurrentPage(t *testing.T) {
+	stop := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("$skiptoken") {
+		case "":
+			// Stop is requested while the first page is being read
+			close(stop)
+			fmt.Fprint(w, `{"value":[{"id":"1"},{"id":"2"}],"@odata.nextLink":"https://graph.microsoft.com/v1.0/auditLogs/signIns?$skiptoken=2"}`)
+		default:
+			t.Error("the run read past the page it was stopped on")
+		}
+	}))
+	defer srv.Close()
+
+	root := t.TempDir()
+	cp, err := NewCheckpoint(root, "run", "tenant", Plan{Datasets: []string{EntraSignIns, EntraDirectoryAudits}})
+	require.NoError(t, err)
+
+	runner := &Runner{
+		Tokens: staticTokens{},
+		Store:  store.NewMemoryStore(),
+		Client: &http.Client{Transport: rewriteTransport{target: srv.URL}},
+		Stop:   stop,
+	}
+	require.ErrorIs(t, runner.Run(t.Context(), cp), ErrStopped)
+
+	cp, err = LoadCheckpoint(root, "run")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), cp.Datasets[0].Items)
+	assert.Contains(t, cp.Datasets[0].Cursor, "$skiptoken=2")
+	assert.Empty(t, cp.Datasets[0].Error, "a stop is not a failure")
+	assert.True(t, cp.Datasets[1].Started.IsZero())
+}
+
+// expiringTokens reports the first token as expired until it is renewed
+type expiringTokens struct {
+	renewed atomic.Bool
+}
+
+func (e *expiringTokens) GetToken(service auth.Service) (*shared.Token, error) {
+	if !e.renewed.Load() {
+		return nil, auth.ErrCredentialsExpired
+	}
+	return &shared.Token{Value: "token"}, nil
+}
+
+func (e *expiringTokens) RenewTokens(ctx context.Context) error {
+	e.renewed.Store(true)
+	return nil
+}
+
+func TestRunnerReportsEvents(t *testing.T) {
+	var throttled atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("$skiptoken") {
+		case "":
+			fmt.Fprint(w, `{"value":[{"id":"1"},{"id":"2"}],"@odata.nextLink":"https://graph.microsoft.com/v1.0/auditLogs/signIns?$skiptoken=2"}`)
+		case "2":
+			if !throttled.Swap(true) {
+				w.Header().Set("Retry-After", "0")
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+			fmt.Fprint(w, `{"value":[{"id":"3"}]}`)
+		}
+	}))
+	defer srv.Close()
+
+	cp, err := NewCheckpoint(t.TempDir(), "run", "tenant", Plan{Datasets: []string{EntraSignIns}})
+	require.NoError(t, err)
+	var events []Event
+	runner := &Runner{
+		Tokens: &expiringTokens{},
+		Store:  store.NewMemoryStore(),
+		Client: &http.Client{Transport: rewriteTransport{target: srv.URL}},
+		Events: func(e Event) { events = append(events, e) },
+	}
+	require.NoError(t, runner.Run(t.Context(), cp))
+
+	var types []EventType
+	for _, e := range events {
+		assert.Equal(t, "run", e.RunID)
+		assert.Equal(t, EntraSignIns, e.Dataset)
+		types = append(types, e.Type)
+	}
+	assert.Equal(t, []EventType{
+		EventDatasetStarted,
+		EventTokenRenewed,
+		EventPageFetched,
+		EventThrottled,
+		EventPageFetched,
+		EventDatasetCompleted,
+	}, types)
+	assert.Equal(t, auth.GraphService, events[1].Service)
+	assert.Equal(t, Event{Type: EventPageFetched, Time: events[2].Time, RunID: "run", Dataset: EntraSignIns, Page: 1, Items: 2, Total: 2}, events[2])
+	assert.Equal(t, http.StatusTooManyRequests, events[3].StatusCode)
+	assert.Equal(t, "0s", events[3].Wait)
+	assert.Equal(t, int64(3), events[4].Total)
+	assert.Equal(t, int64(3), events[5].Total)
+}
+
+func TestRunnerCollectsConcurrently(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "directoryAudits") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		// The first dataset is only read once the second one has failed
+		select {
+		case <-release:
+		case <-time.After(5 * time.Second):
+			t.Error("the datasets were collected one at a time")
+		}
+		fmt.Fprint(w, `{"value":[{"id":"1"},{"id":"2"}]}`)
+	}))
+	defer srv.Close()
+
+	root := t.TempDir()
+	cp, err := NewCheckpoint(root, "run", "tenant", Plan{Datasets: []string{EntraSignIns, EntraDirectoryAudits}})
+	require.NoError(t, err)
+	runner := &Runner{
+		Tokens:      staticTokens{},
+		Store:       store.NewMemoryStore(),
+		Client:      &http.Client{Transport: rewriteTransport{target: srv.URL}},
+		Concurrency: 2,
+		Events: func(e Event) {
+			if e.Type == EventDatasetFailed {
+				close(release)
+			}
+		},
+	}
+	err = runner.Run(t.Context(), cp)
+	require.ErrorContains(t, err, EntraDirectoryAudits)
+
+	s := Summarize(cp)
+	assert.Equal(t, int64(2), s.Records)
+	assert.Equal(t, 1, s.Failed)
+	assert.Equal(t, StatusCompleted, s.Datasets[0].Status)
+	assert.Equal(t, "graph", s.Datasets[0].Service)
+	assert.Equal(t, StatusFailed, s.Datasets[1].Status)
+	assert.NotEmpty(t, s.Datasets[1].Error)
+}
+
+func TestParseWindow(t *testing.T) {
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+
+	since, until, err := ParseWindow("", "", now, 24*time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, now.Add(-24*time.Hour), since)
+	assert.Equal(t, now, until)
+
+	since, until, err = ParseWindow("2h", "2026-10-01T00:00:00Z", now, 24*time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2026, 9, 30, 22, 0, 0, 0, time.UTC), since)
+	assert.Equal(t, time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC), until)
+
+	since, _, err = ParseWindow("2026-10-17T00:00:00+02:00", "", now, 24*time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2026, 10, 16, 22, 0, 0, 0, time.UTC), since)
+
+	_, _, err = ParseWindow("yesterday", "", now, 24*time.Hour)
+	require.Error(t, err)
+	_, _, err = ParseWindow("2026-10-19T00:00:00Z", "", now, 24*time.Hour)
+	require.Error(t, err)
+}
+
+func TestSelectMatchesGlobs(t *testing.T) {
+	names, err := Select([]string{"entra.*"}, []string{EntraSignIns, "entra.directory_*"})
+	require.NoError(t, err)
+	assert.Contains(t, names, EntraUsers)
+	assert.NotContains(t, names, EntraSignIns)
+	assert.NotContains(t, names, EntraDirectoryAudits)
+	assert.NotContains(t, names, EntraDirectoryRoles)
+
+	names, err = Select(nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, Datasets(), names)
+
+	_, err = Select([]string{"azure.*"}, nil)
+	require.ErrorIs(t, err, ErrUnknownDataset)
+	_, err = Select(nil, []string{"["})
+	require.Error(t, err)
+}
@@ -0,0 +1,97 @@
+package collect
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// SummaryFileName is the name of the summary report kept in the run directory of a honk run
+const SummaryFileName = "summary.json"
+
+// Status of a dataset in a summary
+const (
+	StatusCompleted = "completed"
+	StatusFailed    = "failed"
+	StatusPending   = "pending"
+)
+
+// DatasetSummary reports how the collection of a dataset went
+type DatasetSummary struct {
+	Dataset string `json:"dataset"`
+	Service string `json:"service,omitempty"`
+	Status  string `json:"status"`
+	Records int64  `json:"records"`
+	Files   int    `json:"files"`
+
+	// Duration is how long the dataset took to collect, zero until it is done
+	Duration time.Duration `json:"duration_ns"`
+
+	Error string `json:"error,omitempty"`
+}
+
+// Summary reports the outcome of a run for the people investigating it
+type Summary struct {
+	RunID    string           `json:"run_id"`
+	Tenant   string           `json:"tenant"`
+	Since    time.Time        `json:"since,omitzero"`
+	Until    time.Time        `json:"until,omitzero"`
+	Started  time.Time        `json:"started"`
+	Finished time.Time        `json:"finished"`
+	Records  int64            `json:"records"`
+	Failed   int              `json:"failed"`
+	Pending  int              `json:"pending"`
+	Datasets []DatasetSummary `json:"datasets"`
+}
+
+// Summarize reports the outcome of the run of a checkpoint
+func Summarize(cp *Checkpoint) *Summary {
+	s := &Summary{RunID: cp.RunID, Tenant: cp.Tenant, Started: cp.Created, Finished: cp.Updated}
+	for _, p := range cp.Datasets {
+		d := DatasetSummary{
+			Dataset: p.Dataset,
+			Status:  StatusPending,
+			Records: p.Items,
+			Files:   len(p.Files),
+			Error:   p.Error,
+		}
+		if c, err := Lookup(p.Dataset); err == nil {
+			d.Service = string(c.Service())
+		}
+		switch {
+		case p.Done:
+			d.Status = StatusCompleted
+			d.Duration = p.Finished.Sub(p.Started)
+		case p.Error != "":
+			d.Status = StatusFailed
+			s.Failed++
+		default:
+			s.Pending++
+		}
+		if s.Since.IsZero() || p.Since.Before(s.Since) {
+			s.Since = p.Since
+		}
+		if p.Until.After(s.Until) {
+			s.Until = p.Until
+		}
+		s.Records += p.Items
+		s.Datasets = append(s.Datasets, d)
+	}
+	return s
+}
+
+// WriteSummary writes the summary of a run into its run directory; it is written before the
+// manifest so the manifest hashes it
+func WriteSummary(cp *Checkpoint) (*Summary, error) {
+	s := Summarize(cp)
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal summary: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(cp.Dir(), SummaryFileName), data, 0o600); err != nil {
+		return nil, fmt.Errorf("failed to write summary: %w", err)
+	}
+	return s, nil
+}
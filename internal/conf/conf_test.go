@@ -0,0 +1,540 @@
+package conf
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/arustydev/goslings/internal/auth/shared"
+	"github.com/arustydev/goslings/internal/utils/telemetry"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+const tenant = "72f988bf-86f1-41af-91ab-2d7cd011db47"
+
+// useConfig replaces the global configuration with a YAML document
+func useConfig(t *testing.T, doc string) {
+	t.Helper()
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	viper.SetConfigType("yaml")
+	require.NoError(t, viper.ReadConfig(strings.NewReader(doc)))
+}
+
+func TestTemplateSetsOnlyGivenValues(t *testing.T) {
+	var empty map[string]any
+	require.NoError(t, yaml.Unmarshal(Template(nil), &empty))
+	assert.Empty(t, empty, "every key is commented out")
+
+	data := Template(map[string]any{"msft.tenant": tenant, "honk.include": []string{"entra.*"}})
+	var doc map[string]any
+	require.NoError(t, yaml.Unmarshal(data, &doc))
+	assert.Equal(t, map[string]any{
+		"msft": map[string]any{"tenant": tenant},
+		"honk": map[string]any{"include": []any{"entra.*"}},
+	}, doc)
+	assert.Contains(t, string(data), "# Tenant (directory) ID to investigate\n")
+	assert.Contains(t, string(data), "  # type: file\n")
+}
+
+func TestSetKeepsComments(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "configs", "brood.yaml")
+	require.NoError(t, Set(path, "msft.tenant", tenant), "a missing file is created")
+
+	require.NoError(t, os.WriteFile(path, []byte(`# Investigation of contoso
+msft:
+  # The tenant
+  tenant: 00000000-0000-0000-0000-000000000000 # from the ticket
+  usgov:
+    cloud: false
+api:
+honk:
+  include:
+    - entra.users
+`), 0o600))
+	require.NoError(t, Set(path, "msft.tenant", tenant))
+	require.NoError(t, Set(path, "msft.usgov.exo", "true"))
+	require.NoError(t, Set(path, "honk.include", "entra.*, entra.users"))
+	require.NoError(t, Set(path, "api.tls.cert", "server.pem"))
+	require.NoError(t, Set(path, "output.format", "csv"))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, `# Investigation of contoso
+msft:
+  # The tenant
+  tenant: 72f988bf-86f1-41af-91ab-2d7cd011db47 # from the ticket
+  usgov:
+    cloud: false
+    exo: true
+api:
+  tls:
+    cert: server.pem
+honk:
+  include: [entra.*, entra.users]
+output:
+  format: csv
+`, string(data))
+
+	require.ErrorIs(t, Set(path, "msft.nope", "1"), ErrUnknownKey)
+	require.Error(t, Set(path, "msft.tenant", "contoso"), "not a GUID")
+	require.Error(t, Set(path, "honk.concurrency", "many"))
+	require.ErrorIs(t, Set(path, "msft.tenant.id", "1"), ErrUnknownKey)
+}
+
+func TestValidateReportsProblems(t *testing.T) {
+	useConfig(t, `
+msft:
+  tenant: contoso
+auth:
+  app:
+    id: `+tenant+`
+outptu:
+  format: csv
+output:
+  format: xml
+headless:
+  since: soon
+  auth: secret
+remote:
+  api_key: key
+  token: token
+honk:
+  concurrency: 0
+sinks:
+  - type: carrier-pigeon
+trace:
+  exporter: file
+audit:
+  sinks: [siem]
+`)
+	var keys []string
+	for _, p := range Validate() {
+		keys = append(keys, p.Key)
+	}
+	assert.ElementsMatch(t, []string{
+		"outptu.format",
+		"msft.tenant",
+		"output.format",
+		"headless.since",
+		"headless.auth",
+		"remote",
+		"honk.concurrency",
+		"output",
+		"sinks",
+		"trace",
+		"audit",
+		"audit.sinks",
+	}, keys)
+
+	useConfig(t, `
+msft:
+  tenant: `+tenant+`
+api:
+  auth:
+    mtls:
+      roles:
+        alice: admin
+schedule:
+  jobs:
+    - name: hourly
+      schedule: "@hourly"
+`)
+	assert.Empty(t, Validate())
+}
+
+// useLayers isolates LoadConfig from the machine's config files
+func useLayers(t *testing.T) string {
+	t.Helper()
+	viper.Reset()
+	flags = map[string]*pflag.Flag{}
+	t.Cleanup(func() {
+		viper.Reset()
+		loaded = config{refs: map[string]string{}}
+		flags = map[string]*pflag.Flag{}
+	})
+	dir := t.TempDir()
+	system := systemConfigDir
+	systemConfigDir = filepath.Join(dir, "etc")
+	t.Cleanup(func() { systemConfigDir = system })
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(dir, "xdg"))
+	t.Setenv(ConfigEnv, "")
+	t.Chdir(dir)
+	return dir
+}
+
+// writeConfig writes a config file, creating its directory
+func writeConfig(t *testing.T, path, doc string) {
+	t.Helper()
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o700))
+	require.NoError(t, os.WriteFile(path, []byte(doc), 0o600))
+}
+
+func TestLoadConfigWithoutFile(t *testing.T) {
+	useLayers(t)
+	t.Setenv(EnvVar("store.type"), "memory")
+	t.Setenv("GOSLING_APP_SECRET", "s3cret")
+
+	require.NoError(t, LoadConfig(""))
+	assert.Empty(t, Layers())
+	sources := map[string]Setting{}
+	for _, s := range Settings() {
+		sources[s.Key] = s
+	}
+	assert.Equal(t, Setting{Key: "store.type", Value: "memory", Source: SourceEnv, Origin: "GOSLING_STORE_TYPE"}, sources["store.type"])
+	assert.Equal(t, Setting{Key: "auth.app.secret", Value: Redacted, Source: SourceEnv, Origin: "GOSLING_APP_SECRET"}, sources["auth.app.secret"])
+	assert.Equal(t, Setting{Key: "output.format", Value: "jsonl", Source: SourceDefault}, sources["output.format"])
+	assert.Equal(t, "s3cret", GetAuthConfig().ClientSecret)
+
+	require.Error(t, LoadConfig("missing.yaml"), "a --config file must exist")
+	writeConfig(t, DefaultConfigFile, "msft: [\n")
+	require.Error(t, LoadConfig(""), "an unreadable file is an error")
+}
+
+func TestLoadConfigLayers(t *testing.T) {
+	dir := useLayers(t)
+	writeConfig(t, filepath.Join(dir, "etc", "brood.yaml"), "store:\n  type: memory\noutput:\n  format: csv\n  compression: gzip\nhonk:\n  concurrency: 2\n")
+	writeConfig(t, filepath.Join(dir, "xdg", "goslings", "brood.yaml"), "output:\n  format: parquet\nhonk:\n  concurrency: 3\n")
+	writeConfig(t, DefaultConfigFile, "honk:\n  concurrency: 4\n  out: ./runs\n")
+	writeConfig(t, "brood.yaml", "honk:\n  concurrency: 5\n")
+	writeConfig(t, filepath.Join(dir, "case.yaml"), "honk:\n  concurrency: 6\n  since: 24h\n")
+	t.Setenv(EnvVar("honk.since"), "48h")
+	t.Setenv("GOSLING_TENANT", tenant)
+
+	fs := pflag.NewFlagSet("gosling", pflag.ContinueOnError)
+	AddFlags(fs)
+	require.NoError(t, fs.Parse([]string{"--usgov"}))
+	require.NoError(t, LoadConfig(filepath.Join(dir, "case.yaml")))
+
+	var sources []Source
+	for _, l := range Layers() {
+		sources = append(sources, l.Source)
+	}
+	assert.Equal(t, []Source{SourceSystem, SourceUser, SourceProject, SourceConfig}, sources, "./brood.yaml is the project file over ./configs")
+	assert.Equal(t, filepath.Join(dir, "case.yaml"), ConfigFile())
+
+	settings := map[string]Setting{}
+	for _, s := range Settings() {
+		settings[s.Key] = s
+	}
+	for key, want := range map[string]Setting{
+		"store.type":         {Value: "memory", Source: SourceSystem},
+		"output.compression": {Value: "gzip", Source: SourceSystem},
+		"output.format":      {Value: "parquet", Source: SourceUser},
+		"honk.concurrency":   {Value: 6, Source: SourceConfig},
+		"honk.since":         {Value: "48h", Source: SourceEnv},
+		"msft.tenant":        {Value: tenant, Source: SourceEnv},
+		"msft.usgov.cloud":   {Value: true, Source: SourceFlag},
+	} {
+		assert.Equal(t, want.Source, settings[key].Source, key)
+		assert.EqualValues(t, want.Value, settings[key].Value, key)
+	}
+	assert.NotContains(t, settings, "honk.out", "./configs/brood.yaml is not read when ./brood.yaml exists")
+	assert.Equal(t, "--usgov", settings["msft.usgov.cloud"].Origin)
+
+	params := GetAuthConfig()
+	assert.Equal(t, tenant, params.TenantID)
+	assert.True(t, params.UsGovernment)
+}
+
+func TestProfiles(t *testing.T) {
+	useLayers(t)
+	writeConfig(t, DefaultConfigFile, `
+profile: contoso
+msft:
+  tenant: 00000000-0000-0000-0000-000000000000
+  subscription: 22222222-2222-2222-2222-222222222222
+store:
+  path: ./creds
+profiles:
+  contoso:
+    tenant: `+tenant+`
+    usgov: true
+    collectors: [entra]
+  fabrikam:
+    tenant: 11111111-1111-1111-1111-111111111111
+    store_path: /var/lib/fabrikam
+`)
+	require.NoError(t, LoadConfig(""))
+	assert.Equal(t, []string{"contoso", "fabrikam"}, Profiles())
+	assert.Equal(t, "contoso", ActiveProfile())
+	params := GetAuthConfig()
+	assert.Equal(t, tenant, params.TenantID)
+	assert.Equal(t, "22222222-2222-2222-2222-222222222222", params.SubscriptionID, "keys the profile leaves alone are kept")
+	assert.True(t, params.UsGovernment)
+	assert.Equal(t, []string{"entra"}, viper.GetStringSlice("honk.collectors"))
+	assert.Equal(t, filepath.Join("creds", "profiles", "contoso"), viper.GetString("store.path"), "every profile has its own store")
+	assert.Equal(t, "/var/lib/fabrikam", ProfileStorePath("fabrikam"))
+	assert.Empty(t, Validate())
+
+	settings := map[string]Setting{}
+	for _, s := range Settings() {
+		settings[s.Key] = s
+	}
+	assert.Equal(t, Setting{Key: "msft.tenant", Value: tenant, Source: SourceProfile, Origin: "profiles.contoso"}, settings["msft.tenant"])
+
+	// The environment overrides the profile, and selects another one
+	viper.Reset()
+	t.Setenv("GOSLING_PROFILE", "fabrikam")
+	t.Setenv("GOSLING_USGOV_CLOUD", "true")
+	require.NoError(t, LoadConfig(""))
+	params = GetAuthConfig()
+	assert.Equal(t, "11111111-1111-1111-1111-111111111111", params.TenantID)
+	assert.True(t, params.UsGovernment)
+	assert.Equal(t, "/var/lib/fabrikam", viper.GetString("store.path"))
+
+	viper.Reset()
+	t.Setenv("GOSLING_PROFILE", "northwind")
+	require.ErrorIs(t, LoadConfig(""), ErrUnknownProfile)
+}
+
+func TestUnsetKeepsOtherLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "brood.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`profile: contoso
+profiles:
+  # Contoso, case 42
+  contoso:
+    tenant: `+tenant+`
+    collectors:
+      - entra
+  fabrikam:
+    tenant: 11111111-1111-1111-1111-111111111111
+`), 0o600))
+	require.NoError(t, Unset(path, "profiles.contoso"))
+	require.NoError(t, Unset(path, "profile"))
+	require.ErrorIs(t, Unset(path, "profiles.contoso"), ErrNotSet)
+	require.ErrorIs(t, Unset(path, "profiles.fabrikam.tenant.id"), ErrNotSet)
+	require.NoError(t, Set(path, "profiles.fabrikam.usgov", "true"))
+	require.Error(t, Set(path, "profiles.fabrikam.tenant", "fabrikam"), "not a GUID")
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, `profiles:
+  fabrikam:
+    tenant: 11111111-1111-1111-1111-111111111111
+    usgov: true
+`, string(data))
+}
+
+func TestSetReplacesMultiLineValues(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "brood.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`auth:
+  app:
+    secret: |
+      line1
+
+      line2
+    id: `+tenant+`
+  simple:
+    pass: >-
+      folded
+      lines
+
+honk:
+  include: [entra.users,
+    entra.groups]
+  exclude:
+    [entra.devices,
+     entra.apps]
+# The end
+`), 0o600))
+	require.NoError(t, Set(path, "auth.app.secret", "s3cret"))
+	require.NoError(t, Set(path, "auth.simple.user", "gosling"))
+	require.NoError(t, Set(path, "honk.include", "entra.*"))
+	require.NoError(t, Unset(path, "honk.exclude"))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, `auth:
+  app:
+    secret: s3cret
+    id: `+tenant+`
+  simple:
+    pass: >-
+      folded
+      lines
+    user: gosling
+
+honk:
+  include: [entra.*]
+# The end
+`, string(data))
+}
+
+func TestSecretReferences(t *testing.T) {
+	dir := useLayers(t)
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "root" || r.URL.Path != "/v1/secret/data/goslings" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(`{"data":{"data":{"client_secret":"from-vault"}}}`))
+	}))
+	defer vault.Close()
+	k8s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer sa-token" || r.URL.Path != "/api/v1/namespaces/ir/secrets/splunk" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(`{"data":{"token":"ZnJvbS1rOHM="}}`))
+	}))
+	defer k8s.Close()
+	writeConfig(t, filepath.Join(dir, "sa-token"), "sa-token\n")
+	writeConfig(t, filepath.Join(dir, "api-key"), "from-file\n")
+	for scheme, r := range map[string]Resolver{
+		"vault": &VaultResolver{Address: vault.URL, Token: "root"},
+		"k8s":   &K8sResolver{Server: k8s.URL, TokenFile: filepath.Join(dir, "sa-token"), Client: k8s.Client()},
+	} {
+		previous := resolvers[scheme]
+		RegisterResolver(scheme, r)
+		t.Cleanup(func() { RegisterResolver(scheme, previous) })
+	}
+	t.Setenv("CASE_PASS", "from-env")
+	t.Setenv("CASE_TENANT", "not-a-guid")
+
+	writeConfig(t, DefaultConfigFile, `
+msft:
+  tenant: env://CASE_TENANT
+auth:
+  app:
+    secret: vault://secret/data/goslings#client_secret
+  simple:
+    user: responder
+    pass: env://CASE_PASS
+remote:
+  url: https://goslings.example.com
+  api_key: file://`+filepath.Join(dir, "api-key")+`
+sinks:
+  - type: splunk
+    url: https://splunk.example.com
+    token: k8s://ir/splunk#token
+profiles:
+  contoso:
+    client_secret: vault://secret/data/contoso#client_secret
+`)
+	require.NoError(t, LoadConfig(""))
+	params := GetAuthConfig()
+	assert.Equal(t, "from-vault", params.ClientSecret)
+	assert.Equal(t, "from-env", params.Password)
+	assert.Equal(t, "not-a-guid", params.TenantID)
+	assert.Equal(t, "from-file", GetRemoteOptions().APIKey)
+	assert.Equal(t, "https://goslings.example.com", GetRemoteOptions().URL, "other schemes are not references")
+	sinks, err := GetSinkConfigs()
+	require.NoError(t, err)
+	assert.Equal(t, "from-k8s", sinks[0].Token)
+
+	// The references are shown, never what they resolve to
+	settings := map[string]any{}
+	for _, s := range Settings() {
+		settings[s.Key] = s.Value
+	}
+	assert.Equal(t, "vault://secret/data/goslings#client_secret", settings["auth.app.secret"])
+	assert.Equal(t, "env://CASE_TENANT", settings["msft.tenant"])
+	assert.Equal(t, "k8s://ir/splunk#token", settings["sinks"].([]any)[0].(map[string]any)["token"])
+	problems := Validate()
+	require.Len(t, problems, 1)
+	assert.Equal(t, Problem{Key: "msft.tenant", Message: "env://CASE_TENANT does not resolve to a valid value"}, problems[0])
+
+	// Only the active profile is resolved
+	viper.Reset()
+	t.Setenv("GOSLING_PROFILE", "contoso")
+	err = LoadConfig("")
+	require.ErrorIs(t, err, ErrSecretNotFound)
+	assert.Contains(t, err.Error(), "vault://secret/data/contoso#client_secret")
+	assert.NotContains(t, err.Error(), "from-")
+}
+
+func TestAuthParamsAreBound(t *testing.T) {
+	envs := map[string]Key{}
+	for _, k := range Keys {
+		if k.Env != "" {
+			assert.NotEmpty(t, k.Flag, k.Name)
+			envs[k.Env] = k
+		}
+	}
+	fields := reflect.TypeFor[shared.AuthParams]()
+	for i := range fields.NumField() {
+		tag := fields.Field(i).Tag.Get("mapstructure")
+		assert.Contains(t, envs, tag, "%s has no key", fields.Field(i).Name)
+	}
+}
+
+func TestReload(t *testing.T) {
+	useLayers(t)
+	base := "msft:\n  tenant: " + tenant + "\nstore:\n  type: memory\n"
+	writeConfig(t, DefaultConfigFile, base+"log:\n  level: info\n")
+	require.NoError(t, LoadConfig(""))
+
+	var applied []Change
+	apply := func(changes []Change) error {
+		applied = changes
+		return nil
+	}
+	changes, err := Reload(apply)
+	require.NoError(t, err)
+	assert.Empty(t, changes, "nothing changed")
+	assert.Nil(t, applied, "apply is not called without changes")
+
+	writeConfig(t, DefaultConfigFile, base+"log:\n  level: debug\nauth:\n  app:\n    secret: env://RELOAD_SECRET\n")
+	t.Setenv("RELOAD_SECRET", "s3cret")
+	_, err = Reload(apply)
+	require.ErrorIs(t, err, ErrRestartRequired)
+	assert.Equal(t, "info", viper.GetString("log.level"), "a rejected reload keeps the running configuration")
+	assert.Empty(t, viper.GetString("auth.app.secret"))
+
+	writeConfig(t, DefaultConfigFile, base+"log:\n  level: debug\nsinks:\n  - name: siem\n    type: syslog\n    address: localhost:514\n")
+	changes, err = Reload(apply)
+	require.NoError(t, err)
+	assert.Equal(t, changes, applied)
+	require.Len(t, changes, 2)
+	assert.Equal(t, "sinks", changes[0].Key)
+	assert.Equal(t, "log.level: info -> debug", changes[1].String())
+	opts, err := GetLogOptions(telemetry.FormatJSON)
+	require.NoError(t, err)
+	assert.Equal(t, telemetry.LogOptions{Level: "debug", Format: telemetry.FormatJSON, MaxBackups: telemetry.DefaultMaxBackups}, opts)
+	sinks, err := GetSinkConfigs()
+	require.NoError(t, err)
+	assert.Len(t, sinks, 1)
+
+	writeConfig(t, DefaultConfigFile, base+"log:\n  level: loud\n")
+	_, err = Reload(apply)
+	require.ErrorIs(t, err, ErrInvalidConfig)
+	assert.Equal(t, "debug", viper.GetString("log.level"))
+
+	writeConfig(t, DefaultConfigFile, base+"log:\n  level: debug\n")
+	_, err = Reload(func([]Change) error { return errors.New("refused") })
+	require.EqualError(t, err, "refused")
+	sinks, err = GetSinkConfigs()
+	require.NoError(t, err)
+	assert.Len(t, sinks, 1, "a reload apply refuses is rolled back")
+}
+
+func TestWatchReloadsChangedFiles(t *testing.T) {
+	useLayers(t)
+	writeConfig(t, DefaultConfigFile, "store:\n  type: memory\nheadless:\n  interval: 1h\n")
+	require.NoError(t, LoadConfig(""))
+
+	applied := make(chan []Change, 1)
+	require.NoError(t, Watch(t.Context(), func(changes []Change) error {
+		applied <- changes
+		return nil
+	}))
+	writeConfig(t, DefaultConfigFile, "store:\n  type: memory\nheadless:\n  interval: 2h\n")
+	select {
+	case changes := <-applied:
+		require.Len(t, changes, 1)
+		assert.Equal(t, "headless.interval", changes[0].Key)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the changed file was not reloaded")
+	}
+}
@@ -0,0 +1,326 @@
+package conf
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/arustydev/goslings/internal/audit"
+	"gopkg.in/yaml.v3"
+)
+
+// Common errors
+var (
+	ErrUnknownKey = errors.New("unknown configuration key")
+	ErrNotSection = errors.New("not a configuration section")
+	ErrNotSet     = errors.New("not set in the config file")
+)
+
+// Template renders a commented configuration documenting every key. Keys with a value in
+// values are set, the others are commented out with their default or an example.
+func Template(values map[string]any) []byte {
+	var b bytes.Buffer
+	b.WriteString("# Goslings configuration, generated by gosling conf init\n")
+	fmt.Fprintf(&b, "# Every key can be set from the environment instead, eg. %s for msft.tenant\n", EnvVar("msft.tenant"))
+	b.WriteString("# Secrets can be references: vault://path#key, env://VAR, file:///path or k8s://namespace/secret#key\n")
+
+	var prev []string
+	for _, k := range Keys {
+		path := strings.Split(k.Name, ".")
+		common := 0
+		for common < len(prev)-1 && common < len(path)-1 && prev[common] == path[common] {
+			common++
+		}
+		if common == 0 {
+			b.WriteString("\n")
+		}
+		// Open the sections of the key, commented out when none of their keys has a value
+		for depth := common; depth < len(path)-1; depth++ {
+			section := strings.Join(path[:depth+1], ".")
+			writeLine(&b, depth, !hasValue(values, section), path[depth]+":")
+		}
+
+		depth := len(path) - 1
+		writeLine(&b, depth, true, k.Help)
+		value, ok := values[k.Name]
+		if !ok {
+			example := k.Example
+			if k.Default != nil {
+				example = renderValue(k.Default)
+			}
+			writeLine(&b, depth, true, strings.TrimSpace(path[depth]+": "+example))
+		} else {
+			writeLine(&b, depth, false, path[depth]+": "+renderValue(value))
+		}
+		prev = path
+	}
+	return b.Bytes()
+}
+
+// hasValue reports whether a key of a section has a value
+func hasValue(values map[string]any, section string) bool {
+	for key := range values {
+		if strings.HasPrefix(key, section+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// writeLine writes a line of the template indented for its depth
+func writeLine(b *bytes.Buffer, depth int, comment bool, line string) {
+	b.WriteString(strings.Repeat("  ", depth))
+	if comment {
+		b.WriteString("# ")
+	}
+	b.WriteString(line)
+	b.WriteString("\n")
+}
+
+// renderValue renders a value as flow-style YAML
+func renderValue(value any) string {
+	var n yaml.Node
+	if err := n.Encode(value); err != nil {
+		return fmt.Sprint(value)
+	}
+	n.Style = yaml.FlowStyle
+	out, err := yaml.Marshal(&n)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// ParseValue reads the value of a key from text, a list is comma separated; a secret
+// reference is kept as it is
+func ParseValue(k Key, s string) (any, error) {
+	var value any = s
+	if ref, _ := parseRef(s); ref != nil && k.Kind != KindMap && k.Kind != KindTables {
+		return value, nil
+	}
+	switch k.Kind {
+	case KindBool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return nil, fmt.Errorf("expected true or false, got %q", s)
+		}
+		value = b
+	case KindInt:
+		i, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("expected an integer, got %q", s)
+		}
+		value = i
+	case KindList:
+		list := []any{}
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		value = list
+	case KindMap, KindTables:
+		return nil, fmt.Errorf("%s holds a %s, edit the config file to change it", k.Name, k.Kind)
+	}
+	return value, CheckValue(k, value)
+}
+
+// Set sets a key in a config file, keeping its comments; the file is created when missing.
+// The change is audited without its value.
+func Set(path, key, text string) (err error) {
+	defer func() { auditChange(path, key, "set", err) }()
+
+	k, ok := LookupKey(key)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownKey, key)
+	}
+	var value any = text
+	if k.Name == strings.ToLower(key) {
+		var err error
+		if value, err = ParseValue(k, text); err != nil {
+			return fmt.Errorf("invalid %s: %w", key, err)
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+	lines, root, err := parseLines(data)
+	if err != nil {
+		return err
+	}
+	if lines, err = setLines(lines, root, strings.Split(key, "."), renderValue(value)); err != nil {
+		return err
+	}
+	return writeLines(path, lines)
+}
+
+// Unset removes a key, or a section, from a config file, keeping the other lines
+func Unset(path, key string) (err error) {
+	defer func() { auditChange(path, key, "unset", err) }()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+	lines, root, err := parseLines(data)
+	if err != nil {
+		return err
+	}
+	m := root
+	for depth, name := range strings.Split(key, ".") {
+		var k, child *yaml.Node
+		for j := 0; m != nil && j+1 < len(m.Content); j += 2 {
+			if strings.EqualFold(m.Content[j].Value, name) {
+				k, child = m.Content[j], m.Content[j+1]
+			}
+		}
+		switch {
+		case k == nil:
+			return fmt.Errorf("%w: %s", ErrNotSet, key)
+		case depth == strings.Count(key, "."):
+			// The comment right above the key goes with it
+			start := k.Line - 1
+			for n := strings.Count(k.HeadComment, "\n") + 1; k.HeadComment != "" && n > 0 && start > 0; n-- {
+				if !strings.HasPrefix(strings.TrimSpace(lines[start-1]), "#") {
+					break
+				}
+				start--
+			}
+			return writeLines(path, slices.Concat(lines[:start], lines[valueEnd(lines, k, child):]))
+		case child.Kind != yaml.MappingNode:
+			return fmt.Errorf("%w: %s", ErrNotSet, key)
+		}
+		m = child
+	}
+	return nil
+}
+
+// auditChange records a change made to a config file by Set or Unset
+func auditChange(path, key, operation string, err error) {
+	audit.Record(context.Background(), audit.Event{
+		Action:  audit.ActionConfigChange,
+		Target:  key,
+		Details: map[string]string{"file": path, "operation": operation},
+	}, err)
+}
+
+// parseLines splits a config file into lines and parses its root mapping, nil when the file
+// is empty
+func parseLines(data []byte) ([]string, *yaml.Node, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse config file: %w", err)
+	}
+	var root *yaml.Node
+	if len(doc.Content) > 0 && doc.Content[0].Kind == yaml.MappingNode {
+		root = doc.Content[0]
+	} else if len(doc.Content) > 0 && doc.Content[0].Tag != "!!null" {
+		return nil, nil, fmt.Errorf("%w: the config file is not a mapping", ErrNotSection)
+	}
+
+	var lines []string
+	if text := strings.TrimRight(string(data), "\n"); text != "" {
+		lines = strings.Split(text, "\n")
+	}
+	return lines, root, nil
+}
+
+// writeLines writes the lines of a config file, creating its directory
+func writeLines(path string, lines []string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("failed to create config directory: %w", err)
+	}
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o600); err != nil {
+		return fmt.Errorf("failed to write config file: %w", err)
+	}
+	return nil
+}
+
+// setLines sets the value at path in the lines of a config file whose root mapping is root,
+// nil when the file has none. Only the lines of the value change, so comments are kept.
+func setLines(lines []string, root *yaml.Node, path []string, value string) ([]string, error) {
+	// New top-level sections go at the end of the file
+	m, indent, after := root, 0, len(lines)
+	for depth := 0; m != nil; depth++ {
+		name := path[depth]
+		var key, child *yaml.Node
+		for j := 0; j+1 < len(m.Content); j += 2 {
+			if strings.EqualFold(m.Content[j].Value, name) {
+				key, child = m.Content[j], m.Content[j+1]
+			}
+		}
+		if key == nil {
+			// New keys go after the last line of their section
+			if depth > 0 {
+				indent, after = m.Content[0].Column-1, valueEnd(lines, m.Content[len(m.Content)-2], m.Content[len(m.Content)-1])
+			}
+			path = path[depth:]
+			break
+		}
+
+		if depth == len(path)-1 {
+			// Replace the key's line and the lines of a block value with a single line
+			line := lines[key.Line-1][:key.Column-1] + key.Value + ": " + value
+			if comment := child.LineComment + key.LineComment; comment != "" {
+				line += " " + comment
+			}
+			return slices.Concat(lines[:key.Line-1], []string{line}, lines[valueEnd(lines, key, child):]), nil
+		}
+		switch {
+		case child.Kind == yaml.MappingNode:
+			m = child
+		case child.Tag == "!!null":
+			// An empty section such as "api:"
+			m, indent, after = nil, key.Column-1+2, key.Line
+			path = path[depth+1:]
+		default:
+			return nil, fmt.Errorf("%w: %s", ErrNotSection, name)
+		}
+	}
+
+	var insert []string
+	for i, name := range path {
+		line := strings.Repeat(" ", indent+2*i) + name + ":"
+		if i == len(path)-1 {
+			line += " " + value
+		}
+		insert = append(insert, line)
+	}
+	return slices.Concat(lines[:after], insert, lines[after:]), nil
+}
+
+// valueEnd returns the last line of the value of key, counted from 1. The nodes of block
+// scalars and flow collections do not tell where they end, so the value also takes the lines
+// indented deeper than its key, short of any trailing blank lines.
+func valueEnd(lines []string, key, value *yaml.Node) int {
+	end := key.Line
+	for i := key.Line; i < len(lines); i++ {
+		text := strings.TrimSpace(lines[i])
+		if text == "" {
+			continue
+		}
+		if len(lines[i])-len(strings.TrimLeft(lines[i], " \t")) < key.Column {
+			break
+		}
+		end = i + 1
+	}
+	return max(end, lastLine(value))
+}
+
+// lastLine returns the last line of a node and its descendants, counted from 1
+func lastLine(n *yaml.Node) int {
+	last := n.Line
+	for _, c := range n.Content {
+		last = max(last, lastLine(c))
+	}
+	return last
+}
@@ -0,0 +1,159 @@
+package conf
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// configFileName is the name of the config file in every search path
+const configFileName = "brood.yaml"
+
+// systemConfigDir holds the config file shared by every user of the machine
+var systemConfigDir = "/etc/goslings"
+
+// Layer is a config file merged into the configuration
+type Layer struct {
+	Source Source
+
+	Path string
+
+	// keys are the keys the file sets
+	keys []string
+
+	// settings are the values of the file, their secret references resolved
+	settings map[string]any
+}
+
+// Sets reports whether the file sets a key, or a key under it
+func (l Layer) Sets(key string) bool {
+	for _, k := range l.keys {
+		if k == key || strings.HasPrefix(k, key+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// config is a configuration read from its files, see readConfig; apply makes it the one viper
+// returns
+type config struct {
+	// file is the config file named by --config or ConfigEnv, empty when there is none
+	file string
+
+	// layers are the config files read, from the lowest precedence to the highest
+	layers []Layer
+
+	profile activeProfile
+
+	// refs are the secret references resolved, by the path of their value such as
+	// auth.app.secret or sinks.0.token
+	refs map[string]string
+}
+
+var (
+	// loaded is the configuration applied
+	loaded = config{refs: map[string]string{}}
+
+	// flags are the flags bound to keys with BindFlag
+	flags = map[string]*pflag.Flag{}
+)
+
+// Layers returns the config files loaded, from the lowest precedence to the highest
+func Layers() []Layer {
+	return loaded.layers
+}
+
+// ConfigFile returns the config file with the highest precedence, empty when none was loaded
+func ConfigFile() string {
+	if len(loaded.layers) == 0 {
+		return ""
+	}
+	return loaded.layers[len(loaded.layers)-1].Path
+}
+
+// BindFlag binds a flag to a key, the flag overrides every other layer once it is set
+func BindFlag(key string, flag *pflag.Flag) {
+	flags[key] = flag
+	_ = viper.BindPFlag(key, flag)
+}
+
+// searchPaths returns the config files looked for, from the lowest precedence to the highest:
+// the system file, the user file under $XDG_CONFIG_HOME and the project file, in the current
+// directory or else under ./configs
+func searchPaths() []Layer {
+	paths := []Layer{{Source: SourceSystem, Path: filepath.Join(systemConfigDir, configFileName)}}
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		dir = expandHome("~/.config")
+	}
+	paths = append(paths, Layer{Source: SourceUser, Path: filepath.Join(dir, "goslings", configFileName)})
+
+	project := DefaultConfigFile
+	if _, err := os.Stat(configFileName); err == nil {
+		project = configFileName
+	}
+	return append(paths, Layer{Source: SourceProject, Path: project})
+}
+
+// readLayer merges a config file into the configuration v holds
+func (c *config) readLayer(v *viper.Viper, l Layer) error {
+	log.Infof("Reading config file %s", l.Path)
+	file := viper.New()
+	file.SetConfigFile(l.Path)
+	file.SetConfigType("yaml")
+	if err := file.ReadInConfig(); err != nil {
+		return fmt.Errorf("failed to read config file %s: %w", l.Path, err)
+	}
+	settings := file.AllSettings()
+	if _, err := c.resolveRefs("", settings); err != nil {
+		return fmt.Errorf("config file %s: %w", l.Path, err)
+	}
+	if err := v.MergeConfigMap(copyMap(settings)); err != nil {
+		return fmt.Errorf("failed to merge config file %s: %w", l.Path, err)
+	}
+	l.keys, l.settings = file.AllKeys(), settings
+	c.layers = append(c.layers, l)
+	return nil
+}
+
+// copyMap copies a map and the maps and lists it holds, viper merging maps in place
+func copyMap(m map[string]any) map[string]any {
+	out := make(map[string]any, len(m))
+	for key, value := range m {
+		out[key] = copyValue(value)
+	}
+	return out
+}
+
+// copyValue copies the maps and lists of a value
+func copyValue(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		return copyMap(v)
+	case []any:
+		out := make([]any, len(v))
+		for i, item := range v {
+			out[i] = copyValue(item)
+		}
+		return out
+	}
+	return value
+}
+
+// expandHome replaces a leading ~ in a path with the user's home directory
+func expandHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, path[1:])
+}
@@ -0,0 +1,173 @@
+package conf
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// ErrUnknownProfile is returned for a profile missing from the profiles section
+var ErrUnknownProfile = errors.New("unknown profile")
+
+// ProfileField is a field of a profile, it overrides Target while its profile is active
+type ProfileField struct {
+	Key
+
+	// Target is the key the field overrides
+	Target string
+}
+
+// ProfileFields are the fields a profile may set
+var ProfileFields = []ProfileField{
+	{Key{Name: "tenant", Kind: KindString, GUID: true, Help: "Tenant (directory) ID"}, "msft.tenant"},
+	{Key{Name: "subscription", Kind: KindString, GUID: true, Help: "Azure subscription ID"}, "msft.subscription"},
+	{Key{Name: "client_id", Kind: KindString, GUID: true, Help: "Client ID of the application signing in"}, "auth.app.id"},
+	{Key{Name: "client_secret", Kind: KindString, Help: "Client secret of the application"}, "auth.app.secret"},
+	{Key{Name: "usgov", Kind: KindBool, Help: "Use the US Government cloud endpoints"}, "msft.usgov.cloud"},
+	{Key{Name: "usgov_exo", Kind: KindBool, Help: "Use the US Government Exchange Online endpoints"}, "msft.usgov.exo"},
+	{Key{Name: "store_path", Kind: KindString, Help: "Directory of the file store, profiles/<name> under store.path when empty"}, "store.path"},
+	{Key{Name: "collectors", Kind: KindList, Help: "Collector families enabled"}, "honk.collectors"},
+}
+
+// activeProfile is the profile applied over the config files, its name is empty when there is
+// none
+type activeProfile struct {
+	name string
+
+	// keys are the keys the profile overrides
+	keys []string
+
+	// storePath is store.path before the profile is applied
+	storePath string
+
+	// settings are the values the profile overrides, their secret references resolved
+	settings map[string]any
+}
+
+// Profiles returns the names of the profiles, sorted
+func Profiles() []string {
+	return profiles(viper.GetViper())
+}
+
+// profiles returns the names of the profiles in v, sorted
+func profiles(v *viper.Viper) []string {
+	var names []string
+	for name := range v.GetStringMap("profiles") {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Profile returns the fields a profile sets, by field name
+func Profile(name string) (map[string]any, error) {
+	return profileOf(viper.GetViper(), name)
+}
+
+// profileOf returns the fields a profile of v sets, by field name
+func profileOf(v *viper.Viper, name string) (map[string]any, error) {
+	name = strings.ToLower(name)
+	if !slices.Contains(profiles(v), name) {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownProfile, name)
+	}
+	fields := v.GetStringMap("profiles." + name)
+	if fields == nil {
+		fields = map[string]any{}
+	}
+	return fields, nil
+}
+
+// ActiveProfile returns the name of the profile in use, empty when there is none
+func ActiveProfile() string {
+	return loaded.profile.name
+}
+
+// ProfileStorePath returns the directory of a profile's file store
+func ProfileStorePath(name string) string {
+	fields, _ := Profile(name)
+	return profileStorePath(fields, loaded.profile.storePath, name)
+}
+
+// profileStorePath returns the store_path of a profile's fields, or else its directory under
+// the store path of the config files
+func profileStorePath(fields map[string]any, storePath, name string) string {
+	if path, ok := fields["store_path"].(string); ok && path != "" {
+		return path
+	}
+	return filepath.Join(storePath, "profiles", strings.ToLower(name))
+}
+
+// lookupProfileField returns the field a key of the profiles section names, eg.
+// profiles.contoso.tenant, documented under its full name
+func lookupProfileField(name string) (Key, bool) {
+	parts := strings.Split(name, ".")
+	if len(parts) != 3 || parts[0] != "profiles" {
+		return Key{}, false
+	}
+	for _, f := range ProfileFields {
+		if f.Name == parts[2] {
+			k := f.Key
+			k.Name = name
+			return k, true
+		}
+	}
+	return Key{}, false
+}
+
+// readProfile merges the fields of the profile selected by the profile key over the config
+// files v holds. A file store gets a directory of its own, so profiles never share tokens.
+func (c *config) readProfile(v *viper.Viper) error {
+	c.profile = activeProfile{storePath: v.GetString("store.path")}
+	name := strings.ToLower(v.GetString("profile"))
+	if name == "" {
+		return nil
+	}
+	fields, err := profileOf(v, name)
+	if err != nil {
+		return fmt.Errorf("%w, the profiles are %v", err, profiles(v))
+	}
+
+	settings := map[string]any{}
+	set := func(key string, value any) {
+		section := settings
+		path := strings.Split(key, ".")
+		for _, part := range path[:len(path)-1] {
+			if _, ok := section[part].(map[string]any); !ok {
+				section[part] = map[string]any{}
+			}
+			section = section[part].(map[string]any)
+		}
+		section[path[len(path)-1]] = value
+		c.profile.keys = append(c.profile.keys, key)
+	}
+	for _, f := range ProfileFields {
+		value, ok := fields[f.Name]
+		if !ok {
+			continue
+		}
+		value, err := c.resolveRefs(f.Target, value)
+		if err != nil {
+			return fmt.Errorf("profile %s: %w", name, err)
+		}
+		set(f.Target, value)
+	}
+	if _, ok := fields["store_path"]; !ok {
+		set("store.path", profileStorePath(fields, c.profile.storePath, name))
+	}
+	if err := v.MergeConfigMap(copyMap(settings)); err != nil {
+		return fmt.Errorf("failed to apply profile %s: %w", name, err)
+	}
+	c.profile.name, c.profile.settings = name, settings
+	log.Infof("Using profile %s", name)
+	return nil
+}
+
+// profileSets reports whether the active profile sets a key
+func profileSets(key string) bool {
+	return slices.Contains(loaded.profile.keys, key)
+}
@@ -0,0 +1,71 @@
+package conf
+
+import (
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// Redacted replaces secret values wherever configuration is echoed
+const Redacted = "[REDACTED]"
+
+// secretKeyParts mark configuration keys whose values are secrets
+var secretKeyParts = []string{"pass", "secret", "token", "cookie", "apikey", "api_key"}
+
+// IsSecretKey reports whether the last segment of a configuration key names a secret
+func IsSecretKey(key string) bool {
+	key = strings.ToLower(key)
+	if i := strings.LastIndex(key, "."); i >= 0 {
+		key = key[i+1:]
+	}
+	if key == "key" || strings.HasSuffix(key, "_key") {
+		return true
+	}
+	for _, part := range secretKeyParts {
+		if strings.Contains(key, part) {
+			return true
+		}
+	}
+	return false
+}
+
+// Redact returns a copy of settings with every secret value replaced by Redacted
+func Redact(settings map[string]any) map[string]any {
+	out := make(map[string]any, len(settings))
+	for key, value := range settings {
+		switch v := value.(type) {
+		case map[string]any:
+			out[key] = Redact(v)
+		case []any:
+			out[key] = redactList(v)
+		default:
+			if IsSecretKey(key) && value != nil && value != "" {
+				out[key] = Redacted
+			} else {
+				out[key] = value
+			}
+		}
+	}
+	return out
+}
+
+// redactList redacts the maps of a configuration list, such as the sinks
+func redactList(list []any) []any {
+	out := make([]any, len(list))
+	for i, value := range list {
+		switch v := value.(type) {
+		case map[string]any:
+			out[i] = Redact(v)
+		case []any:
+			out[i] = redactList(v)
+		default:
+			out[i] = value
+		}
+	}
+	return out
+}
+
+// Snapshot returns the effective configuration with its secrets redacted
+func Snapshot() map[string]any {
+	return Redact(viper.AllSettings())
+}
@@ -0,0 +1,243 @@
+package conf
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/arustydev/goslings/internal/audit"
+	"github.com/fsnotify/fsnotify"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// ReloadDelay is how long the config files must be left alone before a change is reloaded, as
+// editors and ConfigMap updates write them in several steps
+const ReloadDelay = 500 * time.Millisecond
+
+// Common errors
+var (
+	ErrInvalidConfig   = errors.New("invalid configuration")
+	ErrRestartRequired = errors.New("restart required")
+)
+
+// reloadMu serializes reloads
+var reloadMu sync.Mutex
+
+// Change is a key whose effective value a reload changed
+type Change struct {
+	Key string
+
+	// Old and New have their secrets redacted, and secret references in place of their values
+	Old any
+	New any
+}
+
+func (c Change) String() string {
+	return c.Key + ": " + showChange(c.Old) + " -> " + showChange(c.New)
+}
+
+// showChange formats a value of a Change
+func showChange(value any) string {
+	switch value.(type) {
+	case nil:
+		return "unset"
+	case map[string]any, []any:
+		if data, err := json.Marshal(value); err == nil {
+			return string(data)
+		}
+	}
+	return fmt.Sprint(value)
+}
+
+// Reload reads the configuration again, like LoadConfig did, and hands what changed to apply
+// to put it to use. The new configuration is rejected, and the running one kept, when it
+// cannot be read, when Validate reports problems it did not report before, when it changes a
+// Restart key or when apply fails; the changes are returned either way.
+func Reload(apply func([]Change) error) ([]Change, error) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	c, err := readConfig(loaded.file)
+	if err != nil {
+		return nil, err
+	}
+	running := loaded
+	before, shownBefore := values()
+	problems := Validate()
+	c.apply()
+	after, shownAfter := values()
+
+	var changes []Change
+	var restart []string
+	for _, k := range Keys {
+		if reflect.DeepEqual(before[k.Name], after[k.Name]) {
+			continue
+		}
+		changes = append(changes, Change{Key: k.Name, Old: shownBefore[k.Name], New: shownAfter[k.Name]})
+		if k.Restart {
+			restart = append(restart, k.Name)
+		}
+	}
+	reject := func(err error) ([]Change, error) {
+		running.apply()
+		return changes, err
+	}
+	var added []string
+	for _, p := range Validate() {
+		if !slices.Contains(problems, p) {
+			added = append(added, p.String())
+		}
+	}
+	switch {
+	case len(added) > 0:
+		return reject(fmt.Errorf("%w: %s", ErrInvalidConfig, strings.Join(added, "; ")))
+	case len(restart) > 0:
+		return reject(fmt.Errorf("%w: %s cannot change while the process runs", ErrRestartRequired, strings.Join(restart, ", ")))
+	case len(changes) == 0:
+		return nil, nil
+	}
+	if err := apply(changes); err != nil {
+		return reject(err)
+	}
+	return changes, nil
+}
+
+// values returns the effective value of every key, and the value shown for it
+func values() (map[string]any, map[string]any) {
+	raw, shown := map[string]any{}, map[string]any{}
+	for _, k := range Keys {
+		value := viper.Get(k.Name)
+		if value == nil || value == "" {
+			continue
+		}
+		raw[k.Name] = value
+		shown[k.Name] = showRefs(k.Name, redactValue(k.Name, value))
+	}
+	return raw, shown
+}
+
+// Watch reloads the configuration with Reload whenever a config file changes or the process
+// receives SIGHUP, until ctx is done; it does nothing when the reload key is off. The
+// directories of the config files are watched, so that files created, replaced, or swapped
+// by a Kubernetes ConfigMap update are seen. What each reload changed, or why it was
+// rejected, is logged.
+func Watch(ctx context.Context, apply func([]Change) error) error {
+	if !viper.GetBool("reload") {
+		log.Info("Configuration reload is off, changes need a restart")
+		return nil
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to watch the config files: %w", err)
+	}
+	paths := watchPaths()
+	for _, path := range paths {
+		// Directories that do not exist are skipped, SIGHUP picks up files created in them
+		_ = watcher.Add(filepath.Dir(path))
+	}
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	seen := fingerprint(paths)
+	go func() {
+		defer watcher.Close()
+		defer signal.Stop(hup)
+		timer := time.NewTimer(ReloadDelay)
+		timer.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case _, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				timer.Reset(ReloadDelay)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Warnf("Failed to watch the config files: %v", err)
+			case <-hup:
+				log.Info("Received SIGHUP, reloading the configuration")
+				seen = fingerprint(paths)
+				reload(apply)
+			case <-timer.C:
+				// Events for other files of the directories, or writes leaving a file as it
+				// was, are ignored
+				if fp := fingerprint(paths); fp != seen {
+					seen = fp
+					reload(apply)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// reload reloads the configuration and logs the outcome
+func reload(apply func([]Change) error) {
+	changes, err := Reload(apply)
+	described := make([]string, len(changes))
+	for i, c := range changes {
+		described[i] = c.String()
+	}
+	logger := log.WithField("changes", described)
+	if err != nil || len(changes) > 0 {
+		audit.Record(context.Background(), audit.Event{
+			Action:  audit.ActionConfigChange,
+			Target:  "reload",
+			Details: map[string]string{"changes": strings.Join(described, "; ")},
+		}, err)
+	}
+	switch {
+	case err != nil:
+		logger.Errorf("Rejected the new configuration, keeping the running one: %v", err)
+	case len(changes) == 0:
+		log.Info("Reloaded the configuration, nothing changed")
+	default:
+		logger.Infof("Reloaded the configuration, %d keys changed", len(changes))
+	}
+}
+
+// watchPaths returns every config file LoadConfig may read
+func watchPaths() []string {
+	var paths []string
+	for _, l := range searchPaths() {
+		paths = append(paths, l.Path)
+	}
+	// The project file is in the current directory or else under ./configs, either may appear
+	paths = append(paths, configFileName, DefaultConfigFile)
+	if loaded.file != "" {
+		paths = append(paths, expandHome(loaded.file))
+	}
+	return paths
+}
+
+// fingerprint hashes the content of files, a missing file hashing as such
+func fingerprint(paths []string) string {
+	h := sha256.New()
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			data = []byte("missing")
+		}
+		sum := sha256.Sum256(data)
+		fmt.Fprintf(h, "%s\x00%x\x00", path, sum)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
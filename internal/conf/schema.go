@@ -0,0 +1,175 @@
+package conf
+
+import (
+	"strings"
+)
+
+// Kind is the type of the value of a configuration key
+type Kind string
+
+const (
+	KindString   Kind = "string"
+	KindBool     Kind = "bool"
+	KindInt      Kind = "int"
+	KindDuration Kind = "duration"
+	KindList     Kind = "list"
+
+	// KindMap holds keys chosen by the user, such as role mappings
+	KindMap Kind = "map"
+
+	// KindTables is a list of tables, such as the sinks
+	KindTables Kind = "tables"
+)
+
+// Key documents a configuration key
+type Key struct {
+	// Name is the dotted path of the key, eg. msft.tenant
+	Name string
+
+	Kind Kind
+
+	// Help describes the key in the generated configuration
+	Help string
+
+	// Default is registered with viper, it is nil for keys whose default is applied by the
+	// code reading them
+	Default any
+
+	// Example is shown, commented out, in the generated configuration when there is no Default
+	Example string
+
+	// Values are the allowed values, any value is allowed when empty
+	Values []string
+
+	// GUID requires the value to be a GUID
+	GUID bool
+
+	// Env is another environment variable setting the key, besides the one named by EnvVar
+	Env string
+
+	// Flag is the name of the command line flag setting the key, none when empty
+	Flag string
+
+	// Restart marks a key only read when a process starts, a reload changing it is rejected
+	Restart bool
+}
+
+// Keys are every configuration key, in the order of the generated configuration
+var Keys = []Key{
+	{Name: "profile", Kind: KindString, Flag: "profile", Restart: true, Help: "Profile of the tenant to investigate, see gosling conf profiles"},
+	{Name: "profiles", Kind: KindMap, Help: "Tenants by profile name, each with tenant, subscription, client_id, client_secret, usgov, usgov_exo, store_path and collectors", Example: "{}"},
+
+	{Name: "msft.tenant", Kind: KindString, GUID: true, Env: "GOSLING_TENANT", Flag: "tenant", Restart: true, Help: "Tenant (directory) ID to investigate", Example: "00000000-0000-0000-0000-000000000000"},
+	{Name: "msft.subscription", Kind: KindString, GUID: true, Env: "GOSLING_SUBSCRIPTION", Flag: "subscription", Restart: true, Help: "Azure subscription ID to investigate"},
+	{Name: "msft.usgov.cloud", Kind: KindBool, Env: "GOSLING_USGOV_CLOUD", Flag: "usgov", Restart: true, Help: "Use the US Government cloud endpoints", Example: "false"},
+	{Name: "msft.usgov.exo", Kind: KindBool, Env: "GOSLING_USGOV_EXO", Flag: "usgov-exo", Restart: true, Help: "Use the US Government Exchange Online endpoints", Example: "false"},
+	{Name: "msft.m365auth", Kind: KindBool, Env: "GOSLING_M365_AUTH", Flag: "m365-auth", Restart: true, Help: "Authenticate to Microsoft 365", Example: "false"},
+	{Name: "msft.msgtrace", Kind: KindBool, Env: "GOSLING_EXO_MSG_TRACE", Flag: "msgtrace", Restart: true, Help: "Collect Exchange message traces", Example: "false"},
+
+	{Name: "auth.app.id", Kind: KindString, GUID: true, Env: "GOSLING_APP_ID", Flag: "client-id", Restart: true, Help: "Client ID of the application signing in", Example: "00000000-0000-0000-0000-000000000000"},
+	{Name: "auth.app.secret", Kind: KindString, Env: "GOSLING_APP_SECRET", Flag: "client-secret", Restart: true, Help: "Client secret of the application, better set in the environment or as a reference"},
+	{Name: "auth.simple.user", Kind: KindString, Env: "GOSLING_USER", Flag: "user", Restart: true, Help: "User name for username/password authentication"},
+	{Name: "auth.simple.pass", Kind: KindString, Env: "GOSLING_PASS", Flag: "pass", Restart: true, Help: "Password for username/password authentication, better set in the environment or as a reference"},
+
+	{Name: "store.type", Kind: KindString, Default: "file", Values: []string{"file", "memory", "kubernetes", "vault"}, Restart: true, Help: "Where tokens, delta links and API keys are kept"},
+	{Name: "store.path", Kind: KindString, Default: "./.credentials", Restart: true, Help: "Directory of the file store"},
+	{Name: "store.keyfile", Kind: KindString, Default: "./.credentials.key", Restart: true, Help: "File holding the store encryption key, generated when missing"},
+	{Name: "store.key", Kind: KindString, Restart: true, Help: "Base64 store encryption key, used instead of store.keyfile"},
+
+	{Name: "output.format", Kind: KindString, Default: "jsonl", Values: []string{"jsonl", "csv", "parquet"}, Help: "Format of collected records"},
+	{Name: "output.compression", Kind: KindString, Default: "none", Values: []string{"none", "gzip", "zstd"}, Help: "Compression of collected records"},
+	{Name: "output.rotate.bytes", Kind: KindInt, Help: "Start a new part past this many bytes on disk, after compression (CSV and Parquet: of their JSON Lines spool)", Example: "1073741824"},
+	{Name: "output.rotate.records", Kind: KindInt, Help: "Start a new part past this many records", Example: "1000000"},
+
+	{Name: "honk.out", Kind: KindString, Help: "Directory honk writes runs to", Example: "./output"},
+	{Name: "honk.since", Kind: KindString, Help: "Start of the window, a duration before until or an RFC 3339 time", Example: "168h"},
+	{Name: "honk.until", Kind: KindString, Help: "End of the window as an RFC 3339 time, now when empty"},
+	{Name: "honk.include", Kind: KindList, Help: "Datasets to collect, by name or glob", Example: "[entra.*]"},
+	{Name: "honk.exclude", Kind: KindList, Help: "Datasets to skip, by name or glob", Example: "[]"},
+	{Name: "honk.collectors", Kind: KindList, Help: "Collector families to enable", Example: "[entra, azure, m365, mde, d4iot]"},
+	{Name: "honk.concurrency", Kind: KindInt, Help: "How many datasets are collected at once", Example: "4"},
+	{Name: "honk.auth", Kind: KindString, Values: []string{"device_code", "secret", "managed_identity"}, Help: "How honk signs in when the stored tokens cannot be renewed", Example: "device_code"},
+	{Name: "honk.full", Kind: KindBool, Help: "Ignore stored delta links", Example: "false"},
+	{Name: "honk.ship", Kind: KindBool, Help: "Ship completed runs to the sinks", Example: "false"},
+
+	{Name: "headless.datasets", Kind: KindList, Help: "Datasets collected by every run, every dataset when empty", Example: "[]"},
+	{Name: "headless.since", Kind: KindDuration, Help: "How far back time-windowed datasets are collected", Example: "168h"},
+	{Name: "headless.out", Kind: KindString, Restart: true, Help: "Directory runs are written to", Example: "./output"},
+	{Name: "headless.full", Kind: KindBool, Help: "Ignore stored delta links", Example: "false"},
+	{Name: "headless.interval", Kind: KindDuration, Help: "Start a run every interval, a single run when zero", Example: "0s"},
+	{Name: "headless.auth", Kind: KindString, Values: []string{"secret", "managed_identity"}, Restart: true, Help: "App-only authentication method", Example: "managed_identity"},
+	{Name: "headless.shutdown_timeout", Kind: KindDuration, Restart: true, Help: "How long a stop waits for the page being read", Example: "30s"},
+	{Name: "headless.metrics_address", Kind: KindString, Restart: true, Help: "Address the Prometheus metrics are served on at /metrics, not served when empty", Example: ":9090"},
+
+	{Name: "schedule.jobs", Kind: KindTables, Help: "Cron-scheduled collection jobs (name, schedule, datasets, lookback, delay, full)", Example: "[]"},
+	{Name: "sinks", Kind: KindTables, Help: "Destinations completed runs are shipped to, see the ship command", Example: "[]"},
+
+	{Name: "api.address", Kind: KindString, Restart: true, Help: "Address the API server listens on", Example: ":8080"},
+	{Name: "api.out", Kind: KindString, Restart: true, Help: "Directory the API server writes runs to", Example: "./output"},
+	{Name: "api.shutdown_timeout", Kind: KindDuration, Restart: true, Help: "How long a shutdown waits for jobs to reach a page boundary", Example: "30s"},
+	{Name: "api.drain_timeout", Kind: KindDuration, Restart: true, Help: "How long a shutdown then waits for in-flight requests", Example: "10s"},
+	{Name: "api.require_tokens", Kind: KindBool, Help: "Report the server as not ready without valid tokens", Example: "false"},
+	{Name: "api.trusted_proxies", Kind: KindList, Restart: true, Help: "Addresses or CIDR ranges of the proxies whose X-Forwarded-For header is trusted", Example: "[]"},
+	{Name: "api.tls.cert", Kind: KindString, Restart: true, Help: "Server certificate, TLS is disabled without it"},
+	{Name: "api.tls.key", Kind: KindString, Restart: true, Help: "Private key of the server certificate"},
+	{Name: "api.tls.client_ca", Kind: KindString, Restart: true, Help: "CA of the client certificates accepted for mTLS"},
+	{Name: "api.tls.reload", Kind: KindBool, Restart: true, Help: "Reload the certificate when its files change", Example: "false"},
+	{Name: "api.tls.reload_interval", Kind: KindDuration, Restart: true, Help: "How often the certificate files are checked", Example: "1m"},
+	{Name: "api.auth.mtls.roles", Kind: KindMap, Restart: true, Help: "Roles of client certificates, by subject common name", Example: "{}"},
+	{Name: "api.auth.mtls.default_role", Kind: KindString, Values: []string{"viewer", "operator", "admin"}, Restart: true, Help: "Role of verified certificates missing from the roles, refused when empty"},
+	{Name: "api.auth.oidc.issuer", Kind: KindString, Restart: true, Help: "Issuer of the OIDC bearer tokens accepted"},
+	{Name: "api.auth.oidc.audience", Kind: KindString, Restart: true, Help: "Audience the OIDC bearer tokens must have"},
+	{Name: "api.auth.oidc.jwks_url", Kind: KindString, Restart: true, Help: "Signing keys of the issuer, discovered when empty"},
+	{Name: "api.auth.oidc.roles_claim", Kind: KindString, Restart: true, Help: "Claim holding the caller's roles", Example: "roles"},
+	{Name: "api.auth.oidc.roles", Kind: KindMap, Restart: true, Help: "Roles granted by claim value", Example: "{}"},
+
+	{Name: "remote.url", Kind: KindString, Help: "API server the CLI and TUI drive instead of this machine"},
+	{Name: "remote.api_key", Kind: KindString, Help: "API key for the remote server, better set in the environment or as a reference"},
+	{Name: "remote.token", Kind: KindString, Help: "OIDC bearer token for the remote server"},
+	{Name: "remote.ca", Kind: KindString, Help: "CA verifying the remote server instead of the system roots"},
+	{Name: "remote.cert", Kind: KindString, Help: "Client certificate for mTLS"},
+	{Name: "remote.key", Kind: KindString, Help: "Private key of the client certificate"},
+
+	{Name: "log.level", Kind: KindString, Default: "info", Values: []string{"trace", "debug", "info", "warn", "error"}, Help: "Least severe level logged"},
+	{Name: "log.format", Kind: KindString, Values: []string{"text", "json", "logfmt"}, Help: "How entries are written, json for the api and headless processes and text otherwise when empty"},
+	{Name: "log.file", Kind: KindString, Help: "File entries are written to instead of stderr", Example: "/var/log/goslings/gosling.log"},
+	{Name: "log.max_size", Kind: KindInt, Help: "Rotate the log file past this many bytes, never when zero", Example: "104857600"},
+	{Name: "log.max_backups", Kind: KindInt, Help: "How many rotated log files are kept", Example: "5"},
+	{Name: "trace.exporter", Kind: KindString, Default: "none", Values: []string{"none", "otlp", "file"}, Restart: true, Help: "Where OpenTelemetry spans are sent, to an OTLP/HTTP collector or a file"},
+	{Name: "trace.endpoint", Kind: KindString, Restart: true, Help: "URL of the OTLP/HTTP collector, the OTEL_EXPORTER_OTLP_* environment applies when empty", Example: "http://localhost:4318"},
+	{Name: "trace.file", Kind: KindString, Restart: true, Help: "File the spans are appended to as JSON by the file exporter", Example: "./traces.jsonl"},
+	{Name: "audit.file", Kind: KindString, Restart: true, Help: "File the hash-chained audit log of goslings' own actions is appended to, check it with gosling audit verify; auditing is off until it is set", Example: "/var/log/goslings/audit.jsonl"},
+	{Name: "audit.sinks", Kind: KindList, Restart: true, Help: "Names of the sinks the audit events are also sent to, as the audit dataset", Example: "[]"},
+	{Name: "reload", Kind: KindBool, Default: true, Restart: true, Help: "Apply config file changes to the api and headless processes as they are saved, or on SIGHUP"},
+
+	{Name: "author", Kind: KindString, Default: "Adam Smith <developer@gh.arusty.dev>", Help: "Author recorded for copyright attribution"},
+	{Name: "license", Kind: KindString, Default: "agpl3", Help: "License of the generated output"},
+}
+
+// LookupKey returns the key documenting a configuration key, which may be a key of a map or
+// a field of a table list
+func LookupKey(name string) (Key, bool) {
+	name = strings.ToLower(name)
+	if k, ok := lookupProfileField(name); ok {
+		return k, true
+	}
+	for _, k := range Keys {
+		if k.Name == name {
+			return k, true
+		}
+		if (k.Kind == KindMap || k.Kind == KindTables) && strings.HasPrefix(name, k.Name+".") {
+			return k, true
+		}
+	}
+	return Key{}, false
+}
+
+// isSection reports whether a name is a prefix of configuration keys, such as api.tls
+func isSection(name string) bool {
+	for _, k := range Keys {
+		if strings.HasPrefix(k.Name, name+".") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,340 @@
+package conf
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ResolveTimeout bounds the resolution of a single secret reference
+const ResolveTimeout = 30 * time.Second
+
+// ErrSecretNotFound is returned by a Resolver when a reference names nothing
+var ErrSecretNotFound = errors.New("secret not found")
+
+// Resolver resolves the secret references of a scheme, such as vault://path#key
+type Resolver interface {
+	Resolve(ctx context.Context, ref *url.URL) (string, error)
+}
+
+// ResolverFunc adapts a function to a Resolver
+type ResolverFunc func(ctx context.Context, ref *url.URL) (string, error)
+
+// Resolve implements Resolver.Resolve for ResolverFunc
+func (f ResolverFunc) Resolve(ctx context.Context, ref *url.URL) (string, error) {
+	return f(ctx, ref)
+}
+
+var (
+	resolversMu sync.RWMutex
+
+	// resolvers are the Resolvers by scheme, a value of another scheme is not a reference
+	resolvers = map[string]Resolver{
+		"env":   ResolverFunc(resolveEnv),
+		"file":  ResolverFunc(resolveFile),
+		"vault": &VaultResolver{},
+		"k8s":   &K8sResolver{},
+	}
+)
+
+// RegisterResolver makes the values of a scheme secret references resolved by r, replacing
+// the Resolver of the scheme if there is one
+func RegisterResolver(scheme string, r Resolver) {
+	resolversMu.Lock()
+	defer resolversMu.Unlock()
+	resolvers[strings.ToLower(scheme)] = r
+}
+
+// parseRef returns the reference a value holds, nil when it holds none
+func parseRef(value string) (*url.URL, Resolver) {
+	scheme, _, ok := strings.Cut(value, "://")
+	if !ok {
+		return nil, nil
+	}
+	resolversMu.RLock()
+	r, ok := resolvers[strings.ToLower(scheme)]
+	resolversMu.RUnlock()
+	if !ok {
+		return nil, nil
+	}
+	ref, err := url.Parse(value)
+	if err != nil {
+		return nil, nil
+	}
+	return ref, r
+}
+
+// resolveRefs replaces the secret references in the values of a config file with what they
+// resolve to, recording them in c.refs; path is where value is in the configuration. The
+// profiles are left alone, only the active one is resolved when it is applied.
+func (c *config) resolveRefs(path string, value any) (any, error) {
+	switch v := value.(type) {
+	case map[string]any:
+		if path == "profiles" {
+			return v, nil
+		}
+		for key, item := range v {
+			resolved, err := c.resolveRefs(joinPath(path, key), item)
+			if err != nil {
+				return nil, err
+			}
+			v[key] = resolved
+		}
+	case []any:
+		for i, item := range v {
+			resolved, err := c.resolveRefs(joinPath(path, strconv.Itoa(i)), item)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = resolved
+		}
+	case string:
+		ref, r := parseRef(v)
+		if ref == nil {
+			delete(c.refs, path)
+			return v, nil
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), ResolveTimeout)
+		defer cancel()
+		secret, err := r.Resolve(ctx, ref)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve %s for %s: %w", v, path, err)
+		}
+		c.refs[path] = v
+		return secret, nil
+	default:
+		delete(c.refs, path)
+	}
+	return value, nil
+}
+
+// joinPath appends a key to the path of a value
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// showRefs replaces the resolved values under path with their references, so that they can
+// be shown
+func showRefs(path string, value any) any {
+	if ref, ok := loaded.refs[path]; ok {
+		return ref
+	}
+	switch v := value.(type) {
+	case map[string]any:
+		out := make(map[string]any, len(v))
+		for key, item := range v {
+			out[key] = showRefs(joinPath(path, key), item)
+		}
+		return out
+	case []any:
+		out := make([]any, len(v))
+		for i, item := range v {
+			out[i] = showRefs(joinPath(path, strconv.Itoa(i)), item)
+		}
+		return out
+	}
+	return value
+}
+
+// resolveEnv resolves env://VAR to the value of the environment variable VAR
+func resolveEnv(ctx context.Context, ref *url.URL) (string, error) {
+	value, ok := os.LookupEnv(ref.Host + ref.Path)
+	if !ok {
+		return "", fmt.Errorf("%w: %s is not set", ErrSecretNotFound, ref.Host+ref.Path)
+	}
+	return value, nil
+}
+
+// resolveFile resolves file:///path, or file://relative/path, to the content of the file
+// without its trailing newline
+func resolveFile(ctx context.Context, ref *url.URL) (string, error) {
+	data, err := os.ReadFile(filepath.FromSlash(ref.Host + ref.Path))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("%w: %v", ErrSecretNotFound, err)
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// VaultResolver resolves vault://mount/path#key to a key of a HashiCorp Vault secret, from a
+// KV version 1 or 2 engine
+type VaultResolver struct {
+	// Address is the Vault server, VAULT_ADDR when empty
+	Address string
+
+	// Token authenticates to Vault, VAULT_TOKEN or else ~/.vault-token when empty
+	Token string
+
+	// Namespace is the Vault Enterprise namespace, VAULT_NAMESPACE when empty
+	Namespace string
+
+	// Client sends the requests, http.DefaultClient when nil
+	Client *http.Client
+}
+
+// Resolve implements Resolver.Resolve for VaultResolver
+func (r *VaultResolver) Resolve(ctx context.Context, ref *url.URL) (string, error) {
+	if ref.Fragment == "" {
+		return "", fmt.Errorf("%s names no key, add #key", ref.Redacted())
+	}
+	address := firstOf(r.Address, os.Getenv("VAULT_ADDR"))
+	if address == "" {
+		return "", errors.New("no Vault address, set VAULT_ADDR")
+	}
+	token := firstOf(r.Token, os.Getenv("VAULT_TOKEN"))
+	if token == "" {
+		data, err := os.ReadFile(expandHome("~/.vault-token"))
+		if err != nil {
+			return "", errors.New("no Vault token, set VAULT_TOKEN")
+		}
+		token = strings.TrimSpace(string(data))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(address, "/")+"/v1/"+ref.Host+ref.Path, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	if ns := firstOf(r.Namespace, os.Getenv("VAULT_NAMESPACE")); ns != "" {
+		req.Header.Set("X-Vault-Namespace", ns)
+	}
+	var secret struct {
+		Data map[string]any `json:"data"`
+	}
+	if err := getJSON(r.Client, req, &secret); err != nil {
+		return "", err
+	}
+	data := secret.Data
+	if nested, ok := data["data"].(map[string]any); ok {
+		// KV version 2 nests the secret under data
+		data = nested
+	}
+	value, ok := data[ref.Fragment]
+	if !ok {
+		return "", fmt.Errorf("%w: no key %s in %s", ErrSecretNotFound, ref.Fragment, ref.Host+ref.Path)
+	}
+	if s, ok := value.(string); ok {
+		return s, nil
+	}
+	return fmt.Sprint(value), nil
+}
+
+// K8sResolver resolves k8s://namespace/secret#key to a key of a Kubernetes secret, with the
+// service account of the pod
+type K8sResolver struct {
+	// Server is the API server, https://$KUBERNETES_SERVICE_HOST:$KUBERNETES_SERVICE_PORT when
+	// empty
+	Server string
+
+	// TokenFile holds the service account token, the pod's when empty
+	TokenFile string
+
+	// CAFile holds the CA of the API server, the pod's when empty
+	CAFile string
+
+	// Client sends the requests, one trusting CAFile when nil
+	Client *http.Client
+}
+
+// serviceAccountDir is where a pod's service account is mounted
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// Resolve implements Resolver.Resolve for K8sResolver
+func (r *K8sResolver) Resolve(ctx context.Context, ref *url.URL) (string, error) {
+	name := strings.Trim(ref.Path, "/")
+	if ref.Host == "" || name == "" || ref.Fragment == "" {
+		return "", fmt.Errorf("%s is not k8s://namespace/secret#key", ref.Redacted())
+	}
+	server := r.Server
+	if server == "" {
+		host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+		if host == "" {
+			return "", errors.New("not running in Kubernetes, KUBERNETES_SERVICE_HOST is not set")
+		}
+		server = "https://" + host + ":" + port
+	}
+	token, err := os.ReadFile(firstOf(r.TokenFile, serviceAccountDir+"/token"))
+	if err != nil {
+		return "", fmt.Errorf("failed to read the service account token: %w", err)
+	}
+	client := r.Client
+	if client == nil {
+		pem, err := os.ReadFile(firstOf(r.CAFile, serviceAccountDir+"/ca.crt"))
+		if err != nil {
+			return "", fmt.Errorf("failed to read the API server CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		pool.AppendCertsFromPEM(pem)
+		client = &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}}}
+	}
+
+	u := strings.TrimSuffix(server, "/") + "/api/v1/namespaces/" + url.PathEscape(ref.Host) + "/secrets/" + url.PathEscape(name)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	var secret struct {
+		Data map[string]string `json:"data"`
+	}
+	if err := getJSON(client, req, &secret); err != nil {
+		return "", err
+	}
+	encoded, ok := secret.Data[ref.Fragment]
+	if !ok {
+		return "", fmt.Errorf("%w: no key %s in secret %s/%s", ErrSecretNotFound, ref.Fragment, ref.Host, name)
+	}
+	value, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode key %s of secret %s/%s: %w", ref.Fragment, ref.Host, name, err)
+	}
+	return string(value), nil
+}
+
+// getJSON sends a request and decodes its JSON response
+func getJSON(client *http.Client, req *http.Request, v any) error {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%w: %s", ErrSecretNotFound, req.URL.Path)
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s returned %s: %s", req.URL.Redacted(), resp.Status, strings.TrimSpace(string(body)))
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// firstOf returns the first value that is not empty
+func firstOf(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,89 @@
+package conf
+
+import (
+	"os"
+
+	"github.com/spf13/viper"
+)
+
+// Source is the layer the effective value of a key comes from, see LoadConfig
+type Source string
+
+const (
+	SourceDefault Source = "default"
+	SourceSystem  Source = "system"
+	SourceUser    Source = "user"
+	SourceProject Source = "project"
+	SourceConfig  Source = "config"
+	SourceProfile Source = "profile"
+	SourceEnv     Source = "env"
+	SourceFlag    Source = "flag"
+)
+
+// Setting is the effective value of a key
+type Setting struct {
+	Key string
+
+	// Value has its secrets redacted, and secret references in place of their values
+	Value any
+
+	Source Source
+
+	// Origin is the config file, environment variable or flag setting the value
+	Origin string
+}
+
+// Settings returns the effective value of every key that has one, in the order of Keys;
+// secrets are redacted
+func Settings() []Setting {
+	var settings []Setting
+	for _, k := range Keys {
+		value := viper.Get(k.Name)
+		if value == nil || value == "" {
+			continue
+		}
+		s := Setting{Key: k.Name, Value: redactValue(k.Name, value)}
+		s.Source, s.Origin = source(k)
+		if s.Source != SourceEnv && s.Source != SourceFlag {
+			// References are shown instead of the secrets they resolve to
+			s.Value = showRefs(k.Name, s.Value)
+		}
+		settings = append(settings, s)
+	}
+	return settings
+}
+
+// source returns the layer with the highest precedence setting a key, and its origin
+func source(k Key) (Source, string) {
+	if f, ok := flags[k.Name]; ok && f.Changed {
+		return SourceFlag, "--" + f.Name
+	}
+	for _, env := range []string{EnvVar(k.Name), k.Env} {
+		if _, ok := os.LookupEnv(env); ok && env != "" {
+			return SourceEnv, env
+		}
+	}
+	if profileSets(k.Name) {
+		return SourceProfile, "profiles." + loaded.profile.name
+	}
+	for i := len(loaded.layers) - 1; i >= 0; i-- {
+		if l := loaded.layers[i]; l.Sets(k.Name) {
+			return l.Source, l.Path
+		}
+	}
+	return SourceDefault, ""
+}
+
+// redactValue redacts the value of a key, or the secrets it holds
+func redactValue(key string, value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		return Redact(v)
+	case []any:
+		return redactList(v)
+	}
+	if IsSecretKey(key) {
+		return Redacted
+	}
+	return value
+}
@@ -0,0 +1,213 @@
+package conf
+
+import (
+	"encoding/base64"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/arustydev/goslings/internal/collect"
+	"github.com/arustydev/goslings/internal/schedule"
+	"github.com/arustydev/goslings/internal/sink"
+	"github.com/spf13/viper"
+)
+
+// guidPattern matches a GUID such as a tenant or client ID
+var guidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// sinkTypes are the types of sink that can be configured
+var sinkTypes = []sink.Type{sink.S3, sink.AzureBlob, sink.Splunk, sink.Elasticsearch, sink.Syslog}
+
+// Problem is something wrong with the configuration
+type Problem struct {
+	// Key is the configuration key or section at fault
+	Key string
+
+	Message string
+}
+
+func (p Problem) String() string {
+	return p.Key + ": " + p.Message
+}
+
+// Validate checks the effective configuration: unknown keys, the type and allowed values of
+// every key, GUIDs, and options that conflict or that the components reading them refuse
+func Validate() []Problem {
+	var problems []Problem
+	add := func(key, format string, args ...any) {
+		problems = append(problems, Problem{Key: key, Message: fmt.Sprintf(format, args...)})
+	}
+	// addValue reports an invalid value, without the secret a reference resolved to
+	addValue := func(key string, err error) {
+		if ref, ok := loaded.refs[key]; ok {
+			add(key, "%s does not resolve to a valid value", ref)
+		} else {
+			add(key, "%v", err)
+		}
+	}
+
+	for _, key := range viper.AllKeys() {
+		if _, ok := LookupKey(key); !ok && !isSection(key) {
+			add(key, "unknown key")
+		}
+	}
+	for _, k := range Keys {
+		if !viper.IsSet(k.Name) {
+			continue
+		}
+		if err := CheckValue(k, viper.Get(k.Name)); err != nil {
+			addValue(k.Name, err)
+		}
+	}
+
+	for _, name := range Profiles() {
+		fields, _ := Profile(name)
+		for _, field := range slices.Sorted(maps.Keys(fields)) {
+			value := fields[field]
+			key := "profiles." + name + "." + field
+			k, ok := lookupProfileField(key)
+			if !ok {
+				add(key, "unknown profile field")
+				continue
+			}
+			if s, ok := value.(string); ok {
+				if ref, _ := parseRef(s); ref != nil {
+					// Checked once resolved, when the profile is active
+					continue
+				}
+			}
+			if err := CheckValue(k, value); err != nil {
+				addValue(key, err)
+			}
+		}
+	}
+	if name := viper.GetString("profile"); name != "" && !slices.Contains(Profiles(), strings.ToLower(name)) {
+		add("profile", "no profile named %s", name)
+	}
+
+	// Options that only make sense together
+	set := func(key string) bool { return viper.GetString(key) != "" }
+	if set("remote.api_key") && set("remote.token") {
+		add("remote", "set only one of remote.api_key and remote.token")
+	}
+	if set("remote.cert") != set("remote.key") {
+		add("remote", "remote.cert and remote.key must be set together")
+	}
+	if set("auth.simple.user") != set("auth.simple.pass") {
+		add("auth.simple", "auth.simple.user and auth.simple.pass must be set together")
+	}
+	for _, key := range []string{"headless.auth", "honk.auth"} {
+		if viper.GetString(key) == "secret" && !(set("msft.tenant") && set("auth.app.id") && set("auth.app.secret")) {
+			add(key, "secret authentication needs msft.tenant, auth.app.id and auth.app.secret")
+		}
+	}
+	if set("store.key") {
+		if _, err := base64.StdEncoding.DecodeString(viper.GetString("store.key")); err != nil {
+			add("store.key", "not valid base64: %v", err)
+		}
+	}
+	if viper.IsSet("honk.concurrency") && viper.GetInt("honk.concurrency") < 1 {
+		add("honk.concurrency", "must be at least 1")
+	}
+	if _, _, err := collect.ParseWindow(viper.GetString("honk.since"), viper.GetString("honk.until"), time.Now(), time.Hour); err != nil {
+		add("honk", "%v", err)
+	}
+
+	// Sections are checked by the code reading them
+	if _, err := GetOutputOptions(); err != nil {
+		add("output", "%v", err)
+	}
+	if _, err := GetHeadlessOptions(); err != nil {
+		add("headless", "%v", err)
+	}
+	if _, err := GetAPIOptions(); err != nil {
+		add("api", "%v", err)
+	}
+	if _, err := GetTraceOptions(""); err != nil {
+		add("trace", "%v", err)
+	}
+	if _, err := GetAuditOptions(); err != nil {
+		add("audit", "%v", err)
+	}
+	if jobs, err := GetJobs(); err != nil {
+		add("schedule.jobs", "%v", err)
+	} else if _, err := schedule.New(nil, "", nil, jobs...); err != nil {
+		add("schedule.jobs", "%v", err)
+	}
+	if sinks, err := GetSinkConfigs(); err != nil {
+		add("sinks", "%v", err)
+	} else {
+		for i, c := range sinks {
+			if !slices.Contains(sinkTypes, c.Type) {
+				add("sinks", "sink %d: unknown type %q", i, c.Type)
+			}
+		}
+		for _, name := range viper.GetStringSlice("audit.sinks") {
+			if !slices.ContainsFunc(sinks, func(c sink.Config) bool { return c.Name == name }) {
+				add("audit.sinks", "no sink named %s", name)
+			}
+		}
+	}
+	return problems
+}
+
+// CheckValue checks that a value has the kind of its key, is one of its allowed values and
+// is a GUID when it must be one
+func CheckValue(k Key, value any) error {
+	if value == nil {
+		return nil
+	}
+	s := fmt.Sprint(value)
+	switch k.Kind {
+	case KindBool:
+		if _, err := strconv.ParseBool(s); err != nil {
+			return fmt.Errorf("expected true or false, got %q", s)
+		}
+	case KindInt:
+		if _, err := strconv.ParseInt(s, 10, 64); err != nil {
+			return fmt.Errorf("expected an integer, got %q", s)
+		}
+	case KindDuration:
+		if _, ok := value.(int); ok {
+			break
+		}
+		if _, err := time.ParseDuration(s); err != nil {
+			return fmt.Errorf("expected a duration such as 30s or 24h, got %q", s)
+		}
+	case KindList:
+		switch value.(type) {
+		case []any, []string, string:
+		default:
+			return fmt.Errorf("expected a list, got %q", s)
+		}
+	case KindMap:
+		if _, ok := value.(map[string]any); !ok {
+			return fmt.Errorf("expected a map, got %q", s)
+		}
+	case KindTables:
+		list, ok := value.([]any)
+		if !ok {
+			return fmt.Errorf("expected a list of tables, got %q", s)
+		}
+		for i, v := range list {
+			if _, ok := v.(map[string]any); !ok {
+				return fmt.Errorf("entry %d: expected a table, got %q", i, fmt.Sprint(v))
+			}
+		}
+	}
+
+	if s == "" {
+		return nil
+	}
+	if len(k.Values) > 0 && !slices.Contains(k.Values, s) {
+		return fmt.Errorf("expected one of %s, got %q", strings.Join(k.Values, ", "), s)
+	}
+	if k.GUID && !guidPattern.MatchString(s) {
+		return fmt.Errorf("expected a GUID such as 00000000-0000-0000-0000-000000000000, got %q", s)
+	}
+	return nil
+}
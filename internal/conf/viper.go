@@ -1,64 +1,313 @@
 package conf
 
 import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
 	"strings"
 
+	"github.com/arustydev/goslings/internal/app/api"
+	"github.com/arustydev/goslings/internal/app/headless"
+	"github.com/arustydev/goslings/internal/audit"
+	"github.com/arustydev/goslings/internal/auth"
 	"github.com/arustydev/goslings/internal/auth/shared"
+	"github.com/arustydev/goslings/internal/output"
+	"github.com/arustydev/goslings/internal/schedule"
+	"github.com/arustydev/goslings/internal/sink"
+	"github.com/arustydev/goslings/internal/utils/telemetry"
+	"github.com/arustydev/goslings/pkg/client"
 	log "github.com/sirupsen/logrus"
+	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 )
 
-func InitConfig() {
-	viper.SetConfigName("brood") // Config file name without extension
-	viper.SetConfigType("yaml")  // Config file type
-	viper.AddConfigPath(
-		".",
-	) // 1st: Look for the config file in the current directory
-	viper.AddConfigPath(
-		"./configs",
-	) // 2nd: Look for the config file in the local configs directory
-	viper.AddConfigPath(
-		"~/.config/goslings",
-	) // 3rd: Look for the config file in the home config directory
-	viper.SetConfigFile("./configs/brood.yaml")
-	/*
-	   AutomaticEnv will check for an environment variable any time a viper.Get request is made.
-	   It will apply the following rules.
-	       It will check for an environment variable with a name matching the key uppercased and prefixed with the EnvPrefix if set.
-	*/
-	viper.AutomaticEnv()
-	viper.SetEnvPrefix("env") // will be uppercased automatically
-	viper.SetEnvKeyReplacer(
-		strings.NewReplacer(".", "_"),
-	) // this is useful e.g. want to use . in Get() calls, but environmental variables to use _ delimiters (e.g. app.port -> APP_PORT)
-
-	log.Infof("Reading config file %s", viper.GetViper().ConfigFileUsed())
-	if err := viper.ReadInConfig(); err != nil {
-		log.Fatalf("Error reading config file, %s", err)
-	}
-
-	// Set up environment variable mappings if necessary
-	/*
-	   BindEnv takes one or more parameters. The first parameter is the key name, the rest are the name of the environment variables to bind to this key.
-	   If more than one are provided, they will take precedence in the specified order. The name of the environment variable is case sensitive.
-	   If the ENV variable name is not provided, then Viper will automatically assume that the ENV variable matches the following format: prefix + "_" + the key name in ALL CAPS.
-	   When you explicitly provide the ENV variable name (the second parameter), it does not automatically add the prefix.
-	       For example if the second parameter is "id", Viper will look for the ENV variable "ID".
-	*/
-	// Bind the nested keys to the DISTINCT environment variables
-	// viper.BindEnv("auth.simple.user", "GOSLING_USER")
-	// viper.BindEnv("auth.simple.pass", "GOSLING_PASS")
-	// viper.BindEnv("auth.app.id", "GOSLING_APP_ID")
-	// viper.BindEnv("auth.app.secret", "GOSLING_APP_SECRET")
-	// viper.BindEnv("msft.tenant", "GOSLING_TENANT")
-	// viper.BindEnv("msft.subscription", "GOSLING_SUBSCRIPTION")
-	// viper.BindEnv("msft.usgov.cloud", "GOSLING_USGOV_CLOUD")
-	// viper.BindEnv("msft.usgov.exo", "GOSLING_USGOV_EXO")
-	// viper.BindEnv("msft.m365auth", "GOSLING_M365_AUTH")
-	// viper.BindEnv("msft.msgtrace", "GOSLING_EXO_MSG_TRACE")
-
-	viper.SetDefault("author", "Adam Smith <developer@gh.arusty.dev>")
-	viper.SetDefault("license", "agpl3")
+// DefaultConfigFile is the project config file, where conf init writes the configuration
+const DefaultConfigFile = "./configs/brood.yaml"
+
+// envPrefix prefixes the environment variable of every key, see EnvVar
+const envPrefix = "gosling"
+
+// ConfigEnv names a config file, like --config, for the binaries without flags
+const ConfigEnv = "GOSLING_CONFIG"
+
+// InitConfig loads the configuration, exiting when a config file cannot be read
+func InitConfig(file string) {
+	if err := LoadConfig(file); err != nil {
+		log.Fatalf("%v, check it with gosling conf validate", err)
+	}
+}
+
+// LoadConfig loads the configuration in layers, each overriding the previous one: defaults,
+// the system, user and project config files, file (the --config flag, or ConfigEnv when
+// empty), the active profile, the environment and flags bound with BindFlag. Missing config
+// files are skipped but file must exist. Secret references in the files, such as
+// vault://path#key, are resolved as they are read, see RegisterResolver.
+func LoadConfig(file string) error {
+	c, err := readConfig(file)
+	c.apply()
+	return err
+}
+
+// readConfig reads the config files and the active profile, on error it returns what was
+// read until then
+func readConfig(file string) (*config, error) {
+	if file == "" {
+		file = os.Getenv(ConfigEnv)
+	}
+	c := &config{file: file, refs: map[string]string{}}
+	// The files are merged on a viper of their own, which picks the profile
+	v := viper.New()
+	configure(v)
+	for _, l := range searchPaths() {
+		if _, err := os.Stat(l.Path); err != nil {
+			continue
+		}
+		if err := c.readLayer(v, l); err != nil {
+			return c, err
+		}
+	}
+	if file != "" {
+		if err := c.readLayer(v, Layer{Source: SourceConfig, Path: expandHome(file)}); err != nil {
+			return c, err
+		}
+	}
+	if len(c.layers) == 0 {
+		log.Warnf("No config file found at %s, using the environment and defaults; create one with gosling conf init", DefaultConfigFile)
+	}
+	return c, c.readProfile(v)
+}
+
+// configure registers the defaults of the keys with v, their environment variables and the
+// flags bound with BindFlag
+func configure(v *viper.Viper) {
+	for _, k := range Keys {
+		if k.Default != nil {
+			v.SetDefault(k.Name, k.Default)
+		}
+	}
+
+	// Every key is read from GOSLING_<KEY>, eg. GOSLING_MSFT_TENANT, and some from the
+	// shorter variable of their AuthParams field too
+	v.SetEnvPrefix(envPrefix)
+	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	v.AutomaticEnv()
+	for _, k := range Keys {
+		if k.Env != "" {
+			_ = v.BindEnv(k.Name, EnvVar(k.Name), k.Env)
+		}
+	}
+	for key, flag := range flags {
+		_ = v.BindPFlag(key, flag)
+	}
+}
+
+// apply makes c the configuration viper returns, replacing the files and profile of the one
+// applied before
+func (c *config) apply() {
+	configure(viper.GetViper())
+	// Reading an empty config drops the previous files, keeping the defaults, environment,
+	// flags and values set by the code
+	viper.SetConfigType("yaml")
+	_ = viper.ReadConfig(strings.NewReader(""))
+	for _, l := range c.layers {
+		_ = viper.MergeConfigMap(copyMap(l.settings))
+	}
+	if c.profile.settings != nil {
+		_ = viper.MergeConfigMap(copyMap(c.profile.settings))
+	}
+	loaded = *c
+}
+
+// EnvVar returns the environment variable setting a key
+func EnvVar(key string) string {
+	return strings.ToUpper(envPrefix + "_" + strings.ReplaceAll(key, ".", "_"))
+}
+
+// AddFlags adds a flag for every key that has one, bound to its key
+func AddFlags(flags *pflag.FlagSet) {
+	for _, k := range Keys {
+		if k.Flag == "" {
+			continue
+		}
+		usage := fmt.Sprintf("%s (%s)", k.Help, k.Name)
+		if k.Kind == KindBool {
+			flags.Bool(k.Flag, false, usage)
+		} else {
+			flags.String(k.Flag, "", usage)
+		}
+		BindFlag(k.Name, flags.Lookup(k.Flag))
+	}
+}
+
+// GetLogOptions extracts how the process logs, in format unless log.format is set
+func GetLogOptions(format telemetry.Format) (telemetry.LogOptions, error) {
+	opts := telemetry.LogOptions{
+		Level:      viper.GetString("log.level"),
+		Format:     telemetry.Format(viper.GetString("log.format")),
+		File:       viper.GetString("log.file"),
+		MaxSize:    viper.GetInt64("log.max_size"),
+		MaxBackups: viper.GetInt("log.max_backups"),
+	}
+	if opts.Format == "" {
+		opts.Format = format
+	}
+	return opts, opts.Validate()
+}
+
+// GetTraceOptions extracts how the spans of a service, such as goslings-headless, are exported
+func GetTraceOptions(service string) (telemetry.TraceOptions, error) {
+	opts := telemetry.TraceOptions{
+		Exporter: telemetry.Exporter(viper.GetString("trace.exporter")),
+		Endpoint: viper.GetString("trace.endpoint"),
+		File:     viper.GetString("trace.file"),
+		Service:  service,
+	}
+	return opts, opts.Validate()
+}
+
+// GetAuditOptions extracts where the audit log is written and the sinks it is forwarded to
+func GetAuditOptions() (audit.Options, error) {
+	opts := audit.Options{
+		File:  viper.GetString("audit.file"),
+		Sinks: viper.GetStringSlice("audit.sinks"),
+	}
+	return opts, opts.Validate()
+}
+
+// ConfigureAudit opens the audit log, forwarding it to the sinks it names; the returned func
+// closes it
+func ConfigureAudit() (func(), error) {
+	opts, err := GetAuditOptions()
+	if err != nil {
+		return nil, err
+	}
+	if len(opts.Sinks) > 0 {
+		configs, err := GetSinkConfigs()
+		if err != nil {
+			return nil, err
+		}
+		if opts.Forwarder, err = sink.NewAuditForwarder(configs, opts.Sinks); err != nil {
+			return nil, err
+		}
+	}
+	return audit.Configure(opts)
+}
+
+// GetOutputOptions extracts how collected records are written
+func GetOutputOptions() (output.Options, error) {
+	opts := output.Options{
+		Format:        output.Format(viper.GetString("output.format")),
+		Compression:   output.Compression(viper.GetString("output.compression")),
+		RotateBytes:   viper.GetInt64("output.rotate.bytes"),
+		RotateRecords: viper.GetInt64("output.rotate.records"),
+	}
+	return opts, opts.Validate()
+}
+
+// GetHeadlessOptions extracts the headless daemon options; keys are read one by one so that
+// each can be set from the environment
+func GetHeadlessOptions() (headless.Options, error) {
+	opts := headless.Options{
+		Datasets:        viper.GetStringSlice("headless.datasets"),
+		Since:           viper.GetDuration("headless.since"),
+		Out:             viper.GetString("headless.out"),
+		Full:            viper.GetBool("headless.full"),
+		Interval:        viper.GetDuration("headless.interval"),
+		Auth:            viper.GetString("headless.auth"),
+		ShutdownTimeout: viper.GetDuration("headless.shutdown_timeout"),
+		MetricsAddress:  viper.GetString("headless.metrics_address"),
+	}
+	return opts, opts.Validate()
+}
+
+// GetAPIOptions extracts the API server's options, filling in defaults
+func GetAPIOptions() (api.Options, error) {
+	var opts api.Options
+	if err := viper.UnmarshalKey("api", &opts); err != nil {
+		return opts, fmt.Errorf("failed to decode api: %w", err)
+	}
+	return opts, opts.Validate()
+}
+
+// GetRemoteOptions extracts how to reach a remote API server, its URL is empty when the
+// tools run locally; keys are read one by one so that each can be set from the environment
+func GetRemoteOptions() client.Options {
+	return client.Options{
+		URL:    viper.GetString("remote.url"),
+		APIKey: viper.GetString("remote.api_key"),
+		Token:  viper.GetString("remote.token"),
+		CA:     viper.GetString("remote.ca"),
+		Cert:   viper.GetString("remote.cert"),
+		Key:    viper.GetString("remote.key"),
+	}
+}
+
+// GetJobs extracts the scheduled collection jobs, an empty list disables the scheduler
+func GetJobs() ([]schedule.Job, error) {
+	var jobs []schedule.Job
+	if err := viper.UnmarshalKey("schedule.jobs", &jobs); err != nil {
+		return nil, fmt.Errorf("failed to decode schedule.jobs: %w", err)
+	}
+	return jobs, nil
+}
+
+// GetSinkConfigs extracts the sinks runs are shipped to, an empty list disables shipping
+func GetSinkConfigs() ([]sink.Config, error) {
+	var sinks []sink.Config
+	if err := viper.UnmarshalKey("sinks", &sinks); err != nil {
+		return nil, fmt.Errorf("failed to decode sinks: %w", err)
+	}
+	for i := range sinks {
+		if err := sinks[i].Validate(); err != nil {
+			return nil, fmt.Errorf("sink %d: %w", i, err)
+		}
+	}
+	return sinks, nil
+}
+
+// GetStoreOptions builds the credential store options, loading the encryption key from
+// store.key (base64) or store.keyfile; a missing keyfile is generated
+func GetStoreOptions() (auth.Options, error) {
+	opts := auth.Options{
+		StoreType: shared.StoreType(viper.GetString("store.type")),
+		StorePath: viper.GetString("store.path"),
+		Tenant:    viper.GetString("msft.tenant"),
+	}
+	if opts.StoreType == shared.MemoryStore {
+		return opts, nil
+	}
+
+	if encoded := viper.GetString("store.key"); encoded != "" {
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return opts, fmt.Errorf("failed to decode store.key: %w", err)
+		}
+		opts.EncryptionKey = key
+		return opts, nil
+	}
+
+	keyfile := viper.GetString("store.keyfile")
+	key, err := os.ReadFile(keyfile)
+	if errors.Is(err, os.ErrNotExist) {
+		log.Infof("Generating a new store encryption key at %s", keyfile)
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return opts, fmt.Errorf("failed to generate encryption key: %w", err)
+		}
+		if err := os.MkdirAll(filepath.Dir(keyfile), 0o700); err != nil {
+			return opts, fmt.Errorf("failed to create directory for keyfile: %w", err)
+		}
+		if err := os.WriteFile(keyfile, key, 0o600); err != nil {
+			return opts, fmt.Errorf("failed to write keyfile: %w", err)
+		}
+	} else if err != nil {
+		return opts, fmt.Errorf("failed to read keyfile: %w", err)
+	}
+	opts.EncryptionKey = key
+	return opts, nil
 }
 
 // getConfigFromViper simulates getting config from a viper-based config package
@@ -0,0 +1,71 @@
+package output
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// frameWriter is a compressor whose Close ends a self-contained frame. Concatenated gzip
+// members and zstd frames decompress as one stream, so a part can be truncated back to the
+// end of any frame and appended to.
+type frameWriter interface {
+	io.WriteCloser
+}
+
+// extension returns the file extension of a compression
+func extension(c Compression) string {
+	switch c {
+	case Gzip:
+		return ".gz"
+	case Zstd:
+		return ".zst"
+	default:
+		return ""
+	}
+}
+
+// newFrame starts a new compression frame on w
+func newFrame(c Compression, w io.Writer) (frameWriter, error) {
+	switch c {
+	case Gzip:
+		return gzip.NewWriter(w), nil
+	case Zstd:
+		enc, err := zstd.NewWriter(w)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create zstd encoder: %w", err)
+		}
+		return enc, nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedCompression, c)
+	}
+}
+
+// nopFrame passes writes through uncompressed
+type nopFrame struct {
+	io.Writer
+}
+
+func (nopFrame) Close() error {
+	return nil
+}
+
+// NewReader returns a reader that decompresses a file written with compression c
+func NewReader(c Compression, r io.Reader) (io.ReadCloser, error) {
+	switch c {
+	case Gzip:
+		return gzip.NewReader(r)
+	case Zstd:
+		dec, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create zstd decoder: %w", err)
+		}
+		return dec.IOReadCloser(), nil
+	case None, "":
+		return io.NopCloser(r), nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedCompression, c)
+	}
+}
@@ -0,0 +1,213 @@
+package output
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// parquetBatchSize is the number of rows buffered before being handed to the parquet writer
+const parquetBatchSize = 1024
+
+// compactLine returns a record compacted onto a single, newline terminated line
+func compactLine(rec json.RawMessage) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, rec); err != nil {
+		return nil, fmt.Errorf("failed to compact record: %w", err)
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes(), nil
+}
+
+// Flatten converts a record into columns: nested objects become dotted column names, arrays
+// are kept as JSON and null values are omitted
+func Flatten(rec json.RawMessage) (map[string]string, error) {
+	dec := json.NewDecoder(bytes.NewReader(rec))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, fmt.Errorf("failed to decode record: %w", err)
+	}
+
+	out := make(map[string]string)
+	if obj, ok := v.(map[string]any); ok {
+		flattenInto(out, "", obj)
+	} else if s, ok := scalar(v); ok {
+		out["value"] = s
+	}
+	return out, nil
+}
+
+func flattenInto(out map[string]string, prefix string, obj map[string]any) {
+	for key, v := range obj {
+		name := key
+		if prefix != "" {
+			name = prefix + "." + key
+		}
+		if nested, ok := v.(map[string]any); ok {
+			flattenInto(out, name, nested)
+			continue
+		}
+		if s, ok := scalar(v); ok {
+			out[name] = s
+		}
+	}
+}
+
+// scalar renders a decoded JSON value as a column value; false for null
+func scalar(v any) (string, bool) {
+	switch t := v.(type) {
+	case nil:
+		return "", false
+	case string:
+		return t, true
+	case json.Number:
+		return t.String(), true
+	case bool:
+		return strconv.FormatBool(t), true
+	default:
+		b, _ := json.Marshal(t)
+		return string(b), true
+	}
+}
+
+// eachSpooled calls fn with every flattened record of a spool file
+func eachSpooled(spool string, fn func(map[string]string) error) error {
+	f, err := os.Open(spool)
+	if err != nil {
+		return fmt.Errorf("failed to open spool: %w", err)
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			row, ferr := Flatten(line)
+			if ferr != nil {
+				return ferr
+			}
+			if ferr := fn(row); ferr != nil {
+				return ferr
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read spool: %w", err)
+		}
+	}
+}
+
+// spoolColumns returns the sorted union of the columns of every spooled record
+func spoolColumns(spool string) ([]string, error) {
+	seen := make(map[string]struct{})
+	err := eachSpooled(spool, func(row map[string]string) error {
+		for col := range row {
+			seen[col] = struct{}{}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	columns := make([]string, 0, len(seen))
+	for col := range seen {
+		columns = append(columns, col)
+	}
+	sort.Strings(columns)
+	return columns, nil
+}
+
+// writeCSV writes the spooled records as CSV with a header row
+func writeCSV(w io.Writer, spool string, columns []string, c Compression) error {
+	var frame frameWriter = nopFrame{w}
+	if c != None {
+		var err error
+		if frame, err = newFrame(c, w); err != nil {
+			return err
+		}
+	}
+
+	cw := csv.NewWriter(frame)
+	if err := cw.Write(columns); err != nil {
+		return fmt.Errorf("failed to write csv header: %w", err)
+	}
+	record := make([]string, len(columns))
+	err := eachSpooled(spool, func(row map[string]string) error {
+		for i, col := range columns {
+			record[i] = row[col]
+		}
+		return cw.Write(record)
+	})
+	if err != nil {
+		return err
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return fmt.Errorf("failed to write csv: %w", err)
+	}
+	return frame.Close()
+}
+
+// writeParquet writes the spooled records as a Parquet file of optional string columns
+func writeParquet(w io.Writer, spool string, columns []string, c Compression) error {
+	group := parquet.Group{}
+	for _, col := range columns {
+		group[col] = parquet.Optional(parquet.String())
+	}
+	opts := []parquet.WriterOption{parquet.NewSchema("record", group)}
+	switch c {
+	case Gzip:
+		opts = append(opts, parquet.Compression(&parquet.Gzip))
+	case Zstd:
+		opts = append(opts, parquet.Compression(&parquet.Zstd))
+	}
+	pw := parquet.NewWriter(w, opts...)
+
+	// Leaf columns of a group are ordered by name, which is the order of columns
+	rows := make([]parquet.Row, 0, parquetBatchSize)
+	flush := func() error {
+		if _, err := pw.WriteRows(rows); err != nil {
+			return fmt.Errorf("failed to write parquet rows: %w", err)
+		}
+		rows = rows[:0]
+		return nil
+	}
+	err := eachSpooled(spool, func(rec map[string]string) error {
+		row := make(parquet.Row, len(columns))
+		for i, col := range columns {
+			if v, ok := rec[col]; ok {
+				row[i] = parquet.ByteArrayValue([]byte(v)).Level(0, 1, i)
+			} else {
+				row[i] = parquet.Value{}.Level(0, 0, i)
+			}
+		}
+		rows = append(rows, row)
+		if len(rows) == parquetBatchSize {
+			return flush()
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+	if err := pw.Close(); err != nil {
+		return fmt.Errorf("failed to close parquet writer: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,393 @@
+// Package output provides the writers that persist collected records to files
+package output
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/arustydev/goslings/pkg/apiv1"
+)
+
+// Common errors
+var (
+	ErrUnsupportedFormat      = errors.New("unsupported output format")
+	ErrUnsupportedCompression = errors.New("unsupported output compression")
+)
+
+// Format is the file format records are written in
+type Format = apiv1.Format
+
+const (
+	JSONL   = apiv1.JSONL
+	CSV     = apiv1.CSV
+	Parquet = apiv1.Parquet
+)
+
+// Compression is applied to output files; Parquet files use it as their column codec
+type Compression = apiv1.Compression
+
+const (
+	None = apiv1.None
+	Gzip = apiv1.Gzip
+	Zstd = apiv1.Zstd
+)
+
+// Options selects how a dataset is written
+type Options struct {
+	// Format is the file format, JSONL when empty
+	Format Format `json:"format"`
+
+	// Compression is applied to every part, None when empty
+	Compression Compression `json:"compression"`
+
+	// RotateBytes starts a new part once a part grows past this many bytes on disk, after
+	// compression; CSV and Parquet parts are measured on their JSON Lines spool. 0 disables it
+	RotateBytes int64 `json:"rotate_bytes,omitempty"`
+
+	// RotateRecords starts a new part once a part holds this many records, 0 disables it
+	RotateRecords int64 `json:"rotate_records,omitempty"`
+}
+
+// Validate checks the options, filling in defaults
+func (o *Options) Validate() error {
+	if o.Format == "" {
+		o.Format = JSONL
+	}
+	if o.Compression == "" {
+		o.Compression = None
+	}
+	switch o.Format {
+	case JSONL, CSV, Parquet:
+	default:
+		return fmt.Errorf("%w: %s", ErrUnsupportedFormat, o.Format)
+	}
+	switch o.Compression {
+	case None, Gzip, Zstd:
+	default:
+		return fmt.Errorf("%w: %s", ErrUnsupportedCompression, o.Compression)
+	}
+	return nil
+}
+
+// Position is a durable point in a dataset's output that a writer can be reopened at
+type Position struct {
+	// Part is the index of the part being written
+	Part int `json:"part"`
+
+	// File is the name of the file being written, relative to the output directory
+	File string `json:"file"`
+
+	// Offset is the length of File at the position
+	Offset int64 `json:"offset"`
+}
+
+// Writer writes the records of a dataset into one or more part files
+type Writer interface {
+	// Write appends a record
+	Write(rec json.RawMessage) error
+
+	// Checkpoint makes every record written so far durable and returns the position to
+	// reopen the writer at. Parts are only rotated at checkpoints.
+	Checkpoint() (Position, error)
+
+	// Close finishes the current part
+	Close() error
+
+	// Abandon closes the writer without finishing the current part, so that it can be
+	// reopened at the last checkpoint
+	Abandon() error
+
+	// Files returns the finished part files, relative to the output directory
+	Files() []string
+}
+
+// partWriter implements Writer for every format. JSONL is written straight to the part
+// file; CSV and Parquet need every column of a part up front, so their records are spooled
+// as JSON Lines and converted when the part is finished.
+type partWriter struct {
+	dir     string
+	dataset string
+	opts    Options
+
+	part    int
+	records int64
+	f       *os.File
+	counter *countingWriter
+	buf     *bufio.Writer
+	frame   frameWriter
+	rotate  bool
+	stale   []string
+	files   []string
+}
+
+// Open opens the writer of a dataset in dir at pos; the zero Position starts a new output.
+// Anything written after pos by an earlier writer is discarded.
+func Open(dir, dataset string, opts Options, pos Position) (Writer, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	w := &partWriter{dir: dir, dataset: dataset, opts: opts, part: pos.Part}
+
+	// Parts after the position were started after the last checkpoint
+	for part := pos.Part + 1; ; part++ {
+		final, spool := w.path(w.finalName(part)), w.path(w.spoolName(part))
+		if !exists(final) && !exists(spool) {
+			break
+		}
+		_ = os.Remove(final)
+		_ = os.Remove(spool)
+	}
+	for part := range pos.Part {
+		w.files = append(w.files, w.finalName(part))
+	}
+	if w.spooled() {
+		// A finished part may have been converted after the last checkpoint
+		_ = os.Remove(w.path(w.finalName(pos.Part)))
+	}
+
+	if err := w.openPart(pos.Offset); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// spooled reports whether records are spooled before being converted
+func (w *partWriter) spooled() bool {
+	return w.opts.Format != JSONL
+}
+
+// finalName returns the name of a finished part file
+func (w *partWriter) finalName(part int) string {
+	ext := "." + string(w.opts.Format)
+	if w.opts.Format != Parquet {
+		ext += extension(w.opts.Compression)
+	}
+	if part == 0 {
+		return w.dataset + ext
+	}
+	return fmt.Sprintf("%s.%04d%s", w.dataset, part, ext)
+}
+
+// spoolName returns the name of a part's spool file
+func (w *partWriter) spoolName(part int) string {
+	return fmt.Sprintf("%s.%04d.spool.jsonl", w.dataset, part)
+}
+
+// currentName returns the name of the file being written
+func (w *partWriter) currentName() string {
+	if w.spooled() {
+		return w.spoolName(w.part)
+	}
+	return w.finalName(w.part)
+}
+
+func (w *partWriter) path(name string) string {
+	return filepath.Join(w.dir, name)
+}
+
+// openPart opens the current part's file, truncated to offset
+func (w *partWriter) openPart(offset int64) error {
+	f, err := os.OpenFile(w.path(w.currentName()), os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open output file: %w", err)
+	}
+	if err := f.Truncate(offset); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to truncate output file: %w", err)
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to seek output file: %w", err)
+	}
+	w.f = f
+	w.counter = &countingWriter{w: f, n: offset}
+	w.buf = bufio.NewWriter(w.counter)
+	w.records = 0
+	return nil
+}
+
+// Write implements Writer.Write for partWriter
+func (w *partWriter) Write(rec json.RawMessage) error {
+	line, err := compactLine(rec)
+	if err != nil {
+		return err
+	}
+
+	var out io.Writer = w.buf
+	if !w.spooled() && w.opts.Compression != None {
+		if w.frame == nil {
+			if w.frame, err = newFrame(w.opts.Compression, w.buf); err != nil {
+				return err
+			}
+		}
+		out = w.frame
+	}
+	if _, err := out.Write(line); err != nil {
+		return fmt.Errorf("failed to write record: %w", err)
+	}
+
+	w.records++
+	if w.opts.RotateRecords > 0 && w.records >= w.opts.RotateRecords {
+		w.rotate = true
+	}
+	return nil
+}
+
+// sync ends the current compression frame and flushes the part to disk
+func (w *partWriter) sync() error {
+	if w.frame != nil {
+		if err := w.frame.Close(); err != nil {
+			return fmt.Errorf("failed to finish compression frame: %w", err)
+		}
+		w.frame = nil
+	}
+	if err := w.buf.Flush(); err != nil {
+		return fmt.Errorf("failed to flush output file: %w", err)
+	}
+	if err := w.f.Sync(); err != nil {
+		return fmt.Errorf("failed to sync output file: %w", err)
+	}
+	return nil
+}
+
+// Checkpoint implements Writer.Checkpoint for partWriter
+func (w *partWriter) Checkpoint() (Position, error) {
+	if err := w.sync(); err != nil {
+		return Position{}, err
+	}
+
+	// Spools of finished parts are kept until a checkpoint past them has been taken
+	for _, name := range w.stale {
+		_ = os.Remove(w.path(name))
+	}
+	w.stale = nil
+
+	// Sizes are compared once the frame is finished, so they count the compressed bytes on disk
+	if w.opts.RotateBytes > 0 && w.counter.n >= w.opts.RotateBytes {
+		w.rotate = true
+	}
+	if w.rotate {
+		if err := w.finishPart(); err != nil {
+			return Position{}, err
+		}
+		w.part++
+		w.rotate = false
+		if err := w.openPart(0); err != nil {
+			return Position{}, err
+		}
+	}
+
+	return Position{Part: w.part, File: w.currentName(), Offset: w.counter.n}, nil
+}
+
+// finishPart closes the current part, converting its spool if needed
+func (w *partWriter) finishPart() error {
+	if !w.spooled() && w.counter.n == 0 && w.opts.Compression != None {
+		// An empty part is still a valid compressed file
+		frame, err := newFrame(w.opts.Compression, w.buf)
+		if err != nil {
+			return err
+		}
+		w.frame = frame
+		if err := w.sync(); err != nil {
+			return err
+		}
+	}
+	if err := w.f.Close(); err != nil {
+		return fmt.Errorf("failed to close output file: %w", err)
+	}
+
+	if w.spooled() {
+		if err := w.convert(); err != nil {
+			return err
+		}
+		w.stale = append(w.stale, w.spoolName(w.part))
+	}
+	w.files = append(w.files, w.finalName(w.part))
+	return nil
+}
+
+// convert writes the current part's spool in the final format
+func (w *partWriter) convert() error {
+	spool := w.path(w.spoolName(w.part))
+	final := w.path(w.finalName(w.part))
+
+	columns, err := spoolColumns(spool)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(final, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to create output file: %w", err)
+	}
+	defer f.Close()
+
+	switch w.opts.Format {
+	case CSV:
+		err = writeCSV(f, spool, columns, w.opts.Compression)
+	case Parquet:
+		err = writeParquet(f, spool, columns, w.opts.Compression)
+	}
+	if err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("failed to sync output file: %w", err)
+	}
+	return nil
+}
+
+// Close implements Writer.Close for partWriter
+func (w *partWriter) Close() error {
+	if err := w.sync(); err != nil {
+		return err
+	}
+
+	// A part opened by a rotation that never received a record is dropped
+	if w.part > 0 && w.counter.n == 0 {
+		w.f.Close()
+		if err := os.Remove(w.path(w.currentName())); err != nil {
+			return err
+		}
+	} else if err := w.finishPart(); err != nil {
+		return err
+	}
+	for _, name := range w.stale {
+		_ = os.Remove(w.path(name))
+	}
+	w.stale = nil
+	return nil
+}
+
+// Abandon implements Writer.Abandon for partWriter
+func (w *partWriter) Abandon() error {
+	return w.f.Close()
+}
+
+// Files implements Writer.Files for partWriter
+func (w *partWriter) Files() []string {
+	return append([]string(nil), w.files...)
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -0,0 +1,211 @@
+package output
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readAll(t *testing.T, path string, c Compression) string {
+	t.Helper()
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	r, err := NewReader(c, f)
+	require.NoError(t, err)
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestFlatten(t *testing.T) {
+	row, err := Flatten(json.RawMessage(`{"id":"1","n":2.50,"ok":true,"gone":null,"a":{"b":{"c":"d"}},"list":[1,"x"]}`))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"id":    "1",
+		"n":     "2.50",
+		"ok":    "true",
+		"a.b.c": "d",
+		"list":  `[1,"x"]`,
+	}, row)
+}
+
+func TestJSONLResumeDiscardsUncheckpointedRecords(t *testing.T) {
+	for _, c := range []Compression{None, Gzip, Zstd} {
+		t.Run(string(c), func(t *testing.T) {
+			dir := t.TempDir()
+			opts := Options{Compression: c}
+
+			w, err := Open(dir, "ds", opts, Position{})
+			require.NoError(t, err)
+			require.NoError(t, w.Write(json.RawMessage("{\n \"id\": 1\n}")))
+			pos, err := w.Checkpoint()
+			require.NoError(t, err)
+			// Written after the checkpoint, then the process dies
+			require.NoError(t, w.Write(json.RawMessage(`{"id":2}`)))
+			_, err = w.Checkpoint()
+			require.NoError(t, err)
+
+			w, err = Open(dir, "ds", opts, pos)
+			require.NoError(t, err)
+			require.NoError(t, w.Write(json.RawMessage(`{"id":3}`)))
+			require.NoError(t, w.Close())
+
+			assert.Equal(t, []string{"ds.jsonl" + extension(c)}, w.Files())
+			assert.Equal(t, "{\"id\":1}\n{\"id\":3}\n", readAll(t, filepath.Join(dir, w.Files()[0]), c))
+		})
+	}
+}
+
+func TestRotationByRecords(t *testing.T) {
+	dir := t.TempDir()
+	w, err := Open(dir, "ds", Options{RotateRecords: 2}, Position{})
+	require.NoError(t, err)
+	for range 5 {
+		require.NoError(t, w.Write(json.RawMessage(`{"id":1}`)))
+		_, err := w.Checkpoint()
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+	assert.Equal(t, []string{"ds.jsonl", "ds.0001.jsonl", "ds.0002.jsonl"}, w.Files())
+}
+
+func TestRotationByCompressedBytes(t *testing.T) {
+	// Every page holds one record of the same length, so every page has the same size on disk
+	page := func(w Writer, i int) {
+		require.NoError(t, w.Write(json.RawMessage(fmt.Sprintf(`{"id":"%04d"}`, i))))
+		_, err := w.Checkpoint()
+		require.NoError(t, err)
+	}
+
+	dir := t.TempDir()
+	one, err := Open(dir, "one", Options{Compression: Zstd}, Position{})
+	require.NoError(t, err)
+	page(one, 0)
+	require.NoError(t, one.Close())
+	info, err := os.Stat(filepath.Join(dir, "one.jsonl.zst"))
+	require.NoError(t, err)
+	size := info.Size()
+
+	w, err := Open(dir, "ds", Options{Compression: Zstd, RotateBytes: 10 * size}, Position{})
+	require.NoError(t, err)
+	for i := range 50 {
+		page(w, i)
+	}
+	require.NoError(t, w.Close())
+
+	assert.Equal(t, []string{"ds.jsonl.zst", "ds.0001.jsonl.zst", "ds.0002.jsonl.zst", "ds.0003.jsonl.zst", "ds.0004.jsonl.zst"}, w.Files())
+	for _, name := range w.Files() {
+		info, err := os.Stat(filepath.Join(dir, name))
+		require.NoError(t, err)
+		assert.Equal(t, 10*size, info.Size(), name)
+	}
+}
+
+func TestCSVFlattensEveryColumn(t *testing.T) {
+	dir := t.TempDir()
+	w, err := Open(dir, "ds", Options{Format: CSV, Compression: Gzip}, Position{})
+	require.NoError(t, err)
+	require.NoError(t, w.Write(json.RawMessage(`{"id":"1","user":{"name":"a"}}`)))
+	require.NoError(t, w.Write(json.RawMessage(`{"id":"2","extra":true}`)))
+	require.NoError(t, w.Close())
+
+	require.Equal(t, []string{"ds.csv.gz"}, w.Files())
+	rows, err := csv.NewReader(strings.NewReader(readAll(t, filepath.Join(dir, "ds.csv.gz"), Gzip))).ReadAll()
+	require.NoError(t, err)
+	assert.Equal(t, [][]string{
+		{"extra", "id", "user.name"},
+		{"", "1", "a"},
+		{"true", "2", ""},
+	}, rows)
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1, "spool files are removed")
+}
+
+func TestRotatedCSVRemovesSpools(t *testing.T) {
+	dir := t.TempDir()
+	w, err := Open(dir, "ds", Options{Format: CSV, RotateRecords: 2}, Position{})
+	require.NoError(t, err)
+	require.NoError(t, w.Write(json.RawMessage(`{"id":"1"}`)))
+	require.NoError(t, w.Write(json.RawMessage(`{"id":"2"}`)))
+	_, err = w.Checkpoint()
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	assert.Equal(t, []string{"ds.csv"}, w.Files())
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1, "spool files are removed")
+	assert.Equal(t, "ds.csv", entries[0].Name())
+}
+
+func TestParquetRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	w, err := Open(dir, "ds", Options{Format: Parquet, Compression: Zstd}, Position{})
+	require.NoError(t, err)
+	require.NoError(t, w.Write(json.RawMessage(`{"id":"1","user":{"name":"a"}}`)))
+	require.NoError(t, w.Write(json.RawMessage(`{"id":"2"}`)))
+	require.NoError(t, w.Close())
+
+	f, err := os.Open(filepath.Join(dir, "ds.parquet"))
+	require.NoError(t, err)
+	defer f.Close()
+	stat, err := f.Stat()
+	require.NoError(t, err)
+
+	pf, err := parquet.OpenFile(f, stat.Size())
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), pf.NumRows())
+	assert.Equal(t, [][]string{{"id"}, {"user.name"}}, pf.Schema().Columns())
+
+	rows := make([]parquet.Row, 2)
+	reader := parquet.NewReader(pf)
+	n, err := reader.ReadRows(rows)
+	if err != io.EOF {
+		require.NoError(t, err)
+	}
+	require.Equal(t, 2, n)
+	assert.Equal(t, "1", rows[0][0].String())
+	assert.Equal(t, "a", rows[0][1].String())
+	assert.True(t, rows[1][1].IsNull())
+}
+
+func TestReadRecordsDetectsFormat(t *testing.T) {
+	for _, opts := range []Options{
+		{Compression: Zstd},
+		{Format: CSV, Compression: Gzip},
+		{Format: Parquet},
+	} {
+		t.Run(string(opts.Format), func(t *testing.T) {
+			dir := t.TempDir()
+			w, err := Open(dir, "ds", opts, Position{})
+			require.NoError(t, err)
+			require.NoError(t, w.Write(json.RawMessage(`{"id":"1","user":{"name":"a"}}`)))
+			require.NoError(t, w.Write(json.RawMessage(`{"id":"2"}`)))
+			require.NoError(t, w.Close())
+
+			var got []string
+			for rec, err := range ReadRecords(filepath.Join(dir, w.Files()[0])) {
+				require.NoError(t, err)
+				got = append(got, string(rec))
+			}
+			if opts.Format == "" {
+				assert.Equal(t, []string{`{"id":"1","user":{"name":"a"}}`, `{"id":"2"}`}, got)
+			} else {
+				assert.Equal(t, []string{`{"id":"1","user.name":"a"}`, `{"id":"2"}`}, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,174 @@
+package output
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"os"
+	"strings"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// DetectOptions infers the format and compression of an output file from its name
+func DetectOptions(name string) (Options, error) {
+	opts := Options{Format: JSONL, Compression: None}
+	switch {
+	case strings.HasSuffix(name, ".gz"):
+		opts.Compression = Gzip
+		name = strings.TrimSuffix(name, ".gz")
+	case strings.HasSuffix(name, ".zst"):
+		opts.Compression = Zstd
+		name = strings.TrimSuffix(name, ".zst")
+	}
+	switch {
+	case strings.HasSuffix(name, ".jsonl"):
+		opts.Format = JSONL
+	case strings.HasSuffix(name, ".csv"):
+		opts.Format = CSV
+	case strings.HasSuffix(name, ".parquet"):
+		opts.Format = Parquet
+	default:
+		return opts, fmt.Errorf("%w: %s", ErrUnsupportedFormat, name)
+	}
+	return opts, nil
+}
+
+// ReadRecords iterates over the records of an output file. CSV and Parquet records are
+// returned as flat objects keyed by column, without their empty columns.
+func ReadRecords(path string) iter.Seq2[json.RawMessage, error] {
+	return func(yield func(json.RawMessage, error) bool) {
+		opts, err := DetectOptions(path)
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			yield(nil, fmt.Errorf("failed to open output file: %w", err))
+			return
+		}
+		defer f.Close()
+
+		switch opts.Format {
+		case JSONL:
+			r, err := NewReader(opts.Compression, f)
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			defer r.Close()
+			readJSONL(r, yield)
+		case CSV:
+			r, err := NewReader(opts.Compression, f)
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			defer r.Close()
+			readCSV(r, yield)
+		case Parquet:
+			readParquet(f, yield)
+		}
+	}
+}
+
+func readJSONL(r io.Reader, yield func(json.RawMessage, error) bool) {
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadBytes('\n')
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			if !yield(json.RawMessage(line), nil) {
+				return
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return
+		}
+		if err != nil {
+			yield(nil, fmt.Errorf("failed to read output file: %w", err))
+			return
+		}
+	}
+}
+
+func readCSV(r io.Reader, yield func(json.RawMessage, error) bool) {
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if errors.Is(err, io.EOF) {
+		return
+	}
+	if err != nil {
+		yield(nil, fmt.Errorf("failed to read csv header: %w", err))
+		return
+	}
+	for {
+		row, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return
+		}
+		if err != nil {
+			yield(nil, fmt.Errorf("failed to read csv: %w", err))
+			return
+		}
+		rec := make(map[string]string, len(header))
+		for i, col := range header {
+			if i < len(row) && row[i] != "" {
+				rec[col] = row[i]
+			}
+		}
+		data, err := json.Marshal(rec)
+		if !yield(data, err) {
+			return
+		}
+	}
+}
+
+func readParquet(f *os.File, yield func(json.RawMessage, error) bool) {
+	stat, err := f.Stat()
+	if err != nil {
+		yield(nil, fmt.Errorf("failed to stat output file: %w", err))
+		return
+	}
+	pf, err := parquet.OpenFile(f, stat.Size())
+	if err != nil {
+		yield(nil, fmt.Errorf("failed to open parquet file: %w", err))
+		return
+	}
+
+	var columns []string
+	for _, path := range pf.Schema().Columns() {
+		columns = append(columns, strings.Join(path, "."))
+	}
+
+	reader := parquet.NewReader(pf)
+	defer reader.Close()
+	rows := make([]parquet.Row, parquetBatchSize)
+	for {
+		n, err := reader.ReadRows(rows)
+		for _, row := range rows[:n] {
+			rec := make(map[string]string, len(columns))
+			for _, v := range row {
+				if !v.IsNull() && v.Column() < len(columns) {
+					rec[columns[v.Column()]] = v.String()
+				}
+			}
+			data, merr := json.Marshal(rec)
+			if !yield(data, merr) {
+				return
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return
+		}
+		if err != nil {
+			yield(nil, fmt.Errorf("failed to read parquet rows: %w", err))
+			return
+		}
+	}
+}
@@ -0,0 +1,385 @@
+// Package schedule runs collection jobs on cron schedules over contiguous time windows
+package schedule
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/arustydev/goslings/internal/auth/store"
+	"github.com/arustydev/goslings/internal/utils/telemetry"
+	"github.com/arustydev/goslings/pkg/apiv1"
+	"github.com/robfig/cron/v3"
+)
+
+// Common errors
+var (
+	ErrUnknownJob   = errors.New("unknown job")
+	ErrJobRunning   = errors.New("job is already running")
+	ErrDuplicateJob = errors.New("duplicate job name")
+	ErrInvalidJob   = errors.New("invalid job")
+)
+
+// DefaultLookback is the window of a job's first run
+const DefaultLookback = 7 * 24 * time.Hour
+
+// parser accepts standard five-field expressions and descriptors such as @hourly or @every 6h
+var parser = cron.NewParser(cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+
+// Job is a recurring collection
+type Job struct {
+	// Name identifies the job and its state
+	Name string `mapstructure:"name" json:"name"`
+
+	// Schedule is a cron expression, eg. "0 * * * *" or "@every 6h", in UTC
+	Schedule string `mapstructure:"schedule" json:"schedule"`
+
+	// Datasets are collected by every run of the job
+	Datasets []string `mapstructure:"datasets" json:"datasets"`
+
+	// Lookback is the window of the first run, DefaultLookback when zero
+	Lookback time.Duration `mapstructure:"lookback" json:"lookback,omitempty"`
+
+	// Delay holds back the end of every window, for events that are indexed late
+	Delay time.Duration `mapstructure:"delay" json:"delay,omitempty"`
+
+	// Full ignores stored delta links
+	Full bool `mapstructure:"full" json:"full,omitempty"`
+}
+
+// Window is the time range a run collects, Since inclusive and Until exclusive
+type Window struct {
+	Since time.Time `json:"since"`
+	Until time.Time `json:"until"`
+}
+
+// State is what the scheduler remembers about a job between runs, it is reported by the API
+type State = apiv1.ScheduleState
+
+// RunFunc collects a job's window; the window is only recorded as collected when it succeeds
+type RunFunc func(ctx context.Context, job Job, window Window) error
+
+// entry is a job with its parsed schedule
+type entry struct {
+	job      Job
+	schedule cron.Schedule
+
+	// running prevents overlapping runs of the job, it is shared by the entries replacing it
+	running *sync.Mutex
+	next    time.Time
+
+	// quit is closed when the entry is replaced or removed by Update
+	quit chan struct{}
+}
+
+// Scheduler runs jobs on their schedules, one run per job at a time
+type Scheduler struct {
+	// Store persists the state of every job
+	Store store.Store
+
+	// Tenant scopes the state of the jobs
+	Tenant string
+
+	// Run collects a window of a job
+	Run RunFunc
+
+	// Now returns the current time, time.Now when nil
+	Now func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*entry
+
+	// start runs the loop of an entry while Start runs, it is nil otherwise
+	start func(e *entry)
+	wg    sync.WaitGroup
+}
+
+// New creates a scheduler for jobs, checking their schedules
+func New(st store.Store, tenant string, run RunFunc, jobs ...Job) (*Scheduler, error) {
+	entries, err := newEntries(jobs)
+	if err != nil {
+		return nil, err
+	}
+	return &Scheduler{Store: st, Tenant: tenant, Run: run, entries: entries}, nil
+}
+
+// newEntries checks the schedules of jobs and parses them
+func newEntries(jobs []Job) (map[string]*entry, error) {
+	entries := map[string]*entry{}
+	for _, job := range jobs {
+		if job.Name == "" {
+			return nil, fmt.Errorf("%w: a job has no name", ErrInvalidJob)
+		}
+		if _, ok := entries[job.Name]; ok {
+			return nil, fmt.Errorf("%w: %s", ErrDuplicateJob, job.Name)
+		}
+		sched, err := parser.Parse(job.Schedule)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: invalid schedule %q: %w", ErrInvalidJob, job.Name, job.Schedule, err)
+		}
+		if job.Lookback <= 0 {
+			job.Lookback = DefaultLookback
+		}
+		entries[job.Name] = &entry{job: job, schedule: sched, running: &sync.Mutex{}, quit: make(chan struct{})}
+	}
+	return entries, nil
+}
+
+// Update replaces the jobs, checking their schedules first; it may be called while the
+// scheduler runs. New and changed jobs are scheduled from now on. A run in progress of a
+// changed or removed job is left to finish, and a changed job keeps its state, so its next
+// window still starts where the last one ended.
+func (s *Scheduler) Update(jobs ...Job) error {
+	entries, err := newEntries(jobs)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for name, old := range s.entries {
+		e, ok := entries[name]
+		if ok && reflect.DeepEqual(e.job, old.job) {
+			entries[name] = old
+			continue
+		}
+		close(old.quit)
+		if ok {
+			e.running = old.running
+		}
+	}
+	for name, e := range entries {
+		if s.start != nil && s.entries[name] != e {
+			s.start(e)
+		}
+	}
+	s.entries = entries
+	return nil
+}
+
+// StateKey returns the state store key of a job
+func StateKey(tenant, job string) string {
+	return "schedule/" + tenant + "/" + job
+}
+
+func (s *Scheduler) now() time.Time {
+	if s.Now != nil {
+		return s.Now().UTC()
+	}
+	return time.Now().UTC()
+}
+
+// Jobs returns the scheduled jobs sorted by name
+func (s *Scheduler) Jobs() []Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	jobs := make([]Job, 0, len(s.entries))
+	for _, e := range s.entries {
+		jobs = append(jobs, e.job)
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].Name < jobs[j].Name })
+	return jobs
+}
+
+// Start runs every job on its schedule until stop is closed or ctx is cancelled, then waits
+// for the runs in progress to return
+func (s *Scheduler) Start(ctx context.Context, stop <-chan struct{}) {
+	s.mu.Lock()
+	s.start = func(e *entry) {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.loop(ctx, stop, e)
+		}()
+	}
+	for _, e := range s.entries {
+		s.start(e)
+	}
+	s.mu.Unlock()
+
+	select {
+	case <-stop:
+	case <-ctx.Done():
+	}
+	s.mu.Lock()
+	s.start = nil
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+// loop runs a job every time it is due. A run that outlasts the next due time makes the
+// scheduler skip it; the next window still starts where the last successful one ended.
+func (s *Scheduler) loop(ctx context.Context, stop <-chan struct{}, e *entry) {
+	for {
+		next := e.schedule.Next(s.now())
+		s.mu.Lock()
+		e.next = next
+		s.mu.Unlock()
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-stop:
+			timer.Stop()
+			return
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-e.quit:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		if err := s.runEntry(ctx, e); err != nil && !errors.Is(err, ErrJobRunning) {
+			telemetry.Logger(ctx).WithField(telemetry.FieldJob, e.job.Name).Errorf("Scheduled run of %s failed: %v", e.job.Name, err)
+		}
+	}
+}
+
+// Trigger runs a job now, outside of its schedule
+func (s *Scheduler) Trigger(ctx context.Context, name string) error {
+	s.mu.Lock()
+	e, ok := s.entries[name]
+	s.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownJob, name)
+	}
+	return s.runEntry(ctx, e)
+}
+
+// runEntry runs the next window of a job unless it is already running
+func (s *Scheduler) runEntry(ctx context.Context, e *entry) error {
+	ctx = telemetry.WithField(ctx, telemetry.FieldJob, e.job.Name)
+	logger := telemetry.Logger(ctx)
+	if !e.running.TryLock() {
+		logger.Warnf("Skipping run of %s, the previous run is still in progress", e.job.Name)
+		return fmt.Errorf("%w: %s", ErrJobRunning, e.job.Name)
+	}
+	defer e.running.Unlock()
+
+	st, err := s.LoadState(ctx, e.job.Name)
+	if err != nil {
+		return err
+	}
+	window := s.nextWindow(e.job, st)
+	if !window.Until.After(window.Since) {
+		logger.Infof("Skipping run of %s, its window %s is empty", e.job.Name, window.Since.Format(time.RFC3339))
+		return nil
+	}
+
+	st.LastRun = s.now()
+	logger.Infof("Running %s over %s - %s", e.job.Name, window.Since.Format(time.RFC3339), window.Until.Format(time.RFC3339))
+	runErr := s.Run(ctx, e.job, window)
+	if runErr != nil {
+		st.LastError = runErr.Error()
+	} else {
+		st.Until = window.Until
+		st.LastSuccess = s.now()
+		st.LastError = ""
+	}
+	// The state is saved even when the run was cancelled
+	if err := s.saveState(context.WithoutCancel(ctx), st); err != nil {
+		return errors.Join(runErr, err)
+	}
+	return runErr
+}
+
+// nextWindow starts where the last successful window ended, or Lookback before its end
+func (s *Scheduler) nextWindow(job Job, st *State) Window {
+	until := s.now().Add(-job.Delay)
+	since := st.Until
+	if since.IsZero() {
+		since = until.Add(-job.Lookback)
+	}
+	return Window{Since: since, Until: until}
+}
+
+// Record marks a window of a job as collected by a run made outside of the scheduler, such
+// as a resumed run; the job's next window starts where it ends
+func (s *Scheduler) Record(ctx context.Context, name string, window Window) error {
+	s.mu.Lock()
+	e, ok := s.entries[name]
+	s.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownJob, name)
+	}
+	e.running.Lock()
+	defer e.running.Unlock()
+
+	st, err := s.LoadState(ctx, name)
+	if err != nil {
+		return err
+	}
+	if window.Until.After(st.Until) {
+		st.Until = window.Until
+	}
+	st.LastSuccess = s.now()
+	st.LastError = ""
+	return s.saveState(ctx, st)
+}
+
+// LoadState returns the persisted state of a job
+func (s *Scheduler) LoadState(ctx context.Context, name string) (*State, error) {
+	st := &State{Job: name}
+	data, err := s.Store.LoadState(ctx, StateKey(s.Tenant, name))
+	if errors.Is(err, store.ErrStateNotFound) {
+		return st, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load schedule state: %w", err)
+	}
+	if err := json.Unmarshal(data, st); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal schedule state: %w", err)
+	}
+	return st, nil
+}
+
+// States returns the state of every job, including whether it is running and when it is next due
+func (s *Scheduler) States(ctx context.Context) ([]State, error) {
+	// The entries are taken at once, an Update while the states load does not remove them
+	type scheduled struct {
+		e    *entry
+		next time.Time
+	}
+	s.mu.Lock()
+	entries := make([]scheduled, 0, len(s.entries))
+	for _, e := range s.entries {
+		entries = append(entries, scheduled{e: e, next: e.next})
+	}
+	s.mu.Unlock()
+	sort.Slice(entries, func(i, j int) bool { return entries[i].e.job.Name < entries[j].e.job.Name })
+
+	states := make([]State, 0, len(entries))
+	for _, sc := range entries {
+		st, err := s.LoadState(ctx, sc.e.job.Name)
+		if err != nil {
+			return nil, err
+		}
+		st.Next = sc.next
+		if sc.e.running.TryLock() {
+			sc.e.running.Unlock()
+		} else {
+			st.Running = true
+		}
+		states = append(states, *st)
+	}
+	return states, nil
+}
+
+func (s *Scheduler) saveState(ctx context.Context, st *State) error {
+	persisted := *st
+	persisted.Running = false
+	persisted.Next = time.Time{}
+	data, err := json.Marshal(persisted)
+	if err != nil {
+		return fmt.Errorf("failed to marshal schedule state: %w", err)
+	}
+	if err := s.Store.StoreState(ctx, StateKey(s.Tenant, st.Job), data); err != nil {
+		return fmt.Errorf("failed to save schedule state: %w", err)
+	}
+	return nil
+}
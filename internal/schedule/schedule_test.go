@@ -0,0 +1,197 @@
+package schedule
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/arustydev/goslings/internal/auth/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// clock is a settable time source
+type clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func TestWindowsAreContiguous(t *testing.T) {
+	st := store.NewMemoryStore()
+	clk := &clock{now: time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)}
+	var (
+		windows []Window
+		fail    bool
+	)
+	run := func(ctx context.Context, job Job, w Window) error {
+		windows = append(windows, w)
+		if fail {
+			return errors.New("throttled")
+		}
+		return nil
+	}
+	job := Job{Name: "signins", Schedule: "@hourly", Lookback: 24 * time.Hour, Delay: 5 * time.Minute}
+	s, err := New(st, "tenant", run, job)
+	require.NoError(t, err)
+	s.Now = clk.Now
+
+	require.NoError(t, s.Trigger(t.Context(), "signins"))
+	clk.Advance(time.Hour)
+	fail = true
+	require.Error(t, s.Trigger(t.Context(), "signins"))
+	clk.Advance(time.Hour)
+	fail = false
+	require.NoError(t, s.Trigger(t.Context(), "signins"))
+
+	start := time.Date(2026, 10, 18, 11, 55, 0, 0, time.UTC)
+	require.Len(t, windows, 3)
+	assert.Equal(t, Window{Since: start.Add(-24 * time.Hour), Until: start}, windows[0])
+	// The failed window is collected again by the next run
+	assert.Equal(t, Window{Since: start, Until: start.Add(time.Hour)}, windows[1])
+	assert.Equal(t, Window{Since: start, Until: start.Add(2 * time.Hour)}, windows[2])
+
+	// The state survives the scheduler
+	s, err = New(st, "tenant", run, job)
+	require.NoError(t, err)
+	state, err := s.LoadState(t.Context(), "signins")
+	require.NoError(t, err)
+	assert.Equal(t, start.Add(2*time.Hour), state.Until)
+	assert.Empty(t, state.LastError)
+}
+
+func TestRunsOfAJobDoNotOverlap(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	s, err := New(store.NewMemoryStore(), "tenant", func(ctx context.Context, job Job, w Window) error {
+		close(started)
+		<-release
+		return nil
+	}, Job{Name: "ual", Schedule: "0 */6 * * *"})
+	require.NoError(t, err)
+
+	done := make(chan error)
+	go func() { done <- s.Trigger(t.Context(), "ual") }()
+	<-started
+
+	assert.ErrorIs(t, s.Trigger(t.Context(), "ual"), ErrJobRunning)
+	states, err := s.States(t.Context())
+	require.NoError(t, err)
+	require.Len(t, states, 1)
+	assert.True(t, states[0].Running)
+
+	close(release)
+	require.NoError(t, <-done)
+}
+
+func TestRecordAdvancesWindow(t *testing.T) {
+	s, err := New(store.NewMemoryStore(), "tenant", nil, Job{Name: "ual", Schedule: "@every 6h"})
+	require.NoError(t, err)
+	until := time.Date(2026, 10, 18, 6, 0, 0, 0, time.UTC)
+	require.NoError(t, s.Record(t.Context(), "ual", Window{Since: until.Add(-time.Hour), Until: until}))
+
+	state, err := s.LoadState(t.Context(), "ual")
+	require.NoError(t, err)
+	assert.Equal(t, until, state.Until)
+	assert.ErrorIs(t, s.Record(t.Context(), "nope", Window{}), ErrUnknownJob)
+}
+
+func TestStartRunsDueJobsUntilStopped(t *testing.T) {
+	stop := make(chan struct{})
+	var once sync.Once
+	s, err := New(store.NewMemoryStore(), "tenant", func(ctx context.Context, job Job, w Window) error {
+		once.Do(func() { close(stop) })
+		return nil
+	}, Job{Name: "fast", Schedule: "@every 1s"})
+	require.NoError(t, err)
+
+	finished := make(chan struct{})
+	go func() {
+		s.Start(t.Context(), stop)
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the scheduler did not run the job and stop")
+	}
+}
+
+func TestUpdateReschedulesRunningScheduler(t *testing.T) {
+	stop := make(chan struct{})
+	var once sync.Once
+	s, err := New(store.NewMemoryStore(), "tenant", func(ctx context.Context, job Job, w Window) error {
+		assert.Equal(t, "fast", job.Name)
+		once.Do(func() { close(stop) })
+		return nil
+	}, Job{Name: "slow", Schedule: "@daily"})
+	require.NoError(t, err)
+
+	finished := make(chan struct{})
+	go func() {
+		s.Start(t.Context(), stop)
+		close(finished)
+	}()
+	assert.ErrorIs(t, s.Update(Job{Name: "fast", Schedule: "every second"}), ErrInvalidJob)
+	assert.Equal(t, "slow", s.Jobs()[0].Name)
+	require.NoError(t, s.Update(Job{Name: "fast", Schedule: "@every 1s"}))
+	assert.Len(t, s.Jobs(), 1)
+	select {
+	case <-finished:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the scheduler did not run the added job and stop")
+	}
+}
+
+// updatingStore runs update in another goroutine on every LoadState, waiting for it to return
+type updatingStore struct {
+	store.Store
+	update func(key string)
+}
+
+func (u *updatingStore) LoadState(ctx context.Context, key string) ([]byte, error) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		u.update(key)
+	}()
+	<-done
+	return u.Store.LoadState(ctx, key)
+}
+
+func TestStatesWhileUpdating(t *testing.T) {
+	a, b := Job{Name: "a", Schedule: "@hourly"}, Job{Name: "b", Schedule: "@daily"}
+	st := &updatingStore{Store: store.NewMemoryStore()}
+	s, err := New(st, "tenant", nil, a, b)
+	require.NoError(t, err)
+
+	// Job b is removed while its state loads
+	st.update = func(key string) {
+		if key == StateKey("tenant", "b") {
+			assert.NoError(t, s.Update(a))
+		}
+	}
+	states, err := s.States(t.Context())
+	require.NoError(t, err)
+	assert.Len(t, states, 2)
+	assert.Len(t, s.Jobs(), 1)
+}
+
+func TestNewRejectsInvalidJobs(t *testing.T) {
+	_, err := New(nil, "", nil, Job{Name: "a", Schedule: "every hour"})
+	assert.ErrorIs(t, err, ErrInvalidJob)
+	_, err = New(nil, "", nil, Job{Name: "a", Schedule: "@daily"}, Job{Name: "a", Schedule: "@daily"})
+	assert.ErrorIs(t, err, ErrDuplicateJob)
+}
@@ -0,0 +1,102 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"path/filepath"
+	"slices"
+
+	"github.com/arustydev/goslings/internal/audit"
+)
+
+// AuditDataset is the dataset, and run, of the audit events sent to record sinks
+const AuditDataset = "audit"
+
+// AuditForwarder implements audit.Forwarder over sinks: record sinks receive the events as
+// records of AuditDataset, object sinks the whole audit log under the audit/ prefix
+type AuditForwarder struct {
+	Sinks []Sink
+}
+
+// NewAuditForwarder creates the forwarder of the sinks named, among configs; it is nil when
+// no sink is named
+func NewAuditForwarder(configs []Config, names []string) (audit.Forwarder, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	f := &AuditForwarder{}
+	for _, name := range names {
+		i := slices.IndexFunc(configs, func(c Config) bool { return c.Name == name })
+		if i < 0 {
+			return nil, fmt.Errorf("%w: no sink named %s for the audit log", ErrInvalidConfig, name)
+		}
+		sk, err := New(configs[i])
+		if err != nil {
+			return nil, fmt.Errorf("sink %s: %w", name, err)
+		}
+		f.Sinks = append(f.Sinks, sk)
+	}
+	return f, nil
+}
+
+// Forward implements audit.Forwarder.Forward for AuditForwarder
+func (f *AuditForwarder) Forward(ctx context.Context, file string, events []audit.Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+	records := make([]json.RawMessage, len(events))
+	for i, e := range events {
+		data, err := json.Marshal(e)
+		if err != nil {
+			return fmt.Errorf("failed to marshal audit event %d: %w", e.Seq, err)
+		}
+		records[i] = data
+	}
+
+	var errs []error
+	for _, sk := range f.Sinks {
+		cfg := sk.Config()
+		var err error
+		switch sk := sk.(type) {
+		case ObjectSink:
+			key := path.Join(cfg.Prefix, AuditDataset, filepath.Base(file))
+			err = retry(ctx, cfg, func(ctx context.Context) error {
+				return sk.Upload(ctx, key, file)
+			})
+		case RecordSink:
+			err = f.send(ctx, sk, file, events[0].Seq, records)
+		default:
+			err = fmt.Errorf("%w: %T", ErrUnknownSink, sk)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", cfg.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// send delivers the records of the events numbered from first, in batches of the sink's size;
+// the offset of a record is its event number less one, so a record sent again keeps its ID
+func (f *AuditForwarder) send(ctx context.Context, sk RecordSink, file string, first uint64, records []json.RawMessage) error {
+	cfg := sk.Config()
+	for start := 0; start < len(records); start += cfg.BatchSize {
+		batch := Batch{
+			RunID:   AuditDataset,
+			Dataset: AuditDataset,
+			File:    filepath.Base(file),
+			Offset:  int64(first-1) + int64(start),
+			Records: records[start:min(start+cfg.BatchSize, len(records))],
+		}
+		err := retry(ctx, cfg, func(ctx context.Context) error {
+			return sk.Send(ctx, batch)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to send audit events %d to %d: %w",
+				batch.Offset+1, batch.Offset+int64(len(batch.Records)), err)
+		}
+	}
+	return nil
+}
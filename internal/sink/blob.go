@@ -0,0 +1,90 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+)
+
+// BlobSink uploads run files to an Azure Blob Storage container, or an Azurite emulator
+type BlobSink struct {
+	cfg    Config
+	client *azblob.Client
+}
+
+// NewBlob creates an Azure Blob sink
+func NewBlob(cfg Config) (*BlobSink, error) {
+	if cfg.Bucket == "" {
+		return nil, fmt.Errorf("%w: azureblob sink %s needs a bucket (container)", ErrInvalidConfig, cfg.Name)
+	}
+	serviceURL := cfg.Endpoint
+	if serviceURL == "" {
+		if cfg.Account == "" {
+			return nil, fmt.Errorf("%w: azureblob sink %s needs an account or endpoint", ErrInvalidConfig, cfg.Name)
+		}
+		serviceURL = fmt.Sprintf("https://%s.blob.core.windows.net/", cfg.Account)
+	}
+
+	opts := &azblob.ClientOptions{}
+	opts.Transport = newHTTPClient(cfg)
+	// Retries are handled by the shipper
+	opts.Retry = policy.RetryOptions{MaxRetries: -1}
+
+	var (
+		client *azblob.Client
+		err    error
+	)
+	if cfg.AccountKey != "" {
+		cred, cerr := azblob.NewSharedKeyCredential(cfg.Account, cfg.AccountKey)
+		if cerr != nil {
+			return nil, fmt.Errorf("%w: invalid azureblob account key: %w", ErrInvalidConfig, cerr)
+		}
+		client, err = azblob.NewClientWithSharedKeyCredential(serviceURL, cred, opts)
+	} else {
+		cred, cerr := azidentity.NewDefaultAzureCredential(nil)
+		if cerr != nil {
+			return nil, fmt.Errorf("failed to create azure credential: %w", cerr)
+		}
+		client, err = azblob.NewClient(serviceURL, cred, opts)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create blob client: %w", err)
+	}
+	return &BlobSink{cfg: cfg, client: client}, nil
+}
+
+// Config implements Sink.Config for BlobSink
+func (s *BlobSink) Config() Config {
+	return s.cfg
+}
+
+// Upload implements ObjectSink.Upload for BlobSink
+func (s *BlobSink) Upload(ctx context.Context, key, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return Permanent(fmt.Errorf("failed to open %s: %w", path, err))
+	}
+	defer f.Close()
+
+	ct := contentType(path)
+	_, err = s.client.UploadFile(ctx, s.cfg.Bucket, key, f, &azblob.UploadFileOptions{
+		HTTPHeaders: &blob.HTTPHeaders{BlobContentType: &ct},
+	})
+	if err != nil {
+		var respErr *azcore.ResponseError
+		if errors.As(err, &respErr) && respErr.StatusCode >= 400 && respErr.StatusCode < 500 &&
+			respErr.StatusCode != http.StatusTooManyRequests && respErr.StatusCode != http.StatusRequestTimeout {
+			return Permanent(fmt.Errorf("failed to upload blob %s: %w", key, err))
+		}
+		return fmt.Errorf("failed to upload blob %s: %w", key, err)
+	}
+	return nil
+}
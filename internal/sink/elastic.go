@@ -0,0 +1,144 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// DefaultElasticIndex is used when an Elasticsearch sink has no index
+const DefaultElasticIndex = "goslings-{dataset}"
+
+// ElasticSink indexes records with the Elasticsearch bulk API. Documents get an ID derived
+// from their position in the run, so a batch sent again overwrites instead of duplicating.
+type ElasticSink struct {
+	cfg    Config
+	client *http.Client
+	url    string
+}
+
+// bulkAction is the action line of a bulk request
+type bulkAction struct {
+	Index bulkTarget `json:"index"`
+}
+
+type bulkTarget struct {
+	Index string `json:"_index"`
+	ID    string `json:"_id"`
+}
+
+// bulkResponse is the part of a bulk response needed to find failed items
+type bulkResponse struct {
+	Errors bool `json:"errors"`
+	Items  []map[string]struct {
+		Status int             `json:"status"`
+		Error  json.RawMessage `json:"error,omitempty"`
+	} `json:"items"`
+}
+
+// NewElastic creates an Elasticsearch sink
+func NewElastic(cfg Config) (*ElasticSink, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("%w: elasticsearch sink %s needs a url", ErrInvalidConfig, cfg.Name)
+	}
+	if cfg.Index == "" {
+		cfg.Index = DefaultElasticIndex
+	}
+	return &ElasticSink{cfg: cfg, client: newHTTPClient(cfg), url: strings.TrimSuffix(cfg.URL, "/") + "/_bulk"}, nil
+}
+
+// Config implements Sink.Config for ElasticSink
+func (s *ElasticSink) Config() Config {
+	return s.cfg
+}
+
+// DocumentID returns the ID of the record at index in a run's output file
+func DocumentID(runID, file string, index int64) string {
+	sum := sha256.Sum256(fmt.Appendf(nil, "%s/%s/%d", runID, file, index))
+	return hex.EncodeToString(sum[:16])
+}
+
+// Send implements RecordSink.Send for ElasticSink
+func (s *ElasticSink) Send(ctx context.Context, batch Batch) error {
+	// Index names must be lowercase
+	index := strings.ToLower(expandIndex(s.cfg.Index, batch.Dataset))
+
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	for i, rec := range batch.Records {
+		action := bulkAction{Index: bulkTarget{Index: index, ID: DocumentID(batch.RunID, batch.File, batch.Offset+int64(i))}}
+		if err := enc.Encode(action); err != nil {
+			return Permanent(fmt.Errorf("failed to encode bulk action: %w", err))
+		}
+		body.Write(rec)
+		body.WriteByte('\n')
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, &body)
+	if err != nil {
+		return Permanent(fmt.Errorf("failed to create request: %w", err))
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	switch {
+	case s.cfg.APIKey != "":
+		req.Header.Set("Authorization", "ApiKey "+s.cfg.APIKey)
+	case s.cfg.Username != "":
+		req.SetBasicAuth(s.cfg.Username, s.cfg.Password)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send bulk request: %w", err)
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp); err != nil {
+		return err
+	}
+
+	var result bulkResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("failed to decode bulk response: %w", err)
+	}
+	if !result.Errors {
+		return nil
+	}
+	return bulkError(result)
+}
+
+// bulkError summarises the failed items of a bulk response. The batch is retried when
+// every failure is transient, resending the items that succeeded is harmless.
+func bulkError(result bulkResponse) error {
+	var (
+		failed    int
+		permanent bool
+		first     string
+	)
+	for _, item := range result.Items {
+		for _, r := range item {
+			if r.Status < 300 {
+				continue
+			}
+			failed++
+			if first == "" {
+				first = string(r.Error)
+			}
+			if r.Status != http.StatusTooManyRequests && r.Status < 500 {
+				permanent = true
+			}
+		}
+	}
+	err := fmt.Errorf("%d bulk items failed: %s", failed, first)
+	if failed == 0 {
+		err = errors.New("bulk request reported errors")
+	}
+	if permanent {
+		return Permanent(err)
+	}
+	return err
+}
@@ -0,0 +1,105 @@
+package sink
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// DefaultS3Endpoint is used when an S3 sink has no endpoint
+const DefaultS3Endpoint = "https://s3.amazonaws.com"
+
+// S3Sink uploads run files to an S3-compatible bucket such as AWS S3 or MinIO
+type S3Sink struct {
+	cfg    Config
+	client *minio.Client
+}
+
+// NewS3 creates an S3 sink
+func NewS3(cfg Config) (*S3Sink, error) {
+	if cfg.Bucket == "" {
+		return nil, fmt.Errorf("%w: s3 sink %s needs a bucket", ErrInvalidConfig, cfg.Name)
+	}
+	endpoint := cfg.Endpoint
+	if endpoint == "" {
+		endpoint = DefaultS3Endpoint
+	}
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "https://" + endpoint
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid s3 endpoint: %w", ErrInvalidConfig, err)
+	}
+
+	creds := credentials.NewStaticV4(cfg.AccessKey, cfg.SecretKey, "")
+	if cfg.AccessKey == "" {
+		creds = credentials.NewChainCredentials([]credentials.Provider{
+			&credentials.EnvAWS{},
+			&credentials.FileAWSCredentials{},
+			&credentials.IAM{},
+		})
+	}
+
+	region := cfg.Region
+	if region == "" {
+		// Setting a region skips the bucket location lookup
+		region = "us-east-1"
+	}
+	client, err := minio.New(u.Host, &minio.Options{
+		Creds:     creds,
+		Secure:    u.Scheme == "https",
+		Region:    region,
+		Transport: newHTTPClient(cfg).Transport,
+		// Retries are handled by the shipper
+		MaxRetries: 1,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create s3 client: %w", err)
+	}
+	return &S3Sink{cfg: cfg, client: client}, nil
+}
+
+// Config implements Sink.Config for S3Sink
+func (s *S3Sink) Config() Config {
+	return s.cfg
+}
+
+// Upload implements ObjectSink.Upload for S3Sink
+func (s *S3Sink) Upload(ctx context.Context, key, path string) error {
+	_, err := s.client.FPutObject(ctx, s.cfg.Bucket, key, path, minio.PutObjectOptions{
+		ContentType: contentType(path),
+	})
+	if err != nil {
+		resp := minio.ToErrorResponse(err)
+		if resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != 429 {
+			return Permanent(fmt.Errorf("failed to put object %s: %w", key, err))
+		}
+		return fmt.Errorf("failed to put object %s: %w", key, err)
+	}
+	return nil
+}
+
+// contentType returns the media type of a run file
+func contentType(path string) string {
+	switch {
+	case strings.HasSuffix(path, ".gz"):
+		return "application/gzip"
+	case strings.HasSuffix(path, ".zst"):
+		return "application/zstd"
+	case strings.HasSuffix(path, ".jsonl"):
+		return "application/x-ndjson"
+	case strings.HasSuffix(path, ".json"):
+		return "application/json"
+	case strings.HasSuffix(path, ".csv"):
+		return "text/csv"
+	case strings.HasSuffix(path, ".parquet"):
+		return "application/vnd.apache.parquet"
+	default:
+		return "application/octet-stream"
+	}
+}
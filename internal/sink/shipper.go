@@ -0,0 +1,283 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/arustydev/goslings/internal/auth/store"
+	"github.com/arustydev/goslings/internal/collect"
+	"github.com/arustydev/goslings/internal/output"
+	"github.com/arustydev/goslings/internal/utils/telemetry"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// ErrRunIncomplete is returned when shipping a run that has not finished collecting
+var ErrRunIncomplete = errors.New("run is incomplete")
+
+// FileState records how much of a file has been delivered to a sink
+type FileState struct {
+	// SHA256 is the digest of the file when it was delivered
+	SHA256 string `json:"sha256"`
+
+	// Records is the number of records delivered, for record sinks
+	Records int64 `json:"records,omitempty"`
+
+	// Done is set once the whole file has been delivered
+	Done bool `json:"done"`
+}
+
+// Ledger records what a sink has received from a run, so that an interrupted delivery
+// resumes where it stopped. Progress is saved after a delivery is acknowledged, so a
+// crash can only cause the last batch to be sent again.
+type Ledger struct {
+	RunID   string               `json:"run_id"`
+	Files   map[string]FileState `json:"files"`
+	Updated time.Time            `json:"updated"`
+}
+
+// LedgerKey returns the state store key of a sink's ledger for a run
+func LedgerKey(sinkName, runID string) string {
+	return "ship/" + sinkName + "/" + runID
+}
+
+// Shipper delivers finished runs to every configured sink
+type Shipper struct {
+	Sinks []Sink
+
+	// Store keeps the delivery ledgers
+	Store store.Store
+}
+
+// NewShipper creates the shipper of the sinks of configs, nil when there are none
+func NewShipper(st store.Store, configs []Config) (*Shipper, error) {
+	if len(configs) == 0 {
+		return nil, nil
+	}
+	shipper := &Shipper{Store: st}
+	for _, cfg := range configs {
+		sk, err := New(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("sink %s: %w", cfg.Name, err)
+		}
+		shipper.Sinks = append(shipper.Sinks, sk)
+	}
+	return shipper, nil
+}
+
+// Ship delivers the run of cp to every sink. The run must be complete and have a manifest.
+// A failing sink does not stop delivery to the others.
+func (s *Shipper) Ship(ctx context.Context, cp *collect.Checkpoint) error {
+	if !cp.Done() {
+		return fmt.Errorf("%w: %s", ErrRunIncomplete, cp.RunID)
+	}
+	m, err := collect.LoadManifest(filepath.Join(cp.Dir(), collect.ManifestFileName))
+	if err != nil {
+		return err
+	}
+	manifest, err := collect.HashFile(filepath.Join(cp.Dir(), collect.ManifestFileName))
+	if err != nil {
+		return err
+	}
+	digests := map[string]string{collect.ManifestFileName: manifest.SHA256}
+	for _, f := range m.Files {
+		digests[f.Path] = f.SHA256
+	}
+
+	ctx = telemetry.WithField(ctx, telemetry.FieldRun, cp.RunID)
+	var errs []error
+	for _, sk := range s.Sinks {
+		name := sk.Config().Name
+		ctx := telemetry.WithField(ctx, telemetry.FieldSink, name)
+		if err := s.shipTo(ctx, sk, cp, digests); err != nil {
+			telemetry.Logger(ctx).Errorf("Failed to ship run %s to %s: %v", cp.RunID, name, err)
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			continue
+		}
+		telemetry.Logger(ctx).Infof("Shipped run %s to %s", cp.RunID, name)
+	}
+	return errors.Join(errs...)
+}
+
+// shipTo delivers the run of cp to a sink, in a span parenting the spans of its writes
+func (s *Shipper) shipTo(ctx context.Context, sk Sink, cp *collect.Checkpoint, digests map[string]string) (err error) {
+	ctx, span := telemetry.StartSpan(ctx, "sink.ship",
+		telemetry.Attr(telemetry.FieldSink, sk.Config().Name),
+		attribute.String("goslings.sink_type", string(sk.Config().Type)),
+		telemetry.Attr(telemetry.FieldRun, cp.RunID),
+	)
+	defer func() { telemetry.EndSpan(span, err) }()
+
+	ledger, err := s.loadLedger(ctx, sk.Config().Name, cp.RunID)
+	if err != nil {
+		return err
+	}
+
+	switch sk := sk.(type) {
+	case ObjectSink:
+		return s.shipObjects(ctx, sk, cp, digests, ledger)
+	case RecordSink:
+		return s.shipRecords(ctx, sk, cp, digests, ledger)
+	default:
+		return fmt.Errorf("%w: %T", ErrUnknownSink, sk)
+	}
+}
+
+// shipObjects uploads every file of the run, the manifest last so that its presence
+// marks a complete upload
+func (s *Shipper) shipObjects(ctx context.Context, sk ObjectSink, cp *collect.Checkpoint, digests map[string]string, ledger *Ledger) error {
+	cfg := sk.Config()
+	files := make([]string, 0, len(digests))
+	for rel := range digests {
+		if rel != collect.ManifestFileName {
+			files = append(files, rel)
+		}
+	}
+	slices.Sort(files)
+	files = append(files, collect.ManifestFileName)
+
+	for _, rel := range files {
+		if st := ledger.Files[rel]; st.Done && st.SHA256 == digests[rel] {
+			continue
+		}
+		key := path.Join(cfg.Prefix, cp.RunID, filepath.ToSlash(rel))
+		file := filepath.Join(cp.Dir(), rel)
+		ctx, span := telemetry.StartSpan(ctx, "sink.upload", attribute.String("goslings.key", key))
+		err := retry(ctx, cfg, func(ctx context.Context) error {
+			return sk.Upload(ctx, key, file)
+		})
+		telemetry.EndSpan(span, err)
+		if err != nil {
+			return fmt.Errorf("failed to upload %s: %w", rel, err)
+		}
+		if info, err := os.Stat(file); err == nil {
+			telemetry.AddSinkBytes(cfg.Name, string(cfg.Type), info.Size())
+		}
+		ledger.Files[rel] = FileState{SHA256: digests[rel], Done: true}
+		if err := s.saveLedger(ctx, cfg.Name, ledger); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// shipRecords sends the records of every dataset's output files in batches
+func (s *Shipper) shipRecords(ctx context.Context, sk RecordSink, cp *collect.Checkpoint, digests map[string]string, ledger *Ledger) error {
+	cfg := sk.Config()
+	for _, p := range cp.Datasets {
+		for _, rel := range p.Files {
+			st := ledger.Files[rel]
+			if st.SHA256 != digests[rel] {
+				st = FileState{SHA256: digests[rel]}
+			}
+			if st.Done {
+				continue
+			}
+
+			batch := Batch{RunID: cp.RunID, Tenant: cp.Tenant, Dataset: p.Dataset, File: rel, Offset: st.Records}
+			flush := func() error {
+				if len(batch.Records) == 0 {
+					return nil
+				}
+				ctx, span := telemetry.StartSpan(ctx, "sink.send",
+					telemetry.Attr(telemetry.FieldCollector, batch.Dataset),
+					attribute.String("goslings.file", rel),
+					attribute.Int("goslings.records", len(batch.Records)),
+				)
+				err := retry(ctx, cfg, func(ctx context.Context) error {
+					return sk.Send(ctx, batch)
+				})
+				telemetry.EndSpan(span, err)
+				if err != nil {
+					return fmt.Errorf("failed to send %s records %d-%d: %w",
+						rel, batch.Offset, batch.Offset+int64(len(batch.Records)), err)
+				}
+				var size int64
+				for _, rec := range batch.Records {
+					size += int64(len(rec))
+				}
+				telemetry.AddSinkBytes(cfg.Name, string(cfg.Type), size)
+				st.Records += int64(len(batch.Records))
+				ledger.Files[rel] = st
+				batch.Offset = st.Records
+				batch.Records = batch.Records[:0]
+				return s.saveLedger(ctx, cfg.Name, ledger)
+			}
+
+			var index int64
+			for rec, err := range output.ReadRecords(filepath.Join(cp.Dir(), rel)) {
+				if err != nil {
+					return err
+				}
+				index++
+				if index <= st.Records {
+					continue
+				}
+				batch.Records = append(batch.Records, rec)
+				if len(batch.Records) >= cfg.BatchSize {
+					if err := flush(); err != nil {
+						return err
+					}
+				}
+			}
+			if err := flush(); err != nil {
+				return err
+			}
+
+			st.Done = true
+			ledger.Files[rel] = st
+			if err := s.saveLedger(ctx, cfg.Name, ledger); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Shipper) loadLedger(ctx context.Context, sinkName, runID string) (*Ledger, error) {
+	ledger := &Ledger{RunID: runID, Files: map[string]FileState{}}
+	if s.Store == nil {
+		return ledger, nil
+	}
+	data, err := s.Store.LoadState(ctx, LedgerKey(sinkName, runID))
+	if errors.Is(err, store.ErrStateNotFound) {
+		return ledger, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load delivery ledger: %w", err)
+	}
+	if err := json.Unmarshal(data, ledger); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal delivery ledger: %w", err)
+	}
+	if ledger.Files == nil {
+		ledger.Files = map[string]FileState{}
+	}
+	return ledger, nil
+}
+
+func (s *Shipper) saveLedger(ctx context.Context, sinkName string, ledger *Ledger) error {
+	if s.Store == nil {
+		return nil
+	}
+	ledger.Updated = time.Now().UTC()
+	data, err := json.Marshal(ledger)
+	if err != nil {
+		return fmt.Errorf("failed to marshal delivery ledger: %w", err)
+	}
+	if err := s.Store.StoreState(ctx, LedgerKey(sinkName, ledger.RunID), data); err != nil {
+		return fmt.Errorf("failed to save delivery ledger: %w", err)
+	}
+	return nil
+}
+
+// expandIndex replaces {dataset} in an index template
+func expandIndex(tmpl, dataset string) string {
+	return strings.ReplaceAll(tmpl, "{dataset}", dataset)
+}
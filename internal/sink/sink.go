@@ -0,0 +1,272 @@
+// Package sink ships finished collection runs to remote destinations
+package sink
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Common errors
+var (
+	ErrUnknownSink   = errors.New("unknown sink type")
+	ErrInvalidConfig = errors.New("invalid sink configuration")
+)
+
+// Type selects a sink implementation
+type Type string
+
+const (
+	// S3 uploads run files to an S3-compatible bucket
+	S3 Type = "s3"
+
+	// AzureBlob uploads run files to an Azure Blob Storage container
+	AzureBlob Type = "azureblob"
+
+	// Splunk sends records to a Splunk HTTP Event Collector
+	Splunk Type = "splunk"
+
+	// Elasticsearch indexes records with the bulk API
+	Elasticsearch Type = "elasticsearch"
+
+	// Syslog sends records as RFC 5424 syslog messages
+	Syslog Type = "syslog"
+)
+
+const (
+	DefaultBatchSize  = 500
+	DefaultMaxRetries = 5
+	DefaultRetryWait  = 2 * time.Second
+)
+
+// Config configures a sink, only the fields of its Type are used
+type Config struct {
+	// Name identifies the sink in logs and delivery state, Type when empty
+	Name string `mapstructure:"name" json:"name"`
+
+	// Type selects the sink implementation
+	Type Type `mapstructure:"type" json:"type"`
+
+	// BatchSize is the number of records sent per request by record sinks
+	BatchSize int `mapstructure:"batch_size" json:"batch_size,omitempty"`
+
+	// MaxRetries is the number of times a failed delivery is retried
+	MaxRetries int `mapstructure:"max_retries" json:"max_retries,omitempty"`
+
+	// RetryWait is the wait before the first retry, doubled on every attempt
+	RetryWait time.Duration `mapstructure:"retry_wait" json:"retry_wait,omitempty"`
+
+	// Endpoint is the object storage endpoint, eg. https://s3.amazonaws.com
+	Endpoint string `mapstructure:"endpoint" json:"endpoint,omitempty"`
+
+	// Bucket is the S3 bucket or Azure container objects are written to
+	Bucket string `mapstructure:"bucket" json:"bucket,omitempty"`
+
+	// Prefix is prepended to every object key
+	Prefix string `mapstructure:"prefix" json:"prefix,omitempty"`
+
+	// Region is the S3 region
+	Region string `mapstructure:"region" json:"region,omitempty"`
+
+	// AccessKey and SecretKey are the S3 credentials, the AWS credential chain is used when empty
+	AccessKey string `mapstructure:"access_key" json:"access_key,omitempty"`
+	SecretKey string `mapstructure:"secret_key" json:"secret_key,omitempty"`
+
+	// Account and AccountKey are the Azure storage account and its shared key; the default
+	// Azure credential is used when AccountKey is empty
+	Account    string `mapstructure:"account" json:"account,omitempty"`
+	AccountKey string `mapstructure:"account_key" json:"account_key,omitempty"`
+
+	// URL is the Splunk HEC or Elasticsearch base URL
+	URL string `mapstructure:"url" json:"url,omitempty"`
+
+	// Token is the Splunk HEC token
+	Token string `mapstructure:"token" json:"token,omitempty"`
+
+	// Index is the Splunk or Elasticsearch index, {dataset} is replaced by the dataset name
+	Index string `mapstructure:"index" json:"index,omitempty"`
+
+	// SourceType is the Splunk sourcetype, goslings:{dataset} when empty
+	SourceType string `mapstructure:"sourcetype" json:"sourcetype,omitempty"`
+
+	// Username, Password and APIKey authenticate to Elasticsearch
+	Username string `mapstructure:"username" json:"username,omitempty"`
+	Password string `mapstructure:"password" json:"password,omitempty"`
+	APIKey   string `mapstructure:"api_key" json:"api_key,omitempty"`
+
+	// TLSSkipVerify disables certificate verification of HTTP and syslog TLS sinks
+	TLSSkipVerify bool `mapstructure:"tls_skip_verify" json:"tls_skip_verify,omitempty"`
+
+	// Network is the syslog transport: udp, tcp or tls
+	Network string `mapstructure:"network" json:"network,omitempty"`
+
+	// Address is the syslog host:port
+	Address string `mapstructure:"address" json:"address,omitempty"`
+
+	// Format is the syslog message format: json or cef
+	Format string `mapstructure:"format" json:"format,omitempty"`
+
+	// Facility is the syslog facility, local0 (16) when zero
+	Facility int `mapstructure:"facility" json:"facility,omitempty"`
+}
+
+// Validate checks the common settings, filling in defaults
+func (c *Config) Validate() error {
+	if c.Type == "" {
+		return fmt.Errorf("%w: type is required", ErrInvalidConfig)
+	}
+	if c.Name == "" {
+		c.Name = string(c.Type)
+	}
+	if c.BatchSize <= 0 {
+		c.BatchSize = DefaultBatchSize
+	}
+	if c.MaxRetries <= 0 {
+		c.MaxRetries = DefaultMaxRetries
+	}
+	if c.RetryWait <= 0 {
+		c.RetryWait = DefaultRetryWait
+	}
+	return nil
+}
+
+// Sink is a destination runs are shipped to
+type Sink interface {
+	// Config returns the validated configuration of the sink
+	Config() Config
+}
+
+// ObjectSink receives the files of a run unchanged
+type ObjectSink interface {
+	Sink
+
+	// Upload stores the file at path under key, replacing any existing object
+	Upload(ctx context.Context, key, path string) error
+}
+
+// RecordSink receives the records of a run in batches
+type RecordSink interface {
+	Sink
+
+	// Send delivers a batch; a batch may be sent again after a failure
+	Send(ctx context.Context, batch Batch) error
+}
+
+// Batch is a run of consecutive records from one output file
+type Batch struct {
+	RunID   string
+	Tenant  string
+	Dataset string
+
+	// File is the output file the records were read from, relative to the run directory
+	File string
+
+	// Offset is the index of the first record in File
+	Offset int64
+
+	Records []json.RawMessage
+}
+
+// New creates the sink selected by cfg.Type
+func New(cfg Config) (Sink, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	switch cfg.Type {
+	case S3:
+		return NewS3(cfg)
+	case AzureBlob:
+		return NewBlob(cfg)
+	case Splunk:
+		return NewSplunk(cfg)
+	case Elasticsearch:
+		return NewElastic(cfg)
+	case Syslog:
+		return NewSyslog(cfg)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownSink, cfg.Type)
+	}
+}
+
+// permanentError marks a failure that retrying cannot fix
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// Permanent marks err as not worth retrying
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// IsPermanent reports whether err was marked by Permanent
+func IsPermanent(err error) bool {
+	var p *permanentError
+	return errors.As(err, &p)
+}
+
+// StatusError is returned when a sink answers with an unexpected HTTP status
+type StatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("unexpected status %d: %s", e.StatusCode, e.Body)
+}
+
+// checkResponse returns an error for an unsuccessful response; client errors other than
+// throttling are permanent
+func checkResponse(resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	err := &StatusError{StatusCode: resp.StatusCode, Body: string(body)}
+	if resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests &&
+		resp.StatusCode != http.StatusRequestTimeout {
+		return Permanent(err)
+	}
+	return err
+}
+
+// retry calls fn until it succeeds, fails permanently or cfg.MaxRetries is exhausted
+func retry(ctx context.Context, cfg Config, fn func(ctx context.Context) error) error {
+	wait := cfg.RetryWait
+	for attempt := 0; ; attempt++ {
+		err := fn(ctx)
+		if err == nil || IsPermanent(err) || attempt >= cfg.MaxRetries {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return errors.Join(err, ctx.Err())
+		case <-time.After(wait):
+		}
+		wait *= 2
+	}
+}
+
+// newHTTPClient returns the client of an HTTP sink
+func newHTTPClient(cfg Config) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if cfg.TLSSkipVerify {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true} //nolint:gosec // opt-in for lab sinks
+	}
+	return &http.Client{Transport: transport, Timeout: time.Minute}
+}
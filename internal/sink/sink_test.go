@@ -0,0 +1,381 @@
+package sink
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/arustydev/goslings/internal/audit"
+	"github.com/arustydev/goslings/internal/auth/store"
+	"github.com/arustydev/goslings/internal/collect"
+	"github.com/arustydev/goslings/internal/output"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newRun writes a complete run with n records of dataset ds
+func newRun(t *testing.T, n int) *collect.Checkpoint {
+	t.Helper()
+	cp, err := collect.NewCheckpoint(t.TempDir(), "run1", "tenant1", collect.Plan{Datasets: []string{"ds"}})
+	require.NoError(t, err)
+
+	w, err := output.Open(cp.Dir(), "ds", cp.Output, output.Position{})
+	require.NoError(t, err)
+	for i := range n {
+		require.NoError(t, w.Write(json.RawMessage(`{"id":`+strconv.Itoa(i)+`}`)))
+	}
+	require.NoError(t, w.Close())
+	cp.Datasets[0].Files = w.Files()
+	cp.Datasets[0].Items = int64(n)
+	cp.Datasets[0].Done = true
+	require.NoError(t, cp.Save())
+
+	m, err := collect.BuildManifest(cp, "tester", nil)
+	require.NoError(t, err)
+	require.NoError(t, m.Write(cp.Dir()))
+	return cp
+}
+
+func testConfig(cfg Config) Config {
+	cfg.RetryWait = time.Millisecond
+	if err := cfg.Validate(); err != nil {
+		panic(err)
+	}
+	return cfg
+}
+
+// fakeRecordSink fails the sends listed in failAt, counting from 1
+type fakeRecordSink struct {
+	cfg     Config
+	sends   int
+	failAt  map[int]error
+	records []string
+}
+
+func (f *fakeRecordSink) Config() Config {
+	return f.cfg
+}
+
+func (f *fakeRecordSink) Send(_ context.Context, batch Batch) error {
+	f.sends++
+	if err := f.failAt[f.sends]; err != nil {
+		return err
+	}
+	for _, rec := range batch.Records {
+		f.records = append(f.records, string(rec))
+	}
+	return nil
+}
+
+func TestAuditForwarderKeepsEventOffsets(t *testing.T) {
+	rec := &fakeRecordSink{cfg: testConfig(Config{Name: "siem", Type: "splunk", URL: "https://splunk", BatchSize: 2}),
+		failAt: map[int]error{2: errors.New("unavailable")}}
+	f := &AuditForwarder{Sinks: []Sink{rec}}
+	events := []audit.Event{{Seq: 4, Action: audit.ActionStoreClear}, {Seq: 5, Action: audit.ActionAPIRequest}, {Seq: 6, Action: audit.ActionAPIRequest}}
+
+	require.NoError(t, f.Forward(t.Context(), "/var/log/audit.jsonl", events))
+	require.Len(t, rec.records, 3)
+	var e audit.Event
+	require.NoError(t, json.Unmarshal([]byte(rec.records[2]), &e))
+	assert.Equal(t, uint64(6), e.Seq)
+
+	_, err := NewAuditForwarder([]Config{{Name: "siem", Type: "splunk"}}, []string{"elastic"})
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+	none, err := NewAuditForwarder(nil, nil)
+	require.NoError(t, err)
+	assert.Nil(t, none)
+}
+
+func TestShipperResumesFromLedger(t *testing.T) {
+	cp := newRun(t, 5)
+	st := store.NewMemoryStore()
+	permanent := Permanent(errors.New("rejected"))
+	fake := &fakeRecordSink{
+		cfg:    testConfig(Config{Name: "fake", Type: Splunk, BatchSize: 2, MaxRetries: 1}),
+		failAt: map[int]error{2: errors.New("unavailable"), 3: permanent},
+	}
+	shipper := &Shipper{Sinks: []Sink{fake}, Store: st}
+
+	// The first batch is delivered, the second fails once, is retried and fails permanently
+	err := shipper.Ship(t.Context(), cp)
+	require.ErrorIs(t, err, permanent)
+	assert.Equal(t, []string{`{"id":0}`, `{"id":1}`}, fake.records)
+
+	require.NoError(t, shipper.Ship(t.Context(), cp))
+	assert.Equal(t, []string{`{"id":0}`, `{"id":1}`, `{"id":2}`, `{"id":3}`, `{"id":4}`}, fake.records)
+
+	// A delivered run is not sent again
+	sends := fake.sends
+	require.NoError(t, shipper.Ship(t.Context(), cp))
+	assert.Equal(t, sends, fake.sends)
+}
+
+func TestShipperRejectsIncompleteRun(t *testing.T) {
+	cp := newRun(t, 1)
+	cp.Datasets[0].Done = false
+	err := (&Shipper{}).Ship(t.Context(), cp)
+	assert.ErrorIs(t, err, ErrRunIncomplete)
+}
+
+func TestSplunkSend(t *testing.T) {
+	var (
+		auth   string
+		events []map[string]any
+		calls  int
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		assert.Equal(t, SplunkEventPath, r.URL.Path)
+		auth = r.Header.Get("Authorization")
+		dec := json.NewDecoder(r.Body)
+		for dec.More() {
+			var ev map[string]any
+			require.NoError(t, dec.Decode(&ev))
+			events = append(events, ev)
+		}
+		_, _ = w.Write([]byte(`{"text":"Success","code":0}`))
+	}))
+	defer srv.Close()
+
+	sk, err := New(testConfig(Config{Type: Splunk, URL: srv.URL, Token: "hec-token", Index: "main"}))
+	require.NoError(t, err)
+	cp := newRun(t, 3)
+	require.NoError(t, (&Shipper{Sinks: []Sink{sk}}).Ship(t.Context(), cp))
+
+	assert.Equal(t, "Splunk hec-token", auth)
+	require.Len(t, events, 3)
+	assert.Equal(t, "goslings:ds", events[0]["sourcetype"])
+	assert.Equal(t, "main", events[0]["index"])
+	assert.Equal(t, map[string]any{"id": float64(0)}, events[0]["event"])
+	assert.Equal(t, "run1", events[0]["fields"].(map[string]any)["run_id"])
+}
+
+func TestSplunkClientErrorIsPermanent(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"text":"Invalid token","code":4}`, http.StatusForbidden)
+	}))
+	defer srv.Close()
+
+	sk, err := NewSplunk(testConfig(Config{Type: Splunk, URL: srv.URL, Token: "bad"}))
+	require.NoError(t, err)
+	err = sk.Send(t.Context(), Batch{Records: []json.RawMessage{json.RawMessage(`{}`)}})
+	var status *StatusError
+	require.ErrorAs(t, err, &status)
+	assert.Equal(t, http.StatusForbidden, status.StatusCode)
+	assert.True(t, IsPermanent(err))
+}
+
+func TestElasticBulkIsIdempotent(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		docs  = map[string]string{}
+		calls int
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		assert.Equal(t, "/_bulk", r.URL.Path)
+		assert.Equal(t, "ApiKey secret", r.Header.Get("Authorization"))
+
+		scanner := bufio.NewScanner(r.Body)
+		var items []string
+		for scanner.Scan() {
+			var action bulkAction
+			require.NoError(t, json.Unmarshal(scanner.Bytes(), &action))
+			require.True(t, scanner.Scan())
+			assert.Equal(t, "goslings-ds", action.Index.Index)
+			docs[action.Index.ID] = scanner.Text()
+			items = append(items, `{"index":{"status":201}}`)
+		}
+		if calls == 1 {
+			// The first item is rejected by a full write queue
+			items[0] = `{"index":{"status":429,"error":{"type":"es_rejected_execution_exception"}}}`
+			_, _ = w.Write([]byte(`{"errors":true,"items":[` + strings.Join(items, ",") + `]}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"errors":false,"items":[` + strings.Join(items, ",") + `]}`))
+	}))
+	defer srv.Close()
+
+	sk, err := New(testConfig(Config{Type: Elasticsearch, URL: srv.URL, APIKey: "secret"}))
+	require.NoError(t, err)
+	require.NoError(t, (&Shipper{Sinks: []Sink{sk}}).Ship(t.Context(), newRun(t, 3)))
+
+	assert.Equal(t, 2, calls)
+	assert.Len(t, docs, 3, "the retried batch overwrote its documents")
+	assert.Equal(t, `{"id":0}`, docs[DocumentID("run1", "ds.jsonl", 0)])
+}
+
+func TestElasticMappingErrorIsPermanent(t *testing.T) {
+	err := bulkError(bulkResponse{Errors: true, Items: []map[string]struct {
+		Status int             `json:"status"`
+		Error  json.RawMessage `json:"error,omitempty"`
+	}{{"index": {Status: 400, Error: json.RawMessage(`{"type":"mapper_parsing_exception"}`)}}}})
+	assert.True(t, IsPermanent(err))
+	assert.Contains(t, err.Error(), "mapper_parsing_exception")
+}
+
+// objectServer is an S3 or Azure Blob stand-in recording the objects put to it
+func objectServer(t *testing.T) (*httptest.Server, map[string]string) {
+	t.Helper()
+	var mu sync.Mutex
+	objects := map[string]string{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			http.Error(w, "unexpected "+r.Method, http.StatusMethodNotAllowed)
+			return
+		}
+		assert.NotEmpty(t, r.Header.Get("Authorization"))
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		mu.Lock()
+		objects[r.URL.Path] = string(body)
+		mu.Unlock()
+		w.Header().Set("ETag", `"0x1"`)
+		if r.Header.Get("x-ms-version") != "" {
+			// Put Blob answers 201 where S3 answers 200
+			w.WriteHeader(http.StatusCreated)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv, objects
+}
+
+func TestS3Upload(t *testing.T) {
+	srv, objects := objectServer(t)
+	sk, err := New(testConfig(Config{
+		Type:      S3,
+		Endpoint:  srv.URL,
+		Bucket:    "evidence",
+		Prefix:    "goslings",
+		AccessKey: "minio",
+		SecretKey: "minio123",
+	}))
+	require.NoError(t, err)
+
+	cp := newRun(t, 2)
+	require.NoError(t, (&Shipper{Sinks: []Sink{sk}, Store: store.NewMemoryStore()}).Ship(t.Context(), cp))
+
+	assert.Contains(t, objects, "/evidence/goslings/run1/manifest.json")
+	assert.Contains(t, objects, "/evidence/goslings/run1/checkpoint.json")
+	// Unsigned streaming uploads wrap the content in chunks
+	assert.Contains(t, objects["/evidence/goslings/run1/ds.jsonl"], "{\"id\":0}\n{\"id\":1}\n")
+}
+
+func TestBlobUpload(t *testing.T) {
+	srv, objects := objectServer(t)
+	sk, err := New(testConfig(Config{
+		Type:       AzureBlob,
+		Endpoint:   srv.URL + "/devstoreaccount1",
+		Bucket:     "evidence",
+		Account:    "devstoreaccount1",
+		AccountKey: base64.StdEncoding.EncodeToString([]byte("azurite-test-key")),
+	}))
+	require.NoError(t, err)
+
+	cp := newRun(t, 2)
+	require.NoError(t, (&Shipper{Sinks: []Sink{sk}}).Ship(t.Context(), cp))
+
+	assert.Equal(t, "{\"id\":0}\n{\"id\":1}\n", objects["/devstoreaccount1/evidence/run1/ds.jsonl"])
+	assert.Contains(t, objects, "/devstoreaccount1/evidence/run1/manifest.json")
+}
+
+func TestSyslogTCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+
+	received := make(chan []string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		var msgs []string
+		for {
+			length, err := r.ReadString(' ')
+			if err != nil {
+				break
+			}
+			n, _ := strconv.Atoi(strings.TrimSpace(length))
+			msg := make([]byte, n)
+			if _, err := io.ReadFull(r, msg); err != nil {
+				break
+			}
+			msgs = append(msgs, string(msg))
+		}
+		received <- msgs
+	}()
+
+	sk, err := New(testConfig(Config{Type: Syslog, Network: "tcp", Address: ln.Addr().String(), Format: SyslogCEF}))
+	require.NoError(t, err)
+	require.NoError(t, (&Shipper{Sinks: []Sink{sk}}).Ship(t.Context(), newRun(t, 2)))
+
+	msgs := <-received
+	require.Len(t, msgs, 2)
+	assert.True(t, strings.HasPrefix(msgs[0], "<134>1 "), msgs[0])
+	assert.Contains(t, msgs[0], `[goslings@32473 run="run1" tenant="tenant1" dataset="ds"]`)
+	assert.Contains(t, msgs[0], `CEF:0|goslings|goslings|`)
+	assert.True(t, strings.HasSuffix(msgs[1], `msg={"id":1}`), msgs[1])
+}
+
+func TestSyslogUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close()
+
+	sk, err := New(testConfig(Config{Type: Syslog, Address: conn.LocalAddr().String()}))
+	require.NoError(t, err)
+	require.NoError(t, sk.(RecordSink).Send(t.Context(), Batch{
+		RunID:   "run1",
+		Dataset: "ds",
+		Records: []json.RawMessage{json.RawMessage(`{"id":"a=b"}`)},
+	}))
+
+	buf := make([]byte, 4096)
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	require.NoError(t, err)
+	assert.True(t, strings.HasSuffix(string(buf[:n]), `] {"id":"a=b"}`), string(buf[:n]))
+}
+
+func TestCEFEscaping(t *testing.T) {
+	line := FormatCEF(time.UnixMilli(1), Batch{RunID: "r", Tenant: "t", Dataset: "a|b"}, []byte("{\"k\":\"x=y\\n\"}\n"))
+	assert.Contains(t, line, `|a\|b|`)
+	assert.Contains(t, line, `msg={"k":"x\=y\\n"}\n`)
+}
+
+func TestNewRejectsUnknownType(t *testing.T) {
+	_, err := New(Config{Type: "kafka"})
+	assert.ErrorIs(t, err, ErrUnknownSink)
+	_, err = New(Config{Type: Splunk})
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+}
+
+func TestNewShipper(t *testing.T) {
+	shipper, err := NewShipper(store.NewMemoryStore(), nil)
+	require.NoError(t, err)
+	assert.Nil(t, shipper, "no sinks, no shipper")
+
+	_, err = NewShipper(store.NewMemoryStore(), []Config{{Name: "siem", Type: Splunk}})
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+	assert.ErrorContains(t, err, "sink siem")
+}
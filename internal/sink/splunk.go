@@ -0,0 +1,95 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// SplunkEventPath is the HEC endpoint events are posted to
+const SplunkEventPath = "/services/collector/event"
+
+// SplunkSink sends records to a Splunk HTTP Event Collector
+type SplunkSink struct {
+	cfg    Config
+	client *http.Client
+	url    string
+	host   string
+}
+
+// hecEvent is a single event of a HEC request
+type hecEvent struct {
+	Host       string            `json:"host,omitempty"`
+	Source     string            `json:"source"`
+	SourceType string            `json:"sourcetype"`
+	Index      string            `json:"index,omitempty"`
+	Event      json.RawMessage   `json:"event"`
+	Fields     map[string]string `json:"fields"`
+}
+
+// NewSplunk creates a Splunk HEC sink
+func NewSplunk(cfg Config) (*SplunkSink, error) {
+	if cfg.URL == "" || cfg.Token == "" {
+		return nil, fmt.Errorf("%w: splunk sink %s needs a url and token", ErrInvalidConfig, cfg.Name)
+	}
+	url := strings.TrimSuffix(cfg.URL, "/")
+	if !strings.Contains(url, "/services/collector") {
+		url += SplunkEventPath
+	}
+	host, _ := os.Hostname()
+	return &SplunkSink{cfg: cfg, client: newHTTPClient(cfg), url: url, host: host}, nil
+}
+
+// Config implements Sink.Config for SplunkSink
+func (s *SplunkSink) Config() Config {
+	return s.cfg
+}
+
+// Send implements RecordSink.Send for SplunkSink
+func (s *SplunkSink) Send(ctx context.Context, batch Batch) error {
+	sourceType := s.cfg.SourceType
+	if sourceType == "" {
+		sourceType = "goslings:{dataset}"
+	}
+	fields := map[string]string{
+		"run_id":  batch.RunID,
+		"tenant":  batch.Tenant,
+		"dataset": batch.Dataset,
+	}
+
+	// HEC takes concatenated event objects
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	for _, rec := range batch.Records {
+		err := enc.Encode(hecEvent{
+			Host:       s.host,
+			Source:     "goslings",
+			SourceType: expandIndex(sourceType, batch.Dataset),
+			Index:      expandIndex(s.cfg.Index, batch.Dataset),
+			Event:      rec,
+			Fields:     fields,
+		})
+		if err != nil {
+			return Permanent(fmt.Errorf("failed to encode event: %w", err))
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, &body)
+	if err != nil {
+		return Permanent(fmt.Errorf("failed to create request: %w", err))
+	}
+	req.Header.Set("Authorization", "Splunk "+s.cfg.Token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send events: %w", err)
+	}
+	defer resp.Body.Close()
+	// HEC answers 503 when its queues are full, which is retried
+	return checkResponse(resp)
+}
@@ -1,4 +1,335 @@
+// Package telemetry configures how the processes log, and carries loggers with the fields of
+// their component in contexts
 package telemetry
 
-// go get "github.com/Sirupsen/logrus"
-// https://www.honeybadger.io/blog/golang-logging/
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Format is how log entries are written
+type Format string
+
+const (
+	// FormatText is for people, colored on a terminal
+	FormatText Format = "text"
+
+	// FormatJSON writes an object per line
+	FormatJSON Format = "json"
+
+	// FormatLogfmt writes key=value pairs per line, never colored
+	FormatLogfmt Format = "logfmt"
+)
+
+// DefaultMaxBackups is how many rotated log files are kept
+const DefaultMaxBackups = 5
+
+// Fields of the component an entry comes from, see WithFields
+const (
+	FieldLease     = "lease"
+	FieldStore     = "store"
+	FieldCollector = "collector"
+	FieldJob       = "job"
+	FieldRun       = "run"
+	FieldTenant    = "tenant"
+	FieldSink      = "sink"
+)
+
+// Redacted replaces the secrets removed from log entries
+const Redacted = "[REDACTED]"
+
+// ErrInvalidLogOptions is returned for log options ConfigureLogging cannot apply
+var ErrInvalidLogOptions = errors.New("invalid log options")
+
+// LogOptions configures logging, it is read from the log section of the configuration
+type LogOptions struct {
+	// Level is the least severe level logged, info when empty
+	Level string `mapstructure:"level"`
+
+	// Format is text when empty
+	Format Format `mapstructure:"format"`
+
+	// File receives the entries instead of stderr when set
+	File string `mapstructure:"file"`
+
+	// MaxSize rotates File past this many bytes, it is never rotated when zero
+	MaxSize int64 `mapstructure:"max_size"`
+
+	// MaxBackups is how many rotated files are kept, DefaultMaxBackups when zero
+	MaxBackups int `mapstructure:"max_backups"`
+}
+
+// Validate checks the options, filling in defaults
+func (o *LogOptions) Validate() error {
+	if o.Level == "" {
+		o.Level = log.InfoLevel.String()
+	}
+	if _, err := log.ParseLevel(o.Level); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidLogOptions, err)
+	}
+	switch o.Format {
+	case "":
+		o.Format = FormatText
+	case FormatText, FormatJSON, FormatLogfmt:
+	default:
+		return fmt.Errorf("%w: unknown format %q", ErrInvalidLogOptions, o.Format)
+	}
+	if o.MaxSize < 0 || o.MaxBackups < 0 {
+		return fmt.Errorf("%w: max_size and max_backups cannot be negative", ErrInvalidLogOptions)
+	}
+	if o.MaxBackups == 0 {
+		o.MaxBackups = DefaultMaxBackups
+	}
+	return nil
+}
+
+var (
+	loggingMu sync.Mutex
+
+	// logFile is the file the standard logger writes to, nil when it writes to stderr
+	logFile *RotatingFile
+
+	redactOnce sync.Once
+)
+
+// ConfigureLogging applies options to the standard logger, which every logger returned by
+// Logger writes through; secrets are redacted from every entry. It can be called again, as
+// the configuration is reloaded.
+func ConfigureLogging(opts LogOptions) error {
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+	level, _ := log.ParseLevel(opts.Level)
+
+	loggingMu.Lock()
+	defer loggingMu.Unlock()
+	var out io.Writer = os.Stderr
+	previous := logFile
+	if opts.File != "" {
+		if logFile == nil || logFile.Path != opts.File || logFile.MaxSize != opts.MaxSize || logFile.MaxBackups != opts.MaxBackups {
+			f, err := OpenRotatingFile(opts.File, opts.MaxSize, opts.MaxBackups)
+			if err != nil {
+				return err
+			}
+			logFile = f
+		}
+		out = logFile
+	} else {
+		logFile = nil
+	}
+
+	redactOnce.Do(func() { log.AddHook(redactHook{}) })
+	log.SetLevel(level)
+	log.SetFormatter(newFormatter(opts.Format))
+	log.SetOutput(out)
+	if previous != nil && previous != logFile {
+		// The logger no longer writes to it once SetOutput returns
+		_ = previous.Close()
+	}
+	return nil
+}
+
+// newFormatter returns the formatter of a format
+func newFormatter(format Format) log.Formatter {
+	switch format {
+	case FormatJSON:
+		return &log.JSONFormatter{}
+	case FormatLogfmt:
+		return &log.TextFormatter{DisableColors: true, FullTimestamp: true, QuoteEmptyFields: true}
+	}
+	return &log.TextFormatter{FullTimestamp: true}
+}
+
+// loggerKey is the context key of the logger
+type loggerKey struct{}
+
+// Logger returns the logger ctx carries, or one of the standard logger without fields
+func Logger(ctx context.Context) *log.Entry {
+	if logger, ok := ctx.Value(loggerKey{}).(*log.Entry); ok {
+		return logger
+	}
+	return log.NewEntry(log.StandardLogger())
+}
+
+// WithLogger returns a context carrying logger
+func WithLogger(ctx context.Context, logger *log.Entry) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// WithFields returns a context whose logger adds fields to the ones of the logger of ctx,
+// such as FieldRun and FieldTenant
+func WithFields(ctx context.Context, fields log.Fields) context.Context {
+	return WithLogger(ctx, Logger(ctx).WithFields(fields))
+}
+
+// WithField returns a context whose logger adds a field to the ones of the logger of ctx
+func WithField(ctx context.Context, key string, value any) context.Context {
+	return WithLogger(ctx, Logger(ctx).WithField(key, value))
+}
+
+// secretFields are parts of the names of fields holding secrets
+var secretFields = []string{"token", "password", "passwd", "secret", "cookie", "authorization", "api_key", "apikey"}
+
+// secretPatterns match the secrets of messages and field values
+var secretPatterns = []struct {
+	re   *regexp.Regexp
+	repl string
+}{
+	// Authorization headers
+	{regexp.MustCompile(`(?i)\b(bearer|basic)\s+[A-Za-z0-9._~+/=-]{8,}`), "$1 " + Redacted},
+	// JSON Web Tokens, such as access and ID tokens
+	{regexp.MustCompile(`\beyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`), Redacted},
+	// Cookie headers, up to the end of the line
+	{regexp.MustCompile(`(?i)\b((?:set-)?cookie["']?\s*[:=]\s*)[^\r\n]+`), "${1}" + Redacted},
+	// Secret parameters and fields, as in client_secret=... or "password": "..."
+	{regexp.MustCompile(`(?i)\b((?:access|refresh|id)_token|client_secret|password|passwd|api[_-]?key)(["']?\s*[:=]\s*["']?)[^\s"'&,;]+`), "${1}${2}" + Redacted},
+	// Tokens in query strings
+	{regexp.MustCompile(`(?i)([?&](?:token|code|sig)=)[^&\s"']+`), "${1}" + Redacted},
+}
+
+// Redact removes the tokens, passwords, client secrets and cookies a text holds
+func Redact(text string) string {
+	for _, p := range secretPatterns {
+		text = p.re.ReplaceAllString(text, p.repl)
+	}
+	return text
+}
+
+// isSecretField reports whether a field name holds a secret
+func isSecretField(name string) bool {
+	name = strings.ToLower(name)
+	for _, s := range secretFields {
+		if strings.Contains(name, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactHook redacts the message and fields of every entry before it is written
+type redactHook struct{}
+
+// Levels implements log.Hook.Levels for redactHook
+func (redactHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+// Fire implements log.Hook.Fire for redactHook, the entry is a copy made for the hooks and
+// formatter
+func (redactHook) Fire(entry *log.Entry) error {
+	entry.Message = Redact(entry.Message)
+	for key, value := range entry.Data {
+		if isSecretField(key) {
+			entry.Data[key] = Redacted
+			continue
+		}
+		switch v := value.(type) {
+		case string:
+			entry.Data[key] = Redact(v)
+		case error:
+			entry.Data[key] = Redact(v.Error())
+		case []string:
+			redacted := make([]string, len(v))
+			for i, s := range v {
+				redacted[i] = Redact(s)
+			}
+			entry.Data[key] = redacted
+		}
+	}
+	return nil
+}
+
+// RotatingFile is a log file that is rotated past MaxSize bytes, the rotated files being
+// named Path.1, the newest, to Path.<MaxBackups>
+type RotatingFile struct {
+	Path       string
+	MaxSize    int64
+	MaxBackups int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// OpenRotatingFile opens a log file for appending, creating it and its directory
+func OpenRotatingFile(path string, maxSize int64, maxBackups int) (*RotatingFile, error) {
+	f := &RotatingFile{Path: path, MaxSize: maxSize, MaxBackups: maxBackups}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// open opens the file, appending to what it holds
+func (f *RotatingFile) open() error {
+	if err := os.MkdirAll(filepath.Dir(f.Path), 0o700); err != nil {
+		return fmt.Errorf("failed to create the log directory: %w", err)
+	}
+	file, err := os.OpenFile(f.Path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open the log file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return fmt.Errorf("failed to open the log file: %w", err)
+	}
+	f.file, f.size = file, info.Size()
+	return nil
+}
+
+// Write implements io.Writer.Write for RotatingFile, rotating the file first when p would take
+// it past MaxSize
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+	if f.MaxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.MaxSize {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// rotate shifts the rotated files, dropping the oldest, and starts a new file
+func (f *RotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	f.file = nil
+	_ = os.Remove(f.Path + "." + strconv.Itoa(f.MaxBackups))
+	for i := f.MaxBackups - 1; i >= 1; i-- {
+		_ = os.Rename(f.Path+"."+strconv.Itoa(i), f.Path+"."+strconv.Itoa(i+1))
+	}
+	if err := os.Rename(f.Path, f.Path+".1"); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to rotate the log file: %w", err)
+	}
+	return f.open()
+}
+
+// Close closes the file
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
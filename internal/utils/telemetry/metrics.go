@@ -1,4 +1,185 @@
 package telemetry
 
-// https://pkg.go.dev/runtime/metrics
-// https://www.google.com/search?q=metrics+in+golang
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// MetricsPath is where MetricsHandler is served
+const MetricsPath = "/metrics"
+
+// Operations of tokens, see ObserveToken
+const (
+	TokenAcquire = "acquire"
+	TokenRenew   = "renew"
+)
+
+// Outcomes of token and store operations
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+)
+
+// namespace prefixes the name of every metric but the Go runtime and process ones
+const namespace = "goslings"
+
+var (
+	tokenOperations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "token_operations_total",
+		Help:      "Token acquisitions and renewals, by lease, operation and outcome.",
+	}, []string{"lease", "operation", "outcome"})
+
+	tokenDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "token_operation_duration_seconds",
+		Help:      "Time taken to acquire or renew a token, by lease, operation and outcome.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"lease", "operation", "outcome"})
+
+	apiRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "api_requests_total",
+		Help:      "Requests to the Microsoft APIs collected from, by host and status, error when no response was received.",
+	}, []string{"host", "status"})
+
+	apiDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "api_request_duration_seconds",
+		Help:      "Time taken for the Microsoft APIs to respond, by host.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"host"})
+
+	throttles = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "throttled_requests_total",
+		Help:      "Requests throttled by the Microsoft APIs, by host and status.",
+	}, []string{"host", "status"})
+
+	retryWaits = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "retry_after_wait_seconds",
+		Help:      "Time waited before retrying throttled requests, as asked by Retry-After, by host.",
+		Buckets:   []float64{1, 2, 5, 10, 30, 60, 120, 300},
+	}, []string{"host"})
+
+	records = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "records_collected_total",
+		Help:      "Records collected and checkpointed, by dataset.",
+	}, []string{"dataset"})
+
+	sinkBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "sink_bytes_written_total",
+		Help:      "Bytes delivered to sinks, by sink name and type.",
+	}, []string{"sink", "type"})
+
+	storeDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "store_operation_duration_seconds",
+		Help:      "Latency of the credential and state store, by store type, operation and outcome.",
+		Buckets:   prometheus.ExponentialBuckets(0.0005, 4, 8),
+	}, []string{"store", "operation", "outcome"})
+)
+
+// registry holds the metrics of the process, along with the Go runtime ones read from
+// runtime/metrics
+var registry = newRegistry()
+
+// newRegistry registers every metric
+func newRegistry() *prometheus.Registry {
+	r := prometheus.NewRegistry()
+	r.MustRegister(
+		collectors.NewGoCollector(collectors.WithGoCollectorRuntimeMetrics(collectors.MetricsGC, collectors.MetricsMemory, collectors.MetricsScheduler)),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		tokenOperations, tokenDuration,
+		apiRequests, apiDuration, throttles, retryWaits,
+		records, sinkBytes, storeDuration,
+	)
+	return r
+}
+
+// outcome returns the outcome label of an error
+func outcome(err error) string {
+	if err != nil {
+		return OutcomeFailure
+	}
+	return OutcomeSuccess
+}
+
+// ObserveToken records a token acquisition or renewal of a lease that started at start
+func ObserveToken(lease, operation string, start time.Time, err error) {
+	tokenOperations.WithLabelValues(lease, operation, outcome(err)).Inc()
+	tokenDuration.WithLabelValues(lease, operation, outcome(err)).Observe(time.Since(start).Seconds())
+}
+
+// ObserveRequest records a request to host that started at start, status is zero when no
+// response was received
+func ObserveRequest(host string, status int, start time.Time) {
+	label := "error"
+	if status != 0 {
+		label = strconv.Itoa(status)
+	}
+	apiRequests.WithLabelValues(host, label).Inc()
+	apiDuration.WithLabelValues(host).Observe(time.Since(start).Seconds())
+}
+
+// ObserveThrottle records a throttled request to host, retried after wait
+func ObserveThrottle(host string, status int, wait time.Duration) {
+	throttles.WithLabelValues(host, strconv.Itoa(status)).Inc()
+	retryWaits.WithLabelValues(host).Observe(wait.Seconds())
+}
+
+// AddRecords counts records of a dataset once they are checkpointed
+func AddRecords(dataset string, n int) {
+	records.WithLabelValues(dataset).Add(float64(n))
+}
+
+// AddSinkBytes counts bytes delivered to a sink
+func AddSinkBytes(sink, sinkType string, n int64) {
+	sinkBytes.WithLabelValues(sink, sinkType).Add(float64(n))
+}
+
+// ObserveStore records a store operation that started at start
+func ObserveStore(store, operation string, start time.Time, err error) {
+	storeDuration.WithLabelValues(store, operation, outcome(err)).Observe(time.Since(start).Seconds())
+}
+
+// MetricsHandler serves the metrics in the Prometheus text format, or OpenMetrics when the
+// scraper asks for it
+func MetricsHandler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{EnableOpenMetrics: true})
+}
+
+// ServeMetrics serves MetricsHandler at MetricsPath on address until ctx is done; it only
+// returns an error when address cannot be listened on
+func ServeMetrics(ctx context.Context, address string) error {
+	ln, err := net.Listen("tcp", address)
+	if err != nil {
+		return fmt.Errorf("failed to serve metrics: %w", err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle(MetricsPath, MetricsHandler())
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		_ = srv.Close()
+	}()
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			Logger(ctx).Errorf("Stopped serving metrics: %v", err)
+		}
+	}()
+	Logger(ctx).Infof("Serving metrics on %s%s", ln.Addr(), MetricsPath)
+	return nil
+}
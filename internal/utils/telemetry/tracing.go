@@ -1,4 +1,174 @@
 package telemetry
 
-// https://pkg.go.dev/runtime/trace
-// https://www.google.com/search?q=tracing+in+golang
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/arustydev/goslings/internal/about"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Exporter is where spans are sent
+type Exporter string
+
+const (
+	// ExporterNone records no spans
+	ExporterNone Exporter = "none"
+
+	// ExporterOTLP sends spans to an OpenTelemetry collector over OTLP/HTTP
+	ExporterOTLP Exporter = "otlp"
+
+	// ExporterFile appends spans to a file as JSON, for offline use
+	ExporterFile Exporter = "file"
+)
+
+// TraceFlushTimeout is how long the spans not yet exported are waited for when tracing stops
+const TraceFlushTimeout = 5 * time.Second
+
+// FieldTrace is the field of the ID of the trace an entry was logged in, see StartSpan
+const FieldTrace = "trace_id"
+
+// ErrInvalidTraceOptions is returned for trace options ConfigureTracing cannot apply
+var ErrInvalidTraceOptions = errors.New("invalid trace options")
+
+// instrumentation names the tracer of every span
+const instrumentation = "github.com/arustydev/goslings"
+
+// TraceOptions configures tracing, it is read from the trace section of the configuration
+type TraceOptions struct {
+	// Exporter is none when empty
+	Exporter Exporter `mapstructure:"exporter"`
+
+	// Endpoint is the URL of the OTLP/HTTP collector, eg. http://localhost:4318; the
+	// OTEL_EXPORTER_OTLP_* environment variables apply when empty
+	Endpoint string `mapstructure:"endpoint"`
+
+	// File receives the spans of ExporterFile
+	File string `mapstructure:"file"`
+
+	// Service names the process in the spans, eg. goslings-headless
+	Service string `mapstructure:"-"`
+}
+
+// Validate checks the options, filling in defaults
+func (o *TraceOptions) Validate() error {
+	switch o.Exporter {
+	case "":
+		o.Exporter = ExporterNone
+	case ExporterNone, ExporterOTLP:
+	case ExporterFile:
+		if o.File == "" {
+			return fmt.Errorf("%w: the file exporter needs a file", ErrInvalidTraceOptions)
+		}
+	default:
+		return fmt.Errorf("%w: unknown exporter %q", ErrInvalidTraceOptions, o.Exporter)
+	}
+	if o.Service == "" {
+		o.Service = "goslings"
+	}
+	return nil
+}
+
+// ConfigureTracing installs the tracer provider StartSpan creates spans with. The returned
+// function flushes the spans not yet exported, waiting up to TraceFlushTimeout, and stops
+// exporting; it must be called before the process exits.
+func ConfigureTracing(ctx context.Context, opts TraceOptions) (func(), error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var exporter sdktrace.SpanExporter
+	var file *os.File
+	switch opts.Exporter {
+	case ExporterNone:
+		return func() {}, nil
+	case ExporterOTLP:
+		var options []otlptracehttp.Option
+		if opts.Endpoint != "" {
+			options = append(options, otlptracehttp.WithEndpointURL(opts.Endpoint))
+		}
+		e, err := otlptracehttp.New(ctx, options...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create the OTLP exporter: %w", err)
+		}
+		exporter = e
+	case ExporterFile:
+		if err := os.MkdirAll(filepath.Dir(opts.File), 0o700); err != nil {
+			return nil, fmt.Errorf("failed to create the trace directory: %w", err)
+		}
+		f, err := os.OpenFile(opts.File, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open the trace file: %w", err)
+		}
+		e, err := stdouttrace.New(stdouttrace.WithWriter(f))
+		if err != nil {
+			_ = f.Close()
+			return nil, fmt.Errorf("failed to create the file exporter: %w", err)
+		}
+		exporter, file = e, f
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL,
+		semconv.ServiceName(opts.Service),
+		semconv.ServiceVersion(about.Version),
+	))
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe the process: %w", err)
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.AlwaysSample())),
+	)
+	otel.SetTracerProvider(provider)
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), TraceFlushTimeout)
+		defer cancel()
+		if err := provider.Shutdown(ctx); err != nil {
+			Logger(ctx).Warnf("Failed to export the last spans: %v", err)
+		}
+		if file != nil {
+			_ = file.Close()
+		}
+	}, nil
+}
+
+// StartSpan starts a span as a child of the span of ctx, returning a context carrying it
+// whose logger adds FieldTrace. The span must be ended, with EndSpan.
+func StartSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	ctx, span := otel.Tracer(instrumentation).Start(ctx, name, trace.WithAttributes(attrs...))
+	if sc := span.SpanContext(); sc.IsValid() && Logger(ctx).Data[FieldTrace] != sc.TraceID().String() {
+		ctx = WithField(ctx, FieldTrace, sc.TraceID().String())
+	}
+	return ctx, span
+}
+
+// Attr returns the span attribute of a log field such as FieldRun, named goslings.<field>
+func Attr(field, value string) attribute.KeyValue {
+	return attribute.String("goslings."+field, value)
+}
+
+// EndSpan ends a span, recording err as its failure
+func EndSpan(span trace.Span, err error) {
+	if err != nil {
+		// Errors may quote the requests that failed
+		msg := Redact(err.Error())
+		span.RecordError(errors.New(msg))
+		span.SetStatus(codes.Error, msg)
+	}
+	span.End()
+}
@@ -0,0 +1,91 @@
+package apiv1
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrInvalidRole is returned for the name of an unknown role
+var ErrInvalidRole = errors.New("invalid role")
+
+// Role grants access to a set of /v1 endpoints, every role includes the ones below it
+type Role string
+
+const (
+	// Viewer reads the authentication status, jobs, their progress and their file listings
+	Viewer Role = "viewer"
+
+	// Operator also launches, resumes and cancels jobs and downloads their files
+	Operator Role = "operator"
+
+	// Admin also authenticates the server to tenants and manages API keys
+	Admin Role = "admin"
+)
+
+// rank orders the roles, an unknown role ranks below every role
+func (r Role) rank() int {
+	switch r {
+	case Viewer:
+		return 1
+	case Operator:
+		return 2
+	case Admin:
+		return 3
+	default:
+		return 0
+	}
+}
+
+// Allows reports whether the role includes required
+func (r Role) Allows(required Role) bool {
+	return r.rank() > 0 && r.rank() >= required.rank()
+}
+
+// ParseRole checks the name of a role, case-insensitively
+func ParseRole(name string) (Role, error) {
+	r := Role(strings.ToLower(name))
+	if r.rank() == 0 {
+		return "", fmt.Errorf("%w: %q, expected viewer, operator or admin", ErrInvalidRole, name)
+	}
+	return r, nil
+}
+
+// Principal is who made a request
+type Principal struct {
+	// Subject identifies the caller: the certificate's common name, the API key's ID or the
+	// token's subject
+	Subject string `json:"subject"`
+
+	// Method is how the caller authenticated: mtls, apikey or oidc
+	Method string `json:"method"`
+
+	Role Role `json:"role"`
+}
+
+// Actor names the principal in the audit log, as method:subject
+func (p *Principal) Actor() string {
+	return p.Method + ":" + p.Subject
+}
+
+// APIKey describes an API key; the key itself is only returned when it is created
+type APIKey struct {
+	// ID is the public part of the key, it identifies the caller
+	ID      string    `json:"id"`
+	Name    string    `json:"name"`
+	Role    Role      `json:"role"`
+	Created time.Time `json:"created"`
+}
+
+// KeyRequest creates an API key
+type KeyRequest struct {
+	Name string `json:"name" binding:"required"`
+	Role Role   `json:"role" binding:"required"`
+}
+
+// CreatedKey is the response to creating an API key, the only time its token is returned
+type CreatedKey struct {
+	Key   APIKey `json:"key"`
+	Token string `json:"token"`
+}
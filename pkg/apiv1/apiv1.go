@@ -0,0 +1,14 @@
+// Package apiv1 holds the types of the /v1 API served by the api binary, as sent on the wire.
+// It only depends on the standard library so that clients can use it without the server.
+package apiv1
+
+// Error is the body of every error response
+type Error struct {
+	Error string `json:"error"`
+}
+
+// Health is the response of the probes, Checks is only reported by readiness
+type Health struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
@@ -0,0 +1,61 @@
+package apiv1
+
+import "time"
+
+// DeviceCode signs a user in with a device code relayed through the API
+const DeviceCode = "device_code"
+
+// Service is a service the server holds tokens for
+type Service string
+
+const (
+	// AzureService represents Azure services
+	AzureService Service = "azure"
+
+	// M365Service represents Microsoft 365 services
+	M365Service Service = "m365"
+
+	// GraphService represents Microsoft Graph API
+	GraphService Service = "graph"
+)
+
+// AuthState is where the server is in authenticating
+type AuthState string
+
+const (
+	AuthNone          AuthState = "none"
+	AuthPending       AuthState = "pending"
+	AuthAuthenticated AuthState = "authenticated"
+	AuthFailed        AuthState = "failed"
+)
+
+// AuthRequest starts authentication, its fields override the configured parameters
+type AuthRequest struct {
+	// Method is secret, managed_identity or device_code
+	Method string `json:"method" binding:"required"`
+
+	TenantID     string `json:"tenant_id,omitempty"`
+	ClientID     string `json:"client_id,omitempty"`
+	ClientSecret string `json:"client_secret,omitempty"`
+}
+
+// AuthStatus reports the server's authentication
+type AuthStatus struct {
+	State    AuthState `json:"state"`
+	Method   string    `json:"method,omitempty"`
+	Tenant   string    `json:"tenant,omitempty"`
+	Identity string    `json:"identity,omitempty"`
+
+	// Services have a valid token, the earliest of which expires at ExpiresAt
+	Services  []Service `json:"services,omitempty"`
+	ExpiresAt time.Time `json:"expires_at,omitzero"`
+
+	// UserCode, VerificationURL and Message are set while a device code sign-in is pending,
+	// for admins only
+	UserCode        string `json:"user_code,omitempty"`
+	VerificationURL string `json:"verification_url,omitempty"`
+	Message         string `json:"message,omitempty"`
+
+	// Error is why the last sign-in failed
+	Error string `json:"error,omitempty"`
+}
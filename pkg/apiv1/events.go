@@ -0,0 +1,70 @@
+package apiv1
+
+import "time"
+
+// EventType names what an Event reports
+type EventType string
+
+const (
+	// EventJobStarted is sent when a job starts or resumes
+	EventJobStarted EventType = "job.started"
+
+	// EventDatasetStarted is sent when a dataset starts or resumes
+	EventDatasetStarted EventType = "dataset.started"
+
+	// EventPageFetched is sent once a page has been written and checkpointed
+	EventPageFetched EventType = "page.fetched"
+
+	// EventThrottled is sent before waiting to retry a throttled request
+	EventThrottled EventType = "throttled"
+
+	// EventTokenRenewed is sent when an expired token was renewed during the run
+	EventTokenRenewed EventType = "token.renewed"
+
+	// EventDatasetCompleted is sent once every page of a dataset has been written
+	EventDatasetCompleted EventType = "dataset.completed"
+
+	// EventDatasetFailed is sent when a dataset could not be collected
+	EventDatasetFailed EventType = "dataset.failed"
+
+	// EventJobFinished is sent once a job has stopped, with its status
+	EventJobFinished EventType = "job.finished"
+)
+
+// Event reports the progress of a run as it happens
+type Event struct {
+	Type    EventType `json:"type"`
+	Time    time.Time `json:"time"`
+	RunID   string    `json:"run_id"`
+	Dataset string    `json:"dataset,omitempty"`
+
+	// Page is the 1-based number of the page since the dataset started or resumed
+	Page int `json:"page,omitempty"`
+
+	// Items is the number of records of the page
+	Items int `json:"items,omitempty"`
+
+	// Total is the number of records of the dataset written so far
+	Total int64 `json:"total,omitempty"`
+
+	// Service is the service whose token was renewed
+	Service Service `json:"service,omitempty"`
+
+	// StatusCode, Attempt and Wait describe a throttled request
+	StatusCode int    `json:"status_code,omitempty"`
+	Attempt    int    `json:"attempt,omitempty"`
+	Wait       string `json:"wait,omitempty"`
+
+	// Status is the outcome of a finished job, for events sent by the job's owner
+	Status string `json:"status,omitempty"`
+
+	Error string `json:"error,omitempty"`
+}
+
+// JobEvent is an event of a job with its offset in the job's events
+type JobEvent struct {
+	// Offset numbers the events of a job from 0, across resumes
+	Offset int64 `json:"offset"`
+
+	Event
+}
@@ -0,0 +1,156 @@
+package apiv1
+
+import "time"
+
+// JobStatus is the state of a job
+type JobStatus string
+
+const (
+	// JobRunning is collecting
+	JobRunning JobStatus = "running"
+
+	// JobCancelling has been asked to stop and is finishing the page being read
+	JobCancelling JobStatus = "cancelling"
+
+	// JobCompleted collected every dataset
+	JobCompleted JobStatus = "completed"
+
+	// JobFailed finished with datasets that could not be collected
+	JobFailed JobStatus = "failed"
+
+	// JobCancelled was stopped before every dataset was collected, it can be resumed
+	JobCancelled JobStatus = "cancelled"
+
+	// JobInterrupted did not finish, for example because the server was restarted; it can be resumed
+	JobInterrupted JobStatus = "interrupted"
+)
+
+// Format is the file format records are written in
+type Format string
+
+const (
+	// JSONL writes one JSON object per line
+	JSONL Format = "jsonl"
+
+	// CSV writes flattened records with a header of every column of the file
+	CSV Format = "csv"
+
+	// Parquet writes flattened records as optional string columns
+	Parquet Format = "parquet"
+)
+
+// Compression is applied to output files; Parquet files use it as their column codec
+type Compression string
+
+const (
+	None Compression = "none"
+	Gzip Compression = "gzip"
+	Zstd Compression = "zstd"
+)
+
+// Output selects how a dataset is written
+type Output struct {
+	// Format is the file format, JSONL when empty
+	Format Format `json:"format"`
+
+	// Compression is applied to every part, None when empty
+	Compression Compression `json:"compression"`
+
+	// RotateBytes starts a new part once a part grows past this many bytes on disk, after
+	// compression; CSV and Parquet parts are measured on their JSON Lines spool. 0 disables it
+	RotateBytes int64 `json:"rotate_bytes,omitempty"`
+
+	// RotateRecords starts a new part once a part holds this many records, 0 disables it
+	RotateRecords int64 `json:"rotate_records,omitempty"`
+}
+
+// JobRequest launches a job, or resumes one
+type JobRequest struct {
+	// Datasets are collected in order, every dataset when empty
+	Datasets []string `json:"datasets,omitempty"`
+
+	// Since is a duration before Until, eg. 24h, or an RFC 3339 time; the server's default
+	// when empty
+	Since string `json:"since,omitempty"`
+
+	// Until is an RFC 3339 time, now when empty
+	Until string `json:"until,omitempty"`
+
+	// Full ignores stored delta links
+	Full bool `json:"full,omitempty"`
+
+	// Output overrides how records are written
+	Output *Output `json:"output,omitempty"`
+
+	// Resume continues the cancelled or interrupted job with this ID, other fields are ignored
+	Resume string `json:"resume,omitempty"`
+
+	// Ship ships the job to the configured sinks once it completes
+	Ship bool `json:"ship,omitempty"`
+}
+
+// Job is the state of a collection run
+type Job struct {
+	ID       string      `json:"id"`
+	Status   JobStatus   `json:"status"`
+	Tenant   string      `json:"tenant"`
+	Schedule string      `json:"schedule,omitempty"`
+	Full     bool        `json:"full"`
+	Output   Output      `json:"output"`
+	Created  time.Time   `json:"created"`
+	Updated  time.Time   `json:"updated"`
+	Items    int64       `json:"items"`
+	Datasets []*Progress `json:"datasets"`
+
+	// Error is why the job failed or stopped
+	Error string `json:"error,omitempty"`
+}
+
+// Progress records how far a dataset has been collected
+type Progress struct {
+	// Dataset is the dataset name
+	Dataset string `json:"dataset"`
+
+	// Since and Until are the time window of the collection
+	Since time.Time `json:"since,omitzero"`
+	Until time.Time `json:"until,omitzero"`
+
+	// Cursor is the link of the next unread page
+	Cursor string `json:"cursor,omitempty"`
+
+	// Items is the number of records written
+	Items int64 `json:"items"`
+
+	// Part is the output part being written
+	Part int `json:"part"`
+
+	// File is the output file being written, relative to the run directory
+	File string `json:"file,omitempty"`
+
+	// Offset is the length of File once every record before Cursor was written
+	Offset int64 `json:"offset"`
+
+	// Files are the finished output files, relative to the run directory
+	Files []string `json:"files,omitempty"`
+
+	// Started and Finished are when the dataset collection started and completed
+	Started  time.Time `json:"started,omitzero"`
+	Finished time.Time `json:"finished,omitzero"`
+
+	// Done is set once every page has been read
+	Done bool `json:"done"`
+
+	// Error holds the last error encountered, if any
+	Error string `json:"error,omitempty"`
+}
+
+// Artifact is a file of a job's run directory
+type Artifact struct {
+	// Path is relative to the run directory
+	Path     string    `json:"path"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+
+	// SHA256 is the digest recorded in the manifest, empty until the manifest lists the file
+	SHA256 string `json:"sha256,omitempty"`
+}
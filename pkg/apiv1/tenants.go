@@ -0,0 +1,50 @@
+package apiv1
+
+import "time"
+
+// Tenant is a tenant the server is configured for, authenticated to or has collected from
+type Tenant struct {
+	ID string `json:"id"`
+
+	// Configured is set for the tenant of the configuration
+	Configured bool `json:"configured"`
+
+	// Authenticated is set for the tenant the server holds credentials for
+	Authenticated bool `json:"authenticated"`
+
+	// Jobs is the number of jobs that collected from the tenant, the last created at LastJob
+	Jobs    int       `json:"jobs"`
+	LastJob time.Time `json:"last_job,omitzero"`
+}
+
+// Schedule is a job of schedule.jobs and its state
+type Schedule struct {
+	ScheduleState
+
+	// Schedule is the cron expression of the job
+	Schedule string `json:"schedule"`
+
+	// Datasets are collected by every run, every dataset when empty
+	Datasets []string `json:"datasets,omitempty"`
+}
+
+// ScheduleState is what the scheduler remembers about a job between runs
+type ScheduleState struct {
+	Job string `json:"job"`
+
+	// Until is the end of the last successful window, the next window starts there
+	Until time.Time `json:"until,omitzero"`
+
+	// LastRun and LastSuccess are when the job last started and last succeeded
+	LastRun     time.Time `json:"last_run,omitzero"`
+	LastSuccess time.Time `json:"last_success,omitzero"`
+
+	// LastError is the error of the last run, cleared by a success
+	LastError string `json:"last_error,omitempty"`
+
+	// Running is set while a run is in progress in this process
+	Running bool `json:"running"`
+
+	// Next is when the job is next due
+	Next time.Time `json:"next,omitzero"`
+}
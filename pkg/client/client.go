@@ -0,0 +1,435 @@
+// Package client is a Go client of the /v1 API served by the api binary
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/arustydev/goslings/pkg/apiv1"
+)
+
+// Common errors
+var (
+	ErrNoURL         = errors.New("no API server URL")
+	ErrSignInFailed  = errors.New("sign-in failed")
+	ErrStreamDropped = errors.New("event stream dropped")
+)
+
+const (
+	// DefaultPollInterval is how often a pending sign-in is polled
+	DefaultPollInterval = 2 * time.Second
+
+	// DefaultRetries is how many times a dropped event stream is reconnected in a row
+	DefaultRetries = 5
+)
+
+// Options configures a client, it is read from the remote section of the configuration
+type Options struct {
+	// URL is the root of the API server, eg. https://goslings.example.com
+	URL string `mapstructure:"url"`
+
+	// APIKey is sent in the X-API-Key header
+	APIKey string `mapstructure:"api_key"`
+
+	// Token is an OIDC bearer token, used when APIKey is empty
+	Token string `mapstructure:"token"`
+
+	// CA is a PEM bundle that verifies the server instead of the system roots
+	CA string `mapstructure:"ca"`
+
+	// Cert and Key are a PEM client certificate for mTLS
+	Cert string `mapstructure:"cert"`
+	Key  string `mapstructure:"key"`
+}
+
+// Client calls the /v1 API of a server
+type Client struct {
+	// BaseURL is the root of the server, /v1 paths are resolved against it
+	BaseURL *url.URL
+
+	// HTTP sends every request, it must not time out requests as event streams are long-lived
+	HTTP *http.Client
+
+	APIKey string
+	Token  string
+
+	// PollInterval is how often a pending sign-in is polled
+	PollInterval time.Duration
+}
+
+// New creates a client from its options
+func New(opts Options) (*Client, error) {
+	if opts.URL == "" {
+		return nil, ErrNoURL
+	}
+	base, err := url.Parse(strings.TrimRight(opts.URL, "/"))
+	if err != nil || (base.Scheme != "http" && base.Scheme != "https") || base.Host == "" {
+		return nil, fmt.Errorf("invalid API server URL %q", opts.URL)
+	}
+
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if opts.CA != "" {
+		pem, err := os.ReadFile(opts.CA)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA: %w", err)
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %s", opts.CA)
+		}
+	}
+	if (opts.Cert == "") != (opts.Key == "") {
+		return nil, errors.New("a client certificate needs both cert and key")
+	}
+	if opts.Cert != "" {
+		cert, err := tls.LoadX509KeyPair(opts.Cert, opts.Key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = cfg
+
+	return &Client{
+		BaseURL:      base,
+		HTTP:         &http.Client{Transport: transport},
+		APIKey:       opts.APIKey,
+		Token:        opts.Token,
+		PollInterval: DefaultPollInterval,
+	}, nil
+}
+
+// Error is an error response of the server
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", http.StatusText(e.StatusCode), e.Message)
+}
+
+// IsStatus reports whether err is an error response with the status code
+func IsStatus(err error, code int) bool {
+	var e *Error
+	return errors.As(err, &e) && e.StatusCode == code
+}
+
+// newRequest builds a request of a /v1 path, with a JSON body unless body is nil
+func (c *Client) newRequest(ctx context.Context, method, p string, query url.Values, body any) (*http.Request, error) {
+	u := *c.BaseURL
+	u.Path = path.Join(u.Path, "/v1", p)
+	u.RawQuery = query.Encode()
+
+	var r io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal request: %w", err)
+		}
+		r = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), r)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	switch {
+	case c.APIKey != "":
+		req.Header.Set("X-API-Key", c.APIKey)
+	case c.Token != "":
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	return req, nil
+}
+
+// send sends a request, returning the response when its status is 2xx
+func (c *Client) send(req *http.Request) (*http.Response, error) {
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	var body apiv1.Error
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err := json.Unmarshal(data, &body); err != nil || body.Error == "" {
+		body.Error = strings.TrimSpace(string(data))
+	}
+	return nil, &Error{StatusCode: resp.StatusCode, Message: body.Error}
+}
+
+// do sends a request with a JSON body and decodes a JSON response into out, unless it is nil
+func (c *Client) do(ctx context.Context, method, p string, query url.Values, body, out any) error {
+	req, err := c.newRequest(ctx, method, p, query, body)
+	if err != nil {
+		return err
+	}
+	resp, err := c.send(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+// Me returns who the client authenticates as
+func (c *Client) Me(ctx context.Context) (*apiv1.Principal, error) {
+	var p apiv1.Principal
+	return &p, c.do(ctx, http.MethodGet, "/me", nil, nil, &p)
+}
+
+// AuthStatus reports the server's authentication
+func (c *Client) AuthStatus(ctx context.Context) (*apiv1.AuthStatus, error) {
+	var st apiv1.AuthStatus
+	return &st, c.do(ctx, http.MethodGet, "/auth", nil, nil, &st)
+}
+
+// SignIn authenticates the server. A device code sign-in returns while it is pending, with
+// the code for the user; see WaitForSignIn.
+func (c *Client) SignIn(ctx context.Context, req apiv1.AuthRequest) (*apiv1.AuthStatus, error) {
+	var st apiv1.AuthStatus
+	return &st, c.do(ctx, http.MethodPost, "/auth", nil, req, &st)
+}
+
+// WaitForSignIn polls a pending sign-in until it completes or fails
+func (c *Client) WaitForSignIn(ctx context.Context) (*apiv1.AuthStatus, error) {
+	ticker := time.NewTicker(c.PollInterval)
+	defer ticker.Stop()
+	for {
+		st, err := c.AuthStatus(ctx)
+		if err != nil {
+			return nil, err
+		}
+		switch st.State {
+		case apiv1.AuthAuthenticated:
+			return st, nil
+		case apiv1.AuthFailed, apiv1.AuthNone:
+			return st, fmt.Errorf("%w: %s", ErrSignInFailed, st.Error)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// SignOut signs the server out and clears its credential store
+func (c *Client) SignOut(ctx context.Context) error {
+	return c.do(ctx, http.MethodDelete, "/auth", nil, nil, nil)
+}
+
+// Jobs lists the jobs, oldest first, of every tenant or only of tenant
+func (c *Client) Jobs(ctx context.Context, tenant string) ([]apiv1.Job, error) {
+	query := url.Values{}
+	if tenant != "" {
+		query.Set("tenant", tenant)
+	}
+	var jobs []apiv1.Job
+	return jobs, c.do(ctx, http.MethodGet, "/jobs", query, nil, &jobs)
+}
+
+// Job reports a job
+func (c *Client) Job(ctx context.Context, id string) (*apiv1.Job, error) {
+	var j apiv1.Job
+	return &j, c.do(ctx, http.MethodGet, "/jobs/"+url.PathEscape(id), nil, nil, &j)
+}
+
+// CreateJob launches a job, or resumes one
+func (c *Client) CreateJob(ctx context.Context, req apiv1.JobRequest) (*apiv1.Job, error) {
+	var j apiv1.Job
+	return &j, c.do(ctx, http.MethodPost, "/jobs", nil, req, &j)
+}
+
+// CancelJob stops a job at its next page boundary, or aborts the page being read with force
+func (c *Client) CancelJob(ctx context.Context, id string, force bool) (*apiv1.Job, error) {
+	query := url.Values{}
+	if force {
+		query.Set("force", "true")
+	}
+	var j apiv1.Job
+	return &j, c.do(ctx, http.MethodDelete, "/jobs/"+url.PathEscape(id), query, nil, &j)
+}
+
+// Events calls fn with the events of a job from offset until the job stops, reconnecting
+// from the last event received when the stream drops. It returns fn's error, if any.
+func (c *Client) Events(ctx context.Context, id string, offset int64, fn func(apiv1.JobEvent) error) error {
+	failures := 0
+	for {
+		finished, received, err := c.streamEvents(ctx, id, offset, fn)
+		offset += received
+		switch {
+		case err == nil && finished:
+			return nil
+		case ctx.Err() != nil:
+			return ctx.Err()
+		case err != nil && !errors.Is(err, ErrStreamDropped):
+			return err
+		}
+		if received > 0 {
+			failures = 0
+		}
+		if failures++; failures > DefaultRetries {
+			return fmt.Errorf("%w: %w", ErrStreamDropped, err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Duration(failures) * time.Second):
+		}
+	}
+}
+
+// streamEvents reads one connection of an event stream, reporting whether the job finished
+// and how many events were received
+func (c *Client) streamEvents(ctx context.Context, id string, offset int64, fn func(apiv1.JobEvent) error) (bool, int64, error) {
+	query := url.Values{"offset": {strconv.FormatInt(offset, 10)}}
+	req, err := c.newRequest(ctx, http.MethodGet, "/jobs/"+url.PathEscape(id)+"/events", query, nil)
+	if err != nil {
+		return false, 0, err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err := c.send(req)
+	if err != nil {
+		var e *Error
+		if errors.As(err, &e) {
+			return false, 0, err
+		}
+		return false, 0, fmt.Errorf("%w: %w", ErrStreamDropped, err)
+	}
+	defer resp.Body.Close()
+
+	var received int64
+	finished := false
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data:")
+		if !ok {
+			continue
+		}
+		var e apiv1.JobEvent
+		if err := json.Unmarshal([]byte(data), &e); err != nil {
+			return false, received, fmt.Errorf("failed to decode event: %w", err)
+		}
+		received++
+		finished = e.Type == apiv1.EventJobFinished
+		if err := fn(e); err != nil {
+			return false, received, err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return false, received, fmt.Errorf("%w: %w", ErrStreamDropped, err)
+	}
+	// A stream ends after job.finished, or when a job resumed in the meantime finishes
+	if !finished {
+		return false, received, ErrStreamDropped
+	}
+	return true, received, nil
+}
+
+// Artifacts lists the files of a job
+func (c *Client) Artifacts(ctx context.Context, id string) ([]apiv1.Artifact, error) {
+	var artifacts []apiv1.Artifact
+	return artifacts, c.do(ctx, http.MethodGet, "/jobs/"+url.PathEscape(id)+"/artifacts", nil, nil, &artifacts)
+}
+
+// DownloadArtifact copies a file of a job to w
+func (c *Client) DownloadArtifact(ctx context.Context, id, file string, w io.Writer) error {
+	req, err := c.newRequest(ctx, http.MethodGet, "/jobs/"+url.PathEscape(id)+"/artifacts/"+file, nil, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.send(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		return fmt.Errorf("failed to download %s: %w", file, err)
+	}
+	return nil
+}
+
+// Download copies every file of a job into dir/<id>, the layout of a local run
+func (c *Client) Download(ctx context.Context, id, dir string) ([]apiv1.Artifact, error) {
+	artifacts, err := c.Artifacts(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	root := filepath.Join(dir, id)
+	for _, a := range artifacts {
+		if !filepath.IsLocal(a.Path) {
+			return nil, fmt.Errorf("refusing to download %s outside the run directory", a.Path)
+		}
+		if err := c.downloadFile(ctx, id, a.Path, filepath.Join(root, filepath.FromSlash(a.Path))); err != nil {
+			return nil, err
+		}
+	}
+	return artifacts, nil
+}
+
+func (c *Client) downloadFile(ctx context.Context, id, file, dest string) error {
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	f, err := os.Create(dest)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", dest, err)
+	}
+	if err := c.DownloadArtifact(ctx, id, file, f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Tenants lists the tenants the server knows
+func (c *Client) Tenants(ctx context.Context) ([]apiv1.Tenant, error) {
+	var tenants []apiv1.Tenant
+	return tenants, c.do(ctx, http.MethodGet, "/tenants", nil, nil, &tenants)
+}
+
+// Keys lists the API keys
+func (c *Client) Keys(ctx context.Context) ([]apiv1.APIKey, error) {
+	var keys []apiv1.APIKey
+	return keys, c.do(ctx, http.MethodGet, "/keys", nil, nil, &keys)
+}
+
+// CreateKey issues an API key, its token is only returned here
+func (c *Client) CreateKey(ctx context.Context, req apiv1.KeyRequest) (*apiv1.CreatedKey, error) {
+	var key apiv1.CreatedKey
+	return &key, c.do(ctx, http.MethodPost, "/keys", nil, req, &key)
+}
+
+// RevokeKey revokes an API key
+func (c *Client) RevokeKey(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodDelete, "/keys/"+url.PathEscape(id), nil, nil, nil)
+}
@@ -0,0 +1,186 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/arustydev/goslings/internal/app/api"
+	"github.com/arustydev/goslings/internal/auth"
+	"github.com/arustydev/goslings/internal/auth/shared"
+	"github.com/arustydev/goslings/internal/collect"
+	"github.com/arustydev/goslings/internal/output"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeCredential issues tokens named after their scope; with a prompt it first relays a
+// device code and waits for signedIn
+type fakeCredential struct {
+	prompt   auth.DevicePrompt
+	signedIn chan struct{}
+}
+
+func (f *fakeCredential) GetToken(ctx context.Context, opts policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	if f.prompt != nil {
+		if err := f.prompt(ctx, azidentity.DeviceCodeMessage{UserCode: "ABCD-EFGH", VerificationURL: "https://microsoft.com/devicelogin", Message: "Enter ABCD-EFGH"}); err != nil {
+			return azcore.AccessToken{}, err
+		}
+		f.prompt = nil
+		<-f.signedIn
+	}
+	return azcore.AccessToken{Token: opts.Scopes[0], ExpiresOn: time.Now().Add(time.Hour)}, nil
+}
+
+// rewriteTransport sends every request to a test server
+type rewriteTransport struct {
+	target string
+}
+
+func (t rewriteTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r.URL.Scheme = "http"
+	r.URL.Host = strings.TrimPrefix(t.target, "http://")
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	os.Exit(m.Run())
+}
+
+// newServer serves the API with a fake tenant, returning it and an admin API key
+func newServer(t *testing.T, newCredential func(prompt auth.DevicePrompt) azcore.TokenCredential) (*httptest.Server, string) {
+	t.Helper()
+	a, err := auth.NewAuthManager(t.Context(), auth.Options{StoreType: shared.MemoryStore})
+	require.NoError(t, err)
+	s := api.New(a, &shared.AuthParams{TenantID: "contoso", ClientID: "app"}, t.TempDir(), output.Options{})
+	s.PollInterval = 10 * time.Millisecond
+	s.NewCredential = func(method string, params *shared.AuthParams, prompt auth.DevicePrompt) (azcore.TokenCredential, error) {
+		return newCredential(prompt), nil
+	}
+	graph := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"value":[{"id":"1"},{"id":"2"}]}`)
+	}))
+	t.Cleanup(graph.Close)
+	s.Client = &http.Client{Transport: rewriteTransport{target: graph.URL}}
+	t.Cleanup(func() { _ = s.Shutdown(context.Background()) })
+
+	key, _, err := (&api.Keys{Store: a.Store}).Create(t.Context(), "test", api.Admin)
+	require.NoError(t, err)
+	srv := httptest.NewServer(s)
+	t.Cleanup(srv.Close)
+	return srv, key
+}
+
+func newClient(t *testing.T, url, key string) *Client {
+	t.Helper()
+	c, err := New(Options{URL: url, APIKey: key})
+	require.NoError(t, err)
+	c.PollInterval = 10 * time.Millisecond
+	return c
+}
+
+func TestNewValidatesOptions(t *testing.T) {
+	_, err := New(Options{})
+	assert.ErrorIs(t, err, ErrNoURL)
+	_, err = New(Options{URL: "ftp://example.com"})
+	assert.Error(t, err)
+	_, err = New(Options{URL: "https://example.com", Cert: "client.pem"})
+	assert.Error(t, err)
+
+	c, err := New(Options{URL: "https://example.com/"})
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com", c.BaseURL.String())
+}
+
+func TestErrorsCarryTheirStatus(t *testing.T) {
+	srv, key := newServer(t, func(auth.DevicePrompt) azcore.TokenCredential { return &fakeCredential{} })
+
+	_, err := newClient(t, srv.URL, "").Jobs(t.Context(), "")
+	assert.True(t, IsStatus(err, http.StatusUnauthorized), err)
+
+	c := newClient(t, srv.URL, key)
+	p, err := c.Me(t.Context())
+	require.NoError(t, err)
+	assert.Equal(t, api.Admin, p.Role)
+
+	_, err = c.Job(t.Context(), "missing")
+	assert.True(t, IsStatus(err, http.StatusNotFound), err)
+	_, err = c.CreateJob(t.Context(), api.JobRequest{})
+	assert.True(t, IsStatus(err, http.StatusConflict), err)
+}
+
+func TestDeviceCodeSignIn(t *testing.T) {
+	signedIn := make(chan struct{})
+	srv, key := newServer(t, func(prompt auth.DevicePrompt) azcore.TokenCredential {
+		return &fakeCredential{prompt: prompt, signedIn: signedIn}
+	})
+	c := newClient(t, srv.URL, key)
+
+	st, err := c.SignIn(t.Context(), api.AuthRequest{Method: api.DeviceCode})
+	require.NoError(t, err)
+	assert.Equal(t, api.AuthPending, st.State)
+	assert.Equal(t, "ABCD-EFGH", st.UserCode)
+
+	close(signedIn)
+	st, err = c.WaitForSignIn(t.Context())
+	require.NoError(t, err)
+	assert.Equal(t, api.AuthAuthenticated, st.State)
+	assert.Equal(t, "contoso", st.Tenant)
+}
+
+func TestJobIsFollowedAndDownloaded(t *testing.T) {
+	srv, key := newServer(t, func(auth.DevicePrompt) azcore.TokenCredential { return &fakeCredential{} })
+	c := newClient(t, srv.URL, key)
+	_, err := c.SignIn(t.Context(), api.AuthRequest{Method: string(auth.AppSecret), ClientSecret: "secret"})
+	require.NoError(t, err)
+
+	j, err := c.CreateJob(t.Context(), api.JobRequest{Datasets: []string{collect.EntraSignIns}, Since: "24h"})
+	require.NoError(t, err)
+
+	var events []api.JobEvent
+	require.NoError(t, c.Events(t.Context(), j.ID, 0, func(e api.JobEvent) error {
+		events = append(events, e)
+		return nil
+	}))
+	require.NotEmpty(t, events)
+	assert.Equal(t, api.EventJobStarted, events[0].Type)
+	assert.Equal(t, api.EventJobFinished, events[len(events)-1].Type)
+
+	// Replaying from an offset skips the events before it
+	var replayed []api.JobEvent
+	require.NoError(t, c.Events(t.Context(), j.ID, 1, func(e api.JobEvent) error {
+		replayed = append(replayed, e)
+		return nil
+	}))
+	assert.Equal(t, events[1:], replayed)
+
+	j, err = c.Job(t.Context(), j.ID)
+	require.NoError(t, err)
+	assert.Equal(t, api.JobCompleted, j.Status)
+	jobs, err := c.Jobs(t.Context(), "contoso")
+	require.NoError(t, err)
+	assert.Len(t, jobs, 1)
+
+	dir := t.TempDir()
+	artifacts, err := c.Download(t.Context(), j.ID, dir)
+	require.NoError(t, err)
+	require.NotEmpty(t, artifacts)
+	for _, a := range artifacts {
+		info, err := os.Stat(filepath.Join(dir, j.ID, filepath.FromSlash(a.Path)))
+		require.NoError(t, err)
+		assert.Equal(t, a.Size, info.Size(), a.Path)
+	}
+	_, err = collect.LoadCheckpoint(dir, j.ID)
+	assert.NoError(t, err)
+}